@@ -0,0 +1,163 @@
+//Package apperr holds the errors the api answers with. Each has a machine readable Code that decides its status,
+//a Message for people and, for validation errors, a message per field, so every failure has the same json shape:
+//
+//	{"error": "Please supply a valid email", "code": "validation_failed", "fields": {"email": "..."}, "request_id": "..."}
+package apperr
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+//Code tells clients what went wrong, they can switch on it. Codes don't change once published.
+type Code string
+
+//The codes of the errors the api answers with
+const (
+	CodeBadRequest       Code = "bad_request"
+	CodeValidation       Code = "validation_failed"
+	CodeUnauthorized     Code = "unauthorized"
+	CodeForbidden        Code = "forbidden"
+	CodeNotFound         Code = "not_found"
+	CodeMethodNotAllowed Code = "method_not_allowed"
+	CodeConflict         Code = "conflict"
+	CodeTooLarge         Code = "too_large"
+	CodeUnsupportedMedia Code = "unsupported_media_type"
+	CodeTooManyRequests  Code = "too_many_requests"
+	CodeInternal         Code = "internal"
+)
+
+var statuses = map[Code]int{
+	CodeBadRequest:       http.StatusBadRequest,
+	CodeValidation:       http.StatusBadRequest,
+	CodeUnauthorized:     http.StatusUnauthorized,
+	CodeForbidden:        http.StatusForbidden,
+	CodeNotFound:         http.StatusNotFound,
+	CodeMethodNotAllowed: http.StatusMethodNotAllowed,
+	CodeConflict:         http.StatusConflict,
+	CodeTooLarge:         http.StatusRequestEntityTooLarge,
+	CodeUnsupportedMedia: http.StatusUnsupportedMediaType,
+	CodeTooManyRequests:  http.StatusTooManyRequests,
+	CodeInternal:         http.StatusInternalServerError,
+}
+
+//internalMessage is all clients are told about internal errors, their cause is logged by whoever answers with them
+const internalMessage = "Sorry there was an internal server error"
+
+//Error is an error the api can answer with
+type Error struct {
+	Code    Code              `json:"code"`
+	Message string            `json:"error"`
+	Fields  map[string]string `json:"fields,omitempty"`
+
+	//RetryAfter is how long clients should wait before trying again, sent in the Retry-After header
+	RetryAfter time.Duration `json:"-"`
+
+	//Err is what caused the error, it is never sent
+	Err error `json:"-"`
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return string(e.Code) + ": " + e.Message + ": " + e.Err.Error()
+	}
+
+	return string(e.Code) + ": " + e.Message
+}
+
+//Unwrap returns the cause of e
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+//Status returns the http status e is answered with
+func (e *Error) Status() int {
+	if status, ok := statuses[e.Code]; ok {
+		return status
+	}
+
+	return http.StatusInternalServerError
+}
+
+//BadRequest is a request that can't be understood, such as a body that isn't json or a missing parameter
+func BadRequest(message string) *Error {
+	return &Error{Code: CodeBadRequest, Message: message}
+}
+
+//Validation is a request whose fields have invalid values, fields maps the json name of each to what is wrong with it
+func Validation(message string, fields map[string]string) *Error {
+	return &Error{Code: CodeValidation, Message: message, Fields: fields}
+}
+
+//Unauthorized is a request without valid credentials
+func Unauthorized(message string) *Error {
+	return &Error{Code: CodeUnauthorized, Message: message}
+}
+
+//Forbidden is a request by a user who isn't allowed to make it
+func Forbidden(message string) *Error {
+	return &Error{Code: CodeForbidden, Message: message}
+}
+
+//NotFound is a request for something that doesn't exist
+func NotFound(message string) *Error {
+	return &Error{Code: CodeNotFound, Message: message}
+}
+
+//MethodNotAllowed is a request with a method its route doesn't answer
+func MethodNotAllowed(message string) *Error {
+	return &Error{Code: CodeMethodNotAllowed, Message: message}
+}
+
+//Conflict is a request that clashes with the current state, such as signing up with an email already in use
+func Conflict(message string) *Error {
+	return &Error{Code: CodeConflict, Message: message}
+}
+
+//TooLarge is a request with a body bigger than its route accepts, such as an oversized image
+func TooLarge(message string) *Error {
+	return &Error{Code: CodeTooLarge, Message: message}
+}
+
+//UnsupportedMedia is a request with a body of a type its route doesn't accept
+func UnsupportedMedia(message string) *Error {
+	return &Error{Code: CodeUnsupportedMedia, Message: message}
+}
+
+//TooManyRequests is a request from a client that has to wait retryAfter before trying again
+func TooManyRequests(message string, retryAfter time.Duration) *Error {
+	return &Error{Code: CodeTooManyRequests, Message: message, RetryAfter: retryAfter}
+}
+
+//Internal is a failure of the server caused by err
+func Internal(err error) *Error {
+	return &Error{Code: CodeInternal, Message: internalMessage, Err: err}
+}
+
+//envelope is the json body of every error, with the id of the request it answers so it can be found in the logs
+type envelope struct {
+	*Error
+	RequestID string `json:"request_id,omitempty"`
+}
+
+//Write answers with err. Errors that aren't an *Error are internal errors, and so are never shown to clients.
+//The request id is read from the X-Request-ID header the logging middleware sets on every response. Write doesn't
+//log, callers log the Err of internal errors with the context of the request.
+func Write(w http.ResponseWriter, err error) {
+	var e *Error
+
+	if !errors.As(err, &e) {
+		e = Internal(err)
+	}
+
+	if e.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int((e.RetryAfter+time.Second-1)/time.Second)))
+	}
+
+	w.Header().Set("Content-type", "application/json")
+	w.WriteHeader(e.Status())
+	json.NewEncoder(w).Encode(envelope{Error: e, RequestID: w.Header().Get("X-Request-ID")})
+}
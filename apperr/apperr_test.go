@@ -0,0 +1,57 @@
+package apperr
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWrite(t *testing.T) {
+	cases := []struct {
+		err    error
+		status int
+		code   Code
+		error  string
+	}{
+		{NotFound("Sorry that user doesn't exist"), http.StatusNotFound, CodeNotFound, "Sorry that user doesn't exist"},
+		{Validation("Please correct the invalid fields", map[string]string{"email": "Please supply a valid email"}), http.StatusBadRequest, CodeValidation, "Please correct the invalid fields"},
+		{fmt.Errorf("creating a user: %w", Conflict("Sorry that email is already in use")), http.StatusConflict, CodeConflict, "Sorry that email is already in use"},
+		{TooLarge("Please upload an image of at most 8MB"), http.StatusRequestEntityTooLarge, CodeTooLarge, "Please upload an image of at most 8MB"},
+		{UnsupportedMedia("Please upload a jpeg, png or gif image"), http.StatusUnsupportedMediaType, CodeUnsupportedMedia, "Please upload a jpeg, png or gif image"},
+		{Internal(errors.New("connection refused")), http.StatusInternalServerError, CodeInternal, internalMessage},
+		{errors.New("connection refused"), http.StatusInternalServerError, CodeInternal, internalMessage},
+	}
+
+	for _, c := range cases {
+		res := httptest.NewRecorder()
+		res.Header().Set("X-Request-ID", "the-request")
+		Write(res, c.err)
+
+		var body map[string]interface{}
+
+		if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+
+		if res.Code != c.status || body["code"] != string(c.code) || body["error"] != c.error || body["request_id"] != "the-request" {
+			t.Errorf("%v: got %d %v", c.err, res.Code, body)
+		}
+
+		if _, ok := body["fields"]; ok != (c.code == CodeValidation) {
+			t.Errorf("%v: fields are only sent with validation errors, got %v", c.err, body)
+		}
+	}
+}
+
+func TestWriteRetryAfter(t *testing.T) {
+	res := httptest.NewRecorder()
+	Write(res, TooManyRequests("Sorry there have been too many failed logins, please try again later", 1500*time.Millisecond))
+
+	if res.Code != http.StatusTooManyRequests || res.Header().Get("Retry-After") != "2" {
+		t.Fatalf("got %d with Retry-After %q", res.Code, res.Header().Get("Retry-After"))
+	}
+}
@@ -0,0 +1,44 @@
+//Package blobs keeps uploaded files, such as the photos of items, under keys like items/<id>/<name>.jpg. Keys are
+//made by the api, never by clients, and a file is never changed once put, a new file gets a new key.
+package blobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+
+	"github.com/Samuyi/www/config"
+)
+
+//ErrNotFound is returned when getting a file that doesn't exist
+var ErrNotFound = errors.New("blobs: no such file")
+
+//ErrInvalidKey is returned for keys that could escape the store, such as ones with .. in them
+var ErrInvalidKey = errors.New("blobs: invalid key")
+
+//BlobStore keeps files by key. Deleting a file that doesn't exist isn't an error.
+type BlobStore interface {
+	Put(ctx context.Context, key, contentType string, body []byte) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+}
+
+//New returns the store cfg describes
+func New(cfg config.Uploads) (BlobStore, error) {
+	switch cfg.Store {
+	case "local", "":
+		return NewLocal(cfg.Dir), nil
+	case "s3":
+		return NewS3(cfg.S3), nil
+	}
+
+	return nil, fmt.Errorf("blobs: unknown store %q", cfg.Store)
+}
+
+//ValidKey reports whether key is a slash separated path without empty, . or .. elements
+func ValidKey(key string) bool {
+	return fs.ValidPath(key) && key != "." && !strings.Contains(key, `\`)
+}
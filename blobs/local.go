@@ -0,0 +1,79 @@
+package blobs
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+//Local keeps files in a directory of the local filesystem, for a single server or local development
+type Local struct {
+	Dir string
+}
+
+//NewLocal returns a store that keeps files under dir
+func NewLocal(dir string) *Local {
+	return &Local{Dir: dir}
+}
+
+//path returns where the file with key is kept
+func (s *Local) path(key string) (string, error) {
+	if !ValidKey(key) {
+		return "", ErrInvalidKey
+	}
+
+	return filepath.Join(s.Dir, filepath.FromSlash(key)), nil
+}
+
+//Put writes body to the file with key
+func (s *Local) Put(ctx context.Context, key, contentType string, body []byte) error {
+	name, err := s.path(key)
+
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+
+	// write to a temporary name first so nothing serving the file sees half of it
+	if err = os.WriteFile(name+".tmp", body, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(name+".tmp", name)
+}
+
+//Get opens the file with key
+func (s *Local) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	name, err := s.path(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(name)
+
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+
+	return file, err
+}
+
+//Delete removes the file with key
+func (s *Local) Delete(ctx context.Context, key string) error {
+	name, err := s.path(key)
+
+	if err != nil {
+		return err
+	}
+
+	if err = os.Remove(name); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,209 @@
+package blobs
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Samuyi/www/config"
+)
+
+//S3 keeps files in a bucket of an S3 compatible service, such as Amazon S3 or MinIO. Requests are signed with
+//version 4 of the AWS signature, which every such service accepts.
+type S3 struct {
+	cfg    config.S3
+	client *http.Client
+	now    func() time.Time
+}
+
+//NewS3 returns a store that keeps files in the bucket cfg describes
+func NewS3(cfg config.S3) *S3 {
+	return &S3{cfg: cfg, client: &http.Client{Timeout: 30 * time.Second}, now: time.Now}
+}
+
+//url returns the url of the file with key
+func (s *S3) url(key string) (*url.URL, error) {
+	u, err := url.Parse(strings.TrimSuffix(s.cfg.Endpoint, "/"))
+
+	if err != nil {
+		return nil, err
+	}
+
+	if s.cfg.PathStyle {
+		key = s.cfg.Bucket + "/" + key
+	} else {
+		u.Host = s.cfg.Bucket + "." + u.Host
+	}
+
+	u.Path += "/" + key
+	u.RawPath = escapePath(u.Path)
+
+	return u, nil
+}
+
+//escapePath escapes every byte of path but unreserved characters and slashes, as the signature needs
+func escapePath(path string) string {
+	var escaped strings.Builder
+
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("-_.~/", c) >= 0 {
+			escaped.WriteByte(c)
+		} else {
+			fmt.Fprintf(&escaped, "%%%02X", c)
+		}
+	}
+
+	return escaped.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+
+	return mac.Sum(nil)
+}
+
+//sign adds the headers that authenticate req, with body, as sent at now. Every header already set is signed.
+func (s *S3) sign(req *http.Request, body []byte, now time.Time) {
+	payload := sha256.Sum256(body)
+	stamp := now.UTC().Format("20060102T150405Z")
+	day := stamp[:8]
+
+	req.Header.Set("X-Amz-Date", stamp)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payload[:]))
+
+	names := []string{"host"}
+	values := map[string]string{"host": req.URL.Host}
+
+	for name, value := range req.Header {
+		name = strings.ToLower(name)
+		names = append(names, name)
+		values[name] = strings.TrimSpace(strings.Join(value, ","))
+	}
+
+	sort.Strings(names)
+
+	var headers strings.Builder
+
+	for _, name := range names {
+		headers.WriteString(name + ":" + values[name] + "\n")
+	}
+
+	signed := strings.Join(names, ";")
+	request := strings.Join([]string{req.Method, req.URL.EscapedPath(), req.URL.RawQuery, headers.String(), signed, hex.EncodeToString(payload[:])}, "\n")
+	hashed := sha256.Sum256([]byte(request))
+	scope := day + "/" + s.cfg.Region + "/s3/aws4_request"
+
+	key := hmacSHA256([]byte("AWS4"+s.cfg.SecretKey), day)
+	key = hmacSHA256(key, s.cfg.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+
+	signature := hmacSHA256(key, "AWS4-HMAC-SHA256\n"+stamp+"\n"+scope+"\n"+hex.EncodeToString(hashed[:]))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+s.cfg.AccessKey+"/"+scope+", SignedHeaders="+signed+", Signature="+hex.EncodeToString(signature))
+}
+
+//do sends a signed request for the file with key
+func (s *S3) do(ctx context.Context, method, key string, header http.Header, body []byte) (*http.Response, error) {
+	if !ValidKey(key) {
+		return nil, ErrInvalidKey
+	}
+
+	u, err := s.url(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var reader io.Reader
+
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), reader)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for name, value := range header {
+		req.Header[name] = value
+	}
+
+	s.sign(req, body, s.now())
+
+	return s.client.Do(req)
+}
+
+//failed returns the error of an unexpected answer to a request for the file with key
+func failed(method, key string, resp *http.Response) error {
+	message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+
+	return fmt.Errorf("blobs: s3 %s %s: %s %s", method, key, resp.Status, bytes.TrimSpace(message))
+}
+
+//Put uploads body as the file with key
+func (s *S3) Put(ctx context.Context, key, contentType string, body []byte) error {
+	resp, err := s.do(ctx, http.MethodPut, key, http.Header{"Content-Type": {contentType}}, body)
+
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return failed(http.MethodPut, key, resp)
+	}
+
+	return nil
+}
+
+//Get downloads the file with key
+func (s *S3) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := s.do(ctx, http.MethodGet, key, nil, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, ErrNotFound
+	}
+
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		return nil, failed(http.MethodGet, key, resp)
+	}
+
+	return resp.Body, nil
+}
+
+//Delete removes the file with key
+func (s *S3) Delete(ctx context.Context, key string) error {
+	resp, err := s.do(ctx, http.MethodDelete, key, nil, nil)
+
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 && resp.StatusCode != http.StatusNotFound {
+		return failed(http.MethodDelete, key, resp)
+	}
+
+	return nil
+}
@@ -0,0 +1,797 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//Config holds every setting the application needs to run
+type Config struct {
+	Server   Server   `json:"server"`
+	Database Database `json:"database"`
+	Redis    Redis    `json:"redis"`
+	SMTP     SMTP     `json:"smtp"`
+	Outbox   Outbox   `json:"outbox"`
+	Auth     Auth     `json:"auth"`
+	Lockout  Lockout  `json:"lockout"`
+	CORS     CORS     `json:"cors"`
+	Log      Log      `json:"log"`
+	Uploads  Uploads  `json:"uploads"`
+	Items    Items    `json:"items"`
+
+	//RateLimits holds the request limits of the routes that use one, by name
+	RateLimits map[string]RateLimit `json:"rate_limits"`
+}
+
+//Server holds the http server settings
+type Server struct {
+	Addr    string `json:"addr"`
+	BaseURL string `json:"base_url"`
+}
+
+//Database holds the postgres connection settings
+type Database struct {
+	Driver   string `json:"driver"`
+	URL      string `json:"url,omitempty"`
+	Host     string `json:"host"`
+	Port     int    `json:"port"`
+	User     string `json:"user"`
+	Password string `json:"password"`
+	Name     string `json:"name"`
+	SSLMode  string `json:"sslmode"`
+
+	MaxOpenConns    int      `json:"max_open_conns"`
+	MaxIdleConns    int      `json:"max_idle_conns"`
+	ConnMaxLifetime Duration `json:"conn_max_lifetime"`
+	ConnMaxIdleTime Duration `json:"conn_max_idle_time"`
+	ConnectTimeout  Duration `json:"connect_timeout"`
+
+	AutoMigrate bool `json:"auto_migrate"`
+}
+
+//Duration is a time.Duration written as a string such as "30s" or "5m" in the config file
+type Duration struct {
+	time.Duration
+}
+
+//UnmarshalJSON parses a duration string
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value string
+
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("duration must be a string such as \"30s\"")
+	}
+
+	duration, err := time.ParseDuration(value)
+
+	if err != nil {
+		return err
+	}
+
+	d.Duration = duration
+
+	return nil
+}
+
+//MarshalJSON writes the duration as a string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+//Redis holds the redis connection settings
+type Redis struct {
+	Addr     string `json:"addr"`
+	Password string `json:"password"`
+	DB       int    `json:"db"`
+}
+
+//SMTP holds the settings of the outgoing mail server. An empty TemplateDir uses the email templates built into the binary.
+type SMTP struct {
+	Transport   string `json:"transport"`
+	Host        string `json:"host"`
+	Port        int    `json:"port"`
+	Security    string `json:"security"`
+	Username    string `json:"username"`
+	Password    string `json:"password"`
+	From        string `json:"from"`
+	TemplateDir string `json:"template_dir"`
+	DropDir     string `json:"drop_dir"`
+}
+
+//Outbox holds the settings of the queue emails wait in until they are delivered
+type Outbox struct {
+	Workers      int      `json:"workers"`
+	MaxAttempts  int      `json:"max_attempts"`
+	BaseDelay    Duration `json:"base_delay"`
+	MaxDelay     Duration `json:"max_delay"`
+	PollInterval Duration `json:"poll_interval"`
+	Lease        Duration `json:"lease"`
+
+	//DeadTTL is how long emails that couldn't be delivered are kept for admins to requeue
+	DeadTTL Duration `json:"dead_ttl"`
+}
+
+//Auth holds the keys used to sign and verify tokens and cookies
+type Auth struct {
+	//SigningKey is a shorthand for a single HS256 key with the id "default", used when Keys is empty
+	SigningKey string `json:"signing_key"`
+	SessionKey string `json:"session_key"`
+
+	//Keys are the keys tokens are signed and verified with. Tokens are signed with ActiveKey and
+	//verified with whichever key their kid names, unless it is retired.
+	Keys      []SigningKey `json:"keys"`
+	ActiveKey string       `json:"active_key"`
+
+	//SessionLifetime is how long a session lasts without being refreshed, and so how long refresh tokens work
+	SessionLifetime Duration `json:"session_lifetime"`
+
+	//AccessTokenLifetime is how long the bearer tokens sent with each request work
+	AccessTokenLifetime Duration `json:"access_token_lifetime"`
+
+	//ResetTokenTTL is how long the links sent to reset a password work for
+	ResetTokenTTL Duration `json:"reset_token_ttl"`
+
+	//ConfirmationTTL is how long the links sent to confirm an email work for
+	ConfirmationTTL Duration `json:"confirmation_ttl"`
+
+	//Issuer names the site in authenticator apps when users turn on two-factor authentication
+	Issuer string `json:"issuer"`
+}
+
+//Lockout holds how failed logins are slowed down and then stopped. After FreeAttempts failures for an account
+//each further attempt has to wait BaseDelay, doubling every time up to MaxDelay, and MaxAttempts failures lock
+//the account for Duration. IPMaxAttempts failures from one address lock out the address. Failures are forgotten
+//once there hasn't been one for Window.
+type Lockout struct {
+	FreeAttempts  int      `json:"free_attempts"`
+	MaxAttempts   int      `json:"max_attempts"`
+	IPMaxAttempts int      `json:"ip_max_attempts"`
+	BaseDelay     Duration `json:"base_delay"`
+	MaxDelay      Duration `json:"max_delay"`
+	Window        Duration `json:"window"`
+	Duration      Duration `json:"duration"`
+}
+
+//RateLimit allows Limit requests in any Window. A Limit of 0 turns the limit off.
+type RateLimit struct {
+	Limit  int      `json:"limit"`
+	Window Duration `json:"window"`
+}
+
+//SigningKey is one of the keys tokens are signed with. HS256 keys have a Secret, RS256 and EdDSA keys
+//a PEM encoded PKCS #8 (or PKCS #1 for RSA) private key in PrivateKeyFile.
+type SigningKey struct {
+	ID             string `json:"id"`
+	Algorithm      string `json:"algorithm"`
+	Secret         string `json:"secret,omitempty"`
+	PrivateKeyFile string `json:"private_key_file,omitempty"`
+	Retired        bool   `json:"retired,omitempty"`
+}
+
+//SigningKeys returns the configured keys and the id of the active one, falling back to SigningKey
+func (auth Auth) SigningKeys() ([]SigningKey, string) {
+	if len(auth.Keys) > 0 || auth.SigningKey == "" {
+		return auth.Keys, auth.ActiveKey
+	}
+
+	return []SigningKey{{ID: "default", Algorithm: "HS256", Secret: auth.SigningKey}}, "default"
+}
+
+//CORS holds the cross origin settings. AllowedOrigins are origins like https://example.com, wildcard subdomains
+//like https://*.example.com, or "*" for any origin, which can't be combined with AllowCredentials.
+//MaxAge is how long browsers may cache the answer to a preflight.
+type CORS struct {
+	AllowedOrigins   []string `json:"allowed_origins"`
+	AllowCredentials bool     `json:"allow_credentials"`
+	AllowedHeaders   []string `json:"allowed_headers"`
+	ExposedHeaders   []string `json:"exposed_headers"`
+	MaxAge           Duration `json:"max_age"`
+}
+
+//validate reports the allowed origins that aren't "*", an origin, or an origin with a wildcard subdomain
+func (cors CORS) validate() []string {
+	var problems []string
+
+	for _, origin := range cors.AllowedOrigins {
+		if origin == "*" {
+			if cors.AllowCredentials {
+				problems = append(problems, "cors.allowed_origins can't be * when cors.allow_credentials is set")
+			}
+
+			continue
+		}
+
+		u, err := url.Parse(strings.Replace(origin, "://*.", "://wildcard.", 1))
+
+		if err != nil || u.Scheme == "" || u.Host == "" || u.Path != "" || u.RawQuery != "" || strings.Contains(u.Host, "*") {
+			problems = append(problems, fmt.Sprintf("cors.allowed_origins: %q is not an origin", origin))
+		}
+	}
+
+	if cors.MaxAge.Duration < 0 {
+		problems = append(problems, "cors.max_age can't be negative")
+	}
+
+	return problems
+}
+
+//Log holds the logging settings. Level is debug, info, warn or error.
+type Log struct {
+	Level string `json:"level"`
+}
+
+//Uploads holds where uploaded images are kept and how big they can be. Store is local, which keeps them under Dir,
+//or s3, which keeps them in a bucket of an S3 compatible service. Images are linked to at PublicURL followed by their
+//key, or served by the api itself when it is empty. Uploads larger than MaxBytes or MaxPixels are refused, and an
+//item can have at most MaxPerItem images.
+type Uploads struct {
+	Store      string `json:"store"`
+	Dir        string `json:"dir"`
+	PublicURL  string `json:"public_url"`
+	S3         S3     `json:"s3"`
+	MaxBytes   int64  `json:"max_bytes"`
+	MaxPixels  int    `json:"max_pixels"`
+	MaxPerItem int    `json:"max_per_item"`
+}
+
+//Items holds how long items stay listed. Items open for ExpireAfter without being reserved expire, which is checked
+//every SweepInterval. An ExpireAfter of 0 keeps items open until their owner moves them on.
+type Items struct {
+	ExpireAfter   Duration `json:"expire_after"`
+	SweepInterval Duration `json:"sweep_interval"`
+}
+
+//S3 holds the settings of an S3 compatible service. Endpoint is the url of the service, such as https://s3.amazonaws.com
+//or http://localhost:9000 for a local MinIO. PathStyle puts the bucket in the path of urls instead of their host, as
+//most services other than Amazon's need.
+type S3 struct {
+	Endpoint  string `json:"endpoint"`
+	Region    string `json:"region"`
+	Bucket    string `json:"bucket"`
+	AccessKey string `json:"access_key"`
+	SecretKey string `json:"secret_key"`
+	PathStyle bool   `json:"path_style"`
+}
+
+//validate reports what is missing or malformed in the upload settings
+func (uploads Uploads) validate() []string {
+	var problems []string
+
+	switch uploads.Store {
+	case "local":
+		if uploads.Dir == "" {
+			problems = append(problems, "uploads.dir is required by the local store")
+		}
+	case "s3":
+		if u, err := url.Parse(uploads.S3.Endpoint); err != nil || u.Scheme == "" || u.Host == "" {
+			problems = append(problems, "uploads.s3.endpoint must be an absolute url")
+		}
+
+		if uploads.S3.Region == "" || uploads.S3.Bucket == "" || uploads.S3.AccessKey == "" || uploads.S3.SecretKey == "" {
+			problems = append(problems, "uploads.s3.region, uploads.s3.bucket, uploads.s3.access_key and uploads.s3.secret_key are required by the s3 store")
+		}
+	default:
+		problems = append(problems, "uploads.store must be local or s3")
+	}
+
+	if uploads.PublicURL != "" {
+		if u, err := url.Parse(uploads.PublicURL); err != nil || u.Scheme == "" || u.Host == "" {
+			problems = append(problems, "uploads.public_url must be an absolute url")
+		}
+	}
+
+	if uploads.MaxBytes <= 0 || uploads.MaxPixels <= 0 || uploads.MaxPerItem <= 0 {
+		problems = append(problems, "uploads.max_bytes, uploads.max_pixels and uploads.max_per_item must be positive")
+	}
+
+	return problems
+}
+
+//Default returns the configuration used when nothing else is supplied
+func Default() *Config {
+	return &Config{
+		Server: Server{
+			Addr: ":8080",
+		},
+		Database: Database{
+			Driver:   "postgres",
+			Host:     "localhost",
+			Port:     5432,
+			User:     "help",
+			Password: "help",
+			Name:     "help.ng",
+			SSLMode:  "disable",
+
+			MaxOpenConns:    25,
+			MaxIdleConns:    5,
+			ConnMaxLifetime: Duration{30 * time.Minute},
+			ConnMaxIdleTime: Duration{5 * time.Minute},
+			ConnectTimeout:  Duration{5 * time.Second},
+		},
+		Redis: Redis{
+			Addr: "localhost:6379",
+		},
+		SMTP: SMTP{
+			Transport:   "smtp",
+			Host:        "smtp.gmail.com",
+			Port:        465,
+			Security:    "tls",
+			TemplateDir: "",
+			DropDir:     "mail",
+		},
+		Outbox: Outbox{
+			Workers:      4,
+			MaxAttempts:  8,
+			BaseDelay:    Duration{30 * time.Second},
+			MaxDelay:     Duration{time.Hour},
+			PollInterval: Duration{time.Second},
+			Lease:        Duration{5 * time.Minute},
+			DeadTTL:      Duration{7 * 24 * time.Hour},
+		},
+		Auth: Auth{
+			SessionLifetime:     Duration{72 * time.Hour},
+			AccessTokenLifetime: Duration{15 * time.Minute},
+			ResetTokenTTL:       Duration{time.Hour},
+			ConfirmationTTL:     Duration{7 * 24 * time.Hour},
+			Issuer:              "Giveaway",
+		},
+		Lockout: Lockout{
+			FreeAttempts:  3,
+			MaxAttempts:   10,
+			IPMaxAttempts: 100,
+			BaseDelay:     Duration{time.Second},
+			MaxDelay:      Duration{time.Minute},
+			Window:        Duration{15 * time.Minute},
+			Duration:      Duration{15 * time.Minute},
+		},
+		CORS: CORS{
+			AllowedOrigins:   []string{"http://localhost:3000"},
+			AllowCredentials: true,
+			AllowedHeaders:   []string{"Accept", "Content-Type", "Content-Length", "Accept-Encoding", "X-CSRF-Token", "Authorization", "X-Request-ID"},
+			ExposedHeaders:   []string{"RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "RateLimit-Policy", "Retry-After", "X-Request-ID"},
+			MaxAge:           Duration{10 * time.Minute},
+		},
+		Log: Log{
+			Level: "info",
+		},
+		Uploads: Uploads{
+			Store:      "local",
+			Dir:        "uploads",
+			S3:         S3{Region: "us-east-1"},
+			MaxBytes:   8 << 20,
+			MaxPixels:  40000000,
+			MaxPerItem: 8,
+		},
+		Items: Items{
+			ExpireAfter:   Duration{30 * 24 * time.Hour},
+			SweepInterval: Duration{time.Hour},
+		},
+		RateLimits: map[string]RateLimit{
+			"register":        {Limit: 10, Window: Duration{time.Hour}},
+			"forgot_password": {Limit: 5, Window: Duration{time.Hour}},
+			"bid":             {Limit: 20, Window: Duration{time.Hour}},
+			"comment":         {Limit: 10, Window: Duration{time.Minute}},
+		},
+	}
+}
+
+//Load reads the configuration from the file at path, applies environment overrides and validates the result.
+//An empty path skips the file and uses the defaults.
+func Load(path string) (*Config, error) {
+	cfg := Default()
+
+	if path != "" {
+		file, err := os.Open(path)
+
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+
+		decoder := json.NewDecoder(file)
+		decoder.DisallowUnknownFields()
+
+		if err = decoder.Decode(cfg); err != nil {
+			return nil, fmt.Errorf("config: %s: %v", path, err)
+		}
+	}
+
+	if err := cfg.applyEnv(); err != nil {
+		return nil, err
+	}
+
+	if cfg.SMTP.From == "" {
+		cfg.SMTP.From = cfg.SMTP.Username
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+func (cfg *Config) applyEnv() error {
+	setString(&cfg.Server.Addr, "LISTEN_ADDR")
+	setString(&cfg.Server.BaseURL, "BASE_URL")
+
+	setString(&cfg.Database.Driver, "DB_DRIVER")
+	setString(&cfg.Database.URL, "DATABASE_URL")
+	setString(&cfg.Database.Host, "DB_HOST")
+	setString(&cfg.Database.User, "DB_USER")
+	setString(&cfg.Database.Password, "DB_PASSWORD")
+	setString(&cfg.Database.Name, "DB_NAME")
+	setString(&cfg.Database.SSLMode, "DB_SSLMODE")
+
+	setString(&cfg.Redis.Addr, "REDIS_ADDR")
+	setString(&cfg.Redis.Password, "REDIS_PASSWORD")
+
+	setString(&cfg.SMTP.Transport, "MAIL_TRANSPORT")
+	setString(&cfg.SMTP.Host, "SMTP_HOST")
+	setString(&cfg.SMTP.Security, "SMTP_SECURITY")
+	setString(&cfg.SMTP.Username, "SMTP_USERNAME")
+	setString(&cfg.SMTP.Password, "SMTP_PASSWORD")
+	setString(&cfg.SMTP.From, "SMTP_FROM")
+	setString(&cfg.SMTP.TemplateDir, "SMTP_TEMPLATE_DIR")
+	setString(&cfg.SMTP.DropDir, "MAIL_DROP_DIR")
+
+	setString(&cfg.Auth.SigningKey, "SIGNING_KEY")
+	setString(&cfg.Auth.ActiveKey, "ACTIVE_KEY")
+	setString(&cfg.Auth.SessionKey, "SESSION_KEY")
+	setString(&cfg.Auth.Issuer, "TOTP_ISSUER")
+
+	setList(&cfg.CORS.AllowedOrigins, "CORS_ORIGINS")
+	setList(&cfg.CORS.AllowedHeaders, "CORS_ALLOWED_HEADERS")
+	setList(&cfg.CORS.ExposedHeaders, "CORS_EXPOSED_HEADERS")
+	setString(&cfg.Log.Level, "LOG_LEVEL")
+
+	setString(&cfg.Uploads.Store, "UPLOADS_STORE")
+	setString(&cfg.Uploads.Dir, "UPLOADS_DIR")
+	setString(&cfg.Uploads.PublicURL, "UPLOADS_PUBLIC_URL")
+	setString(&cfg.Uploads.S3.Endpoint, "S3_ENDPOINT")
+	setString(&cfg.Uploads.S3.Region, "S3_REGION")
+	setString(&cfg.Uploads.S3.Bucket, "S3_BUCKET")
+	setString(&cfg.Uploads.S3.AccessKey, "S3_ACCESS_KEY")
+	setString(&cfg.Uploads.S3.SecretKey, "S3_SECRET_KEY")
+
+	if value, ok := os.LookupEnv("CORS_ALLOW_CREDENTIALS"); ok {
+		credentials, err := strconv.ParseBool(value)
+
+		if err != nil {
+			return fmt.Errorf("config: CORS_ALLOW_CREDENTIALS must be true or false")
+		}
+
+		cfg.CORS.AllowCredentials = credentials
+	}
+
+	if value, ok := os.LookupEnv("DB_AUTO_MIGRATE"); ok {
+		migrate, err := strconv.ParseBool(value)
+
+		if err != nil {
+			return fmt.Errorf("config: DB_AUTO_MIGRATE must be true or false")
+		}
+
+		cfg.Database.AutoMigrate = migrate
+	}
+
+	if value, ok := os.LookupEnv("S3_PATH_STYLE"); ok {
+		pathStyle, err := strconv.ParseBool(value)
+
+		if err != nil {
+			return fmt.Errorf("config: S3_PATH_STYLE must be true or false")
+		}
+
+		cfg.Uploads.S3.PathStyle = pathStyle
+	}
+
+	for name, field := range map[string]*int{
+		"DB_PORT":                 &cfg.Database.Port,
+		"DB_MAX_OPEN_CONNS":       &cfg.Database.MaxOpenConns,
+		"DB_MAX_IDLE_CONNS":       &cfg.Database.MaxIdleConns,
+		"REDIS_DB":                &cfg.Redis.DB,
+		"SMTP_PORT":               &cfg.SMTP.Port,
+		"OUTBOX_WORKERS":          &cfg.Outbox.Workers,
+		"OUTBOX_MAX_ATTEMPTS":     &cfg.Outbox.MaxAttempts,
+		"LOCKOUT_FREE_ATTEMPTS":   &cfg.Lockout.FreeAttempts,
+		"LOCKOUT_MAX_ATTEMPTS":    &cfg.Lockout.MaxAttempts,
+		"LOCKOUT_IP_MAX_ATTEMPTS": &cfg.Lockout.IPMaxAttempts,
+		"UPLOADS_MAX_PIXELS":      &cfg.Uploads.MaxPixels,
+		"UPLOADS_MAX_PER_ITEM":    &cfg.Uploads.MaxPerItem,
+	} {
+		if err := setInt(field, name); err != nil {
+			return err
+		}
+	}
+
+	if value, ok := os.LookupEnv("UPLOADS_MAX_BYTES"); ok {
+		n, err := strconv.ParseInt(value, 10, 64)
+
+		if err != nil {
+			return fmt.Errorf("config: UPLOADS_MAX_BYTES must be a number")
+		}
+
+		cfg.Uploads.MaxBytes = n
+	}
+
+	for name, field := range map[string]*Duration{
+		"DB_CONN_MAX_LIFETIME":  &cfg.Database.ConnMaxLifetime,
+		"DB_CONN_MAX_IDLE_TIME": &cfg.Database.ConnMaxIdleTime,
+		"DB_CONNECT_TIMEOUT":    &cfg.Database.ConnectTimeout,
+		"OUTBOX_BASE_DELAY":     &cfg.Outbox.BaseDelay,
+		"OUTBOX_MAX_DELAY":      &cfg.Outbox.MaxDelay,
+		"OUTBOX_DEAD_TTL":       &cfg.Outbox.DeadTTL,
+		"OUTBOX_POLL_INTERVAL":  &cfg.Outbox.PollInterval,
+		"OUTBOX_LEASE":          &cfg.Outbox.Lease,
+		"SESSION_LIFETIME":      &cfg.Auth.SessionLifetime,
+		"ACCESS_TOKEN_LIFETIME": &cfg.Auth.AccessTokenLifetime,
+		"RESET_TOKEN_TTL":       &cfg.Auth.ResetTokenTTL,
+		"CONFIRMATION_TTL":      &cfg.Auth.ConfirmationTTL,
+		"LOCKOUT_DURATION":      &cfg.Lockout.Duration,
+		"LOCKOUT_BASE_DELAY":    &cfg.Lockout.BaseDelay,
+		"LOCKOUT_MAX_DELAY":     &cfg.Lockout.MaxDelay,
+		"LOCKOUT_WINDOW":        &cfg.Lockout.Window,
+		"CORS_MAX_AGE":          &cfg.CORS.MaxAge,
+		"ITEMS_EXPIRE_AFTER":    &cfg.Items.ExpireAfter,
+		"ITEMS_SWEEP_INTERVAL":  &cfg.Items.SweepInterval,
+	} {
+		if err := setDuration(field, name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func setString(field *string, name string) {
+	if value, ok := os.LookupEnv(name); ok {
+		*field = value
+	}
+}
+
+//setList sets field from a comma separated variable when it is set and not empty
+func setList(field *[]string, name string) {
+	value := os.Getenv(name)
+
+	if value == "" {
+		return
+	}
+
+	*field = nil
+
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			*field = append(*field, entry)
+		}
+	}
+}
+
+func setInt(field *int, name string) error {
+	value, ok := os.LookupEnv(name)
+
+	if !ok {
+		return nil
+	}
+
+	n, err := strconv.Atoi(value)
+
+	if err != nil {
+		return fmt.Errorf("config: %s must be a number", name)
+	}
+
+	*field = n
+
+	return nil
+}
+
+func setDuration(field *Duration, name string) error {
+	value, ok := os.LookupEnv(name)
+
+	if !ok {
+		return nil
+	}
+
+	d, err := time.ParseDuration(value)
+
+	if err != nil {
+		return fmt.Errorf("config: %s must be a duration such as 30s", name)
+	}
+
+	field.Duration = d
+
+	return nil
+}
+
+//Validate checks that every required setting is present and well formed
+func (cfg *Config) Validate() error {
+	var problems []string
+
+	if cfg.Server.Addr == "" {
+		problems = append(problems, "server.addr is required")
+	}
+
+	if cfg.Server.BaseURL != "" {
+		if u, err := url.Parse(cfg.Server.BaseURL); err != nil || u.Scheme == "" || u.Host == "" {
+			problems = append(problems, "server.base_url must be an absolute url")
+		}
+	}
+
+	switch cfg.Database.Driver {
+	case "postgres", "memory":
+	default:
+		problems = append(problems, "database.driver must be postgres or memory")
+	}
+
+	if cfg.Database.Driver == "postgres" && cfg.Database.URL == "" {
+		if cfg.Database.Host == "" || cfg.Database.Name == "" || cfg.Database.User == "" {
+			problems = append(problems, "database.host, database.name and database.user are required")
+		}
+
+		if cfg.Database.Port <= 0 {
+			problems = append(problems, "database.port must be positive")
+		}
+	}
+
+	if cfg.Database.MaxOpenConns < 0 || cfg.Database.MaxIdleConns < 0 {
+		problems = append(problems, "database.max_open_conns and database.max_idle_conns can't be negative")
+	}
+
+	if cfg.Database.MaxOpenConns > 0 && cfg.Database.MaxIdleConns > cfg.Database.MaxOpenConns {
+		problems = append(problems, "database.max_idle_conns can't be more than database.max_open_conns")
+	}
+
+	if cfg.Database.Driver == "postgres" && cfg.Redis.Addr == "" {
+		problems = append(problems, "redis.addr is required")
+	}
+
+	switch cfg.SMTP.Transport {
+	case "smtp":
+		if cfg.SMTP.Host == "" || cfg.SMTP.Port <= 0 {
+			problems = append(problems, "smtp.host and smtp.port are required")
+		}
+
+		switch cfg.SMTP.Security {
+		case "tls", "starttls", "none":
+		default:
+			problems = append(problems, "smtp.security must be tls, starttls or none")
+		}
+	case "file":
+		if cfg.SMTP.DropDir == "" {
+			problems = append(problems, "smtp.drop_dir is required by the file transport")
+		}
+	case "memory":
+	default:
+		problems = append(problems, "smtp.transport must be smtp, file or memory")
+	}
+
+	if _, err := mail.ParseAddress(cfg.SMTP.From); err != nil {
+		problems = append(problems, "smtp.from must be an email address such as \"Giveaway <noreply@example.com>\"")
+	}
+
+	if cfg.Outbox.Workers <= 0 || cfg.Outbox.MaxAttempts <= 0 {
+		problems = append(problems, "outbox.workers and outbox.max_attempts must be positive")
+	}
+
+	if cfg.Outbox.BaseDelay.Duration <= 0 || cfg.Outbox.MaxDelay.Duration < cfg.Outbox.BaseDelay.Duration {
+		problems = append(problems, "outbox.base_delay must be positive and no more than outbox.max_delay")
+	}
+
+	if cfg.Outbox.PollInterval.Duration <= 0 || cfg.Outbox.Lease.Duration <= 0 || cfg.Outbox.DeadTTL.Duration <= 0 {
+		problems = append(problems, "outbox.poll_interval, outbox.lease and outbox.dead_ttl must be positive")
+	}
+
+	problems = append(problems, cfg.Auth.validateKeys()...)
+
+	if cfg.Auth.SessionKey == "" {
+		problems = append(problems, "auth.session_key is required")
+	}
+
+	if cfg.Auth.SessionLifetime.Duration <= 0 || cfg.Auth.ResetTokenTTL.Duration <= 0 || cfg.Auth.ConfirmationTTL.Duration <= 0 {
+		problems = append(problems, "auth.session_lifetime, auth.reset_token_ttl and auth.confirmation_ttl must be positive")
+	}
+
+	if cfg.Auth.AccessTokenLifetime.Duration <= 0 || cfg.Auth.AccessTokenLifetime.Duration > cfg.Auth.SessionLifetime.Duration {
+		problems = append(problems, "auth.access_token_lifetime must be positive and no longer than auth.session_lifetime")
+	}
+
+	if cfg.Lockout.FreeAttempts < 0 || cfg.Lockout.MaxAttempts <= cfg.Lockout.FreeAttempts || cfg.Lockout.IPMaxAttempts <= 0 {
+		problems = append(problems, "lockout.max_attempts must be more than lockout.free_attempts and lockout.ip_max_attempts positive")
+	}
+
+	if cfg.Lockout.BaseDelay.Duration <= 0 || cfg.Lockout.MaxDelay.Duration < cfg.Lockout.BaseDelay.Duration {
+		problems = append(problems, "lockout.base_delay must be positive and no longer than lockout.max_delay")
+	}
+
+	if cfg.Lockout.Window.Duration <= 0 || cfg.Lockout.Duration.Duration <= 0 {
+		problems = append(problems, "lockout.window and lockout.duration must be positive")
+	}
+
+	problems = append(problems, cfg.CORS.validate()...)
+	problems = append(problems, cfg.Uploads.validate()...)
+
+	if cfg.Items.ExpireAfter.Duration < 0 || cfg.Items.SweepInterval.Duration <= 0 {
+		problems = append(problems, "items.expire_after can't be negative and items.sweep_interval must be positive")
+	}
+
+	switch strings.ToLower(cfg.Log.Level) {
+	case "debug", "info", "warn", "error":
+	default:
+		problems = append(problems, "log.level must be debug, info, warn or error")
+	}
+
+	for name, limit := range cfg.RateLimits {
+		if limit.Limit < 0 || (limit.Limit > 0 && limit.Window.Duration <= 0) {
+			problems = append(problems, fmt.Sprintf("rate_limits.%s needs a limit of 0 or more and a positive window", name))
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("config: %s", strings.Join(problems, "; "))
+	}
+
+	return nil
+}
+
+func (auth Auth) validateKeys() []string {
+	keys, active := auth.SigningKeys()
+
+	if len(keys) == 0 {
+		return []string{"auth.signing_key or auth.keys is required"}
+	}
+
+	var problems []string
+
+	seen := map[string]bool{}
+	activeFound := false
+
+	for _, key := range keys {
+		if key.ID == "" || seen[key.ID] {
+			problems = append(problems, "every auth.keys entry needs a unique id")
+		}
+
+		seen[key.ID] = true
+
+		switch key.Algorithm {
+		case "HS256":
+			if key.Secret == "" {
+				problems = append(problems, fmt.Sprintf("auth key %q needs a secret", key.ID))
+			}
+		case "RS256", "EdDSA":
+			if key.PrivateKeyFile == "" {
+				problems = append(problems, fmt.Sprintf("auth key %q needs a private_key_file", key.ID))
+			}
+		default:
+			problems = append(problems, fmt.Sprintf("auth key %q: algorithm must be HS256, RS256 or EdDSA", key.ID))
+		}
+
+		if key.ID == active && !key.Retired {
+			activeFound = true
+		}
+	}
+
+	if !activeFound {
+		problems = append(problems, "auth.active_key must name one of auth.keys that isn't retired")
+	}
+
+	return problems
+}
+
+//DSN returns the connection string for the database
+func (db Database) DSN() string {
+	if db.URL != "" {
+		return db.URL
+	}
+
+	dsn := fmt.Sprintf("host=%s port=%d user=%s dbname=%s sslmode=%s", db.Host, db.Port, db.User, db.Name, db.SSLMode)
+
+	if db.Password != "" {
+		dsn += fmt.Sprintf(" password='%s'", strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(db.Password))
+	}
+
+	return dsn
+}
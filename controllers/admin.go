@@ -0,0 +1,154 @@
+package controllers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+
+	"github.com/Samuyi/www/apperr"
+	"github.com/Samuyi/www/models/outbox"
+	"github.com/Samuyi/www/models/users"
+	validator "github.com/asaskevich/govalidator"
+	"github.com/gorilla/mux"
+)
+
+//deadEmail is what admins are shown of an email that couldn't be delivered. Never its body, which can hold live
+//links such as to reset a password.
+type deadEmail struct {
+	ID        string   `json:"id"`
+	To        []string `json:"to"`
+	Template  string   `json:"template"`
+	Attempts  int      `json:"attempts"`
+	LastError string   `json:"last_error,omitempty"`
+}
+
+//GetDeadEmails lists the emails that couldn't be delivered
+func (h *Handler) GetDeadEmails(w http.ResponseWriter, r *http.Request) {
+	entries, err := h.Outbox.Dead(r.Context())
+
+	if err != nil {
+		writeError(w, r, apperr.Internal(err))
+
+		return
+	}
+
+	dead := make([]deadEmail, 0, len(entries))
+
+	for _, entry := range entries {
+		dead = append(dead, deadEmail{ID: entry.ID, To: entry.Message.To, Template: entry.Message.Template, Attempts: entry.Attempts, LastError: entry.LastError})
+	}
+
+	w.Header().Set("Content-type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(dead)
+}
+
+//RequeueEmail gives an email that couldn't be delivered another set of attempts
+func (h *Handler) RequeueEmail(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	err := h.Outbox.Requeue(r.Context(), id)
+
+	if err == outbox.ErrNotFound {
+		writeError(w, r, apperr.NotFound(err.Error()))
+
+		return
+	}
+
+	if err != nil {
+		writeError(w, r, apperr.Internal(err))
+
+		return
+	}
+
+	msg := map[string]string{"message": "Success!"}
+	w.Header().Set("Content-type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(msg)
+}
+
+//SetUserRole changes the role of a user. Their sessions are ended so the new role applies from their next login.
+func (h *Handler) SetUserRole(w http.ResponseWriter, r *http.Request) {
+	var change struct {
+		Role users.Role `json:"role"`
+	}
+
+	if r.Body == nil || json.NewDecoder(r.Body).Decode(&change) != nil || !users.ValidRole(change.Role) {
+		writeError(w, r, apperr.BadRequest("Please supply a role of user, moderator or admin"))
+
+		return
+	}
+
+	var user = &users.User{ID: mux.Vars(r)["id"]}
+
+	err := sql.ErrNoRows
+
+	if validator.IsUUID(user.ID) {
+		err = h.Users.Get(r.Context(), user)
+	}
+
+	if err == sql.ErrNoRows {
+		writeError(w, r, apperr.NotFound("Sorry that user doesn't exist"))
+
+		return
+	}
+
+	if err != nil {
+		writeError(w, r, apperr.Internal(err))
+
+		return
+	}
+
+	user.Role = change.Role
+
+	if err = h.Users.SetRole(r.Context(), user); err != nil {
+		writeError(w, r, apperr.Internal(err))
+
+		return
+	}
+
+	if err = h.Sessions.DeleteUserSessions(r.Context(), user.ID); err != nil {
+		writeError(w, r, apperr.Internal(err))
+
+		return
+	}
+
+	msg := map[string]string{"message": "Success!"}
+	w.Header().Set("Content-type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(msg)
+}
+
+//UnlockUser lifts the lockout of a user's account after too many failed logins
+func (h *Handler) UnlockUser(w http.ResponseWriter, r *http.Request) {
+	var user = &users.User{ID: mux.Vars(r)["id"]}
+
+	err := sql.ErrNoRows
+
+	if validator.IsUUID(user.ID) {
+		err = h.Users.Get(r.Context(), user)
+	}
+
+	if err == sql.ErrNoRows {
+		writeError(w, r, apperr.NotFound("Sorry that user doesn't exist"))
+
+		return
+	}
+
+	if err != nil {
+		writeError(w, r, apperr.Internal(err))
+
+		return
+	}
+
+	if err = h.Lockouts.Unlock(r.Context(), accountKey(user.Email)); err != nil {
+		writeError(w, r, apperr.Internal(err))
+
+		return
+	}
+
+	msg := map[string]string{"message": "Success!"}
+	w.Header().Set("Content-type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(msg)
+}
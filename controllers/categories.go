@@ -0,0 +1,241 @@
+package controllers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+
+	"github.com/Samuyi/www/apperr"
+	"github.com/Samuyi/www/logging"
+	"github.com/Samuyi/www/models/categories"
+	"github.com/Samuyi/www/models/locations"
+	validator "github.com/asaskevich/govalidator"
+	"github.com/gorilla/mux"
+)
+
+//categoryErr returns the api error a category store error stands for
+func categoryErr(err error) error {
+	switch err {
+	case categories.ErrNameTaken, categories.ErrHasChildren:
+		return apperr.Conflict(err.Error())
+	case categories.ErrUnknownParent:
+		return apperr.Validation("Please correct the invalid fields", map[string]string{"parent_id": err.Error()})
+	}
+
+	return apperr.Internal(err)
+}
+
+//GetCategories gets the tree of categories
+func (h *Handler) GetCategories(w http.ResponseWriter, r *http.Request) {
+	all, err := h.Categories.GetAll(r.Context())
+
+	if err != nil {
+		writeError(w, r, apperr.Internal(err))
+
+		return
+	}
+
+	w.Header().Set("Content-type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(categories.Tree(all))
+}
+
+//CreateCategory creates a category, at the top of the tree unless it names a parent
+func (h *Handler) CreateCategory(w http.ResponseWriter, r *http.Request) {
+	if r.Body == nil {
+		writeError(w, r, apperr.BadRequest("Please supply a name and, optionally, a parent_id"))
+
+		return
+	}
+
+	var category = &categories.Category{}
+
+	err := json.NewDecoder(r.Body).Decode(category)
+
+	if err != nil {
+		logging.From(r.Context()).Debug("decoding the request body", "err", err)
+		writeError(w, r, apperr.BadRequest("Please supply a name and, optionally, a parent_id"))
+
+		return
+	}
+
+	category.ID = ""
+
+	if errors := category.Validate(); len(errors) > 0 {
+		writeError(w, r, apperr.Validation("Please correct the invalid fields", errors))
+
+		return
+	}
+
+	if err = h.Categories.Create(r.Context(), category); err != nil {
+		writeError(w, r, categoryErr(err))
+
+		return
+	}
+
+	resp := map[string]string{
+		"message": "success",
+		"id":      category.ID,
+	}
+
+	w.Header().Set("Content-type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(resp)
+}
+
+//UpdateCategory renames a category or moves it under another, which can't be one of the categories under it
+func (h *Handler) UpdateCategory(w http.ResponseWriter, r *http.Request) {
+	var category = &categories.Category{ID: mux.Vars(r)["id"]}
+
+	err := sql.ErrNoRows
+
+	if validator.IsUUID(category.ID) {
+		err = h.Categories.Get(r.Context(), category)
+	}
+
+	if err == sql.ErrNoRows {
+		writeError(w, r, apperr.NotFound("Sorry that category doesn't exist"))
+
+		return
+	}
+
+	if err != nil {
+		writeError(w, r, apperr.Internal(err))
+
+		return
+	}
+
+	var changes struct {
+		Name     *string `json:"name"`
+		ParentID *string `json:"parent_id"`
+	}
+
+	if r.Body == nil || json.NewDecoder(r.Body).Decode(&changes) != nil {
+		writeError(w, r, apperr.BadRequest("Please supply a name or a parent_id"))
+
+		return
+	}
+
+	if changes.Name != nil {
+		category.Name = *changes.Name
+	}
+
+	if changes.ParentID != nil {
+		category.ParentID = *changes.ParentID
+	}
+
+	if errors := category.Validate(); len(errors) > 0 {
+		writeError(w, r, apperr.Validation("Please correct the invalid fields", errors))
+
+		return
+	}
+
+	if category.ParentID != "" {
+		all, err := h.Categories.GetAll(r.Context())
+
+		if err != nil {
+			writeError(w, r, apperr.Internal(err))
+
+			return
+		}
+
+		if categories.Descendants(all, category.ID)[category.ParentID] {
+			writeError(w, r, apperr.Validation("Please correct the invalid fields", map[string]string{"parent_id": "Sorry a category can't be moved under itself"}))
+
+			return
+		}
+	}
+
+	if err = h.Categories.Update(r.Context(), category); err != nil {
+		writeError(w, r, categoryErr(err))
+
+		return
+	}
+
+	w.Header().Set("Content-type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(category)
+}
+
+//DeleteCategory deletes a category without categories under it, its items are left without a category
+func (h *Handler) DeleteCategory(w http.ResponseWriter, r *http.Request) {
+	var category = &categories.Category{ID: mux.Vars(r)["id"]}
+
+	err := sql.ErrNoRows
+
+	if validator.IsUUID(category.ID) {
+		err = h.Categories.Get(r.Context(), category)
+	}
+
+	if err == sql.ErrNoRows {
+		writeError(w, r, apperr.NotFound("Sorry that category doesn't exist"))
+
+		return
+	}
+
+	if err != nil {
+		writeError(w, r, apperr.Internal(err))
+
+		return
+	}
+
+	if err = h.Categories.Delete(r.Context(), category); err != nil {
+		writeError(w, r, categoryErr(err))
+
+		return
+	}
+
+	msg := map[string]string{"message": "Success!"}
+	w.Header().Set("Content-type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(msg)
+}
+
+//GetCategoryCounts counts the open items in each category at a location, including the categories under it
+func (h *Handler) GetCategoryCounts(w http.ResponseWriter, r *http.Request) {
+	var location = &locations.Location{LocationID: r.URL.Query().Get("location_id")}
+
+	if location.LocationID == "" {
+		writeError(w, r, apperr.BadRequest("location_id required"))
+
+		return
+	}
+
+	err := sql.ErrNoRows
+
+	if validator.IsUUID(location.LocationID) {
+		err = h.Locations.Get(r.Context(), location)
+	}
+
+	if err == sql.ErrNoRows {
+		writeError(w, r, apperr.NotFound("Sorry that location doesn't exist"))
+
+		return
+	}
+
+	if err != nil {
+		writeError(w, r, apperr.Internal(err))
+
+		return
+	}
+
+	all, err := h.Categories.GetAll(r.Context())
+
+	if err != nil {
+		writeError(w, r, apperr.Internal(err))
+
+		return
+	}
+
+	direct, err := h.Categories.CountItems(r.Context(), location.LocationID)
+
+	if err != nil {
+		writeError(w, r, apperr.Internal(err))
+
+		return
+	}
+
+	w.Header().Set("Content-type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(categories.Counts(all, direct))
+}
@@ -2,42 +2,35 @@ package controllers
 
 import (
 	"encoding/json"
-	"log"
 	"net/http"
 
 	"github.com/gorilla/mux"
 
+	"github.com/Samuyi/www/apperr"
+	"github.com/Samuyi/www/logging"
 	"github.com/Samuyi/www/models/comments"
+	"github.com/Samuyi/www/models/page"
 )
 
 //CreateComment creates a comment
-func CreateComment(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) CreateComment(w http.ResponseWriter, r *http.Request) {
 	sessionID := r.Header.Get("sessionID")
-	user, err := getUserFromSession(sessionID)
+	user, err := h.getUserFromSession(r.Context(), sessionID)
 
 	if err != nil {
-		msg := map[string]string{"error": "Sorry there was an internal server error"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(msg)
+		writeError(w, r, apperr.Internal(err))
 
 		return
 	}
 
 	if !user.Active {
-		msg := map[string]string{"error": "Sorry your account isn't activated yet"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(msg)
+		writeError(w, r, apperr.Unauthorized("Sorry your account isn't activated yet"))
 
 		return
 	}
 
 	if r.Body == nil {
-		msg := map[string]string{"error": "Sorry you need to supply an item id and a comment text"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(msg)
+		writeError(w, r, apperr.BadRequest("Sorry you need to supply an item id and a comment text"))
 
 		return
 	}
@@ -46,25 +39,20 @@ func CreateComment(w http.ResponseWriter, r *http.Request) {
 
 	err = json.NewDecoder(r.Body).Decode(&comment)
 
-	if err != nil {
-		log.Println(err)
-		msg := map[string]string{"error": "Please supply a valid email and password"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(msg)
+	if err != nil || comment.ItemID == "" || comment.Comment == "" {
+		logging.From(r.Context()).Debug("decoding the request body", "err", err)
+		writeError(w, r, apperr.BadRequest("Please supply an item id and the comment text"))
 
 		return
 	}
 
+	comment.UserID = user.ID
 	comment.Username = user.DisplayName
 
-	err = comment.Create()
+	err = h.Comments.Create(r.Context(), &comment)
 
 	if err != nil {
-		msg := map[string]string{"error": "Sorry there was an internal server error"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(msg)
+		writeError(w, r, apperr.Internal(err))
 
 		return
 	}
@@ -78,24 +66,18 @@ func CreateComment(w http.ResponseWriter, r *http.Request) {
 }
 
 //CreateReply creates a reply
-func CreateReply(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) CreateReply(w http.ResponseWriter, r *http.Request) {
 	sessionID := r.Header.Get("sessionID")
-	user, err := getUserFromSession(sessionID)
+	user, err := h.getUserFromSession(r.Context(), sessionID)
 
 	if err != nil {
-		msg := map[string]string{"error": "Sorry there was an internal server error"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(msg)
+		writeError(w, r, apperr.Internal(err))
 
 		return
 	}
 
 	if !user.Active {
-		msg := map[string]string{"error": "Sorry your account isn't activated yet"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(msg)
+		writeError(w, r, apperr.Unauthorized("Sorry your account isn't activated yet"))
 
 		return
 	}
@@ -104,19 +86,13 @@ func CreateReply(w http.ResponseWriter, r *http.Request) {
 	commentID := params["comment_id"]
 
 	if commentID == "" {
-		msg := map[string]string{"error": "comment id required"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(msg)
+		writeError(w, r, apperr.BadRequest("comment id required"))
 
 		return
 	}
 
 	if r.Body == nil {
-		msg := map[string]string{"error": "Sorry you need to supply an item id and a comment text"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(msg)
+		writeError(w, r, apperr.BadRequest("Sorry you need to supply an item id and a comment text"))
 
 		return
 	}
@@ -125,26 +101,21 @@ func CreateReply(w http.ResponseWriter, r *http.Request) {
 
 	err = json.NewDecoder(r.Body).Decode(&reply)
 
-	if err != nil {
-		log.Println(err)
-		msg := map[string]string{"error": "Sorry there was an error"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(msg)
+	if err != nil || reply.Comment == "" {
+		logging.From(r.Context()).Debug("decoding the request body", "err", err)
+		writeError(w, r, apperr.BadRequest("Please supply the comment text"))
 
 		return
 	}
 
 	reply.CommentID = commentID
+	reply.UserID = user.ID
 	reply.Username = user.DisplayName
 
-	err = reply.Create()
+	err = h.Comments.CreateReply(r.Context(), &reply)
 
 	if err != nil {
-		msg := map[string]string{"error": "Sorry there was an error"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(msg)
+		writeError(w, r, apperr.Internal(err))
 
 		return
 	}
@@ -159,14 +130,11 @@ func CreateReply(w http.ResponseWriter, r *http.Request) {
 }
 
 //GetComment gets a comment
-func GetComment(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) GetComment(w http.ResponseWriter, r *http.Request) {
 	id := r.URL.Query().Get("id")
 
 	if id == "" {
-		msg := map[string]string{"error": "id required"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(msg)
+		writeError(w, r, apperr.BadRequest("id required"))
 
 		return
 	}
@@ -175,24 +143,24 @@ func GetComment(w http.ResponseWriter, r *http.Request) {
 
 	comment.ID = id
 
-	err := comment.Get()
+	err := h.Comments.Get(r.Context(), &comment)
+
+	if err == comments.ErrNotFound {
+		writeError(w, r, apperr.NotFound(err.Error()))
+
+		return
+	}
 
 	if err != nil {
-		msg := map[string]string{"error": "Sorry there was an internal server error"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(msg)
+		writeError(w, r, apperr.Internal(err))
 
 		return
 	}
 
-	err = comment.GetReplies()
+	err = h.Comments.GetReplies(r.Context(), &comment)
 
 	if err != nil {
-		msg := map[string]string{"error": "Sorry there was an internal server error"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(msg)
+		writeError(w, r, apperr.Internal(err))
 
 		return
 	}
@@ -206,15 +174,12 @@ func GetComment(w http.ResponseWriter, r *http.Request) {
 }
 
 //GetReplies gets all replies for a comment
-func GetReplies(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) GetReplies(w http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)
 	commentID := params["comment_id"]
 
 	if commentID == "" {
-		msg := map[string]string{"error": "comment id required"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(msg)
+		writeError(w, r, apperr.BadRequest("comment id required"))
 
 		return
 	}
@@ -222,13 +187,10 @@ func GetReplies(w http.ResponseWriter, r *http.Request) {
 
 	comment.ID = commentID
 
-	err := comment.GetReplies()
+	err := h.Comments.GetReplies(r.Context(), &comment)
 
 	if err != nil {
-		msg := map[string]string{"error": "Sorry there was an internal server error"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(msg)
+		writeError(w, r, apperr.Internal(err))
 
 		return
 	}
@@ -241,30 +203,30 @@ func GetReplies(w http.ResponseWriter, r *http.Request) {
 
 }
 
-//GetItemComments gets all comments for an item
-func GetItemComments(w http.ResponseWriter, r *http.Request) {
-	id := r.URL.Query().Get("id")
+//GetItemComments gets a page of the comments on an item, oldest first unless asked otherwise
+func (h *Handler) GetItemComments(w http.ResponseWriter, r *http.Request) {
+	values := r.URL.Query()
+	id := values.Get("id")
 
 	if id == "" {
-		msg := map[string]string{"error": "id required"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(msg)
+		writeError(w, r, apperr.BadRequest("id required"))
 
 		return
 	}
 
-	var comment comments.Comment
+	problems := make(map[string]string)
+	q := page.Parse(values, page.Oldest, problems)
+
+	if len(problems) > 0 {
+		writeError(w, r, apperr.Validation("Please correct the invalid parameters", problems))
 
-	comment.ItemID = id
+		return
+	}
 
-	commentArray, err := comment.GetItemComments()
+	commentArray, err := h.Comments.GetItemComments(r.Context(), id, q)
 
 	if err != nil {
-		msg := map[string]string{"error": "Sorry there was an internal server error"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(msg)
+		writeError(w, r, apperr.Internal(err))
 
 		return
 	}
@@ -278,24 +240,18 @@ func GetItemComments(w http.ResponseWriter, r *http.Request) {
 }
 
 //UpdateComment updates a comment
-func UpdateComment(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) UpdateComment(w http.ResponseWriter, r *http.Request) {
 	sessionID := r.Header.Get("sessionID")
-	user, err := getUserFromSession(sessionID)
+	user, err := h.getUserFromSession(r.Context(), sessionID)
 
 	if err != nil {
-		msg := map[string]string{"error": "Sorry there was an internal server error"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(msg)
+		writeError(w, r, apperr.Internal(err))
 
 		return
 	}
 
 	if !user.Active {
-		msg := map[string]string{"error": "Sorry your account isn't activated yet"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(msg)
+		writeError(w, r, apperr.Unauthorized("Sorry your account isn't activated yet"))
 
 		return
 	}
@@ -303,57 +259,52 @@ func UpdateComment(w http.ResponseWriter, r *http.Request) {
 	id := r.URL.Query().Get("id")
 
 	if id == "" {
-		msg := map[string]string{"error": "id required"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(msg)
+		writeError(w, r, apperr.BadRequest("id required"))
 
 		return
 	}
 
 	var comment = &comments.Comment{ID: id}
 
-	err = comment.Get()
+	err = h.Comments.Get(r.Context(), comment)
+
+	if err == comments.ErrNotFound {
+		writeError(w, r, apperr.NotFound(err.Error()))
+
+		return
+	}
 
 	if err != nil {
-		msg := map[string]string{"error": "Sorry there was an internal server error"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(msg)
+		writeError(w, r, apperr.Internal(err))
 
 		return
 	}
 
-	if user.DisplayName != comment.Username {
-		msg := map[string]string{"error": "Sorry you're not authorized to view this page"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusForbidden)
-		json.NewEncoder(w).Encode(msg)
+	if user.ID != comment.UserID {
+		writeError(w, r, apperr.Forbidden("Sorry you're not authorized to view this page"))
 
 		return
 	}
 
-	err = json.NewDecoder(r.Body).Decode(&comment)
+	// only the text can be changed, whatever else the body holds
+	var change struct {
+		Comment string `json:"comment"`
+	}
 
-	if err != nil {
-		log.Println(err)
-		msg := map[string]string{"error": "Sorry there was an internal server error"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(msg)
+	if r.Body == nil || json.NewDecoder(r.Body).Decode(&change) != nil || change.Comment == "" {
+		writeError(w, r, apperr.BadRequest("Please supply the comment text"))
 
 		return
 	}
 
+	comment.Comment = change.Comment
+
 	comment.Replies = []comments.Reply{}
 
-	err = comment.Update()
+	err = h.Comments.Update(r.Context(), comment)
 
 	if err != nil {
-		msg := map[string]string{"error": "Sorry there was an internal server error"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(msg)
+		writeError(w, r, apperr.Internal(err))
 
 		return
 	}
@@ -365,24 +316,18 @@ func UpdateComment(w http.ResponseWriter, r *http.Request) {
 }
 
 //UpdateReply updates a reply
-func UpdateReply(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) UpdateReply(w http.ResponseWriter, r *http.Request) {
 	sessionID := r.Header.Get("sessionID")
-	user, err := getUserFromSession(sessionID)
+	user, err := h.getUserFromSession(r.Context(), sessionID)
 
 	if err != nil {
-		msg := map[string]string{"error": "Sorry there was an internal server error"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(msg)
+		writeError(w, r, apperr.Internal(err))
 
 		return
 	}
 
 	if !user.Active {
-		msg := map[string]string{"error": "Sorry your account isn't activated yet"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(msg)
+		writeError(w, r, apperr.Unauthorized("Sorry your account isn't activated yet"))
 
 		return
 	}
@@ -390,55 +335,50 @@ func UpdateReply(w http.ResponseWriter, r *http.Request) {
 	id := r.URL.Query().Get("id")
 
 	if id == "" {
-		msg := map[string]string{"error": "id required"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(msg)
+		writeError(w, r, apperr.BadRequest("id required"))
 
 		return
 	}
 
 	var reply = &comments.Reply{ID: id}
 
-	err = reply.Get()
+	err = h.Comments.GetReply(r.Context(), reply)
+
+	if err == comments.ErrNotFound {
+		writeError(w, r, apperr.NotFound(err.Error()))
+
+		return
+	}
 
 	if err != nil {
-		msg := map[string]string{"error": "Sorry there was an internal server error"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(msg)
+		writeError(w, r, apperr.Internal(err))
 
 		return
 	}
 
-	if user.DisplayName != reply.Username {
-		msg := map[string]string{"error": "Sorry you're not authorized to view this page"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusForbidden)
-		json.NewEncoder(w).Encode(msg)
+	if user.ID != reply.UserID {
+		writeError(w, r, apperr.Forbidden("Sorry you're not authorized to view this page"))
 
 		return
 	}
 
-	err = json.NewDecoder(r.Body).Decode(&reply)
+	// only the text can be changed, whatever else the body holds
+	var change struct {
+		Comment string `json:"comment"`
+	}
 
-	if err != nil {
-		log.Println(err)
-		msg := map[string]string{"error": "Sorry there was an internal server error"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(msg)
+	if r.Body == nil || json.NewDecoder(r.Body).Decode(&change) != nil || change.Comment == "" {
+		writeError(w, r, apperr.BadRequest("Please supply the comment text"))
 
 		return
 	}
 
-	err = reply.Update()
+	reply.Comment = change.Comment
+
+	err = h.Comments.UpdateReply(r.Context(), reply)
 
 	if err != nil {
-		msg := map[string]string{"error": "Sorry there was an internal server error"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(msg)
+		writeError(w, r, apperr.Internal(err))
 
 		return
 	}
@@ -450,24 +390,18 @@ func UpdateReply(w http.ResponseWriter, r *http.Request) {
 }
 
 //DeleteComment deletes a comment
-func DeleteComment(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) DeleteComment(w http.ResponseWriter, r *http.Request) {
 	sessionID := r.Header.Get("sessionID")
-	user, err := getUserFromSession(sessionID)
+	user, err := h.getUserFromSession(r.Context(), sessionID)
 
 	if err != nil {
-		msg := map[string]string{"error": "Sorry there was an internal server error"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(msg)
+		writeError(w, r, apperr.Internal(err))
 
 		return
 	}
 
 	if !user.Active {
-		msg := map[string]string{"error": "Sorry your account isn't activated yet"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(msg)
+		writeError(w, r, apperr.Unauthorized("Sorry your account isn't activated yet"))
 
 		return
 	}
@@ -475,43 +409,37 @@ func DeleteComment(w http.ResponseWriter, r *http.Request) {
 	id := r.URL.Query().Get("id")
 
 	if id == "" {
-		msg := map[string]string{"error": "id required"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(msg)
+		writeError(w, r, apperr.BadRequest("id required"))
 
 		return
 	}
 
 	var comment = &comments.Comment{ID: id}
 
-	err = comment.Get()
+	err = h.Comments.Get(r.Context(), comment)
+
+	if err == comments.ErrNotFound {
+		writeError(w, r, apperr.NotFound(err.Error()))
+
+		return
+	}
 
 	if err != nil {
-		msg := map[string]string{"error": "Sorry there was an internal server error"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(msg)
+		writeError(w, r, apperr.Internal(err))
 
 		return
 	}
 
-	if user.DisplayName != comment.Username {
-		msg := map[string]string{"error": "Sorry you're not authorized to carry out this activity"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusForbidden)
-		json.NewEncoder(w).Encode(msg)
+	if user.ID != comment.UserID && !user.Role.CanModerate() {
+		writeError(w, r, apperr.Forbidden("Sorry you're not authorized to carry out this activity"))
 
 		return
 	}
 
-	err = comment.Delete()
+	err = h.Comments.Delete(r.Context(), comment)
 
 	if err != nil {
-		msg := map[string]string{"error": "Sorry there was an internal server error"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(msg)
+		writeError(w, r, apperr.Internal(err))
 
 		return
 	}
@@ -525,24 +453,18 @@ func DeleteComment(w http.ResponseWriter, r *http.Request) {
 }
 
 //DeleteReply deletes a reply
-func DeleteReply(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) DeleteReply(w http.ResponseWriter, r *http.Request) {
 	sessionID := r.Header.Get("sessionID")
-	user, err := getUserFromSession(sessionID)
+	user, err := h.getUserFromSession(r.Context(), sessionID)
 
 	if err != nil {
-		msg := map[string]string{"error": "Sorry there was an internal server error"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(msg)
+		writeError(w, r, apperr.Internal(err))
 
 		return
 	}
 
 	if !user.Active {
-		msg := map[string]string{"error": "Sorry your account isn't activated yet"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(msg)
+		writeError(w, r, apperr.Unauthorized("Sorry your account isn't activated yet"))
 
 		return
 	}
@@ -550,10 +472,7 @@ func DeleteReply(w http.ResponseWriter, r *http.Request) {
 	id := r.URL.Query().Get("id")
 
 	if id == "" {
-		msg := map[string]string{"error": "id required"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(msg)
+		writeError(w, r, apperr.BadRequest("id required"))
 
 		return
 	}
@@ -562,43 +481,37 @@ func DeleteReply(w http.ResponseWriter, r *http.Request) {
 	commentID := params["comment_id"]
 
 	if commentID == "" {
-		msg := map[string]string{"error": "comment id required"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(msg)
+		writeError(w, r, apperr.BadRequest("comment id required"))
 
 		return
 	}
 
 	var reply = &comments.Reply{ID: id, CommentID: commentID}
 
-	err = reply.Get()
+	err = h.Comments.GetReply(r.Context(), reply)
+
+	if err == comments.ErrNotFound {
+		writeError(w, r, apperr.NotFound(err.Error()))
+
+		return
+	}
 
 	if err != nil {
-		msg := map[string]string{"error": "Sorry there was an internal server error"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(msg)
+		writeError(w, r, apperr.Internal(err))
 
 		return
 	}
 
-	if user.DisplayName != reply.Username {
-		msg := map[string]string{"error": "Sorry you're not authorized to view this page"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusForbidden)
-		json.NewEncoder(w).Encode(msg)
+	if user.ID != reply.UserID && !user.Role.CanModerate() {
+		writeError(w, r, apperr.Forbidden("Sorry you're not authorized to view this page"))
 
 		return
 	}
 
-	err = reply.Delete()
+	err = h.Comments.DeleteReply(r.Context(), reply)
 
 	if err != nil {
-		msg := map[string]string{"error": "Sorry there was an internal server error"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(msg)
+		writeError(w, r, apperr.Internal(err))
 
 		return
 	}
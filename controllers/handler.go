@@ -0,0 +1,72 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Samuyi/www/apperr"
+	"github.com/Samuyi/www/blobs"
+	"github.com/Samuyi/www/config"
+	"github.com/Samuyi/www/keys"
+	"github.com/Samuyi/www/logging"
+	"github.com/Samuyi/www/models"
+	"github.com/gorilla/sessions"
+)
+
+//Handler serves the api using the stores it is given
+type Handler struct {
+	*models.Stores
+
+	files     blobs.BlobStore
+	uploads   config.Uploads
+	baseURL   string
+	keys      *keys.Manager
+	store     *sessions.CookieStore
+	issuer    string
+	lockout   config.Lockout
+	resetTTL  time.Duration
+	lifetime  time.Duration
+	accessTTL time.Duration
+
+	confirmTTL time.Duration
+
+	expireAfter time.Duration
+}
+
+//NewHandler returns a handler backed by stores, keeping uploads in files, configured from cfg that signs tokens with manager
+func NewHandler(stores *models.Stores, files blobs.BlobStore, cfg *config.Config, manager *keys.Manager) *Handler {
+	return &Handler{
+		Stores:    stores,
+		files:     files,
+		uploads:   cfg.Uploads,
+		baseURL:   strings.TrimSuffix(cfg.Server.BaseURL, "/"),
+		keys:      manager,
+		store:     sessions.NewCookieStore([]byte(cfg.Auth.SessionKey)),
+		issuer:    cfg.Auth.Issuer,
+		lockout:   cfg.Lockout,
+		resetTTL:  cfg.Auth.ResetTokenTTL.Duration,
+		lifetime:  cfg.Auth.SessionLifetime.Duration,
+		accessTTL: cfg.Auth.AccessTokenLifetime.Duration,
+
+		confirmTTL:  cfg.Auth.ConfirmationTTL.Duration,
+		expireAfter: cfg.Items.ExpireAfter.Duration,
+	}
+}
+
+//writeError answers r with err in the json shape of every error, see apperr.Write. The causes of internal errors are
+//never sent, so they are logged here, along with the id of the request.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	var e *apperr.Error
+
+	if !errors.As(err, &e) {
+		e = apperr.Internal(err)
+	}
+
+	if e.Code == apperr.CodeInternal && e.Err != nil {
+		logging.From(r.Context()).Error("answering with an internal error", "err", e.Err)
+	}
+
+	apperr.Write(w, e)
+}
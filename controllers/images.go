@@ -0,0 +1,447 @@
+package controllers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"image"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/Samuyi/www/apperr"
+	"github.com/Samuyi/www/blobs"
+	"github.com/Samuyi/www/logging"
+	"github.com/Samuyi/www/models/items"
+	"github.com/Samuyi/www/models/tokens"
+	"github.com/Samuyi/www/photos"
+	validator "github.com/asaskevich/govalidator"
+	"github.com/gorilla/mux"
+)
+
+//The most pixels a side of the photos of items, their thumbnails and avatars are shown at
+const (
+	photoSize     = 1600
+	thumbnailSize = 320
+	avatarSize    = 256
+)
+
+//fileURL returns where clients can fetch the uploaded file with key, nothing when there is no key
+func (h *Handler) fileURL(key string) string {
+	if key == "" {
+		return ""
+	}
+
+	if h.uploads.PublicURL != "" {
+		return strings.TrimSuffix(h.uploads.PublicURL, "/") + "/" + key
+	}
+
+	return "/api/images/" + key
+}
+
+//deleteFiles deletes uploaded files that are no longer used. Failures only leave files behind, so they are just logged.
+func (h *Handler) deleteFiles(ctx context.Context, keys ...string) {
+	for _, key := range keys {
+		if err := h.files.Delete(ctx, key); err != nil {
+			logging.From(ctx).Error("deleting an uploaded file", "key", key, "err", err)
+		}
+	}
+}
+
+//withImages fills in the images of each of list, with the urls they can be fetched from
+func (h *Handler) withImages(ctx context.Context, list []items.Item) error {
+	if len(list) == 0 {
+		return nil
+	}
+
+	ids := make([]string, 0, len(list))
+
+	for _, item := range list {
+		ids = append(ids, item.ID)
+	}
+
+	images, err := h.Items.Images(ctx, ids)
+
+	if err != nil {
+		return err
+	}
+
+	for i := range list {
+		list[i].Images = images[list[i].ID]
+
+		for j := range list[i].Images {
+			image := &list[i].Images[j]
+			image.URL, image.ThumbnailURL = h.fileURL(image.Key), h.fileURL(image.ThumbnailKey)
+		}
+	}
+
+	return nil
+}
+
+//readUpload reads the image sent in the image field of a multipart form, refusing ones bigger than uploads allow
+func (h *Handler) readUpload(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	limit := strconv.FormatInt(h.uploads.MaxBytes>>10, 10) + "KB"
+
+	if h.uploads.MaxBytes >= 1<<20 {
+		limit = strconv.FormatInt(h.uploads.MaxBytes>>20, 10) + "MB"
+	}
+
+	tooLarge := apperr.TooLarge("Please upload an image of at most " + limit)
+
+	// leave room for the rest of the form around the image
+	r.Body = http.MaxBytesReader(w, r.Body, h.uploads.MaxBytes+64<<10)
+
+	reader, err := r.MultipartReader()
+
+	if err != nil {
+		return nil, apperr.UnsupportedMedia("Please upload the image as multipart/form-data in a field named image")
+	}
+
+	for {
+		part, err := reader.NextPart()
+
+		var maxBytes *http.MaxBytesError
+
+		if errors.As(err, &maxBytes) {
+			return nil, tooLarge
+		}
+
+		if err != nil {
+			logging.From(r.Context()).Debug("reading the multipart form", "err", err)
+			return nil, apperr.BadRequest("Please upload an image in a field named image")
+		}
+
+		if part.FormName() != "image" {
+			continue
+		}
+
+		if !photos.Supported(part.Header.Get("Content-Type")) {
+			return nil, apperr.UnsupportedMedia(photos.ErrUnsupported.Error())
+		}
+
+		data, err := io.ReadAll(io.LimitReader(part, h.uploads.MaxBytes+1))
+
+		if errors.As(err, &maxBytes) || int64(len(data)) > h.uploads.MaxBytes {
+			return nil, tooLarge
+		}
+
+		if err != nil {
+			logging.From(r.Context()).Debug("reading the uploaded image", "err", err)
+			return nil, apperr.BadRequest("Please upload an image in a field named image")
+		}
+
+		return data, nil
+	}
+}
+
+//decodeUpload decodes an uploaded image, turned the right way up
+func (h *Handler) decodeUpload(data []byte) (image.Image, string, error) {
+	img, format, err := photos.Decode(data, h.uploads.MaxPixels)
+
+	switch err {
+	case nil:
+		return img, format, nil
+	case photos.ErrTooManyPixels:
+		return nil, "", apperr.TooLarge(err.Error())
+	}
+
+	return nil, "", apperr.UnsupportedMedia(err.Error())
+}
+
+//putPhotos encodes and keeps the photos of an upload, under the keys made by key from the extension of each.
+//Either every photo is kept, or none is.
+func (h *Handler) putPhotos(ctx context.Context, format string, key func(i int, ext string) string, imgs ...image.Image) ([]string, []photos.Photo, error) {
+	var keys []string
+	var encoded []photos.Photo
+
+	for i, img := range imgs {
+		photo, err := photos.Encode(img, format)
+
+		if err == nil {
+			err = h.files.Put(ctx, key(i, photo.Ext), photo.ContentType, photo.Body)
+		}
+
+		if err != nil {
+			h.deleteFiles(ctx, keys...)
+			return nil, nil, err
+		}
+
+		keys = append(keys, key(i, photo.Ext))
+		encoded = append(encoded, photo)
+	}
+
+	return keys, encoded, nil
+}
+
+//UploadItemImage adds a photo to an item of the signed in user, sent as the image field of a multipart form. It is
+//kept without its metadata, at most photoSize pixels a side, along with a square thumbnail.
+func (h *Handler) UploadItemImage(w http.ResponseWriter, r *http.Request) {
+	item, err := h.editableItem(r)
+
+	if err != nil {
+		writeError(w, r, err)
+
+		return
+	}
+
+	// refuse before reading the upload, adding the image checks again
+	existing, err := h.Items.Images(r.Context(), []string{item.ID})
+
+	if err != nil {
+		writeError(w, r, apperr.Internal(err))
+
+		return
+	}
+
+	if len(existing[item.ID]) >= h.uploads.MaxPerItem {
+		writeError(w, r, apperr.Conflict(items.ErrTooManyImages.Error()))
+
+		return
+	}
+
+	data, err := h.readUpload(w, r)
+
+	if err != nil {
+		writeError(w, r, err)
+
+		return
+	}
+
+	img, format, err := h.decodeUpload(data)
+
+	if err != nil {
+		writeError(w, r, err)
+
+		return
+	}
+
+	name, err := tokens.Random()
+
+	if err != nil {
+		writeError(w, r, apperr.Internal(err))
+
+		return
+	}
+
+	keys, encoded, err := h.putPhotos(r.Context(), format, func(i int, ext string) string {
+		if i == 1 {
+			return "items/" + item.ID + "/" + name + "-thumb." + ext
+		}
+
+		return "items/" + item.ID + "/" + name + "." + ext
+	}, photos.Fit(img, photoSize), photos.Square(img, thumbnailSize))
+
+	if err != nil {
+		writeError(w, r, apperr.Internal(err))
+
+		return
+	}
+
+	var image = &items.Image{ItemID: item.ID, Key: keys[0], ThumbnailKey: keys[1], Width: encoded[0].Width, Height: encoded[0].Height}
+
+	err = h.Items.AddImage(r.Context(), image, h.uploads.MaxPerItem)
+
+	if err != nil {
+		h.deleteFiles(r.Context(), keys...)
+
+		if err == items.ErrTooManyImages {
+			writeError(w, r, apperr.Conflict(err.Error()))
+
+			return
+		}
+
+		writeError(w, r, apperr.Internal(err))
+
+		return
+	}
+
+	image.URL, image.ThumbnailURL = h.fileURL(image.Key), h.fileURL(image.ThumbnailKey)
+
+	w.Header().Set("Content-type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(image)
+}
+
+//DeleteItemImage deletes the photo with image_id from an item of the signed in user
+func (h *Handler) DeleteItemImage(w http.ResponseWriter, r *http.Request) {
+	item, err := h.editableItem(r)
+
+	if err != nil {
+		writeError(w, r, err)
+
+		return
+	}
+
+	var image = &items.Image{ID: r.URL.Query().Get("image_id"), ItemID: item.ID}
+
+	if image.ID == "" {
+		writeError(w, r, apperr.BadRequest("image_id required"))
+
+		return
+	}
+
+	if !validator.IsUUID(image.ID) {
+		writeError(w, r, apperr.NotFound("Sorry that photo doesn't exist"))
+
+		return
+	}
+
+	err = h.Items.RemoveImage(r.Context(), image)
+
+	if err == sql.ErrNoRows {
+		writeError(w, r, apperr.NotFound("Sorry that photo doesn't exist"))
+
+		return
+	}
+
+	if err != nil {
+		writeError(w, r, apperr.Internal(err))
+
+		return
+	}
+
+	h.deleteFiles(r.Context(), image.Key, image.ThumbnailKey)
+
+	msg := map[string]string{"message": "Success!"}
+	w.Header().Set("Content-type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(msg)
+}
+
+//UploadAvatar replaces the avatar of the signed in user with the image field of a multipart form, cropped to a
+//square of avatarSize pixels and kept without its metadata
+func (h *Handler) UploadAvatar(w http.ResponseWriter, r *http.Request) {
+	user, err := h.getUserFromSession(r.Context(), r.Header.Get("sessionID"))
+
+	if err != nil {
+		writeError(w, r, apperr.Internal(err))
+
+		return
+	}
+
+	data, err := h.readUpload(w, r)
+
+	if err != nil {
+		writeError(w, r, err)
+
+		return
+	}
+
+	img, format, err := h.decodeUpload(data)
+
+	if err != nil {
+		writeError(w, r, err)
+
+		return
+	}
+
+	name, err := tokens.Random()
+
+	if err != nil {
+		writeError(w, r, apperr.Internal(err))
+
+		return
+	}
+
+	keys, _, err := h.putPhotos(r.Context(), format, func(i int, ext string) string {
+		return "avatars/" + user.ID + "/" + name + "." + ext
+	}, photos.Square(img, avatarSize))
+
+	if err != nil {
+		writeError(w, r, apperr.Internal(err))
+
+		return
+	}
+
+	user.Avatar = keys[0]
+	previous, err := h.Users.SetAvatar(r.Context(), &user)
+
+	if err != nil {
+		h.deleteFiles(r.Context(), keys...)
+		writeError(w, r, apperr.Internal(err))
+
+		return
+	}
+
+	if previous != "" {
+		h.deleteFiles(r.Context(), previous)
+	}
+
+	resp := map[string]string{
+		"message": "Success!",
+		"avatar":  h.fileURL(user.Avatar),
+	}
+
+	w.Header().Set("Content-type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
+}
+
+//DeleteAvatar removes the avatar of the signed in user
+func (h *Handler) DeleteAvatar(w http.ResponseWriter, r *http.Request) {
+	user, err := h.getUserFromSession(r.Context(), r.Header.Get("sessionID"))
+
+	if err != nil {
+		writeError(w, r, apperr.Internal(err))
+
+		return
+	}
+
+	user.Avatar = ""
+	previous, err := h.Users.SetAvatar(r.Context(), &user)
+
+	if err != nil {
+		writeError(w, r, apperr.Internal(err))
+
+		return
+	}
+
+	if previous != "" {
+		h.deleteFiles(r.Context(), previous)
+	}
+
+	msg := map[string]string{"message": "Success!"}
+	w.Header().Set("Content-type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(msg)
+}
+
+//GetImage serves an uploaded image, for stores that aren't reachable by clients themselves. Keys are never reused,
+//so images can be cached for good.
+func (h *Handler) GetImage(w http.ResponseWriter, r *http.Request) {
+	key := mux.Vars(r)["key"]
+
+	file, err := h.files.Get(r.Context(), key)
+
+	if err == blobs.ErrNotFound || err == blobs.ErrInvalidKey {
+		writeError(w, r, apperr.NotFound("Sorry that image doesn't exist"))
+
+		return
+	}
+
+	if err != nil {
+		writeError(w, r, apperr.Internal(err))
+
+		return
+	}
+	defer file.Close()
+
+	contentType := mime.TypeByExtension(path.Ext(key))
+
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	w.Header().Set("Content-type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.WriteHeader(http.StatusOK)
+
+	if _, err = io.Copy(w, file); err != nil {
+		logging.From(r.Context()).Debug("serving an image", "key", key, "err", err)
+	}
+}
@@ -1,425 +1,452 @@
 package controllers
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
-	"log"
+	"fmt"
 	"net/http"
+	"net/url"
+	"runtime/debug"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/Samuyi/www/apperr"
 	"github.com/Samuyi/www/email"
-	"github.com/Samuyi/www/models/comments"
+	"github.com/Samuyi/www/logging"
+	"github.com/Samuyi/www/metrics"
+	"github.com/Samuyi/www/models/bids"
 	"github.com/Samuyi/www/models/items"
+	"github.com/Samuyi/www/models/page"
+	"github.com/Samuyi/www/models/users"
+	validator "github.com/asaskevich/govalidator"
 	"github.com/gorilla/websocket"
 )
 
-const baseURL = ""
-
 var upgrader = websocket.Upgrader{
 	ReadBufferSize:  1024,
 	WriteBufferSize: 1024,
 }
 
-//CreateItem creates an item
-func CreateItem(w http.ResponseWriter, r *http.Request) {
-	sessionID := r.Header.Get("sessionID")
-	user, err := getUserFromSession(sessionID)
+//maxSearchTerms is the most words a search can be made of
+const maxSearchTerms = 10
 
-	if err != nil {
-		msg := map[string]string{"error": "Sorry there was an internal server error"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(msg)
+//itemQuery reads the page a request asks for of a list of items sorted by fallback unless it asks otherwise, and the
+//filter narrowing it. Lists hold open items unless another status is asked for, drafts only when drafts is set, and
+//can be narrowed down to a city or location, to the items of a user, to a category, taking in the categories under
+//it, and to a condition.
+func itemQuery(values url.Values, fallback page.Sort, drafts bool, problems map[string]string) (items.Filter, page.Query) {
+	q := page.Parse(values, fallback, problems)
 
-		return
+	var filter = items.Filter{Status: items.Status(values.Get("status")), City: strings.ToUpper(values.Get("city")), LocationID: values.Get("location_id"),
+		UserID: values.Get("user"), CategoryID: values.Get("category"), Condition: items.Condition(values.Get("condition"))}
+
+	if filter.Status == "" {
+		filter.Status = items.StatusOpen
 	}
 
-	if !user.Active {
-		msg := map[string]string{"error": "Sorry your account isn't activated yet"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(msg)
+	if !items.ValidStatus(filter.Status) || (filter.Status == items.StatusDraft && !drafts) {
+		problems["status"] = "Please supply a status of open, reserved, handed_over, cancelled or expired"
+	}
 
-		return
+	if filter.LocationID != "" && !validator.IsUUID(filter.LocationID) {
+		problems["location_id"] = "Please supply a valid location id"
 	}
 
-	if r.Body == nil {
-		msg := map[string]string{"error": "Please supply Name, UserID, PhoneNo, LocationID and Instruction"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(msg)
+	if filter.UserID != "" && !validator.IsUUID(filter.UserID) {
+		problems["user"] = "Please supply a valid user id"
+	}
 
-		return
+	if filter.CategoryID != "" && !validator.IsUUID(filter.CategoryID) {
+		problems["category"] = "Please supply a valid category id"
 	}
 
-	var item = &items.Item{}
-	err = json.NewDecoder(r.Body).Decode(&item)
+	if filter.Condition != "" && !items.ValidCondition(filter.Condition) {
+		problems["condition"] = "Please supply a condition of new, good, fair or for-parts"
+	}
 
-	log.Println(item.Location)
+	return filter, q
+}
 
-	if err != nil {
-		log.Println(err)
-		msg := map[string]string{"error": "Please supply a valid name, email and password"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(msg)
+//findItem gets the item with the id in the request
+func (h *Handler) findItem(r *http.Request) (*items.Item, error) {
+	var item = &items.Item{ID: r.URL.Query().Get("id")}
 
-		return
+	if item.ID == "" {
+		return nil, apperr.BadRequest("id required")
 	}
-	item.UserID = user.ID
-	errors := item.Validate()
 
-	if len(errors) > 0 {
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(errors)
-
-		return
+	if !validator.IsUUID(item.ID) {
+		return nil, apperr.NotFound("Sorry that item doesn't exist")
 	}
 
-	err = item.Create()
-
-	if err != nil {
-		if err.Error() == "pq: insert or update on table \"items\" violates foreign key constraint \"items_city_fkey\"" {
-			msg := map[string]string{"error": "Please supply a valid city"}
-			w.Header().Set("Content-type", "application/json")
-			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(msg)
-
-			return
-		}
-		msg := map[string]string{"error": "Sorry there was an internal server error"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(msg)
+	err := h.Items.Get(r.Context(), item)
 
-		return
+	if err == sql.ErrNoRows {
+		return nil, apperr.NotFound("Sorry that item doesn't exist")
 	}
 
-	resp := map[string]string{
-		"message": "success",
+	if err != nil {
+		return nil, apperr.Internal(err)
 	}
 
-	w.Header().Set("Content-type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(resp)
-
-	return
-
+	return item, nil
 }
 
-//GetItem gets an item
-func GetItem(w http.ResponseWriter, r *http.Request) {
-	id := r.URL.Query().Get("id")
+//visibleItem gets the item with the id in the request, unless it is a draft of someone other than userID. Drafts
+//answer the same as items that don't exist, so nobody else learns of them.
+func (h *Handler) visibleItem(r *http.Request, userID string) (*items.Item, error) {
+	item, err := h.findItem(r)
 
-	if id == "" {
-		msg := map[string]string{"error": "id required"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(msg)
+	if err != nil {
+		return nil, err
+	}
 
-		return
+	if item.Status == items.StatusDraft && item.UserID != userID {
+		return nil, apperr.NotFound("Sorry that item doesn't exist")
 	}
 
-	var item = &items.Item{}
-	item.ID = id
+	return item, nil
+}
 
-	err := item.Get()
+//ownedItem gets the item with the id in the request, if the signed in user owns it
+func (h *Handler) ownedItem(r *http.Request) (*items.Item, error) {
+	user, err := h.getUserFromSession(r.Context(), r.Header.Get("sessionID"))
 
 	if err != nil {
-		msg := map[string]string{"error": "Please try again later"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(msg)
-
-		return
+		return nil, apperr.Internal(err)
 	}
 
-	var comment comments.Comment
-
-	comment.ItemID = id
+	if !user.Active {
+		return nil, apperr.Unauthorized("Sorry your account isn't activated yet")
+	}
 
-	itemComments, err := comment.GetItemComments()
+	item, err := h.findItem(r)
 
 	if err != nil {
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(item)
-
-		return
+		return nil, err
 	}
 
-	item.Comments = itemComments
-
-	w.Header().Set("Content-type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(item)
+	if user.ID != item.UserID {
+		return nil, apperr.Forbidden("Sorry you're not authorized to make a change here")
+	}
 
-	return
+	return item, nil
 }
 
-//GetItemsInALocation gets all items in a particular location not yet closed
-func GetItemsInALocation(w http.ResponseWriter, r *http.Request) {
-	locationID := r.URL.Query().Get("location_id")
-
-	if locationID == "" {
-		msg := map[string]string{"error": "location_id required"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(msg)
+//editableItem gets the item with the id in the request, if the signed in user owns it and can still change it
+func (h *Handler) editableItem(r *http.Request) (*items.Item, error) {
+	item, err := h.ownedItem(r)
 
-		return
-	}
-	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Println(err)
-		msg := map[string]string{"error": "Please try again later"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(msg)
-
-		return
+		return nil, err
 	}
 
-	go func(conn *websocket.Conn) {
-		c := time.Tick(2 * time.Minute)
+	if !item.Status.Editable() {
+		return nil, apperr.Forbidden("Sorry that item can't be changed once it is " + describe(item.Status))
+	}
 
-		for range c {
+	return item, nil
+}
 
-			var itemArray *items.Item
-			itemArray.Location.LocationID = locationID
+//listItems answers with the page returned by fetch, with the images of its items. Websocket requests are sent it straight away and then every
+//two minutes instead.
+func (h *Handler) listItems(w http.ResponseWriter, r *http.Request, fetch func(context.Context) (page.Page[items.Item], error)) {
+	list := fetch
 
-			resp, err := itemArray.ItemsInALocation()
-			if err != nil {
-				conn.Close()
+	fetch = func(ctx context.Context) (page.Page[items.Item], error) {
+		resp, err := list(ctx)
 
-				return
-			}
+		if err == nil {
+			err = h.withImages(ctx, resp.Items)
+		}
 
-			err = conn.WriteJSON(resp)
+		return resp, err
+	}
 
-			if err != nil {
-				log.Println(err)
-				conn.Close()
+	if !websocket.IsWebSocketUpgrade(r) {
+		resp, err := fetch(r.Context())
 
-				return
-			}
+		if err != nil {
+			writeError(w, r, apperr.Internal(err))
 
+			return
 		}
-	}(conn)
 
-}
+		w.Header().Set("Content-type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(resp)
+
+		return
+	}
 
-//GetAllItems gets all items not yet closed
-func GetAllItems(w http.ResponseWriter, r *http.Request) {
-	var itemsList *items.Item
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Println(err)
-		msg := map[string]string{"error": "Please try again later"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(msg)
+		logging.From(r.Context()).Debug("upgrading to a websocket", "err", err)
+		writeError(w, r, apperr.Internal(err))
 
 		return
 	}
 
-	go func(conn *websocket.Conn) {
-		c := time.Tick(2 * time.Minute)
+	go h.streamItems(context.WithoutCancel(r.Context()), conn, fetch)
+}
+
+//streamItems writes the page returned by fetch to conn straight away and then every two minutes until either fails.
+//It outlives the request that opened conn, so ctx shouldn't be cancelled with it.
+func (h *Handler) streamItems(ctx context.Context, conn *websocket.Conn, fetch func(context.Context) (page.Page[items.Item], error)) {
+	defer conn.Close()
 
-		for range c {
-			resp, err := itemsList.GetAllItems()
+	// the stream runs after the request was served, out of reach of the middleware that recovers panics
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			metrics.Panics.Add(1)
+			logging.From(ctx).Error("panic streaming items", "panic", fmt.Sprint(recovered), "stack", string(debug.Stack()))
+		}
+	}()
 
-			if err != nil {
-				conn.Close()
-			}
+	ticker := time.NewTicker(2 * time.Minute)
+	defer ticker.Stop()
 
-			err = conn.WriteJSON(resp)
+	for {
+		resp, err := fetch(ctx)
 
-			if err != nil {
-				log.Println(err)
-				conn.Close()
+		if err != nil {
+			logging.From(ctx).Error("fetching items to stream", "err", err)
+			return
+		}
 
-				return
-			}
+		err = conn.WriteJSON(resp)
 
+		if err != nil {
+			logging.From(ctx).Debug("streaming items", "err", err)
+			return
 		}
-	}(conn)
 
+		<-ticker.C
+	}
 }
 
-//BidItem bids for an item not yet closed
-func BidItem(w http.ResponseWriter, r *http.Request) {
+//CreateItem creates an item
+func (h *Handler) CreateItem(w http.ResponseWriter, r *http.Request) {
 	sessionID := r.Header.Get("sessionID")
-	user, err := getUserFromSession(sessionID)
+	user, err := h.getUserFromSession(r.Context(), sessionID)
 
 	if err != nil {
-		msg := map[string]string{"error": "Sorry there was an internal server error"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(msg)
+		writeError(w, r, apperr.Internal(err))
 
 		return
 	}
 
 	if !user.Active {
-		msg := map[string]string{"error": "Sorry your account isn't activated yet"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(msg)
+		writeError(w, r, apperr.Unauthorized("Sorry your account isn't activated yet"))
 
 		return
 	}
 
-	id := r.URL.Query().Get("id")
-
-	if id == "" {
-		msg := map[string]string{"error": "id required"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(msg)
+	if r.Body == nil {
+		writeError(w, r, apperr.BadRequest("Please supply the name, phone number, location and instructions of the item"))
 
 		return
 	}
 
-	if r.Body == nil {
-		msg := map[string]string{"error": "Please supply reasons for your bid"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(msg)
+	var item = &items.Item{}
+	err = json.NewDecoder(r.Body).Decode(&item)
+
+	if err != nil {
+		logging.From(r.Context()).Debug("decoding the request body", "err", err)
+		writeError(w, r, apperr.BadRequest("Please supply the name, phone number, location and instructions of the item"))
 
 		return
 	}
+	item.UserID = user.ID
+	item.RecipientID = ""
 
-	var item = &items.Item{ID: id}
+	if item.Status == "" {
+		item.Status = items.StatusOpen
+	}
 
-	err = item.Get()
+	errors := item.Validate()
 
-	if err != nil {
-		msg := map[string]string{"error": "Sorry there was an internal server error"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(msg)
+	if item.Status != items.StatusDraft && item.Status != items.StatusOpen {
+		if errors == nil {
+			errors = make(map[string]string)
+		}
+
+		errors["status"] = "New items can only be a draft or open"
+	}
+
+	if len(errors) > 0 {
+		writeError(w, r, apperr.Validation("Please correct the invalid fields", errors))
 
 		return
 	}
 
-	if item.Closed {
-		msg := map[string]string{"error": "Sorry item has been closed"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(msg)
+	err = h.Items.Create(r.Context(), item)
+
+	if err != nil {
+		if err == items.ErrUnknownCity {
+			writeError(w, r, apperr.BadRequest("Please supply a valid city"))
+
+			return
+		}
+		if err == items.ErrUnknownCategory {
+			writeError(w, r, apperr.Validation("Please correct the invalid fields", map[string]string{"category_id": err.Error()}))
+
+			return
+		}
+		writeError(w, r, apperr.Internal(err))
 
 		return
 	}
 
-	var msg = make(map[string]interface{})
+	resp := map[string]string{
+		"message": "success",
+		"id":      item.ID,
+	}
+
+	w.Header().Set("Content-type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(resp)
+
+	return
+
+}
 
-	err = json.NewDecoder(r.Body).Decode(&msg)
+//GetItem gets an item
+func (h *Handler) GetItem(w http.ResponseWriter, r *http.Request) {
+	// drafts are only listed to their owners, at /api/items/mine
+	item, err := h.visibleItem(r, "")
 
 	if err != nil {
-		log.Println(err)
-		res := map[string]string{"error": "Please supply a valid message"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(res)
+		writeError(w, r, err)
 
 		return
 	}
-	key := id + ":bids"
-	_, err = client.HSet(key, user.DisplayName, msg["message"]).Result()
 
-	if err != nil {
-		log.Println(err)
-		res := map[string]string{"error": "Please try again later there was an error"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(res)
+	list := []items.Item{*item}
+
+	if err = h.withImages(r.Context(), list); err != nil {
+		writeError(w, r, apperr.Internal(err))
 
 		return
 	}
 
-	var mail = &email.Mail{To: item.UserEmail}
+	item = &list[0]
 
-	go mail.SendBidAlertMail(item.DisplayName, baseURL+"/?id="+id)
+	itemComments, err := h.Comments.GetItemComments(r.Context(), item.ID, page.Query{Limit: page.DefaultLimit, Sort: page.Oldest})
 
-	res := map[string]string{
-		"message": "success",
+	if err != nil {
+		w.Header().Set("Content-type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(item)
+
+		return
 	}
 
+	item.Comments = itemComments.Items
+
 	w.Header().Set("Content-type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(res)
+	json.NewEncoder(w).Encode(item)
 
 	return
 }
 
-//GetBidsOnItem gets all bids for an item
-func GetBidsOnItem(w http.ResponseWriter, r *http.Request) {
-	sessionID := r.Header.Get("sessionID")
-	user, err := getUserFromSession(sessionID)
+//GetItemsInALocation gets a page of the items in a particular location, see itemQuery
+func (h *Handler) GetItemsInALocation(w http.ResponseWriter, r *http.Request) {
+	values := r.URL.Query()
+	locationID := values.Get("location_id")
 
-	if err != nil {
-		msg := map[string]string{"error": "Sorry there was an internal server error"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(msg)
+	if locationID == "" {
+		writeError(w, r, apperr.BadRequest("location_id required"))
 
 		return
 	}
 
-	if !user.Active {
-		msg := map[string]string{"error": "Sorry your account isn't activated yet"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(msg)
+	problems := make(map[string]string)
+	filter, q := itemQuery(values, page.Newest, false, problems)
+
+	if len(problems) > 0 {
+		writeError(w, r, apperr.Validation("Please correct the invalid parameters", problems))
 
 		return
 	}
 
-	id := r.URL.Query().Get("id")
+	h.listItems(w, r, func(ctx context.Context) (page.Page[items.Item], error) {
+		return h.Items.ItemsInALocation(ctx, locationID, filter, q)
+	})
+}
 
-	log.Println("i'm here")
+//GetAllItems gets a page of the items, see itemQuery
+func (h *Handler) GetAllItems(w http.ResponseWriter, r *http.Request) {
+	problems := make(map[string]string)
+	filter, q := itemQuery(r.URL.Query(), page.Newest, false, problems)
 
-	if id == "" {
-		msg := map[string]string{"error": "id required"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(msg)
+	if len(problems) > 0 {
+		writeError(w, r, apperr.Validation("Please correct the invalid parameters", problems))
 
 		return
 	}
 
-	var item = &items.Item{ID: id}
+	h.listItems(w, r, func(ctx context.Context) (page.Page[items.Item], error) {
+		return h.Items.GetAllItems(ctx, filter, q)
+	})
+}
 
-	err = item.Get()
+//GetMyItems gets a page of the items of the signed in user, their drafts included, see itemQuery
+func (h *Handler) GetMyItems(w http.ResponseWriter, r *http.Request) {
+	user, err := h.getUserFromSession(r.Context(), r.Header.Get("sessionID"))
 
 	if err != nil {
-		msg := map[string]string{"error": "Sorry there was an internal server error"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(msg)
+		writeError(w, r, apperr.Internal(err))
 
 		return
 	}
 
-	if user.ID != item.UserID {
-		msg := map[string]string{"error": "Sorry you're not authorized to view this page"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusForbidden)
-		json.NewEncoder(w).Encode(msg)
+	problems := make(map[string]string)
+	filter, q := itemQuery(r.URL.Query(), page.Newest, true, problems)
+
+	if len(problems) > 0 {
+		writeError(w, r, apperr.Validation("Please correct the invalid parameters", problems))
 
 		return
 	}
 
-	key := id + ":bids"
-	resp, err := client.HGetAll(key).Result()
+	filter.UserID = user.ID
+
+	h.listItems(w, r, func(ctx context.Context) (page.Page[items.Item], error) {
+		return h.Items.GetAllItems(ctx, filter, q)
+	})
+}
+
+//SearchItems gets a page of the items matching the words in q, the best matches first unless asked otherwise, each
+//with a snippet of its text highlighting them. It takes the filters of the other lists of items, see itemQuery.
+func (h *Handler) SearchItems(w http.ResponseWriter, r *http.Request) {
+	values := r.URL.Query()
+	search := values.Get("q")
+
+	problems := make(map[string]string)
+	filter, q := itemQuery(values, page.Relevance, false, problems)
+
+	if terms := items.Terms(search); len(terms) == 0 || len(terms) > maxSearchTerms {
+		problems["q"] = "Please supply between 1 and " + strconv.Itoa(maxSearchTerms) + " words to search for"
+	}
+
+	if len(problems) > 0 {
+		writeError(w, r, apperr.Validation("Please correct the invalid parameters", problems))
+
+		return
+	}
+
+	resp, err := h.Items.Search(r.Context(), search, filter, q)
+
+	if err == nil {
+		err = h.withImages(r.Context(), resp.Items)
+	}
 
 	if err != nil {
-		log.Println(err)
-		msg := map[string]string{"error": "Sorry there was an internal server error"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(msg)
+		writeError(w, r, apperr.Internal(err))
 
 		return
 	}
@@ -431,164 +458,186 @@ func GetBidsOnItem(w http.ResponseWriter, r *http.Request) {
 	return
 }
 
-//CloseItem closes an item from people biding
-func CloseItem(w http.ResponseWriter, r *http.Request) {
+//BidItem bids for an open item
+func (h *Handler) BidItem(w http.ResponseWriter, r *http.Request) {
 	sessionID := r.Header.Get("sessionID")
-	user, err := getUserFromSession(sessionID)
+	user, err := h.getUserFromSession(r.Context(), sessionID)
 
 	if err != nil {
-		msg := map[string]string{"error": "Sorry there was an internal server error"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(msg)
+		writeError(w, r, apperr.Internal(err))
 
 		return
 	}
 
 	if !user.Active {
-		msg := map[string]string{"error": "Sorry your account isn't activated yet"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(msg)
+		writeError(w, r, apperr.Unauthorized("Sorry your account isn't activated yet"))
 
 		return
 	}
 
-	id := r.URL.Query().Get("id")
+	item, err := h.visibleItem(r, user.ID)
 
-	if id == "" {
-		msg := map[string]string{"error": "id required"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(msg)
+	if err != nil {
+		writeError(w, r, err)
 
 		return
 	}
 
-	var item = &items.Item{ID: id}
+	if r.Body == nil {
+		writeError(w, r, apperr.BadRequest("Please supply reasons for your bid"))
 
-	err = item.Get()
+		return
+	}
 
-	if err != nil {
-		msg := map[string]string{"error": "Sorry there was an internal server error"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(msg)
+	if item.Status != items.StatusOpen {
+		writeError(w, r, apperr.BadRequest("Sorry that item is "+describe(item.Status)+", it no longer takes bids"))
 
 		return
 	}
 
-	if user.ID != item.UserID {
-		msg := map[string]string{"error": "Sorry you're not authorized to view this page"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusForbidden)
-		json.NewEncoder(w).Encode(msg)
+	var bid = &bids.Bid{}
+
+	err = json.NewDecoder(r.Body).Decode(bid)
+
+	if err != nil {
+		logging.From(r.Context()).Debug("decoding the request body", "err", err)
+		writeError(w, r, apperr.BadRequest("Please supply a valid message"))
 
 		return
 	}
 
-	item.Closed = true
+	bid.ItemID = item.ID
+	bid.Username = user.DisplayName
 
-	err = item.Update()
+	err = h.Bids.Place(r.Context(), bid)
 
 	if err != nil {
-		msg := map[string]string{"error": "Sorry there was an internal server error"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(msg)
+		writeError(w, r, apperr.Internal(err))
 
 		return
 	}
 
+	var owner = &users.User{ID: item.UserID}
+
+	if err = h.Users.Get(r.Context(), owner); err != nil {
+		logging.From(r.Context()).Error("fetching the owner of an item", "item_id", item.ID, "err", err)
+	}
+
+	var mail = &email.Mail{To: item.UserEmail, Locale: owner.Locale}
+
+	err = mail.SendBidAlertMail(r.Context(), item.DisplayName, h.baseURL+"/?id="+item.ID)
+
+	if err != nil {
+		logging.From(r.Context()).Error("sending a bid alert", "item_id", item.ID, "err", err)
+	}
+
+	res := map[string]string{
+		"message": "success",
+	}
+
 	w.Header().Set("Content-type", "application/json")
 	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(res)
 
 	return
 }
 
-//UpdateItem updates an item
-func UpdateItem(w http.ResponseWriter, r *http.Request) {
+//GetBidsOnItem gets all bids for an item
+func (h *Handler) GetBidsOnItem(w http.ResponseWriter, r *http.Request) {
 	sessionID := r.Header.Get("sessionID")
-	user, err := getUserFromSession(sessionID)
+	user, err := h.getUserFromSession(r.Context(), sessionID)
 
 	if err != nil {
-		msg := map[string]string{"error": "Sorry there was an internal server error"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(msg)
+		writeError(w, r, apperr.Internal(err))
 
 		return
 	}
 
 	if !user.Active {
-		msg := map[string]string{"error": "Sorry your account isn't activated yet"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(msg)
+		writeError(w, r, apperr.Unauthorized("Sorry your account isn't activated yet"))
 
 		return
 	}
 
-	id := r.URL.Query().Get("id")
+	item, err := h.visibleItem(r, user.ID)
 
-	if id == "" {
-		msg := map[string]string{"error": "id required"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(msg)
+	if err != nil {
+		writeError(w, r, err)
 
 		return
 	}
 
-	var item = &items.Item{ID: id}
+	if user.ID != item.UserID {
+		writeError(w, r, apperr.Forbidden("Sorry you're not authorized to view this page"))
+
+		return
+	}
 
-	err = item.Get()
+	itemBids, err := h.Bids.GetItemBids(r.Context(), item.ID)
 
 	if err != nil {
-		msg := map[string]string{"error": "Sorry there was an internal server error"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(msg)
+		writeError(w, r, apperr.Internal(err))
 
 		return
 	}
 
-	if user.ID != item.UserID {
-		msg := map[string]string{"error": "Sorry you're not authorized to make a change here"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusForbidden)
-		json.NewEncoder(w).Encode(msg)
+	resp := make(map[string]string, len(itemBids))
+
+	for _, bid := range itemBids {
+		resp[bid.Username] = bid.Message
+	}
+
+	w.Header().Set("Content-type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
+
+	return
+}
+
+//UpdateItem updates the details of an item of the signed in user, until it is reserved. Its status only moves on
+//through SetItemStatus.
+func (h *Handler) UpdateItem(w http.ResponseWriter, r *http.Request) {
+	item, err := h.editableItem(r)
+
+	if err != nil {
+		writeError(w, r, err)
 
 		return
 	}
-	if item.Closed {
-		msg := map[string]string{"error": "Sorry item is closed already"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusForbidden)
-		json.NewEncoder(w).Encode(msg)
+
+	// only these can be changed, anything else in the body is left as it is
+	var changes = struct {
+		Name        string          `json:"name"`
+		PhoneNo     string          `json:"phone_no"`
+		Instruction string          `json:"instruction"`
+		CategoryID  string          `json:"category_id"`
+		Condition   items.Condition `json:"condition"`
+	}{item.Name, item.PhoneNo, item.Instruction, item.CategoryID, item.Condition}
+
+	if r.Body == nil || json.NewDecoder(r.Body).Decode(&changes) != nil {
+		writeError(w, r, apperr.BadRequest("Please supply the changes to the item"))
 
 		return
 	}
 
-	err = json.NewDecoder(r.Body).Decode(&item)
+	item.Name, item.PhoneNo, item.Instruction, item.CategoryID, item.Condition = changes.Name, changes.PhoneNo, changes.Instruction, changes.CategoryID, changes.Condition
 
-	if err != nil {
-		log.Println(err)
-		msg := map[string]string{"error": "Sorry there was an internal server error"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(msg)
+	if errors := item.Validate(); len(errors) > 0 {
+		writeError(w, r, apperr.Validation("Please correct the invalid fields", errors))
 
 		return
 	}
 
-	err = item.Update()
+	err = h.Items.Update(r.Context(), item)
+
+	if err == items.ErrUnknownCategory {
+		writeError(w, r, apperr.Validation("Please correct the invalid fields", map[string]string{"category_id": err.Error()}))
+
+		return
+	}
 
 	if err != nil {
-		msg := map[string]string{"error": "Sorry there was an internal server error"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(msg)
+		writeError(w, r, apperr.Internal(err))
 
 		return
 	}
@@ -0,0 +1,14 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+//GetJWKS publishes the public keys tokens can be verified with
+func (h *Handler) GetJWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(h.keys.JWKS())
+}
@@ -0,0 +1,235 @@
+package controllers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Samuyi/www/apperr"
+	"github.com/Samuyi/www/email"
+	"github.com/Samuyi/www/logging"
+	"github.com/Samuyi/www/models/items"
+	"github.com/Samuyi/www/models/users"
+	validator "github.com/asaskevich/govalidator"
+)
+
+//statusChange is a request to move an item on to Status, reserving it for RecipientID
+type statusChange struct {
+	Status      items.Status `json:"status"`
+	RecipientID string       `json:"recipient_id"`
+}
+
+//describe returns status the way it is written in messages
+func describe(status items.Status) string {
+	return strings.ReplaceAll(string(status), "_", " ")
+}
+
+//notifyStatus emails the owner of item that it moved on to its status, and recipientID, who it is or was reserved for,
+//when there is one. Failures are only logged, the move is made either way.
+func (h *Handler) notifyStatus(ctx context.Context, item *items.Item, recipientID string) {
+	url := h.baseURL + "/?id=" + item.ID
+
+	for _, id := range []string{item.UserID, recipientID} {
+		if id == "" {
+			continue
+		}
+
+		var user = &users.User{ID: id}
+
+		if err := h.Users.Get(ctx, user); err != nil {
+			logging.From(ctx).Error("fetching who to tell an item changed status", "item_id", item.ID, "user_id", id, "err", err)
+			continue
+		}
+
+		var mail = &email.Mail{To: user.Email, Locale: user.Locale}
+
+		if err := mail.SendItemStatusMail(ctx, user.DisplayName, item.Name, string(item.Status), url, id != item.UserID); err != nil {
+			logging.From(ctx).Error("sending an item status email", "item_id", item.ID, "user_id", id, "err", err)
+		}
+	}
+}
+
+//recipient checks the user an item is being reserved for, who has to be an active user other than its owner
+func (h *Handler) recipient(ctx context.Context, item *items.Item, id string) error {
+	invalid := func(message string) error {
+		return apperr.Validation("Please correct the invalid fields", map[string]string{"recipient_id": message})
+	}
+
+	if id == "" {
+		return invalid("Please choose who to reserve the item for")
+	}
+
+	if !validator.IsUUID(id) || id == item.UserID {
+		return invalid("Please supply a valid user id")
+	}
+
+	var user = &users.User{ID: id}
+
+	err := h.Users.Get(ctx, user)
+
+	if err == sql.ErrNoRows {
+		return invalid("Please supply a valid user id")
+	}
+
+	if err != nil {
+		return apperr.Internal(err)
+	}
+
+	if !user.Active {
+		return invalid("Sorry that user hasn't activated their account yet")
+	}
+
+	return nil
+}
+
+//SetItemStatus moves an item of the signed in user on to the status in the request. Items are reserved for a
+//recipient_id, and handed over to whoever they were reserved for. Every move is recorded and emailed about.
+func (h *Handler) SetItemStatus(w http.ResponseWriter, r *http.Request) {
+	item, err := h.ownedItem(r)
+
+	if err != nil {
+		writeError(w, r, err)
+
+		return
+	}
+
+	var change statusChange
+
+	if r.Body == nil || json.NewDecoder(r.Body).Decode(&change) != nil {
+		writeError(w, r, apperr.BadRequest("Please supply the status to move the item on to"))
+
+		return
+	}
+
+	if !items.ValidStatus(change.Status) {
+		writeError(w, r, apperr.Validation("Please correct the invalid fields", map[string]string{"status": "Please supply a status of open, reserved, handed_over or cancelled"}))
+
+		return
+	}
+
+	// nobody makes items expire, they do once they have been open long enough
+	if change.Status == items.StatusExpired || !item.Status.CanMove(change.Status) {
+		writeError(w, r, apperr.Conflict("Sorry that item is "+describe(item.Status)+", it can't become "+describe(change.Status)))
+
+		return
+	}
+
+	// items are handed over to who they were reserved for, and stop being reserved for anyone otherwise
+	var transition = &items.Transition{To: change.Status, UserID: item.UserID}
+	previous := item.RecipientID
+
+	switch change.Status {
+	case items.StatusReserved:
+		if err = h.recipient(r.Context(), item, change.RecipientID); err != nil {
+			writeError(w, r, err)
+
+			return
+		}
+
+		transition.RecipientID = change.RecipientID
+	case items.StatusHandedOver:
+		transition.RecipientID = item.RecipientID
+	default:
+		if change.RecipientID != "" {
+			writeError(w, r, apperr.Validation("Please correct the invalid fields", map[string]string{"recipient_id": "Only reserving an item takes a recipient"}))
+
+			return
+		}
+	}
+
+	err = h.Items.Transition(r.Context(), item, transition)
+
+	if err == items.ErrStatusChanged {
+		writeError(w, r, apperr.Conflict(err.Error()))
+
+		return
+	}
+
+	if err != nil {
+		writeError(w, r, apperr.Internal(err))
+
+		return
+	}
+
+	if transition.RecipientID != "" {
+		previous = transition.RecipientID
+	}
+
+	h.notifyStatus(r.Context(), item, previous)
+
+	w.Header().Set("Content-type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(transition)
+
+	return
+}
+
+//GetItemHistory gets every change of status of an item, oldest first. Only its owner and moderators can see it.
+func (h *Handler) GetItemHistory(w http.ResponseWriter, r *http.Request) {
+	user, err := h.getUserFromSession(r.Context(), r.Header.Get("sessionID"))
+
+	if err != nil {
+		writeError(w, r, apperr.Internal(err))
+
+		return
+	}
+
+	item, err := h.findItem(r)
+
+	if err != nil {
+		writeError(w, r, err)
+
+		return
+	}
+
+	if user.ID != item.UserID && !user.Role.CanModerate() {
+		writeError(w, r, apperr.Forbidden("Sorry you're not authorized to view this page"))
+
+		return
+	}
+
+	history, err := h.Items.History(r.Context(), item.ID)
+
+	if err != nil {
+		writeError(w, r, apperr.Internal(err))
+
+		return
+	}
+
+	if history == nil {
+		history = []items.Transition{}
+	}
+
+	w.Header().Set("Content-type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(history)
+
+	return
+}
+
+//ExpireItems moves the items that have been open since before now less the time items stay listed on to expired,
+//emailing their owners. It does nothing when items don't expire.
+func (h *Handler) ExpireItems(ctx context.Context, now time.Time) error {
+	if h.expireAfter <= 0 {
+		return nil
+	}
+
+	expired, err := h.Items.Expire(ctx, now.Add(-h.expireAfter))
+
+	if err != nil {
+		return err
+	}
+
+	if len(expired) > 0 {
+		logging.From(ctx).Info("expired items", "count", len(expired))
+	}
+
+	for i := range expired {
+		h.notifyStatus(ctx, &expired[i], "")
+	}
+
+	return nil
+}
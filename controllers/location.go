@@ -1,32 +1,31 @@
 package controllers
 
 import (
+	"database/sql"
 	"encoding/json"
-	"log"
 	"net/http"
+	"strings"
 
+	"github.com/Samuyi/www/apperr"
+	"github.com/Samuyi/www/logging"
 	"github.com/Samuyi/www/models/locations"
+	"github.com/Samuyi/www/models/page"
+	validator "github.com/asaskevich/govalidator"
 )
 
 //CreateLocation creates a location
-func CreateLocation(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) CreateLocation(w http.ResponseWriter, r *http.Request) {
 	sessionID := r.Header.Get("sessionID")
-	user, err := getUserFromSession(sessionID)
+	user, err := h.getUserFromSession(r.Context(), sessionID)
 
 	if err != nil {
-		msg := map[string]string{"error": "Sorry there was an internal server error"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(msg)
+		writeError(w, r, apperr.Internal(err))
 
 		return
 	}
 
 	if !user.Active {
-		msg := map[string]string{"error": "Sorry your account isn't activated yet"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(msg)
+		writeError(w, r, apperr.Unauthorized("Sorry your account isn't activated yet"))
 
 		return
 	}
@@ -34,20 +33,16 @@ func CreateLocation(w http.ResponseWriter, r *http.Request) {
 	var location = &locations.Location{}
 
 	if r.Body == nil {
-		msg := map[string]string{"error": "Please supply a name, state and country code of a location"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(msg)
+		writeError(w, r, apperr.BadRequest("Please supply a name, state and country code of a location"))
+
+		return
 	}
 
 	err = json.NewDecoder(r.Body).Decode(&location)
 
 	if err != nil {
-		log.Println(err)
-		msg := map[string]string{"error": "Please supply a valid name, email and password"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(msg)
+		logging.From(r.Context()).Debug("decoding the request body", "err", err)
+		writeError(w, r, apperr.BadRequest("Please supply a valid city, state and country code of a location"))
 
 		return
 	}
@@ -56,20 +51,15 @@ func CreateLocation(w http.ResponseWriter, r *http.Request) {
 	errors := location.Validate()
 
 	if len(errors) > 0 {
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(errors)
+		writeError(w, r, apperr.Validation("Please correct the invalid fields", errors))
 
 		return
 	}
 
-	err = location.Create()
+	err = h.Locations.Create(r.Context(), location)
 
 	if err != nil {
-		msg := map[string]string{"error": "Sorry there was an internal server error"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(msg)
+		writeError(w, r, apperr.Internal(err))
 
 		return
 	}
@@ -83,17 +73,31 @@ func CreateLocation(w http.ResponseWriter, r *http.Request) {
 
 }
 
-//GetLocations gets all locations
-func GetLocations(w http.ResponseWriter, r *http.Request) {
-	var location = &locations.Location{}
+//GetLocations gets a page of the locations, oldest first unless asked otherwise.
+//They can be narrowed down to a country, by its code, and a state.
+func (h *Handler) GetLocations(w http.ResponseWriter, r *http.Request) {
+	values := r.URL.Query()
+	problems := make(map[string]string)
+	q := page.Parse(values, page.Oldest, problems)
+
+	// normalizing gives the names locations are stored under
+	var place = locations.Location{CountryCode: strings.ToUpper(values.Get("country_code")), State: values.Get("state")}
+	place.Normalize()
+
+	if place.CountryCode != "" && place.Country == "" {
+		problems["country_code"] = "Please supply a valid country code"
+	}
+
+	if len(problems) > 0 {
+		writeError(w, r, apperr.Validation("Please correct the invalid parameters", problems))
+
+		return
+	}
 
-	locations, err := location.GetAll()
+	locations, err := h.Locations.GetAll(r.Context(), locations.Filter{Country: place.Country, State: place.State}, q)
 
 	if err != nil {
-		msg := map[string]string{"error": "Sorry there was an internal server error"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(msg)
+		writeError(w, r, apperr.Internal(err))
 
 		return
 	}
@@ -106,14 +110,11 @@ func GetLocations(w http.ResponseWriter, r *http.Request) {
 }
 
 //GetLocation gets a location
-func GetLocation(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) GetLocation(w http.ResponseWriter, r *http.Request) {
 	id := r.URL.Query().Get("id")
 
 	if id == "" {
-		msg := map[string]string{"error": "id required"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(msg)
+		writeError(w, r, apperr.BadRequest("id required"))
 
 		return
 	}
@@ -122,13 +123,20 @@ func GetLocation(w http.ResponseWriter, r *http.Request) {
 
 	location.LocationID = id
 
-	err := location.Get()
+	err := sql.ErrNoRows
+
+	if validator.IsUUID(id) {
+		err = h.Locations.Get(r.Context(), location)
+	}
+
+	if err == sql.ErrNoRows {
+		writeError(w, r, apperr.NotFound("Sorry that location doesn't exist"))
+
+		return
+	}
 
 	if err != nil {
-		msg := map[string]string{"error": "Sorry there was an internal server error"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(msg)
+		writeError(w, r, apperr.Internal(err))
 
 		return
 	}
@@ -142,24 +150,18 @@ func GetLocation(w http.ResponseWriter, r *http.Request) {
 }
 
 //UpdateLocation updates a location
-func UpdateLocation(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) UpdateLocation(w http.ResponseWriter, r *http.Request) {
 	sessionID := r.Header.Get("sessionID")
-	user, err := getUserFromSession(sessionID)
+	user, err := h.getUserFromSession(r.Context(), sessionID)
 
 	if err != nil {
-		msg := map[string]string{"error": "Sorry there was an internal server error"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(msg)
+		writeError(w, r, apperr.Internal(err))
 
 		return
 	}
 
 	if !user.Active {
-		msg := map[string]string{"error": "Sorry your account isn't activated yet"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(msg)
+		writeError(w, r, apperr.Unauthorized("Sorry your account isn't activated yet"))
 
 		return
 	}
@@ -167,21 +169,39 @@ func UpdateLocation(w http.ResponseWriter, r *http.Request) {
 	id := r.URL.Query().Get("id")
 
 	if id == "" {
-		msg := map[string]string{"error": "Please supply a valid id"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(msg)
+		writeError(w, r, apperr.BadRequest("Please supply a valid id"))
 
 		return
 	}
 
 	var location = &locations.Location{LocationID: id}
 
+	err = sql.ErrNoRows
+
+	if validator.IsUUID(id) {
+		err = h.Locations.Get(r.Context(), location)
+	}
+
+	if err == sql.ErrNoRows {
+		writeError(w, r, apperr.NotFound("Sorry that location doesn't exist"))
+
+		return
+	}
+
+	if err != nil {
+		writeError(w, r, apperr.Internal(err))
+
+		return
+	}
+
+	if location.UserID != user.ID && !user.Role.CanModerate() {
+		writeError(w, r, apperr.Forbidden("Sorry you're not authorized to make a change here"))
+
+		return
+	}
+
 	if r.Body == nil {
-		msg := map[string]string{"error": "Please supply values to be updated"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(msg)
+		writeError(w, r, apperr.BadRequest("Please supply values to be updated"))
 
 		return
 	}
@@ -191,22 +211,22 @@ func UpdateLocation(w http.ResponseWriter, r *http.Request) {
 	err = json.NewDecoder(r.Body).Decode(&changes)
 
 	if err != nil {
-		log.Println(err)
-		msg := map[string]string{"error": "Sorry only city, country or state names can be updated"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(msg)
+		logging.From(r.Context()).Debug("decoding the request body", "err", err)
+		writeError(w, r, apperr.BadRequest("Sorry only city, country or state names can be updated"))
 
 		return
 	}
 
-	err = location.Update(changes)
+	err = h.Locations.Update(r.Context(), location, changes)
+
+	if err == locations.ErrInvalidChange {
+		writeError(w, r, apperr.BadRequest(err.Error()))
+
+		return
+	}
 
 	if err != nil {
-		msg := map[string]string{"error": "Sorry there was an internal server error"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(msg)
+		writeError(w, r, apperr.Internal(err))
 
 		return
 	}
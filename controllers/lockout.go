@@ -0,0 +1,133 @@
+package controllers
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Samuyi/www/apperr"
+	"github.com/Samuyi/www/email"
+	"github.com/Samuyi/www/logging"
+	"github.com/Samuyi/www/models/users"
+	"github.com/Samuyi/www/utilities"
+)
+
+//accountKey names the failed logins of an email, whether or not an account has it, so locking out
+//doesn't give away which emails are registered
+func accountKey(mail string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(mail))
+}
+
+//addressKey names the failed logins from an address
+func addressKey(ip string) string {
+	return "ip:" + ip
+}
+
+var dummyHash struct {
+	once sync.Once
+	hash string
+}
+
+//checkNoPassword takes as long as checking a password, so logins for unknown emails can't be told apart by timing
+func checkNoPassword(password string) {
+	dummyHash.once.Do(func() {
+		hash, err := utilities.HashPassword("not the password of anyone")
+
+		if err != nil {
+			slog.Error("hashing the dummy password", "err", err)
+		}
+
+		dummyHash.hash = hash
+	})
+
+	utilities.CheckPassword(password, dummyHash.hash)
+}
+
+//lockedFor returns how much longer logins for mail from ip are stopped, 0 when they aren't
+func (h *Handler) lockedFor(ctx context.Context, mail, ip string) (time.Duration, error) {
+	account, err := h.Lockouts.LockedFor(ctx, accountKey(mail))
+
+	if err != nil {
+		return 0, err
+	}
+
+	address, err := h.Lockouts.LockedFor(ctx, addressKey(ip))
+
+	if err != nil {
+		return 0, err
+	}
+
+	if address > account {
+		return address, nil
+	}
+
+	return account, nil
+}
+
+//loginFailed counts a failed login for mail from ip. Past the free attempts each failure makes the account wait
+//twice as long as the last one, and too many lock it, telling user, who is nil when no account has the email.
+//Too many failures from ip lock out the address.
+func (h *Handler) loginFailed(ctx context.Context, mail, ip string, user *users.User) error {
+	failures, err := h.Lockouts.AddFailure(ctx, accountKey(mail), h.lockout.Window.Duration)
+
+	if err != nil {
+		return err
+	}
+
+	free := int64(h.lockout.FreeAttempts)
+
+	switch {
+	case failures >= int64(h.lockout.MaxAttempts):
+		if err = h.Lockouts.Lock(ctx, accountKey(mail), h.lockout.Duration.Duration); err != nil {
+			return err
+		}
+
+		// only the failure that locks the account sends the email
+		if failures == int64(h.lockout.MaxAttempts) && user != nil {
+			logging.From(ctx).Warn("account locked", "locked_user_id", user.ID, "failures", failures)
+
+			var mail = &email.Mail{To: user.Email, Locale: user.Locale}
+
+			if err = mail.SendAccountLockedMail(ctx, user.FirstName, h.baseURL+"/forgot-password", h.lockout.Duration.Duration); err != nil {
+				logging.From(ctx).Error("sending the account locked email", "locked_user_id", user.ID, "err", err)
+			}
+		}
+	case failures > free:
+		delay := h.lockout.MaxDelay.Duration
+
+		if shift := failures - free - 1; shift < 30 && h.lockout.BaseDelay.Duration<<uint(shift) < delay {
+			delay = h.lockout.BaseDelay.Duration << uint(shift)
+		}
+
+		if err = h.Lockouts.Lock(ctx, accountKey(mail), delay); err != nil {
+			return err
+		}
+	}
+
+	failures, err = h.Lockouts.AddFailure(ctx, addressKey(ip), h.lockout.Window.Duration)
+
+	if err != nil {
+		return err
+	}
+
+	if failures >= int64(h.lockout.IPMaxAttempts) {
+		logging.From(ctx).Warn("address locked out", "ip", ip, "failures", failures)
+
+		return h.Lockouts.Lock(ctx, addressKey(ip), h.lockout.Duration.Duration)
+	}
+
+	return nil
+}
+
+//tooManyAttempts tells a client to wait before trying to log in again
+func tooManyAttempts(w http.ResponseWriter, r *http.Request, wait time.Duration) {
+	writeError(w, r, apperr.TooManyRequests("Sorry there have been too many failed logins, please try again later", wait))
+}
+
+//invalidCredentials answers every failed login the same way, whether the email or the password was wrong
+func invalidCredentials(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, apperr.BadRequest("Invalid credentials"))
+}
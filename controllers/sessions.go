@@ -0,0 +1,199 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/Samuyi/www/apperr"
+	"github.com/Samuyi/www/logging"
+	"github.com/Samuyi/www/models/tokens"
+	"github.com/gorilla/mux"
+)
+
+//GetSessions lists the sessions of the logged in user, marking the one making the request as current
+func (h *Handler) GetSessions(w http.ResponseWriter, r *http.Request) {
+	sessionID := r.Header.Get("sessionID")
+
+	user, err := h.getUserFromSession(r.Context(), sessionID)
+
+	if err != nil {
+		writeError(w, r, apperr.Internal(err))
+
+		return
+	}
+
+	sessions, err := h.Sessions.GetUserSessions(r.Context(), user.ID)
+
+	if err != nil {
+		writeError(w, r, apperr.Internal(err))
+
+		return
+	}
+
+	for i := range sessions {
+		sessions[i].Current = sessions[i].Key == sessionID
+	}
+
+	w.Header().Set("Content-type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(sessions)
+}
+
+//DeleteSession logs the user out of one of their sessions
+func (h *Handler) DeleteSession(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	user, err := h.getUserFromSession(r.Context(), r.Header.Get("sessionID"))
+
+	if err != nil {
+		writeError(w, r, apperr.Internal(err))
+
+		return
+	}
+
+	sessions, err := h.Sessions.GetUserSessions(r.Context(), user.ID)
+
+	if err != nil {
+		writeError(w, r, apperr.Internal(err))
+
+		return
+	}
+
+	var found *tokens.Session
+
+	for i := range sessions {
+		if sessions[i].ID == id {
+			found = &sessions[i]
+			break
+		}
+	}
+
+	if found == nil {
+		writeError(w, r, apperr.NotFound("Sorry that session doesn't exist"))
+
+		return
+	}
+
+	err = h.Sessions.DeleteSession(r.Context(), found.Key)
+
+	if err != nil {
+		writeError(w, r, apperr.Internal(err))
+
+		return
+	}
+
+	msg := map[string]string{"message": "Success!"}
+	w.Header().Set("Content-type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(msg)
+}
+
+//LogOutEverywhere ends every session of the logged in user, including the one making the request
+func (h *Handler) LogOutEverywhere(w http.ResponseWriter, r *http.Request) {
+	user, err := h.getUserFromSession(r.Context(), r.Header.Get("sessionID"))
+
+	if err != nil {
+		writeError(w, r, apperr.Internal(err))
+
+		return
+	}
+
+	err = h.Sessions.DeleteUserSessions(r.Context(), user.ID)
+
+	if err != nil {
+		writeError(w, r, apperr.Internal(err))
+
+		return
+	}
+
+	msg := map[string]string{"message": "Success!"}
+	w.Header().Set("Content-type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(msg)
+}
+
+//RefreshToken exchanges a refresh token for a new access token and a new refresh token. Each refresh token works once;
+//when one is presented again it was copied, so the session it belongs to is ended for both holders.
+func (h *Handler) RefreshToken(w http.ResponseWriter, r *http.Request) {
+	var refresh struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	if r.Body == nil || json.NewDecoder(r.Body).Decode(&refresh) != nil || refresh.RefreshToken == "" {
+		writeError(w, r, apperr.BadRequest("Please supply a refresh token"))
+
+		return
+	}
+
+	sessionID, err := h.Refreshes.UseRefresh(r.Context(), refresh.RefreshToken)
+
+	if err == tokens.ErrRefreshReused {
+		logging.From(r.Context()).Warn("refresh token reused, ending its session", "session", tokens.Hash(sessionID))
+
+		if err = h.Sessions.DeleteSession(r.Context(), sessionID); err != nil {
+			logging.From(r.Context()).Error("ending a session", "err", err)
+		}
+
+		writeError(w, r, apperr.Unauthorized("Sorry that refresh token was already used, please log in again"))
+
+		return
+	}
+
+	if err == tokens.ErrInvalidKey {
+		writeError(w, r, apperr.Unauthorized("Sorry that refresh token is invalid or has expired, please log in again"))
+
+		return
+	}
+
+	if err != nil {
+		writeError(w, r, apperr.Internal(err))
+
+		return
+	}
+
+	// refreshing keeps the session going for another lifetime, unless it ended in the meantime
+	values := map[string]interface{}{
+		"RefreshedAt": time.Now().UTC().Format(time.RFC3339),
+	}
+
+	err = h.Sessions.ExtendSession(r.Context(), sessionID, values, h.lifetime)
+
+	if err == tokens.ErrSessionExpired {
+		writeError(w, r, apperr.Unauthorized("Sorry session has expired, please log in again"))
+
+		return
+	}
+
+	if err != nil {
+		writeError(w, r, apperr.Internal(err))
+
+		return
+	}
+
+	token, err := h.signToken(sessionID)
+
+	if err != nil {
+		logging.From(r.Context()).Error("signing a token", "err", err)
+		writeError(w, r, apperr.Internal(err))
+
+		return
+	}
+
+	refreshToken, err := h.Refreshes.SetRefresh(r.Context(), sessionID, h.lifetime)
+
+	if err != nil {
+		writeError(w, r, apperr.Internal(err))
+
+		return
+	}
+
+	resp := map[string]string{
+		"token":         token,
+		"refresh_token": refreshToken,
+	}
+
+	w.Header().Set("Content-type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
+}
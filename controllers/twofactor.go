@@ -0,0 +1,352 @@
+package controllers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/Samuyi/www/apperr"
+	"github.com/Samuyi/www/logging"
+	"github.com/Samuyi/www/models/tokens"
+	"github.com/Samuyi/www/models/users"
+	"github.com/Samuyi/www/totp"
+	"github.com/Samuyi/www/utilities"
+)
+
+//challengeTTL is how long a user has to enter their code after giving their password
+const challengeTTL = 5 * time.Minute
+
+//recoveryCodes is how many recovery codes a user gets when enrolling
+const recoveryCodes = 10
+
+//twoFactorCode is a code from an authenticator app or, in its place, one of the recovery codes
+type twoFactorCode struct {
+	Challenge    string `json:"challenge,omitempty"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+//checkCode reports whether code is valid for the user of tf. Authenticator codes only work once,
+//and so do recovery codes, which are deleted when used.
+func (h *Handler) checkCode(ctx context.Context, tf *users.TwoFactor, code twoFactorCode) (bool, error) {
+	if code.Code != "" {
+		counter, ok := totp.Validate(tf.Secret, code.Code, time.Now())
+
+		if !ok {
+			return false, nil
+		}
+
+		return h.TwoFactor.UseCounter(ctx, tf, counter)
+	}
+
+	if code.RecoveryCode != "" && tf.Enabled {
+		return h.TwoFactor.UseRecoveryCode(ctx, tf, tokens.Hash(totp.NormalizeRecoveryCode(code.RecoveryCode)))
+	}
+
+	return false, nil
+}
+
+//challengeTwoFactor answers a login with the right password from a user with two-factor authentication
+//with a challenge, to be sent back to LoginTwoFactor along with a code
+func (h *Handler) challengeTwoFactor(w http.ResponseWriter, r *http.Request, user *users.User) {
+	challenge, err := h.Challenges.SetChallenge(r.Context(), user.ID, challengeTTL)
+
+	if err != nil {
+		writeError(w, r, apperr.Internal(err))
+
+		return
+	}
+
+	resp := map[string]string{
+		"challenge": challenge,
+		"message":   "Please enter the code from your authenticator app",
+	}
+
+	w.Header().Set("Content-type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
+}
+
+//LoginTwoFactor finishes logging in a user with two-factor authentication, starting their session
+//once they send the challenge they got from Login with a valid code. Wrong codes count as failed logins,
+//so guessing them locks the account like guessing passwords does.
+func (h *Handler) LoginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var code twoFactorCode
+
+	if r.Body == nil || json.NewDecoder(r.Body).Decode(&code) != nil || code.Challenge == "" || (code.Code == "" && code.RecoveryCode == "") {
+		writeError(w, r, apperr.BadRequest("Please supply the challenge and a code"))
+
+		return
+	}
+
+	userID, err := h.Challenges.CheckChallenge(r.Context(), code.Challenge)
+
+	if err == tokens.ErrInvalidKey {
+		writeError(w, r, apperr.Unauthorized("Sorry that login has expired, please log in again"))
+
+		return
+	}
+
+	if err != nil {
+		writeError(w, r, apperr.Internal(err))
+
+		return
+	}
+
+	user := &users.User{ID: userID}
+
+	if err = h.Users.Get(r.Context(), user); err != nil {
+		writeError(w, r, apperr.Internal(err))
+
+		return
+	}
+
+	ip := utilities.ClientIP(r)
+
+	wait, err := h.lockedFor(r.Context(), user.Email, ip)
+
+	if err != nil {
+		writeError(w, r, apperr.Internal(err))
+
+		return
+	}
+
+	if wait > 0 {
+		tooManyAttempts(w, r, wait)
+
+		return
+	}
+
+	tf := &users.TwoFactor{UserID: userID}
+
+	if err = h.TwoFactor.GetTwoFactor(r.Context(), tf); err != nil {
+		writeError(w, r, apperr.Internal(err))
+
+		return
+	}
+
+	ok, err := h.checkCode(r.Context(), tf, code)
+
+	if err != nil {
+		writeError(w, r, apperr.Internal(err))
+
+		return
+	}
+
+	if !ok {
+		if err = h.loginFailed(r.Context(), user.Email, ip, user); err != nil {
+			logging.From(r.Context()).Error("counting a failed login", "err", err)
+		}
+
+		writeError(w, r, apperr.Unauthorized("Invalid code"))
+
+		return
+	}
+
+	if err = h.Challenges.DeleteChallenge(r.Context(), code.Challenge); err != nil {
+		logging.From(r.Context()).Error("deleting a login challenge", "err", err)
+	}
+
+	if err = h.Lockouts.Unlock(r.Context(), accountKey(user.Email)); err != nil {
+		logging.From(r.Context()).Error("clearing failed logins", "err", err)
+	}
+
+	user.Password = ""
+
+	h.startSession(w, r, user)
+}
+
+//EnrollTwoFactor starts turning on two-factor authentication for the logged in user. It responds with the
+//otpauth:// uri to add to an authenticator app and the recovery codes, which are only ever shown here.
+//Two-factor authentication is on once VerifyTwoFactor gets a code from the app.
+func (h *Handler) EnrollTwoFactor(w http.ResponseWriter, r *http.Request) {
+	user, err := h.getUserFromSession(r.Context(), r.Header.Get("sessionID"))
+
+	if err != nil {
+		writeError(w, r, apperr.Internal(err))
+
+		return
+	}
+
+	if err = h.Users.Get(r.Context(), &user); err != nil {
+		writeError(w, r, apperr.Internal(err))
+
+		return
+	}
+
+	secret, err := totp.NewSecret()
+
+	if err != nil {
+		logging.From(r.Context()).Error("generating a totp secret", "err", err)
+		writeError(w, r, apperr.Internal(err))
+
+		return
+	}
+
+	codes, err := totp.RecoveryCodes(recoveryCodes)
+
+	if err != nil {
+		logging.From(r.Context()).Error("generating recovery codes", "err", err)
+		writeError(w, r, apperr.Internal(err))
+
+		return
+	}
+
+	hashes := make([]string, len(codes))
+
+	for i, code := range codes {
+		hashes[i] = tokens.Hash(totp.NormalizeRecoveryCode(code))
+	}
+
+	err = h.TwoFactor.SetTwoFactor(r.Context(), &users.TwoFactor{UserID: user.ID, Secret: secret}, hashes)
+
+	if err == users.ErrTwoFactorEnabled {
+		writeError(w, r, apperr.Conflict("Two-factor authentication is already on, turn it off first to enroll again"))
+
+		return
+	}
+
+	if err != nil {
+		writeError(w, r, apperr.Internal(err))
+
+		return
+	}
+
+	resp := struct {
+		Secret        string   `json:"secret"`
+		URI           string   `json:"uri"`
+		RecoveryCodes []string `json:"recovery_codes"`
+	}{secret, totp.URI(h.issuer, user.Email, secret), codes}
+
+	w.Header().Set("Content-type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(resp)
+}
+
+//VerifyTwoFactor finishes enrolment once the logged in user sends a code from their authenticator app,
+//after which logging in takes a code as well as the password
+func (h *Handler) VerifyTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var code twoFactorCode
+
+	if r.Body == nil || json.NewDecoder(r.Body).Decode(&code) != nil || code.Code == "" {
+		writeError(w, r, apperr.BadRequest("Please supply a code"))
+
+		return
+	}
+
+	user, err := h.getUserFromSession(r.Context(), r.Header.Get("sessionID"))
+
+	if err != nil {
+		writeError(w, r, apperr.Internal(err))
+
+		return
+	}
+
+	tf := &users.TwoFactor{UserID: user.ID}
+	err = h.TwoFactor.GetTwoFactor(r.Context(), tf)
+
+	if err == sql.ErrNoRows {
+		writeError(w, r, apperr.BadRequest("Please enroll before verifying"))
+
+		return
+	}
+
+	if err != nil {
+		writeError(w, r, apperr.Internal(err))
+
+		return
+	}
+
+	if tf.Enabled {
+		writeError(w, r, apperr.Conflict("Two-factor authentication is already on"))
+
+		return
+	}
+
+	ok, err := h.checkCode(r.Context(), tf, twoFactorCode{Code: code.Code})
+
+	if err != nil {
+		writeError(w, r, apperr.Internal(err))
+
+		return
+	}
+
+	if !ok {
+		writeError(w, r, apperr.BadRequest("Invalid code"))
+
+		return
+	}
+
+	if err = h.TwoFactor.EnableTwoFactor(r.Context(), tf); err != nil {
+		writeError(w, r, apperr.Internal(err))
+
+		return
+	}
+
+	msg := map[string]string{"message": "Two-factor authentication is on"}
+	w.Header().Set("Content-type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(msg)
+}
+
+//DisableTwoFactor turns off two-factor authentication for the logged in user, who has to send a current code
+//or a recovery code so a stolen session can't do it
+func (h *Handler) DisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var code twoFactorCode
+
+	if r.Body == nil || json.NewDecoder(r.Body).Decode(&code) != nil || (code.Code == "" && code.RecoveryCode == "") {
+		writeError(w, r, apperr.BadRequest("Please supply a code"))
+
+		return
+	}
+
+	user, err := h.getUserFromSession(r.Context(), r.Header.Get("sessionID"))
+
+	if err != nil {
+		writeError(w, r, apperr.Internal(err))
+
+		return
+	}
+
+	tf := &users.TwoFactor{UserID: user.ID}
+	err = h.TwoFactor.GetTwoFactor(r.Context(), tf)
+
+	if err == sql.ErrNoRows {
+		writeError(w, r, apperr.BadRequest("Two-factor authentication isn't on"))
+
+		return
+	}
+
+	if err != nil {
+		writeError(w, r, apperr.Internal(err))
+
+		return
+	}
+
+	ok, err := h.checkCode(r.Context(), tf, code)
+
+	if err != nil {
+		writeError(w, r, apperr.Internal(err))
+
+		return
+	}
+
+	if !ok {
+		writeError(w, r, apperr.BadRequest("Invalid code"))
+
+		return
+	}
+
+	if err = h.TwoFactor.DisableTwoFactor(r.Context(), tf); err != nil {
+		writeError(w, r, apperr.Internal(err))
+
+		return
+	}
+
+	msg := map[string]string{"message": "Two-factor authentication is off"}
+	w.Header().Set("Content-type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(msg)
+}
@@ -1,23 +1,24 @@
 package controllers
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
 	"fmt"
-	"log"
-	"math/rand"
 	"net/http"
-	"os"
+	"net/url"
 	"strconv"
-	"strings"
 	"time"
 
+	"github.com/Samuyi/www/apperr"
 	"github.com/Samuyi/www/email"
+	"github.com/Samuyi/www/logging"
+	"github.com/Samuyi/www/models/page"
+	"github.com/Samuyi/www/models/tokens"
 	"github.com/Samuyi/www/models/users"
 	"github.com/Samuyi/www/utilities"
 	jwt "github.com/dgrijalva/jwt-go"
-	"github.com/go-redis/redis"
 	"github.com/gorilla/mux"
-	"github.com/gorilla/sessions"
 )
 
 //MyCustomClaims is a type for the jwt claims
@@ -26,55 +27,51 @@ type MyCustomClaims struct {
 	jwt.StandardClaims
 }
 
-var store = sessions.NewCookieStore([]byte(os.Getenv("signing_key")))
+func (h *Handler) createToken(ctx context.Context) (map[string]string, error) {
+	SessionID, err := tokens.Random()
 
-var client *redis.Client
+	if err != nil {
+		logging.From(ctx).Error("generating a session id", "err", err)
+		return nil, err
+	}
 
-func init() {
-	client = redis.NewClient(&redis.Options{
-		Addr:     "localhost:6379",
-		Password: "",
-		DB:       0,
-	})
-}
+	ss, err := h.signToken(SessionID)
 
-func createToken() (map[string]string, error) {
-	mySigningKey := []byte(os.Getenv("SIGNING_KEY"))
+	if err != nil {
+		return nil, err
+	}
 
-	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	session := map[string]string{
+		"sessionID": SessionID,
+		"token":     ss,
+	}
 
-	letters := strings.Split("abcdefghijklmnopqrstuvwxyz", "")
-	r.Shuffle(len(letters), func(i, j int) {
-		letters[i], letters[j] = letters[j], letters[i]
-	})
+	return session, nil
+}
 
-	SessionID := strings.Join(letters[2:12], "")
-	exp := time.Now().Unix() + (86400 * 3)
+//signToken returns a short lived access token for a session
+func (h *Handler) signToken(sessionID string) (string, error) {
+	exp := time.Now().Add(h.accessTTL).Unix()
 
 	claims := MyCustomClaims{
-		SessionID,
+		sessionID,
 		jwt.StandardClaims{
 			ExpiresAt: exp,
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	ss, err := token.SignedString(mySigningKey)
+	ss, err := h.keys.Sign(claims)
 
 	if err != nil {
-		log.Println(err)
-		return nil, err
-	}
-
-	session := map[string]string{
-		"sessionID": SessionID,
-		"token":     ss,
+		return "", err
 	}
 
-	return session, nil
+	return ss, nil
 }
 
-func setSession(sessionID string, sessionValues map[interface{}]interface{}) error {
+//setSession saves a session for the session lifetime, along with the device and address it was started from,
+//and returns the first refresh token of the session
+func (h *Handler) setSession(r *http.Request, sessionID string, sessionValues map[interface{}]interface{}) (string, error) {
 
 	session := map[string]interface{}{}
 
@@ -83,24 +80,20 @@ func setSession(sessionID string, sessionValues map[interface{}]interface{}) err
 		session[key] = v
 	}
 
-	_, err := client.HMSet(sessionID, session).Result()
-	if err != nil {
-		log.Println(err)
-		return err
-	}
+	session["UserAgent"] = r.UserAgent()
+	session["IP"] = utilities.ClientIP(r)
+	session["CreatedAt"] = time.Now().UTC().Format(time.RFC3339)
 
-	duration, _ := time.ParseDuration("36h") // expire after three days
-	_, err = client.Expire(sessionID, duration).Result()
-	if err != nil {
-		log.Println(err)
-		return err
+	if err := h.Sessions.SetSession(r.Context(), sessionID, session, h.lifetime); err != nil {
+		return "", err
 	}
-	return nil
+
+	return h.Refreshes.SetRefresh(r.Context(), sessionID, h.lifetime)
 }
 
-func getUserFromSession(sessionID string) (users.User, error) {
+func (h *Handler) getUserFromSession(ctx context.Context, sessionID string) (users.User, error) {
 	var user = users.User{}
-	session, err := utilities.GetSession(sessionID)
+	session, err := h.Sessions.GetSession(ctx, sessionID)
 	if err != nil {
 		return user, err
 	}
@@ -114,20 +107,22 @@ func getUserFromSession(sessionID string) (users.User, error) {
 	user.DisplayName = session["DisplayName"]
 	user.LastName = session["LastName"]
 	user.Avatar = session["Avatar"]
+	user.Role = users.Role(session["Role"])
+
+	if user.Role == "" {
+		user.Role = users.RoleUser
+	}
 
 	return user, nil
 
 }
 
 //RegisterUser controller to create a new user
-func RegisterUser(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) RegisterUser(w http.ResponseWriter, r *http.Request) {
 	var user users.User
 
 	if r.Body == nil {
-		msg := map[string]string{"error": "Please supply name, email and password"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(msg)
+		writeError(w, r, apperr.BadRequest("Please supply name, email and password"))
 
 		return
 	}
@@ -135,81 +130,75 @@ func RegisterUser(w http.ResponseWriter, r *http.Request) {
 	err := json.NewDecoder(r.Body).Decode(&user)
 
 	if err != nil {
-		log.Println(err)
-		msg := map[string]string{"error": "Please supply a valid name, email and password"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(msg)
+		logging.From(r.Context()).Debug("decoding the request body", "err", err)
+		writeError(w, r, apperr.BadRequest("Please supply a valid name, email and password"))
 
 		return
 	}
 
+	if user.Locale == "" {
+		user.Locale = email.Locale(r.Header.Get("Accept-Language"))
+	}
+
+	// avatars can only be uploaded, once signed up
+	user.Avatar = ""
+
 	errors := user.Validate()
 
 	if len(errors) > 0 {
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(errors)
+		writeError(w, r, apperr.Validation("Please correct the invalid fields", errors))
 
 		return
 	}
 
-	err = user.Create()
+	err = h.Users.Create(r.Context(), &user)
+
+	if err == users.ErrEmailTaken || err == users.ErrDisplayNameTaken {
+		writeError(w, r, apperr.Conflict(err.Error()))
+
+		return
+	}
 
 	if err != nil {
-		msg := map[string]string{"error": "Sorry there was an internal server error"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(msg)
+		writeError(w, r, apperr.Internal(err))
 
 		return
 	}
 
 	user.Password = ""
-	id, err := utilities.SetUserForConfirmation(user.ID)
+	id, err := h.Confirmations.SetConfirmation(r.Context(), user.ID, h.confirmTTL)
 
 	if err != nil {
-		_ = user.Delete()
-		msg := map[string]string{"error": "Sorry there was an internal server error"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(msg)
+		_ = h.Users.Delete(r.Context(), &user)
+		writeError(w, r, apperr.Internal(err))
 
 		return
 	}
 
-	var mail = &email.Mail{To: user.Email}
+	var mail = &email.Mail{To: user.Email, Locale: user.Locale}
+
+	err = mail.SendConfirmationMail(r.Context(), user.FirstName, h.baseURL+"/?key="+url.QueryEscape(id))
 
-	go mail.SendConfirmationMail(user.FirstName, baseURL+"/?key="+id)
 	if err != nil {
-		_ = user.Delete()
-		msg := map[string]string{"error": "Sorry there was an internal server error"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(msg)
+		_ = h.Users.Delete(r.Context(), &user)
+		writeError(w, r, apperr.Internal(err))
 
 		return
 	}
 
-	sessionInfo, err := createToken()
+	sessionInfo, err := h.createToken(r.Context())
 	if err != nil {
-		msg := map[string]string{"error": "Sorry there was an internal server error"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(msg)
+		writeError(w, r, apperr.Internal(err))
 
 		return
 	}
 	sessionID := sessionInfo["sessionID"]
 	token := sessionInfo["token"]
 
-	session, err := store.Get(r, sessionID)
+	session, err := h.store.Get(r, sessionID)
 	if err != nil {
-		log.Println(err)
-		msg := map[string]string{"error": "Sorry there was an internal server error"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(msg)
+		logging.From(r.Context()).Error("loading the cookie session", "err", err)
+		writeError(w, r, apperr.Internal(err))
 
 		return
 	}
@@ -219,34 +208,30 @@ func RegisterUser(w http.ResponseWriter, r *http.Request) {
 	session.Values["DisplayName"] = user.DisplayName
 	session.Values["Active"] = user.Active
 	session.Values["Avatar"] = user.Avatar
+	session.Values["Role"] = string(user.Role)
 	session.Values["userID"] = user.ID
 	session.Values["email"] = user.Email
 
 	err = session.Save(r, w)
 
 	if err != nil {
-		log.Println(err)
-		msg := map[string]string{"error": "Sorry there was an internal server error"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(msg)
+		logging.From(r.Context()).Error("saving the cookie session", "err", err)
+		writeError(w, r, apperr.Internal(err))
 
 		return
 	}
 
-	err = setSession(sessionID, session.Values)
+	refreshToken, err := h.setSession(r, sessionID, session.Values)
 
 	if err != nil {
-		msg := map[string]string{"error": "Sorry there was an internal server error"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(msg)
+		writeError(w, r, apperr.Internal(err))
 
 		return
 	}
 
 	resp := map[string]string{
-		"token": token,
+		"token":         token,
+		"refresh_token": refreshToken,
 	}
 
 	w.Header().Set("Content-type", "application/json")
@@ -257,14 +242,11 @@ func RegisterUser(w http.ResponseWriter, r *http.Request) {
 }
 
 //Login logs a user into the application
-func Login(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
 	var user = &users.User{}
 
 	if r.Body == nil {
-		msg := map[string]string{"error": "Please supply email and password"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(msg)
+		writeError(w, r, apperr.BadRequest("Please supply email and password"))
 
 		return
 	}
@@ -272,70 +254,86 @@ func Login(w http.ResponseWriter, r *http.Request) {
 	err := json.NewDecoder(r.Body).Decode(&user)
 
 	if err != nil {
-		log.Println(err)
-		msg := map[string]string{"error": "Please supply a valid email and password"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(msg)
+		logging.From(r.Context()).Debug("decoding the request body", "err", err)
+		writeError(w, r, apperr.BadRequest("Please supply a valid email and password"))
 
 		return
 	}
 
 	password := user.Password
-	err = user.GetID()
+	mail := user.Email
+	ip := utilities.ClientIP(r)
+
+	wait, err := h.lockedFor(r.Context(), mail, ip)
 
 	if err != nil {
-		if err.Error() == "sql: no rows in result set" {
-			msg := map[string]string{"error": "Sorry email is invalid "}
-			w.Header().Set("Content-type", "application/json")
-			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(msg)
+		writeError(w, r, apperr.Internal(err))
 
-			return
-		}
+		return
+	}
 
-		msg := map[string]string{"error": "Sorry there was an internal server error"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(msg)
+	if wait > 0 {
+		tooManyAttempts(w, r, wait)
 
 		return
 	}
 
-	ok := utilities.CheckPassword(password, user.Password)
+	err = h.Users.GetByEmail(r.Context(), user)
+
+	if err != nil && err != sql.ErrNoRows {
+		writeError(w, r, apperr.Internal(err))
+
+		return
+	}
+
+	if err == sql.ErrNoRows {
+		checkNoPassword(password)
+		user = nil
+	}
+
+	if user == nil || !utilities.CheckPassword(password, user.Password) {
+		if err = h.loginFailed(r.Context(), mail, ip, user); err != nil {
+			logging.From(r.Context()).Error("counting a failed login", "err", err)
+		}
 
-	if !ok {
-		msg := map[string]string{"error": "Invalid Password"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(msg)
+		invalidCredentials(w, r)
 
 		return
 	}
 
 	user.Password = ""
 
-	sessionInfo, err := createToken()
+	// with two-factor authentication the failures are only cleared once the code is right too
+	if user.TwoFactor {
+		h.challengeTwoFactor(w, r, user)
+
+		return
+	}
+
+	if err = h.Lockouts.Unlock(r.Context(), accountKey(mail)); err != nil {
+		logging.From(r.Context()).Error("clearing failed logins", "err", err)
+	}
+
+	h.startSession(w, r, user)
+}
+
+//startSession logs user in on a new session and responds with its tokens
+func (h *Handler) startSession(w http.ResponseWriter, r *http.Request, user *users.User) {
+	sessionInfo, err := h.createToken(r.Context())
 
 	if err != nil {
-		log.Println(err)
-		msg := map[string]string{"error": "Sorry there was an internal server error"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(msg)
+		logging.From(r.Context()).Error("creating a token", "err", err)
+		writeError(w, r, apperr.Internal(err))
 
 		return
 	}
 
 	sessionID := sessionInfo["sessionID"]
 
-	session, err := store.Get(r, sessionID)
+	session, err := h.store.Get(r, sessionID)
 	if err != nil {
-		log.Println(err)
-		msg := map[string]string{"error": "Sorry there was an internal server error"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(msg)
+		logging.From(r.Context()).Error("loading the cookie session", "err", err)
+		writeError(w, r, apperr.Internal(err))
 
 		return
 	}
@@ -345,33 +343,29 @@ func Login(w http.ResponseWriter, r *http.Request) {
 	session.Values["DisplayName"] = user.DisplayName
 	session.Values["Active"] = user.Active
 	session.Values["Avatar"] = user.Avatar
+	session.Values["Role"] = string(user.Role)
 	session.Values["userID"] = user.ID
 
 	err = session.Save(r, w)
 
 	if err != nil {
-		log.Println(err)
-		msg := map[string]string{"error": "Sorry there was an internal server error"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(msg)
+		logging.From(r.Context()).Error("saving the cookie session", "err", err)
+		writeError(w, r, apperr.Internal(err))
 
 		return
 	}
 
-	err = setSession(sessionID, session.Values)
+	refreshToken, err := h.setSession(r, sessionID, session.Values)
 
 	if err != nil {
-		msg := map[string]string{"error": "Sorry there was an internal server error please try again later"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(msg)
+		writeError(w, r, apperr.Internal(err))
 
 		return
 	}
 
 	resp := map[string]string{
-		"token": sessionInfo["token"],
+		"token":         sessionInfo["token"],
+		"refresh_token": refreshToken,
 	}
 
 	w.Header().Set("Content-type", "application/json")
@@ -382,92 +376,97 @@ func Login(w http.ResponseWriter, r *http.Request) {
 }
 
 //ConfirmUser confirms a user's email address
-func ConfirmUser(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) ConfirmUser(w http.ResponseWriter, r *http.Request) {
 	key := r.URL.Query().Get("key")
 
 	if key == "" {
-		msg := map[string]string{"error": "Key required"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(msg)
+		writeError(w, r, apperr.BadRequest("Key required"))
 
 		return
 	}
 
-	id, err := utilities.GetUserForConfirmation(key)
+	id, err := h.Confirmations.TakeConfirmation(r.Context(), key)
+
+	if err == tokens.ErrInvalidKey {
+		writeError(w, r, apperr.BadRequest(err.Error()))
+
+		return
+	}
 
 	if err != nil {
-		msg := map[string]interface{}{"error": err}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(msg)
+		writeError(w, r, apperr.Internal(err))
 
 		return
 	}
 
 	var user = &users.User{ID: id}
 
-	err = user.Get()
+	err = h.Users.Get(r.Context(), user)
+
+	if err != nil {
+		writeError(w, r, apperr.Internal(err))
+
+		return
+	}
+
+	err = h.Users.SetActive(r.Context(), user)
 
 	if err != nil {
-		msg := map[string]string{"error": "Please try again later"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(msg)
+		writeError(w, r, apperr.Internal(err))
 
 		return
 	}
 
-	err = user.SetUserActive()
+	sessionInfo, err := h.createToken(r.Context())
 
 	if err != nil {
-		msg := map[string]string{"error": "Sorry there was an internal server error. Please try again latter"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(msg)
+		writeError(w, r, apperr.Internal(err))
 
 		return
 	}
 
-	sessionInfo, err := createToken()
 	sessionID := sessionInfo["sessionID"]
 	token := sessionInfo["token"]
-	session, err := store.Get(r, sessionID)
+
+	session, err := h.store.Get(r, sessionID)
+	if err != nil {
+		logging.From(r.Context()).Error("loading the cookie session", "err", err)
+		writeError(w, r, apperr.Internal(err))
+
+		return
+	}
 
 	session.Values["FirstName"] = user.FirstName
 	session.Values["LastName"] = user.LastName
 	session.Values["DisplayName"] = user.DisplayName
 	session.Values["Active"] = user.Active
-	session.Values["Avartar"] = user.Avatar
+	session.Values["Avatar"] = user.Avatar
+	session.Values["Role"] = string(user.Role)
 	session.Values["userID"] = user.ID
 
 	err = session.Save(r, w)
 
 	if err != nil {
-		log.Println(err)
-		msg := map[string]string{"error": "Sorry there was an internal server error. Please try again latter"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(msg)
+		logging.From(r.Context()).Error("saving the cookie session", "err", err)
+		writeError(w, r, apperr.Internal(err))
 
 		return
 	}
 
-	err = setSession(sessionID, session.Values)
+	refreshToken, err := h.setSession(r, sessionID, session.Values)
 
 	if err != nil {
-		msg := map[string]string{"error": "Sorry there was an internal server error"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(msg)
+		writeError(w, r, apperr.Internal(err))
 
 		return
 	}
 
 	resp := map[string]string{
-		"token": token,
+		"token":         token,
+		"refresh_token": refreshToken,
 	}
 
+	w.Header().Set("Content-type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(resp)
 
@@ -475,13 +474,11 @@ func ConfirmUser(w http.ResponseWriter, r *http.Request) {
 
 }
 
-//ForgotPassword changes a user's password if they forgot their password
-func ForgotPassword(w http.ResponseWriter, r *http.Request) {
+//ForgotPassword emails a link to reset the password of an account. It answers the same whether or not
+//the email belongs to an account, so it can't be used to find out who has one.
+func (h *Handler) ForgotPassword(w http.ResponseWriter, r *http.Request) {
 	if r.Body == nil {
-		msg := map[string]string{"error": "Please supply a valid email"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(msg)
+		writeError(w, r, apperr.BadRequest("Please supply a valid email"))
 
 		return
 	}
@@ -490,132 +487,192 @@ func ForgotPassword(w http.ResponseWriter, r *http.Request) {
 
 	err := json.NewDecoder(r.Body).Decode(user)
 
-	if err != nil {
-		log.Println(err)
-		msg := map[string]string{"error": "Sorry there was an internal server error"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(msg)
+	if err != nil || user.Email == "" {
+		writeError(w, r, apperr.BadRequest("Please supply a valid email"))
 
 		return
 	}
+
 	user.ID = ""
-	err = user.GetID()
+
+	if err = h.sendResetLink(r.Context(), user); err != nil {
+		logging.From(r.Context()).Error("sending a reset link", "err", err)
+	}
+
+	resp := map[string]string{
+		"message": "If that email belongs to an account, we have sent it a link to reset the password. Please check your email.",
+	}
+
+	w.Header().Set("Content-type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
+
+	return
+}
+
+//sendResetLink issues a reset token to the user with the email of user and emails it to them.
+//It does nothing when no user has that email.
+func (h *Handler) sendResetLink(ctx context.Context, user *users.User) error {
+	err := h.Users.GetByEmail(ctx, user)
+
+	if err == sql.ErrNoRows {
+		return nil
+	}
 
 	if err != nil {
-		if err.Error() == "sql: no rows in result set" {
-			msg := map[string]string{"error": "Sorry email is invalid "}
-			w.Header().Set("Content-type", "application/json")
-			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(msg)
+		return err
+	}
 
-			return
-		}
+	token, err := h.Resets.SetReset(ctx, user.ID, h.resetTTL)
 
-		msg := map[string]string{"error": "Sorry there was an internal server error"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(msg)
+	if err != nil {
+		return err
+	}
+
+	var mail = &email.Mail{To: user.Email, Locale: user.Locale}
+
+	return mail.SendPasswordResetMail(ctx, user.FirstName, h.baseURL+"/reset-password?token="+url.QueryEscape(token), h.resetTTL)
+}
+
+//ResetPassword sets a new password for the user a reset token was sent to
+func (h *Handler) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var reset struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+
+	if r.Body == nil || json.NewDecoder(r.Body).Decode(&reset) != nil || reset.Token == "" {
+		writeError(w, r, apperr.BadRequest("Please supply the token you were sent and a new password"))
 
 		return
 	}
 
-	random := rand.New(rand.NewSource(time.Now().UnixNano()))
+	if len(reset.Password) < 8 {
+		writeError(w, r, apperr.BadRequest("Password must be greater than 7 characters."))
+
+		return
+	}
 
-	letters := strings.Split("abcdefghijklmnopqrstuvwxyz", "")
-	random.Shuffle(len(letters), func(i, j int) {
-		letters[i], letters[j] = letters[j], letters[i]
-	})
+	id, err := h.Resets.TakeReset(r.Context(), reset.Token)
 
-	password := strings.Join(letters[2:12], "")
+	if err == tokens.ErrInvalidKey {
+		writeError(w, r, apperr.BadRequest("Sorry that link is invalid or has expired, please ask for a new one"))
 
-	user.Password = password
-	err = user.UpdatePassword()
+		return
+	}
 
 	if err != nil {
-		msg := map[string]string{"error": "Sorry there was an internal server error"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(msg)
+		writeError(w, r, apperr.Internal(err))
 
 		return
 	}
 
-	// send email to user of updated paasword in the database and ask user to update their password
-
-	var mail = &email.Mail{To: user.Email}
+	var user = &users.User{ID: id, Password: reset.Password}
 
-	err = mail.EmailPassword(password, baseURL+"/login")
+	err = h.Users.UpdatePassword(r.Context(), user)
 
 	if err != nil {
-		msg := map[string]string{"error": "Sorry there was an internal server error"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(msg)
+		writeError(w, r, apperr.Internal(err))
 
 		return
 	}
 
-	resp := map[string]string{
-		"message": "We have sent a tempoary password to your email. Please check your email.",
+	// whoever knew the old password shouldn't stay logged in
+	if err = h.Sessions.DeleteUserSessions(r.Context(), id); err != nil {
+		logging.From(r.Context()).Error("ending the sessions of a user", "err", err)
+	}
+
+	// and the owner of the account shouldn't stay locked out of it
+	if err = h.Users.Get(r.Context(), user); err == nil {
+		err = h.Lockouts.Unlock(r.Context(), accountKey(user.Email))
 	}
 
+	if err != nil {
+		logging.From(r.Context()).Error("clearing failed logins", "err", err)
+	}
+
+	msg := map[string]string{"message": "Your password has been changed, you can now log in with it."}
 	w.Header().Set("Content-type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(resp)
-
-	return
+	json.NewEncoder(w).Encode(msg)
 }
 
-//UpdateUser updates a user's data in the database
-func UpdateUser(w http.ResponseWriter, r *http.Request) {
+//UpdateUser updates the names, locale and password of the logged in user. Changing the password ends the other
+//sessions of the user.
+func (h *Handler) UpdateUser(w http.ResponseWriter, r *http.Request) {
 	sessionID := r.Header.Get("sessionID")
 
-	user, err := getUserFromSession(sessionID)
+	current, err := h.getUserFromSession(r.Context(), sessionID)
 
 	if err != nil {
-		msg := map[string]string{"error": "Sorry there was an internal server error"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(msg)
+		writeError(w, r, apperr.Internal(err))
 
 		return
 	}
 
-	if r.Body == nil {
-		msg := map[string]string{"error": "Please supply values to be updated"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(msg)
+	var user = &users.User{ID: current.ID}
+
+	if err = h.Users.Get(r.Context(), user); err != nil {
+		writeError(w, r, apperr.Internal(err))
 
 		return
 	}
 
-	user.Password = ""
+	// only these can be changed, whatever else the body holds, and always for the user of the session
+	var changes = struct {
+		FirstName   string `json:"first_name"`
+		LastName    string `json:"last_name"`
+		DisplayName string `json:"display_name"`
+		Locale      string `json:"locale"`
+		Password    string `json:"password"`
+	}{user.FirstName, user.LastName, user.DisplayName, user.Locale, ""}
 
-	err = json.NewDecoder(r.Body).Decode(&user)
+	if r.Body == nil || json.NewDecoder(r.Body).Decode(&changes) != nil {
+		writeError(w, r, apperr.BadRequest("Please supply values to be updated"))
 
-	if err != nil {
-		log.Println(err)
-		msg := map[string]string{"error": "Sorry there was an internal server error"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(msg)
+		return
+	}
+
+	if changes.Locale != "" && !users.ValidLocale(changes.Locale) {
+		writeError(w, r, apperr.BadRequest("Locale must look like en or en-GB"))
+
+		return
+	}
+
+	if changes.Password != "" && len(changes.Password) < 8 {
+		writeError(w, r, apperr.BadRequest("Password must be greater than 7 characters."))
 
 		return
 	}
 
-	err = user.Update()
+	user.FirstName, user.LastName, user.DisplayName = changes.FirstName, changes.LastName, changes.DisplayName
+	user.Locale, user.Password = changes.Locale, changes.Password
+
+	err = h.Users.Update(r.Context(), user)
+
+	if err == users.ErrDisplayNameTaken {
+		writeError(w, r, apperr.Conflict(err.Error()))
+
+		return
+	}
 
 	if err != nil {
-		msg := map[string]string{"error": "Sorry there was an internal server error"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(msg)
+		writeError(w, r, apperr.Internal(err))
 
 		return
 	}
 
+	// whoever knew the old password shouldn't stay logged in, but the user making the change should
+	if changes.Password != "" {
+		if err = h.endOtherSessions(r.Context(), user.ID, sessionID); err != nil {
+			logging.From(r.Context()).Error("ending the other sessions of a user", "err", err)
+		}
+	}
+
+	if err = h.renameSessions(r.Context(), user); err != nil {
+		logging.From(r.Context()).Error("updating the names in the sessions of a user", "err", err)
+	}
+
 	msg := map[string]string{"message": "Success!"}
 	w.Header().Set("Content-type", "application/json")
 	w.WriteHeader(http.StatusOK)
@@ -625,40 +682,74 @@ func UpdateUser(w http.ResponseWriter, r *http.Request) {
 
 }
 
+//endOtherSessions ends every session of a user but the one with sessionID
+func (h *Handler) endOtherSessions(ctx context.Context, userID, sessionID string) error {
+	sessions, err := h.Sessions.GetUserSessions(ctx, userID)
+
+	if err != nil {
+		return err
+	}
+
+	for _, session := range sessions {
+		if session.Key == sessionID {
+			continue
+		}
+
+		if err = h.Sessions.DeleteSession(ctx, session.Key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//renameSessions copies the names of user into every session they have, which is where requests read them from
+func (h *Handler) renameSessions(ctx context.Context, user *users.User) error {
+	sessions, err := h.Sessions.GetUserSessions(ctx, user.ID)
+
+	if err != nil {
+		return err
+	}
+
+	values := map[string]interface{}{
+		"FirstName":   user.FirstName,
+		"LastName":    user.LastName,
+		"DisplayName": user.DisplayName,
+	}
+
+	for _, session := range sessions {
+		if err = h.Sessions.UpdateSession(ctx, session.Key, values); err != nil && err != tokens.ErrSessionExpired {
+			return err
+		}
+	}
+
+	return nil
+}
+
 //DeleteUser Deletes a user from the application
-func DeleteUser(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) DeleteUser(w http.ResponseWriter, r *http.Request) {
 	sessionID := r.Header.Get("sessionID")
 
-	user, err := getUserFromSession(sessionID)
+	user, err := h.getUserFromSession(r.Context(), sessionID)
 
 	if err != nil {
-		msg := map[string]string{"error": "Sorry there was an internal server error"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(msg)
+		writeError(w, r, apperr.Internal(err))
 
 		return
 	}
 
-	err = user.Delete()
+	err = h.Users.Delete(r.Context(), &user)
 
 	if err != nil {
-		msg := map[string]string{"error": "Sorry there was an internal server error"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(msg)
+		writeError(w, r, apperr.Internal(err))
 
 		return
 	}
 
-	_, err = client.Del(sessionID).Result()
+	err = h.Sessions.DeleteUserSessions(r.Context(), user.ID)
 
 	if err != nil {
-		log.Println(err)
-		msg := map[string]string{"error": "Sorry there was an internal server error"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(msg)
+		writeError(w, r, apperr.Internal(err))
 
 		return
 	}
@@ -672,19 +763,37 @@ func DeleteUser(w http.ResponseWriter, r *http.Request) {
 
 }
 
-//GetAllUsers fetches all users from the application
-func GetAllUsers(w http.ResponseWriter, r *http.Request) {
-	resp, err := users.GetAll()
+//GetAllUsers fetches a page of the users of the application, newest first unless asked otherwise.
+//They can be narrowed down to a role.
+func (h *Handler) GetAllUsers(w http.ResponseWriter, r *http.Request) {
+	values := r.URL.Query()
+	problems := make(map[string]string)
+	q := page.Parse(values, page.Newest, problems)
+
+	filter := users.Filter{Role: users.Role(values.Get("role"))}
+
+	if filter.Role != "" && !users.ValidRole(filter.Role) {
+		problems["role"] = "Please supply a role of user, moderator or admin"
+	}
+
+	if len(problems) > 0 {
+		writeError(w, r, apperr.Validation("Please correct the invalid parameters", problems))
+
+		return
+	}
+
+	resp, err := h.Users.GetAll(r.Context(), filter, q)
 
 	if err != nil {
-		msg := map[string]string{"error": "Sorry there was an internal server error"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(msg)
+		writeError(w, r, apperr.Internal(err))
 
 		return
 	}
 
+	for i := range resp.Items {
+		resp.Items[i].Avatar = h.fileURL(resp.Items[i].Avatar)
+	}
+
 	w.Header().Set("Content-type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(resp)
@@ -694,33 +803,47 @@ func GetAllUsers(w http.ResponseWriter, r *http.Request) {
 }
 
 //GetUser gets a user
-func GetUser(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) GetUser(w http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)
 	username := params["username"]
 
 	if username == "" {
-		msg := map[string]string{"error": "please supply a valid id"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(msg)
+		writeError(w, r, apperr.BadRequest("please supply a valid id"))
+
+		return
+	}
+
+	viewer, err := h.getUserFromSession(r.Context(), r.Header.Get("sessionID"))
+
+	if err != nil {
+		writeError(w, r, apperr.Internal(err))
 
 		return
 	}
 
 	var user = &users.User{DisplayName: username}
 
-	err := user.GetUserByName()
+	err = h.Users.GetByName(r.Context(), user)
+
+	if err == sql.ErrNoRows {
+		writeError(w, r, apperr.NotFound("Sorry that user doesn't exist"))
+
+		return
+	}
 
 	if err != nil {
-		msg := map[string]string{"error": "Sorry there was an internal server error"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(msg)
+		writeError(w, r, apperr.Internal(err))
 
 		return
 	}
 
 	user.Password = ""
+	user.Avatar = h.fileURL(user.Avatar)
+
+	// only the user themselves and moderators get to see an email address
+	if viewer.DisplayName != user.DisplayName && !viewer.Role.CanModerate() {
+		user.Email = ""
+	}
 
 	w.Header().Set("Content-type", "application/json")
 	w.WriteHeader(http.StatusOK)
@@ -730,17 +853,13 @@ func GetUser(w http.ResponseWriter, r *http.Request) {
 }
 
 //LogOut logs a user out of the application
-func LogOut(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) LogOut(w http.ResponseWriter, r *http.Request) {
 	sessionID := r.Header.Get("sessionID")
 
-	_, err := client.Del(sessionID).Result()
+	err := h.Sessions.DeleteSession(r.Context(), sessionID)
 
 	if err != nil {
-		log.Println(err)
-		msg := map[string]string{"error": "Sorry there was an internal server error"}
-		w.Header().Set("Content-type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(msg)
+		writeError(w, r, apperr.Internal(err))
 
 		return
 	}
@@ -2,338 +2,334 @@ package email
 
 import (
 	"bytes"
-	"crypto/tls"
+	"context"
+	"embed"
 	"fmt"
-	"html/template"
-	"log"
-	"net/smtp"
+	htmltemplate "html/template"
+	"io/fs"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/Samuyi/www/config"
+	"github.com/Samuyi/www/logging"
 )
 
+//DefaultLocale is used when an email isn't available in the locale asked for
+const DefaultLocale = "en"
+
+//names are the emails every locale has to provide
+var names = []string{"confirmation", "password-reset", "bid-alert", "account-locked", "item-status"}
+
+//go:embed templates
+var embedded embed.FS
+
 //Mail type
 type Mail struct {
-	To      string
+	To     string
+	Locale string
+
 	subject string
 	body    string
+	text    string
 }
 
-type smtpServer struct {
-	host string
-	port string
+//templates are the text and html versions of one email. The subject is the "subject" template of the text version.
+type templates struct {
+	html *htmltemplate.Template
+	text *texttemplate.Template
 }
 
-func (s *smtpServer) serverName() string {
-	return s.host + ":" + s.port
-}
+var transport Transport
+
+var sender string
+
+//catalog holds the emails of every locale, by locale and then name
+var catalog map[string]map[string]*templates
 
-func (mail *Mail) parseTemplate(templateFileName string, data interface{}) error {
-	t, err := template.ParseFiles(templateFileName)
+//Init sets the sender and the transport every email goes through and parses the templates,
+//from cfg.TemplateDir when it is set and from the ones built into the binary otherwise
+func Init(cfg config.SMTP, t Transport) error {
+	var fsys fs.FS = os.DirFS(cfg.TemplateDir)
+
+	if cfg.TemplateDir == "" {
+		sub, err := fs.Sub(embedded, "templates")
+
+		if err != nil {
+			return err
+		}
+
+		fsys = sub
+	}
+
+	parsed, err := parseTemplates(fsys)
 
 	if err != nil {
-		log.Println(err)
 		return err
 	}
 
-	buf := new(bytes.Buffer)
+	transport = t
+	catalog = parsed
+	sender = cfg.From
 
-	if err = t.Execute(buf, data); err != nil {
-		log.Println(err)
-		return err
+	if sender == "" {
+		sender = cfg.Username
 	}
-	mail.body = buf.String()
 
 	return nil
-
 }
 
-func (mail *Mail) buildConfirmationMessage(data interface{}) (string, error) {
-	message := ""
-	message += fmt.Sprintf("From: %s\r\n", "")
-	message += fmt.Sprintf("Subject: %s\r\n", mail.subject)
-	message += "MIME-version: 1.0;\nContent-Type: text/html; charset=\"UTF-8\";\n\n"
-	err := mail.parseTemplate("/home/samuyi/projects/website/src/github.com/Samuyi/www/email/confirmation_template.html", data)
-
-	if err != nil {
-		log.Println(err)
-		return "", err
+//NewTransport returns the transport named in cfg
+func NewTransport(cfg config.SMTP) (Transport, error) {
+	switch cfg.Transport {
+	case "smtp", "":
+		return NewSMTPTransport(cfg), nil
+	case "file":
+		return NewFileTransport(cfg.DropDir), nil
+	case "memory":
+		return NewRecorder(), nil
 	}
-	message += "\r\n" + mail.body
 
-	return message, nil
+	return nil, fmt.Errorf("email: unknown transport %q", cfg.Transport)
 }
 
-func (mail *Mail) buildBidNotificationMessage(data interface{}) (string, error) {
-	message := ""
-	message += fmt.Sprintf("From: %s\r\n", "")
-	message += fmt.Sprintf("Subject: %s\r\n", mail.subject)
-	message += "MIME-version: 1.0;\nContent-Type: text/html; charset=\"UTF-8\";\n\n"
-	err := mail.parseTemplate("/home/samuyi/projects/website/src/github.com/Samuyi/www/email/bid-alert_template.html", data)
+//parseTemplates parses every locale in fsys. Each locale is a directory with a common.html and common.txt
+//shared by its emails and a .html and .txt file for each email, all rendered inside layout.html and layout.txt.
+func parseTemplates(fsys fs.FS) (map[string]map[string]*templates, error) {
+	entries, err := fs.ReadDir(fsys, ".")
 
 	if err != nil {
-		log.Println(err)
-		return "", err
+		return nil, err
 	}
 
-	message += "\r\n" + mail.body
+	parsed := map[string]map[string]*templates{}
 
-	return message, nil
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
 
-}
+		locale := entry.Name()
+		parsed[locale] = map[string]*templates{}
 
-func (mail *Mail) buildPasswordChangeMessage(data interface{}) (string, error) {
-	message := ""
-	message += fmt.Sprintf("From: %s\r\n", "")
-	message += fmt.Sprintf("Subject: %s\r\n", mail.subject)
-	message += "MIME-version: 1.0;\nContent-Type: text/html; charset=\"UTF-8\";\n\n"
-	err := mail.parseTemplate("/home/samuyi/projects/website/src/github.com/Samuyi/www/email/password-change_template.html", data)
+		for _, name := range names {
+			html, err := htmltemplate.ParseFS(fsys, "layout.html", locale+"/common.html", locale+"/"+name+".html")
 
-	if err != nil {
-		log.Println(err)
-		return "", err
-	}
-	message += "\r\n" + mail.body
+			if err != nil {
+				return nil, fmt.Errorf("email: %s/%s: %v", locale, name, err)
+			}
 
-	return message, nil
-}
+			text, err := texttemplate.ParseFS(fsys, "layout.txt", locale+"/common.txt", locale+"/"+name+".txt")
 
-//SendConfirmationMail send email to new users
-func (mail *Mail) SendConfirmationMail(name, url string) error {
-	mail.subject = "Welcome to our network"
-	capitalizedName := strings.Title(name)
-	data := map[string]string{
-		"name": capitalizedName,
-		"url":  url,
+			if err != nil {
+				return nil, fmt.Errorf("email: %s/%s: %v", locale, name, err)
+			}
+
+			if text.Lookup("subject") == nil {
+				return nil, fmt.Errorf("email: %s/%s.txt doesn't define a subject", locale, name)
+			}
+
+			parsed[locale][name] = &templates{html: html, text: text}
+		}
 	}
-	message, err := mail.buildConfirmationMessage(data)
 
-	if err != nil {
-		log.Println(err)
-		return err
+	if parsed[DefaultLocale] == nil {
+		return nil, fmt.Errorf("email: there are no templates for the default locale %q", DefaultLocale)
 	}
 
-	smtpServer := smtpServer{host: "smtp.gmail.com", port: "465"}
+	return parsed, nil
+}
 
-	auth := smtp.PlainAuth("", os.Getenv("email"), os.Getenv("email_password"), smtpServer.host)
+//available returns the locale of the catalog closest to tag, trying the language on its own when the region isn't available
+func available(tag string) (string, bool) {
+	tag = strings.Replace(strings.TrimSpace(tag), "_", "-", -1)
 
-	// Gmail will reject connection if it's not secure
-	// TLS config
-	tlsconfig := &tls.Config{
-		InsecureSkipVerify: true,
-		ServerName:         smtpServer.host,
+	if _, ok := catalog[tag]; ok {
+		return tag, true
 	}
 
-	conn, err := tls.Dial("tcp", smtpServer.serverName(), tlsconfig)
+	language := strings.ToLower(strings.SplitN(tag, "-", 2)[0])
 
-	if err != nil {
-		log.Println(err)
-		return err
+	if _, ok := catalog[language]; ok {
+		return language, true
 	}
 
-	client, err := smtp.NewClient(conn, smtpServer.host)
-	if err != nil {
-		log.Println(err)
-		return err
-	}
+	return "", false
+}
 
-	// step 1: Use Auth
-	if err = client.Auth(auth); err != nil {
-		log.Println(err)
-		return err
+//match returns the locale to send an email in, the default when locale isn't available
+func match(locale string) string {
+	if found, ok := available(locale); ok {
+		return found
 	}
 
-	// step 2: add all from and to
-	if err = client.Mail(os.Getenv("email")); err != nil {
-		log.Println(err)
-		return err
-	}
+	return DefaultLocale
+}
 
-	if err = client.Rcpt(mail.To); err != nil {
-		log.Println(err)
-		return err
+//Locale returns the available locale that best matches an Accept-Language header
+func Locale(acceptLanguage string) string {
+	type weighted struct {
+		tag string
+		q   float64
 	}
 
-	// Data
-	w, err := client.Data()
-	if err != nil {
-		log.Println(err)
-		return err
-	}
+	var tags []weighted
 
-	_, err = w.Write([]byte(message))
-	if err != nil {
-		log.Println(err)
-		return err
-	}
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		fields := strings.Split(part, ";")
+		tag := strings.TrimSpace(fields[0])
 
-	err = w.Close()
-	if err != nil {
-		log.Println(err)
-		return err
-	}
+		if tag == "" || tag == "*" {
+			continue
+		}
 
-	client.Quit()
-	return nil
+		q := 1.0
 
-}
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
 
-//EmailPassword sends a users password to them via email
-func (mail *Mail) EmailPassword(password, url string) error {
-	mail.subject = "New Password"
+			if strings.HasPrefix(param, "q=") {
+				if _, err := fmt.Sscanf(param, "q=%g", &q); err != nil {
+					q = 0
+				}
+			}
+		}
 
-	data := map[string]string{
-		"password": password,
-		"url":      url,
+		if q > 0 {
+			tags = append(tags, weighted{tag, q})
+		}
 	}
 
-	message, err := mail.buildPasswordChangeMessage(data)
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].q > tags[j].q })
 
-	if err != nil {
-		log.Println(err)
-		return err
+	for _, tag := range tags {
+		if locale, ok := available(tag.tag); ok {
+			return locale
+		}
 	}
 
-	smtpServer := smtpServer{host: "smtp.gmail.com", port: "465"}
+	return DefaultLocale
+}
 
-	auth := smtp.PlainAuth("", os.Getenv("email"), os.Getenv("email_password"), smtpServer.host)
+//render fills in the subject, body and text of the mail from the named email in the mail's locale
+func (mail *Mail) render(ctx context.Context, name string, data map[string]string) error {
+	locale := match(mail.Locale)
+	t := catalog[locale][name]
 
-	// Gmail will reject connection if it's not secure
-	// TLS config
-	tlsconfig := &tls.Config{
-		InsecureSkipVerify: true,
-		ServerName:         smtpServer.host,
-	}
+	data["locale"] = locale
 
-	conn, err := tls.Dial("tcp", smtpServer.serverName(), tlsconfig)
+	var subject, text, body bytes.Buffer
 
-	if err != nil {
-		log.Println(err)
+	if err := t.text.ExecuteTemplate(&subject, "subject", data); err != nil {
+		logging.From(ctx).Error("rendering the subject of an email", "template", name, "err", err)
 		return err
 	}
 
-	client, err := smtp.NewClient(conn, smtpServer.host)
-	if err != nil {
-		log.Println(err)
-		return err
-	}
+	mail.subject = strings.TrimSpace(subject.String())
+	data["subject"] = mail.subject
 
-	// step 1: Use Auth
-	if err = client.Auth(auth); err != nil {
-		log.Println(err)
+	if err := t.text.Execute(&text, data); err != nil {
+		logging.From(ctx).Error("rendering the text of an email", "template", name, "err", err)
 		return err
 	}
 
-	// step 2: add all from and to
-	if err = client.Mail(os.Getenv("email")); err != nil {
-		log.Println(err)
+	if err := t.html.Execute(&body, data); err != nil {
+		logging.From(ctx).Error("rendering the html of an email", "template", name, "err", err)
 		return err
 	}
 
-	if err = client.Rcpt(mail.To); err != nil {
-		log.Println(err)
-		return err
-	}
+	mail.text = text.String()
+	mail.body = body.String()
+
+	return nil
+}
+
+//send renders the named email with data and hands the result to the transport
+func (mail *Mail) send(ctx context.Context, name string, data map[string]string) error {
+	err := mail.render(ctx, name, data)
 
-	// Data
-	w, err := client.Data()
 	if err != nil {
-		log.Println(err)
 		return err
 	}
 
-	_, err = w.Write([]byte(message))
-	if err != nil {
-		log.Println(err)
-		return err
+	msg := &Message{
+		From:    sender,
+		To:      []string{mail.To},
+		Subject: mail.subject,
+		Text:    mail.text,
+		HTML:    mail.body,
+
+		Template: name,
 	}
 
-	err = w.Close()
-	if err != nil {
-		log.Println(err)
+	if err = transport.Send(ctx, msg); err != nil {
+		logging.From(ctx).Error("sending an email", "template", name, "err", err)
 		return err
 	}
 
-	client.Quit()
 	return nil
-
 }
 
-//SendBidAlertMail sends an email to the owner of an item that a bid has been placed on his item
-func (mail *Mail) SendBidAlertMail(name, url string) error {
-	mail.subject = "Bid placed on your item"
-
+//SendConfirmationMail send email to new users
+func (mail *Mail) SendConfirmationMail(ctx context.Context, name, url string) error {
+	capitalizedName := strings.Title(name)
 	data := map[string]string{
-		"name": name,
+		"name": capitalizedName,
 		"url":  url,
 	}
-	message, err := mail.buildBidNotificationMessage(data)
-
-	if err != nil {
-		log.Println(err)
-		return err
-	}
-
-	smtpServer := smtpServer{host: "smtp.gmail.com", port: "465"}
-
-	auth := smtp.PlainAuth("", os.Getenv("email"), os.Getenv("email_password"), smtpServer.host)
-
-	// Gmail will reject connection if it's not secure
-	// TLS config
-	tlsconfig := &tls.Config{
-		InsecureSkipVerify: true,
-		ServerName:         smtpServer.host,
-	}
 
-	conn, err := tls.Dial("tcp", smtpServer.serverName(), tlsconfig)
+	return mail.send(ctx, "confirmation", data)
+}
 
-	if err != nil {
-		log.Println(err)
-		return err
+//SendPasswordResetMail sends a user the link to choose a new password, which stops working after expires
+func (mail *Mail) SendPasswordResetMail(ctx context.Context, name, url string, expires time.Duration) error {
+	data := map[string]string{
+		"name":    strings.Title(name),
+		"url":     url,
+		"minutes": strconv.Itoa(int(expires / time.Minute)),
 	}
 
-	client, err := smtp.NewClient(conn, smtpServer.host)
-	if err != nil {
-		log.Println(err)
-		return err
-	}
+	return mail.send(ctx, "password-reset", data)
+}
 
-	// step 1: Use Auth
-	if err = client.Auth(auth); err != nil {
-		log.Println(err)
-		return err
+//SendAccountLockedMail tells a user their account was locked for a while after too many failed logins,
+//with a link to choose a new password in case it wasn't them
+func (mail *Mail) SendAccountLockedMail(ctx context.Context, name, url string, lockedFor time.Duration) error {
+	data := map[string]string{
+		"name":    strings.Title(name),
+		"url":     url,
+		"minutes": strconv.Itoa(int(lockedFor / time.Minute)),
 	}
 
-	// step 2: add all from and to
-	if err = client.Mail(os.Getenv("email")); err != nil {
-		log.Println(err)
-		return err
-	}
+	return mail.send(ctx, "account-locked", data)
+}
 
-	if err = client.Rcpt(mail.To); err != nil {
-		log.Println(err)
-		return err
+//SendBidAlertMail sends an email to the owner of an item that a bid has been placed on his item
+func (mail *Mail) SendBidAlertMail(ctx context.Context, name, url string) error {
+	data := map[string]string{
+		"name": name,
+		"url":  url,
 	}
 
-	// Data
-	w, err := client.Data()
-	if err != nil {
-		log.Println(err)
-		return err
-	}
+	return mail.send(ctx, "bid-alert", data)
+}
 
-	_, err = w.Write([]byte(message))
-	if err != nil {
-		log.Println(err)
-		return err
+//SendItemStatusMail tells the owner of an item that it moved on to status or, when toRecipient, the person it is or
+//was reserved for
+func (mail *Mail) SendItemStatusMail(ctx context.Context, name, item, status, url string, toRecipient bool) error {
+	data := map[string]string{
+		"name":   strings.Title(name),
+		"item":   item,
+		"status": status,
+		"url":    url,
 	}
 
-	err = w.Close()
-	if err != nil {
-		log.Println(err)
-		return err
+	if toRecipient {
+		data["recipient"] = "true"
 	}
 
-	client.Quit()
-	return nil
-
+	return mail.send(ctx, "item-status", data)
 }
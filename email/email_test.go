@@ -0,0 +1,438 @@
+package email
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Samuyi/www/config"
+)
+
+func testMessage() *Message {
+	return &Message{
+		From:    "Giveaway <noreply@example.com>",
+		To:      []string{"Ada <ada@example.com>"},
+		Subject: "Bid placed on your item ✓",
+		HTML:    "<p>Someone wants your sofa</p>",
+	}
+}
+
+func TestMessageBytes(t *testing.T) {
+	raw, err := testMessage().Bytes()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := mail.ReadMessage(strings.NewReader(string(raw)))
+
+	if err != nil {
+		t.Fatalf("message doesn't parse: %v\n%s", err, raw)
+	}
+
+	subject, err := new(mime.WordDecoder).DecodeHeader(parsed.Header.Get("Subject"))
+
+	if err != nil || subject != "Bid placed on your item ✓" {
+		t.Fatalf("subject: got %q, %v", subject, err)
+	}
+
+	for name, want := range map[string]string{
+		"From":         `"Giveaway" <noreply@example.com>`,
+		"To":           `"Ada" <ada@example.com>`,
+		"MIME-Version": "1.0",
+	} {
+		if got := parsed.Header.Get(name); got != want {
+			t.Errorf("%s: got %q, want %q", name, got, want)
+		}
+	}
+
+	if _, err = parsed.Header.Date(); err != nil {
+		t.Errorf("Date: %v", err)
+	}
+
+	if strings.Contains(strings.ReplaceAll(string(raw), "\r\n", ""), "\n") {
+		t.Errorf("lines must end in CRLF:\n%q", raw)
+	}
+
+	msg := testMessage()
+	msg.From = ""
+
+	if _, err = msg.Bytes(); err == nil {
+		t.Error("a message without a sender must not be formatted")
+	}
+}
+
+func TestMultipartMessage(t *testing.T) {
+	msg := testMessage()
+	msg.Text = "Someone wants your sofa"
+
+	raw, err := msg.Bytes()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := mail.ReadMessage(strings.NewReader(string(raw)))
+
+	if err != nil {
+		t.Fatalf("message doesn't parse: %v\n%s", err, raw)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("Content-Type: got %q, %v", mediaType, err)
+	}
+
+	parts := multipart.NewReader(parsed.Body, params["boundary"])
+
+	for _, want := range []struct{ contentType, content string }{
+		{"text/plain", msg.Text},
+		{"text/html", msg.HTML},
+	} {
+		part, err := parts.NextRawPart()
+
+		if err != nil {
+			t.Fatalf("%s part: %v", want.contentType, err)
+		}
+
+		if got, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type")); got != want.contentType {
+			t.Fatalf("part: got %q, want %q", got, want.contentType)
+		}
+
+		content, err := io.ReadAll(quotedprintable.NewReader(part))
+
+		if err != nil || string(content) != want.content {
+			t.Fatalf("%s part: got %q, %v", want.contentType, content, err)
+		}
+	}
+
+	if _, err = parts.NextPart(); err != io.EOF {
+		t.Fatalf("expected two parts, got %v", err)
+	}
+}
+
+func TestTemplates(t *testing.T) {
+	recorder := NewRecorder()
+
+	for _, dir := range []string{"", "templates"} {
+		if err := Init(config.SMTP{From: "Giveaway <noreply@example.com>", TemplateDir: dir}, recorder); err != nil {
+			t.Fatalf("templates from %q: %v", dir, err)
+		}
+	}
+
+	for locale, subject := range map[string]string{
+		"":      "Welcome to our network",
+		"fr":    "Bienvenue sur notre réseau",
+		"fr-CA": "Bienvenue sur notre réseau",
+		"de":    "Welcome to our network",
+	} {
+		recorder.Reset()
+
+		mail := &Mail{To: "ada@example.com", Locale: locale}
+
+		if err := mail.SendConfirmationMail(context.Background(), "ada <b>", "http://localhost/confirm?key=1&x=2"); err != nil {
+			t.Fatal(err)
+		}
+
+		msg := recorder.Messages()[0]
+
+		if msg.Subject != subject {
+			t.Errorf("locale %q: got subject %q, want %q", locale, msg.Subject, subject)
+		}
+
+		if !strings.Contains(msg.Text, "Ada <B>") || !strings.Contains(msg.Text, "http://localhost/confirm?key=1&x=2") {
+			t.Errorf("locale %q: text part is missing the name or link:\n%s", locale, msg.Text)
+		}
+
+		if strings.Contains(msg.HTML, "<b>Ada <B></b>") || !strings.Contains(msg.HTML, "key=1&amp;x=2") {
+			t.Errorf("locale %q: html part isn't escaped:\n%s", locale, msg.HTML)
+		}
+
+		if msg.From != "Giveaway <noreply@example.com>" {
+			t.Errorf("locale %q: got sender %q", locale, msg.From)
+		}
+	}
+
+	for header, want := range map[string]string{
+		"":                        "en",
+		"fr-FR,fr;q=0.9,en;q=0.8": "fr",
+		"de-DE,de;q=0.9,en;q=0.5": "en",
+		"en;q=0.5,fr;q=0.9":       "fr",
+		"fr;q=0,en":               "en",
+		"*":                       "en",
+	} {
+		if got := Locale(header); got != want {
+			t.Errorf("Locale(%q): got %q, want %q", header, got, want)
+		}
+	}
+}
+
+func TestItemStatusMail(t *testing.T) {
+	recorder := NewRecorder()
+
+	if err := Init(config.SMTP{From: "Giveaway <noreply@example.com>"}, recorder); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		locale, status string
+		toRecipient    bool
+		subject        string
+	}{
+		{"en", "open", false, "Your item is listed"},
+		{"en", "reserved", false, "Your item is reserved"},
+		{"en", "reserved", true, "An item was reserved for you"},
+		{"en", "handed_over", true, "An item was handed over to you"},
+		{"en", "cancelled", true, "Your reservation has ended"},
+		{"en", "expired", false, "Your item has expired"},
+		{"fr", "handed_over", false, "Merci d'avoir donné votre objet"},
+	} {
+		recorder.Reset()
+
+		mail := &Mail{To: "ada@example.com", Locale: c.locale}
+
+		if err := mail.SendItemStatusMail(context.Background(), "ada", "Sofa <b>", c.status, "http://localhost/?id=1", c.toRecipient); err != nil {
+			t.Fatal(err)
+		}
+
+		msg := recorder.Messages()[0]
+
+		if msg.Subject != c.subject {
+			t.Errorf("%s %s: got subject %q, want %q", c.locale, c.status, msg.Subject, c.subject)
+		}
+
+		if !strings.Contains(msg.Text, "Sofa <b>") || !strings.Contains(msg.HTML, "Sofa &lt;b&gt;") {
+			t.Errorf("%s %s: the item is missing or not escaped:\n%s\n%s", c.locale, c.status, msg.Text, msg.HTML)
+		}
+	}
+}
+
+func TestFileTransport(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "drop")
+
+	if err := NewFileTransport(dir).Send(context.Background(), testMessage()); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+
+	if err != nil || len(files) != 1 {
+		t.Fatalf("expected one .eml file, got %v %v", files, err)
+	}
+
+	raw, err := os.ReadFile(files[0])
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(raw), "Someone wants your sofa") {
+		t.Fatalf("unexpected file contents:\n%s", raw)
+	}
+}
+
+func TestSMTPTransport(t *testing.T) {
+	cert, roots := testCertificate(t)
+
+	for _, security := range []string{"tls", "starttls", "none"} {
+		t.Run(security, func(t *testing.T) {
+			server := newFakeSMTP(t, security, cert)
+
+			transport := &SMTPTransport{
+				Host:      "127.0.0.1",
+				Port:      server.port,
+				Username:  "user",
+				Password:  "secret",
+				Security:  security,
+				TLSConfig: &tls.Config{ServerName: "127.0.0.1", RootCAs: roots},
+				Timeout:   5 * time.Second,
+			}
+
+			if err := transport.Send(context.Background(), testMessage()); err != nil {
+				t.Fatal(err)
+			}
+
+			got := <-server.received
+
+			if got.from != "noreply@example.com" || got.to != "ada@example.com" || !strings.Contains(got.data, "Someone wants your sofa") {
+				t.Fatalf("server received %+v", got)
+			}
+		})
+	}
+
+	// without the test root the certificate must be rejected rather than skipped
+	for _, security := range []string{"tls", "starttls"} {
+		t.Run(security+" unverified", func(t *testing.T) {
+			server := newFakeSMTP(t, security, cert)
+
+			transport := &SMTPTransport{Host: "127.0.0.1", Port: server.port, Security: security, Timeout: 5 * time.Second}
+
+			if err := transport.Send(context.Background(), testMessage()); err == nil {
+				t.Fatal("a certificate that doesn't chain to a trusted root was accepted")
+			}
+		})
+	}
+}
+
+type fakeDelivery struct {
+	from, to, data string
+}
+
+type fakeSMTP struct {
+	port     int
+	received chan fakeDelivery
+}
+
+//newFakeSMTP starts a server that speaks just enough smtp to accept one message
+func newFakeSMTP(t *testing.T, security string, cert tls.Certificate) *fakeSMTP {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	server := &fakeSMTP{port: listener.Addr().(*net.TCPAddr).Port, received: make(chan fakeDelivery, 1)}
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}}
+
+	go func() {
+		conn, err := listener.Accept()
+
+		if err != nil {
+			return
+		}
+		defer func() { conn.Close() }()
+
+		if security == "tls" {
+			conn = tls.Server(conn, tlsConfig)
+		}
+
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+		r := bufio.NewReader(conn)
+		reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+
+		var delivery fakeDelivery
+		encrypted := security == "tls"
+
+		reply("220 localhost ESMTP")
+
+		for {
+			line, err := r.ReadString('\n')
+
+			if err != nil {
+				return
+			}
+
+			command := strings.ToUpper(strings.Fields(line + " x")[0])
+
+			switch command {
+			case "EHLO":
+				if !encrypted && security == "starttls" {
+					reply("250-localhost")
+					reply("250 STARTTLS")
+				} else {
+					reply("250-localhost")
+					reply("250 AUTH PLAIN")
+				}
+			case "STARTTLS":
+				reply("220 ready")
+				conn = tls.Server(conn, tlsConfig)
+				r = bufio.NewReader(conn)
+				encrypted = true
+			case "AUTH":
+				reply("235 ok")
+			case "MAIL":
+				delivery.from = strings.Trim(strings.TrimSpace(line[len("MAIL FROM:"):]), "<>")
+				reply("250 ok")
+			case "RCPT":
+				delivery.to = strings.Trim(strings.TrimSpace(line[len("RCPT TO:"):]), "<>")
+				reply("250 ok")
+			case "DATA":
+				reply("354 go ahead")
+
+				var data strings.Builder
+
+				for {
+					line, err := r.ReadString('\n')
+
+					if err != nil {
+						return
+					}
+
+					if line == ".\r\n" {
+						break
+					}
+					data.WriteString(line)
+				}
+
+				delivery.data = data.String()
+				server.received <- delivery
+				reply("250 ok")
+			case "QUIT":
+				reply("221 bye")
+				return
+			default:
+				reply("502 unknown command")
+			}
+		}
+	}()
+
+	return server
+}
+
+//testCertificate returns a self signed certificate for 127.0.0.1 and a pool trusting it
+func testCertificate(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := x509.ParseCertificate(der)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(parsed)
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, roots
+}
@@ -0,0 +1,39 @@
+package email
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+)
+
+//FileTransport writes every message to an .eml file in Dir instead of sending it, for local development
+type FileTransport struct {
+	Dir string
+}
+
+//NewFileTransport returns a transport that drops messages in dir
+func NewFileTransport(dir string) *FileTransport {
+	return &FileTransport{Dir: dir}
+}
+
+//Send writes msg to a new file in the drop directory
+func (t *FileTransport) Send(ctx context.Context, msg *Message) error {
+	body, err := msg.Bytes()
+
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(t.Dir, 0700); err != nil {
+		return err
+	}
+
+	// write to a temporary name first so nothing reading the directory sees half a message
+	name := filepath.Join(t.Dir, randomName())
+
+	if err = os.WriteFile(name+".tmp", body, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(name+".tmp", name+".eml")
+}
@@ -0,0 +1,49 @@
+package email
+
+import (
+	"context"
+	"sync"
+)
+
+//Recorder keeps every message it is given in memory, for tests
+type Recorder struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+//NewRecorder returns an empty recorder
+func NewRecorder() *Recorder {
+	return &Recorder{}
+}
+
+//Send records msg after checking that it can be formatted
+func (r *Recorder) Send(ctx context.Context, msg *Message) error {
+	if _, err := msg.Bytes(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	sent := *msg
+	sent.To = append([]string(nil), msg.To...)
+	r.messages = append(r.messages, sent)
+
+	return nil
+}
+
+//Messages returns a copy of every message recorded so far
+func (r *Recorder) Messages() []Message {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]Message(nil), r.messages...)
+}
+
+//Reset forgets every recorded message
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.messages = nil
+}
@@ -0,0 +1,152 @@
+package email
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strconv"
+	"time"
+
+	"github.com/Samuyi/www/config"
+)
+
+//SMTPTransport sends messages through an smtp server
+type SMTPTransport struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+
+	//Security is tls for implicit tls, starttls to upgrade a plain connection or none
+	Security string
+
+	//TLSConfig is used for both kinds of tls, by default the server certificate is verified against the system roots
+	TLSConfig *tls.Config
+
+	Timeout time.Duration
+}
+
+//NewSMTPTransport returns an smtp transport configured from cfg
+func NewSMTPTransport(cfg config.SMTP) *SMTPTransport {
+	return &SMTPTransport{
+		Host:     cfg.Host,
+		Port:     cfg.Port,
+		Username: cfg.Username,
+		Password: cfg.Password,
+		Security: cfg.Security,
+		Timeout:  30 * time.Second,
+	}
+}
+
+func (t *SMTPTransport) tlsConfig() *tls.Config {
+	if t.TLSConfig != nil {
+		return t.TLSConfig
+	}
+
+	return &tls.Config{ServerName: t.Host}
+}
+
+func (t *SMTPTransport) dial() (*smtp.Client, error) {
+	addr := net.JoinHostPort(t.Host, strconv.Itoa(t.Port))
+	dialer := &net.Dialer{Timeout: t.Timeout}
+
+	var conn net.Conn
+	var err error
+
+	if t.Security == "tls" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, t.tlsConfig())
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if t.Timeout > 0 {
+		conn.SetDeadline(time.Now().Add(t.Timeout))
+	}
+
+	client, err := smtp.NewClient(conn, t.Host)
+
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if t.Security == "starttls" {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			client.Close()
+			return nil, fmt.Errorf("email: %s doesn't support STARTTLS", addr)
+		}
+
+		if err = client.StartTLS(t.tlsConfig()); err != nil {
+			client.Close()
+			return nil, err
+		}
+	}
+
+	return client, nil
+}
+
+//Send delivers msg over a new connection to the server
+func (t *SMTPTransport) Send(ctx context.Context, msg *Message) error {
+	body, err := msg.Bytes()
+
+	if err != nil {
+		return err
+	}
+
+	recipients, err := msg.recipients()
+
+	if err != nil {
+		return err
+	}
+
+	from, err := address(msg.From)
+
+	if err != nil {
+		return err
+	}
+
+	client, err := t.dial()
+
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if t.Username != "" {
+		if err = client.Auth(smtp.PlainAuth("", t.Username, t.Password, t.Host)); err != nil {
+			return err
+		}
+	}
+
+	if err = client.Mail(from); err != nil {
+		return err
+	}
+
+	for _, recipient := range recipients {
+		if err = client.Rcpt(recipient); err != nil {
+			return err
+		}
+	}
+
+	w, err := client.Data()
+
+	if err != nil {
+		return err
+	}
+
+	if _, err = w.Write(body); err != nil {
+		return err
+	}
+
+	if err = w.Close(); err != nil {
+		return err
+	}
+
+	return client.Quit()
+}
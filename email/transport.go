@@ -0,0 +1,188 @@
+package email
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+//Transport delivers a message
+type Transport interface {
+	Send(ctx context.Context, msg *Message) error
+}
+
+//Message is an email ready to be handed to a transport
+type Message struct {
+	From    string    `json:"from"`
+	To      []string  `json:"to"`
+	Subject string    `json:"subject"`
+	Text    string    `json:"text,omitempty"`
+	HTML    string    `json:"html"`
+	Date    time.Time `json:"date"`
+
+	//Template is the name of the template the message was made from
+	Template string `json:"template,omitempty"`
+}
+
+//Bytes returns the message formatted as described in RFC 5322
+func (msg *Message) Bytes() ([]byte, error) {
+	from, err := mail.ParseAddress(msg.From)
+
+	if err != nil {
+		return nil, fmt.Errorf("email: invalid from address %q: %v", msg.From, err)
+	}
+
+	if len(msg.To) == 0 {
+		return nil, fmt.Errorf("email: message has no recipients")
+	}
+
+	var to []string
+
+	for _, address := range msg.To {
+		parsed, err := mail.ParseAddress(address)
+
+		if err != nil {
+			return nil, fmt.Errorf("email: invalid recipient %q: %v", address, err)
+		}
+
+		to = append(to, parsed.String())
+	}
+
+	date := msg.Date
+
+	if date.IsZero() {
+		date = time.Now()
+	}
+
+	var buf bytes.Buffer
+
+	header := func(name, value string) {
+		buf.WriteString(name + ": " + value + "\r\n")
+	}
+
+	header("From", from.String())
+	header("To", strings.Join(to, ", "))
+	header("Subject", mime.QEncoding.Encode("utf-8", msg.Subject))
+	header("Date", date.Format(time.RFC1123Z))
+	header("Message-ID", messageID(from.Address))
+	header("MIME-Version", "1.0")
+
+	if msg.Text == "" || msg.HTML == "" {
+		contentType, content := `text/html; charset="UTF-8"`, msg.HTML
+
+		if msg.HTML == "" {
+			contentType, content = `text/plain; charset="UTF-8"`, msg.Text
+		}
+
+		header("Content-Type", contentType)
+		header("Content-Transfer-Encoding", "quoted-printable")
+		buf.WriteString("\r\n")
+
+		if err = writeQuotedPrintable(&buf, content); err != nil {
+			return nil, err
+		}
+
+		return buf.Bytes(), nil
+	}
+
+	// the parts go from the plainest to the richest, mail clients show the last one they understand
+	var parts bytes.Buffer
+
+	alternatives := multipart.NewWriter(&parts)
+
+	header("Content-Type", mime.FormatMediaType("multipart/alternative", map[string]string{"boundary": alternatives.Boundary()}))
+	buf.WriteString("\r\n")
+
+	for _, part := range []struct{ contentType, content string }{
+		{`text/plain; charset="UTF-8"`, msg.Text},
+		{`text/html; charset="UTF-8"`, msg.HTML},
+	} {
+		w, err := alternatives.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+
+		if err != nil {
+			return nil, err
+		}
+
+		if err = writeQuotedPrintable(w, part.content); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = alternatives.Close(); err != nil {
+		return nil, err
+	}
+
+	buf.Write(parts.Bytes())
+
+	return buf.Bytes(), nil
+}
+
+//writeQuotedPrintable writes content to w in the quoted-printable encoding with CRLF line endings
+func writeQuotedPrintable(w io.Writer, content string) error {
+	body := quotedprintable.NewWriter(w)
+
+	if _, err := body.Write([]byte(content)); err != nil {
+		return err
+	}
+
+	return body.Close()
+}
+
+//recipients returns the bare addresses of everyone the message is sent to
+func (msg *Message) recipients() ([]string, error) {
+	var addresses []string
+
+	for _, value := range msg.To {
+		parsed, err := address(value)
+
+		if err != nil {
+			return nil, err
+		}
+
+		addresses = append(addresses, parsed)
+	}
+
+	return addresses, nil
+}
+
+//address returns the bare address in a value such as "Name <name@example.com>"
+func address(value string) (string, error) {
+	parsed, err := mail.ParseAddress(value)
+
+	if err != nil {
+		return "", fmt.Errorf("email: invalid address %q: %v", value, err)
+	}
+
+	return parsed.Address, nil
+}
+
+func messageID(from string) string {
+	domain := "localhost"
+
+	if at := strings.LastIndex(from, "@"); at >= 0 {
+		domain = from[at+1:]
+	}
+
+	return "<" + randomName() + "@" + domain + ">"
+}
+
+//randomName returns a name that is unique enough for message ids and file names
+func randomName() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+
+	return fmt.Sprintf("%d.%s", time.Now().UnixNano(), hex.EncodeToString(b))
+}
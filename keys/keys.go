@@ -0,0 +1,201 @@
+package keys
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+
+	"github.com/Samuyi/www/config"
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+//ErrUnknownKey is returned when a token is signed with a key that isn't configured or is retired
+var ErrUnknownKey = errors.New("keys: token is signed with an unknown or retired key")
+
+//Key is a key tokens are signed or verified with
+type Key struct {
+	ID      string
+	Method  jwt.SigningMethod
+	Retired bool
+
+	signing   interface{}
+	verifying interface{}
+}
+
+//Manager signs tokens with the active key and verifies them with any key that isn't retired
+type Manager struct {
+	keys   map[string]*Key
+	active *Key
+}
+
+//New returns a manager holding the keys in cfg, reading the private keys from disk
+func New(cfg config.Auth) (*Manager, error) {
+	configured, active := cfg.SigningKeys()
+
+	m := &Manager{keys: map[string]*Key{}}
+
+	for _, c := range configured {
+		key, err := load(c)
+
+		if err != nil {
+			return nil, err
+		}
+
+		m.keys[key.ID] = key
+
+		if key.ID == active && !key.Retired {
+			m.active = key
+		}
+	}
+
+	if m.active == nil {
+		return nil, fmt.Errorf("keys: the active key %q isn't configured or is retired", active)
+	}
+
+	return m, nil
+}
+
+func load(c config.SigningKey) (*Key, error) {
+	key := &Key{ID: c.ID, Retired: c.Retired}
+
+	if c.Algorithm == "HS256" {
+		key.Method = jwt.SigningMethodHS256
+		key.signing = []byte(c.Secret)
+		key.verifying = key.signing
+
+		return key, nil
+	}
+
+	raw, err := os.ReadFile(c.PrivateKeyFile)
+
+	if err != nil {
+		return nil, fmt.Errorf("keys: %s: %v", c.ID, err)
+	}
+
+	block, _ := pem.Decode(raw)
+
+	if block == nil {
+		return nil, fmt.Errorf("keys: %s: %s has no PEM data", c.ID, c.PrivateKeyFile)
+	}
+
+	private, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+
+	if err != nil {
+		private, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("keys: %s: %v", c.ID, err)
+	}
+
+	switch private := private.(type) {
+	case *rsa.PrivateKey:
+		if c.Algorithm != "RS256" {
+			break
+		}
+
+		key.Method = jwt.SigningMethodRS256
+		key.signing = private
+		key.verifying = &private.PublicKey
+
+		return key, nil
+	case ed25519.PrivateKey:
+		if c.Algorithm != "EdDSA" {
+			break
+		}
+
+		key.Method = SigningMethodEdDSA
+		key.signing = private
+		key.verifying = private.Public()
+
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("keys: %s: %s doesn't hold a %s key", c.ID, c.PrivateKeyFile, c.Algorithm)
+}
+
+//Sign signs claims with the active key, naming it in the kid header
+func (m *Manager) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(m.active.Method, claims)
+	token.Header["kid"] = m.active.ID
+
+	return token.SignedString(m.active.signing)
+}
+
+//Parse verifies a token with the key its kid names and fills in claims
+func (m *Manager) Parse(tokenString string, claims jwt.Claims) (*jwt.Token, error) {
+	return jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, ok := m.keys[kid]
+
+		if !ok || key.Retired {
+			return nil, ErrUnknownKey
+		}
+
+		// the token says which algorithm it uses, make sure it is the one of the key
+		if token.Method.Alg() != key.Method.Alg() {
+			return nil, fmt.Errorf("keys: token is signed with %s but key %q is %s", token.Method.Alg(), kid, key.Method.Alg())
+		}
+
+		return key.verifying, nil
+	})
+}
+
+//JWK is a public key in the JSON Web Key format of RFC 7517
+type JWK struct {
+	KeyType   string `json:"kty"`
+	ID        string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+}
+
+//JWKS returns the public keys other services can verify tokens with. Symmetric keys are never published.
+func (m *Manager) JWKS() map[string][]JWK {
+	set := []JWK{}
+
+	for _, key := range m.keys {
+		if key.Retired {
+			continue
+		}
+
+		switch public := key.verifying.(type) {
+		case *rsa.PublicKey:
+			set = append(set, JWK{
+				KeyType:   "RSA",
+				ID:        key.ID,
+				Use:       "sig",
+				Algorithm: key.Method.Alg(),
+				N:         encode(public.N.Bytes()),
+				E:         encode(big.NewInt(int64(public.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			set = append(set, JWK{
+				KeyType:   "OKP",
+				ID:        key.ID,
+				Use:       "sig",
+				Algorithm: key.Method.Alg(),
+				Curve:     "Ed25519",
+				X:         encode(public),
+			})
+		}
+	}
+
+	sort.Slice(set, func(i, j int) bool { return set[i].ID < set[j].ID })
+
+	return map[string][]JWK{"keys": set}
+}
+
+func encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,219 @@
+package keys
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Samuyi/www/config"
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+//writeKey saves private as a PKCS #8 PEM file and returns its path
+func writeKey(t *testing.T, name string, private interface{}) string {
+	t.Helper()
+
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), name+".pem")
+
+	if err = os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func testKeys(t *testing.T) (config.Auth, *rsa.PrivateKey, ed25519.PrivateKey) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	auth := config.Auth{
+		Keys: []config.SigningKey{
+			{ID: "2019", Algorithm: "HS256", Secret: "an old shared secret"},
+			{ID: "2020", Algorithm: "RS256", PrivateKeyFile: writeKey(t, "rsa", rsaKey)},
+			{ID: "2021", Algorithm: "EdDSA", PrivateKeyFile: writeKey(t, "ed25519", edKey)},
+		},
+	}
+
+	return auth, rsaKey, edKey
+}
+
+func claims() jwt.MapClaims {
+	return jwt.MapClaims{"sessionID": "abc", "exp": time.Now().Add(time.Minute).Unix()}
+}
+
+func TestSignAndParse(t *testing.T) {
+	auth, _, _ := testKeys(t)
+
+	for _, active := range []string{"2019", "2020", "2021"} {
+		auth.ActiveKey = active
+
+		m, err := New(auth)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		signed, err := m.Sign(claims())
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		parsed := jwt.MapClaims{}
+		token, err := m.Parse(signed, parsed)
+
+		if err != nil || !token.Valid || parsed["sessionID"] != "abc" {
+			t.Fatalf("%s: got %v, %v", active, parsed, err)
+		}
+
+		if token.Header["kid"] != active {
+			t.Fatalf("%s: signed with kid %v", active, token.Header["kid"])
+		}
+	}
+}
+
+func TestRotation(t *testing.T) {
+	auth, _, _ := testKeys(t)
+	auth.ActiveKey = "2020"
+
+	old, err := New(auth)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signed, err := old.Sign(claims())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a new active key still accepts tokens signed with the previous one
+	auth.ActiveKey = "2021"
+	rotated, err := New(auth)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = rotated.Parse(signed, jwt.MapClaims{}); err != nil {
+		t.Fatalf("token signed with the previous key: %v", err)
+	}
+
+	// until that key is retired
+	auth.Keys[1].Retired = true
+	retired, err := New(auth)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = retired.Parse(signed, jwt.MapClaims{}); err == nil {
+		t.Fatal("a token signed with a retired key was accepted")
+	}
+
+	auth.ActiveKey = "2020"
+
+	if _, err = New(auth); err == nil {
+		t.Fatal("a retired key was made active")
+	}
+}
+
+func TestRejectsForgedTokens(t *testing.T) {
+	auth, rsaKey, _ := testKeys(t)
+	auth.ActiveKey = "2020"
+
+	m, err := New(auth)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	public, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	forge := func(method jwt.SigningMethod, kid string, key interface{}) string {
+		token := jwt.NewWithClaims(method, claims())
+
+		if kid != "" {
+			token.Header["kid"] = kid
+		}
+
+		signed, err := token.SignedString(key)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return signed
+	}
+
+	for name, signed := range map[string]string{
+		// the published rsa key used as an hmac secret
+		"algorithm confusion": forge(jwt.SigningMethodHS256, "2020", public),
+		"unknown kid":         forge(jwt.SigningMethodHS256, "1999", []byte("an old shared secret")),
+		"no kid":              forge(jwt.SigningMethodHS256, "", []byte("an old shared secret")),
+		"wrong secret":        forge(jwt.SigningMethodHS256, "2019", []byte("a guessed secret")),
+		"unsigned":            forge(jwt.SigningMethodNone, "2019", jwt.UnsafeAllowNoneSignatureType),
+	} {
+		if _, err := m.Parse(signed, jwt.MapClaims{}); err == nil {
+			t.Errorf("%s: token was accepted", name)
+		}
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	auth, rsaKey, edKey := testKeys(t)
+	auth.ActiveKey = "2021"
+
+	m, err := New(auth)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	set := m.JWKS()["keys"]
+
+	if len(set) != 2 || set[0].ID != "2020" || set[1].ID != "2021" {
+		t.Fatalf("jwks: got %+v", set)
+	}
+
+	n, _ := base64.RawURLEncoding.DecodeString(set[0].N)
+	e, _ := base64.RawURLEncoding.DecodeString(set[0].E)
+
+	if set[0].KeyType != "RSA" || set[0].Algorithm != "RS256" || new(big.Int).SetBytes(n).Cmp(rsaKey.N) != 0 || new(big.Int).SetBytes(e).Int64() != int64(rsaKey.E) {
+		t.Errorf("rsa key: got %+v", set[0])
+	}
+
+	x, _ := base64.RawURLEncoding.DecodeString(set[1].X)
+
+	if set[1].KeyType != "OKP" || set[1].Curve != "Ed25519" || set[1].Algorithm != "EdDSA" || !ed25519.PublicKey(x).Equal(edKey.Public()) {
+		t.Errorf("ed25519 key: got %+v", set[1])
+	}
+}
@@ -0,0 +1,68 @@
+//Package logging writes the structured logs of the application and carries the logger of each request
+//in its context, so everything logged while serving a request can be traced back to it
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+type contextKey int
+
+const (
+	loggerKey contextKey = iota
+	requestIDKey
+)
+
+//New returns a logger writing json lines to w, leaving out records below level
+func New(w io.Writer, level slog.Level) *slog.Logger {
+	return slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{Level: level}))
+}
+
+//ParseLevel reads debug, info, warn or error, reporting false for anything else
+func ParseLevel(name string) (slog.Level, bool) {
+	var level slog.Level
+
+	if err := level.UnmarshalText([]byte(strings.TrimSpace(name))); err != nil {
+		return slog.LevelInfo, false
+	}
+
+	return level, true
+}
+
+//NewContext returns a copy of ctx carrying logger
+func NewContext(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey, logger)
+}
+
+//From returns the logger ctx carries, the default logger when it has none
+func From(ctx context.Context) *slog.Logger {
+	if ctx != nil {
+		if logger, ok := ctx.Value(loggerKey).(*slog.Logger); ok {
+			return logger
+		}
+	}
+
+	return slog.Default()
+}
+
+//With returns a copy of ctx whose logger adds args to every record
+func With(ctx context.Context, args ...interface{}) context.Context {
+	return NewContext(ctx, From(ctx).With(args...))
+}
+
+//WithRequestID returns a copy of ctx belonging to the request with id, whose logger records it
+func WithRequestID(ctx context.Context, id string) context.Context {
+	ctx = context.WithValue(ctx, requestIDKey, id)
+
+	return With(ctx, "request_id", id)
+}
+
+//RequestID returns the id of the request ctx belongs to, "" outside of a request
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+
+	return id
+}
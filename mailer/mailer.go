@@ -0,0 +1,165 @@
+package mailer
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/Samuyi/www/config"
+	"github.com/Samuyi/www/email"
+	"github.com/Samuyi/www/logging"
+	"github.com/Samuyi/www/models"
+	"github.com/Samuyi/www/models/outbox"
+)
+
+//Mailer is an email transport that saves messages to an outbox and delivers them from a pool of workers,
+//retrying failures with exponential backoff and burying messages that fail too often.
+type Mailer struct {
+	store    models.OutboxStore
+	delivery email.Transport
+	cfg      config.Outbox
+
+	wake   chan struct{}
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+//New returns a mailer that queues messages in store and delivers them with delivery
+func New(store models.OutboxStore, delivery email.Transport, cfg config.Outbox) *Mailer {
+	return &Mailer{
+		store:    store,
+		delivery: delivery,
+		cfg:      cfg,
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+//Send queues msg. It only fails when the outbox can't be written to.
+func (m *Mailer) Send(ctx context.Context, msg *email.Message) error {
+	if msg.Date.IsZero() {
+		msg.Date = time.Now()
+	}
+
+	err := m.store.Enqueue(ctx, &outbox.Entry{Message: *msg})
+
+	if err != nil {
+		return err
+	}
+
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+//Start runs the workers until Stop is called
+func (m *Mailer) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	m.cancel = cancel
+
+	for i := 0; i < m.cfg.Workers; i++ {
+		m.wg.Add(1)
+
+		go func() {
+			defer m.wg.Done()
+			m.work(ctx)
+		}()
+	}
+}
+
+//Stop waits for the messages being delivered and stops the workers. Anything still queued is sent on the next start.
+func (m *Mailer) Stop() {
+	if m.cancel == nil {
+		return
+	}
+
+	m.cancel()
+	m.wg.Wait()
+}
+
+func (m *Mailer) work(ctx context.Context) {
+	ticker := time.NewTicker(m.cfg.PollInterval.Duration)
+	defer ticker.Stop()
+
+	for {
+		// keep going while there is work so a backlog drains without waiting for the ticker
+		for ctx.Err() == nil && m.deliverNext() {
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-m.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+//deliverNext sends the next due message and reports whether there was one
+func (m *Mailer) deliverNext() bool {
+	// deliveries aren't cut short by Stop, they finish and record how they went
+	ctx := logging.With(context.Background(), "component", "mailer")
+
+	entry, err := m.store.Claim(ctx, m.cfg.Lease.Duration)
+
+	if err != nil {
+		logging.From(ctx).Error("claiming an email", "err", err)
+		return false
+	}
+
+	if entry == nil {
+		return false
+	}
+
+	err = m.delivery.Send(ctx, &entry.Message)
+
+	if err == nil {
+		if err = m.store.Complete(ctx, entry); err != nil {
+			logging.From(ctx).Error("completing an email", "id", entry.ID, "err", err)
+		}
+
+		return true
+	}
+
+	entry.Attempts++
+	entry.LastError = err.Error()
+
+	if entry.Attempts >= m.cfg.MaxAttempts {
+		logging.From(ctx).Warn("giving up on an email", "id", entry.ID, "to", entry.Message.To, "attempts", entry.Attempts, "err", err)
+
+		if err = m.store.Bury(ctx, entry, m.cfg.DeadTTL.Duration); err != nil {
+			logging.From(ctx).Error("burying an email", "id", entry.ID, "err", err)
+		}
+
+		return true
+	}
+
+	if err = m.store.Retry(ctx, entry, time.Now().Add(m.backoff(entry.Attempts))); err != nil {
+		logging.From(ctx).Error("rescheduling an email", "id", entry.ID, "err", err)
+	}
+
+	return true
+}
+
+//backoff returns how long to wait before the next attempt, doubling from the base delay up to the max delay
+//with up to a fifth added at random so messages that failed together don't retry together
+func (m *Mailer) backoff(attempts int) time.Duration {
+	delay := m.cfg.BaseDelay.Duration
+
+	for i := 1; i < attempts && delay < m.cfg.MaxDelay.Duration; i++ {
+		delay *= 2
+	}
+
+	if delay > m.cfg.MaxDelay.Duration {
+		delay = m.cfg.MaxDelay.Duration
+	}
+
+	if jitter := int64(delay / 5); jitter > 0 {
+		delay += time.Duration(rand.Int63n(jitter))
+	}
+
+	return delay
+}
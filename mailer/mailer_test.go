@@ -0,0 +1,189 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Samuyi/www/config"
+	"github.com/Samuyi/www/email"
+	"github.com/Samuyi/www/models/memory"
+)
+
+//flaky fails the first failures sends and records the rest
+type flaky struct {
+	mu       sync.Mutex
+	failures int
+	attempts int
+	sent     []email.Message
+}
+
+func (f *flaky) Send(ctx context.Context, msg *email.Message) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.attempts++
+
+	if f.attempts <= f.failures {
+		return errors.New("421 try again later")
+	}
+
+	f.sent = append(f.sent, *msg)
+
+	return nil
+}
+
+func (f *flaky) count() (attempts, sent int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.attempts, len(f.sent)
+}
+
+func testConfig() config.Outbox {
+	return config.Outbox{
+		Workers:      3,
+		MaxAttempts:  3,
+		BaseDelay:    config.Duration{Duration: 5 * time.Millisecond},
+		MaxDelay:     config.Duration{Duration: 10 * time.Millisecond},
+		PollInterval: config.Duration{Duration: 5 * time.Millisecond},
+		Lease:        config.Duration{Duration: time.Minute},
+		DeadTTL:      config.Duration{Duration: time.Minute},
+	}
+}
+
+func message() *email.Message {
+	return &email.Message{From: "noreply@example.com", To: []string{"ada@example.com"}, Subject: "hi", HTML: "<p>hi</p>"}
+}
+
+func waitFor(t *testing.T, what string, done func() bool) {
+	t.Helper()
+
+	for deadline := time.Now().Add(5 * time.Second); !done(); time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+	}
+}
+
+func TestRetriesUntilDelivered(t *testing.T) {
+	transport := &flaky{failures: 2}
+	store := memory.New().Outbox()
+	m := New(store, transport, testConfig())
+	m.Start()
+	defer m.Stop()
+
+	if err := m.Send(context.Background(), message()); err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, "delivery", func() bool {
+		_, sent := transport.count()
+		return sent == 1
+	})
+
+	if attempts, _ := transport.count(); attempts != 3 {
+		t.Fatalf("got %d attempts, want 3", attempts)
+	}
+
+	if dead, _ := store.Dead(context.Background()); len(dead) != 0 {
+		t.Fatalf("a delivered message was buried: %v", dead)
+	}
+
+	if entry, _ := store.Claim(context.Background(), time.Minute); entry != nil {
+		t.Fatalf("a delivered message is still queued: %v", entry)
+	}
+}
+
+func TestBuriesAfterMaxAttemptsAndRequeues(t *testing.T) {
+	transport := &flaky{failures: 3}
+	store := memory.New().Outbox()
+	m := New(store, transport, testConfig())
+	m.Start()
+	defer m.Stop()
+
+	if err := m.Send(context.Background(), message()); err != nil {
+		t.Fatal(err)
+	}
+
+	var buried []string
+
+	waitFor(t, "the message to be buried", func() bool {
+		dead, _ := store.Dead(context.Background())
+
+		for _, entry := range dead {
+			if entry.Attempts != 3 || entry.LastError != "421 try again later" {
+				t.Fatalf("dead letter: got %+v", entry)
+			}
+
+			buried = append(buried, entry.ID)
+		}
+
+		return len(buried) > 0
+	})
+
+	if err := store.Requeue(context.Background(), buried[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, "the requeued message", func() bool {
+		_, sent := transport.count()
+		return sent == 1
+	})
+
+	if dead, _ := store.Dead(context.Background()); len(dead) != 0 {
+		t.Fatalf("requeued message is still dead: %v", dead)
+	}
+}
+
+func TestDeadLettersExpire(t *testing.T) {
+	transport := &flaky{failures: 3}
+	store := memory.New().Outbox()
+	cfg := testConfig()
+	cfg.DeadTTL.Duration = 50 * time.Millisecond
+	m := New(store, transport, cfg)
+	m.Start()
+	defer m.Stop()
+
+	if err := m.Send(context.Background(), message()); err != nil {
+		t.Fatal(err)
+	}
+
+	var buried string
+
+	waitFor(t, "the message to be buried", func() bool {
+		dead, _ := store.Dead(context.Background())
+
+		if len(dead) > 0 {
+			buried = dead[0].ID
+		}
+
+		return buried != ""
+	})
+
+	waitFor(t, "the dead letter to expire", func() bool {
+		dead, _ := store.Dead(context.Background())
+		return len(dead) == 0
+	})
+
+	if err := store.Requeue(context.Background(), buried); err == nil {
+		t.Fatal("an expired dead letter was requeued")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	m := New(nil, nil, config.Outbox{
+		BaseDelay: config.Duration{Duration: time.Second},
+		MaxDelay:  config.Duration{Duration: 10 * time.Second},
+	})
+
+	for attempts, want := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 4: 8 * time.Second, 5: 10 * time.Second, 40: 10 * time.Second} {
+		got := m.backoff(attempts)
+
+		if got < want || got > want+want/5 {
+			t.Errorf("backoff after %d attempts: got %v, want %v plus at most a fifth", attempts, got, want)
+		}
+	}
+}
@@ -1,52 +1,172 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"log"
+	"log/slog"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/Samuyi/www/blobs"
+	"github.com/Samuyi/www/config"
 	"github.com/Samuyi/www/controllers"
-	"github.com/Samuyi/www/middleware"
-	"github.com/gorilla/mux"
+	"github.com/Samuyi/www/email"
+	"github.com/Samuyi/www/keys"
+	"github.com/Samuyi/www/logging"
+	"github.com/Samuyi/www/mailer"
+	"github.com/Samuyi/www/migrations"
+	"github.com/Samuyi/www/models"
+	"github.com/Samuyi/www/storage"
 )
 
 func main() {
-	router := mux.NewRouter()
-
-	router.HandleFunc("/api/users", middleware.ChainMiddlewares(controllers.RegisterUser, middleware.Method("POST", "OPTIONS"), middleware.WithCors())).Methods("POST", "OPTIONS")
-	router.HandleFunc("/api/users", middleware.ChainMiddlewares(controllers.GetAllUsers, middleware.Method("GET"), middleware.Auth())).Methods("GET")
-	router.HandleFunc("/api/users/{username}", middleware.ChainMiddlewares(controllers.GetUser, middleware.Method("GET"), middleware.Auth())).Methods("GET")
-	router.HandleFunc("/api/login", middleware.ChainMiddlewares(controllers.Login, middleware.Method("POST", "OPTIONS"))).Methods("POST", "OPTIONS")
-	router.HandleFunc("/api/logout", middleware.ChainMiddlewares(controllers.LogOut, middleware.Method("GET"), middleware.Auth())).Methods("GET")
-	router.HandleFunc("/api/confirm-email", middleware.ChainMiddlewares(controllers.ConfirmUser, middleware.Method("GET"))).Methods("GET")
-	router.HandleFunc("/api/users", middleware.ChainMiddlewares(controllers.UpdateUser, middleware.Method("PUT", "OPTIONS"), middleware.WithCors(), middleware.Auth())).Methods("PUT", "OPTIONS")
-	router.HandleFunc("/api/users", middleware.ChainMiddlewares(controllers.DeleteUser, middleware.Method("DELETE", "OPTIONS"), middleware.WithCors(), middleware.Auth())).Methods("DELETE", "OPTIONS")
-	router.HandleFunc("/api/forgot-password", middleware.ChainMiddlewares(controllers.ForgotPassword, middleware.Method("POST", "OPTIONS"), middleware.WithCors())).Methods("POST", "OPTIONS")
-
-	router.HandleFunc("/api/items", middleware.ChainMiddlewares(controllers.CreateItem, middleware.Method("POST", "OPTIONS"), middleware.WithCors(), middleware.Auth())).Methods("POST", "OPTIONS")
-	router.HandleFunc("/api/items", middleware.ChainMiddlewares(controllers.GetItem, middleware.Method("GET"))).Methods("GET")
-	router.HandleFunc("/api/items", middleware.ChainMiddlewares(controllers.GetAllItems, middleware.Method("GET"))).Methods("GET")
-	router.HandleFunc("/api/items/location", middleware.ChainMiddlewares(controllers.GetItemsInALocation, middleware.Method("GET"))).Methods("GET")
-	router.HandleFunc("/api/items", middleware.ChainMiddlewares(controllers.UpdateItem, middleware.Method("PUT", "OPTIONS"), middleware.WithCors(), middleware.Auth())).Methods("PUT", "OPTIONS")
-	router.HandleFunc("/api/items", middleware.ChainMiddlewares(controllers.CloseItem, middleware.Method("PATCH", "OPTIONS"), middleware.WithCors(), middleware.Auth())).Methods("PATCH", "OPTIONS")
-	router.HandleFunc("/api/items/bid", middleware.ChainMiddlewares(controllers.BidItem, middleware.Method("POST", "OPTIONS"), middleware.WithCors(), middleware.Auth())).Methods("POST", "OPTIONS")
-	router.HandleFunc("/api/items/bid", middleware.ChainMiddlewares(controllers.GetBidsOnItem, middleware.Method("GET"), middleware.Auth())).Methods("GET")
-
-	router.HandleFunc("/api/comments", middleware.ChainMiddlewares(controllers.CreateComment, middleware.Method("POST", "OPTIONS"), middleware.WithCors(), middleware.Auth())).Methods("POST", "OPTIONS")
-	router.HandleFunc("/api/comments", middleware.ChainMiddlewares(controllers.GetComment, middleware.Method("GET"))).Methods("GET")
-	router.HandleFunc("/api/comments/item", middleware.ChainMiddlewares(controllers.GetItemComments, middleware.Method("GET"))).Methods("GET")
-	router.HandleFunc("/api/comments", middleware.ChainMiddlewares(controllers.UpdateComment, middleware.Method("PUT", "OPTIONS"), middleware.WithCors(), middleware.Auth())).Methods("PUT", "OPTIONS")
-	router.HandleFunc("/api/comments", middleware.ChainMiddlewares(controllers.DeleteComment, middleware.Method("DELETE", "OPTIONS"), middleware.WithCors(), middleware.Auth())).Methods("DELETE", "OPTIONS")
-	router.HandleFunc("/api/comments/{comment_id}/reply", middleware.ChainMiddlewares(controllers.GetReplies, middleware.Method("GET"))).Methods("GET")
-	router.HandleFunc("/api/comments/{comment_id}/reply", middleware.ChainMiddlewares(controllers.CreateReply, middleware.Method("POST", "OPTIONS"), middleware.WithCors(), middleware.Auth())).Methods("POST", "OPTIONS")
-	router.HandleFunc("/api/comments/{comment_id}/reply", middleware.ChainMiddlewares(controllers.UpdateReply, middleware.Method("PUT", "OPTIONS"), middleware.WithCors(), middleware.Auth())).Methods("PUT", "OPTIONS")
-	router.HandleFunc("/api/comments/{comment_id}/reply", middleware.ChainMiddlewares(controllers.DeleteReply, middleware.Method("DELETE", "OPTIONS"), middleware.WithCors(), middleware.Auth())).Methods("DELETE", "OPTIONS")
-
-	router.HandleFunc("/api/locations", middleware.ChainMiddlewares(controllers.CreateLocation, middleware.Method("POST", "OPTIONS"), middleware.WithCors(), middleware.Auth())).Methods("POST", "OPTIONS")
-	router.HandleFunc("/api/locations", middleware.ChainMiddlewares(controllers.GetLocations, middleware.Method("GET"))).Methods("GET")
-	router.HandleFunc("/api/locations/location", middleware.ChainMiddlewares(controllers.GetLocation, middleware.Method("GET"))).Methods("GET")
-	router.HandleFunc("/api/locations", middleware.ChainMiddlewares(controllers.UpdateLocation, middleware.Method("PUT", "OPTIONS"), middleware.WithCors(), middleware.Auth())).Methods("PUT", "OPTINS")
-
-	http.Handle("/api/", router)
-
-	http.ListenAndServe(":8080", nil)
+	configFile := flag.String("config", os.Getenv("CONFIG_FILE"), "path to a json configuration file")
+	flag.Parse()
 
+	cfg, err := config.Load(*configFile)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if flag.NArg() > 0 {
+		switch flag.Arg(0) {
+		case "migrate":
+			err = migrate(cfg.Database, flag.Args()[1:])
+		case "role":
+			err = role(cfg.Database, flag.Args()[1:])
+		default:
+			log.Fatalf("unknown command %q, the commands are migrate and role", flag.Arg(0))
+		}
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		return
+	}
+
+	level, _ := logging.ParseLevel(cfg.Log.Level)
+	logger := logging.New(os.Stdout, level)
+
+	// what is still logged with the log package goes through logger too
+	slog.SetDefault(logger)
+
+	var stores *models.Stores
+	var store *storage.Storage
+
+	if cfg.Database.Driver == "memory" {
+		logger.Warn("keeping all data in memory, it will be lost when the server stops")
+		stores = models.NewMemoryStores()
+	} else {
+		store, err = storage.Open(cfg)
+
+		if err != nil {
+			fatal("connecting to the databases", err)
+		}
+
+		if cfg.Database.AutoMigrate {
+			if _, err = migrations.Up(store.DB); err != nil {
+				store.Close()
+				fatal("migrating the database", err)
+			}
+		}
+
+		stores = models.NewStores(store.DB, store.Redis)
+	}
+
+	delivery, err := email.NewTransport(cfg.SMTP)
+
+	if err != nil {
+		fatal("setting up email delivery", err)
+	}
+
+	queue := mailer.New(stores.Outbox, delivery, cfg.Outbox)
+	queue.Start()
+
+	if err = email.Init(cfg.SMTP, queue); err != nil {
+		fatal("loading the email templates", err)
+	}
+
+	manager, err := keys.New(cfg.Auth)
+
+	if err != nil {
+		fatal("loading the signing keys", err)
+	}
+
+	files, err := blobs.New(cfg.Uploads)
+
+	if err != nil {
+		fatal("setting up the upload store", err)
+	}
+
+	h := controllers.NewHandler(stores, files, cfg, manager)
+	router := newRouter(h, stores, manager, cfg, logger)
+
+	sweeping, stopSweeping := context.WithCancel(logging.With(context.Background(), "component", "expiry"))
+	go expireItems(sweeping, h, cfg.Items.SweepInterval.Duration)
+
+	// not the default mux, where expvar publishes the metrics to anyone who asks
+	mux := http.NewServeMux()
+	mux.Handle("/api/", router)
+	mux.Handle("/.well-known/", router)
+
+	server := &http.Server{Addr: cfg.Server.Addr, Handler: mux}
+
+	go func() {
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+		<-stop
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		if err := server.Shutdown(ctx); err != nil {
+			logger.Error("shutting down", "err", err)
+		}
+	}()
+
+	logger.Info("listening", "addr", cfg.Server.Addr)
+
+	if err = server.ListenAndServe(); err != http.ErrServerClosed {
+		logger.Error("serving", "err", err)
+	}
+
+	stopSweeping()
+	queue.Stop()
+
+	if store != nil {
+		if err = store.Close(); err != nil {
+			logger.Error("closing the databases", "err", err)
+		}
+	}
+
+}
+
+//expireItems expires the items that have been open too long straight away and then every interval, until ctx is done
+func expireItems(ctx context.Context, h *controllers.Handler, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := h.ExpireItems(ctx, time.Now()); err != nil {
+			logging.From(ctx).Error("expiring items", "err", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+//fatal logs err, saying what was being done when it happened, and exits
+func fatal(msg string, err error) {
+	slog.Error(msg, "err", err)
+	os.Exit(1)
 }
@@ -0,0 +1,1726 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Samuyi/www/blobs"
+	"github.com/Samuyi/www/config"
+	"github.com/Samuyi/www/controllers"
+	"github.com/Samuyi/www/email"
+	"github.com/Samuyi/www/keys"
+	"github.com/Samuyi/www/logging"
+	"github.com/Samuyi/www/mailer"
+	"github.com/Samuyi/www/migrations"
+	"github.com/Samuyi/www/models"
+	"github.com/Samuyi/www/models/tokens"
+	"github.com/Samuyi/www/models/users"
+	"github.com/Samuyi/www/storage"
+	"github.com/Samuyi/www/totp"
+	"github.com/gorilla/websocket"
+)
+
+//confirmations records every confirmation key handed out so the test can play the part of the user's inbox
+type confirmations struct {
+	models.ConfirmationStore
+
+	mu   sync.Mutex
+	last string
+}
+
+func (c *confirmations) SetConfirmation(ctx context.Context, userID string, ttl time.Duration) (string, error) {
+	key, err := c.ConfirmationStore.SetConfirmation(ctx, userID, ttl)
+
+	if err != nil {
+		return "", err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.last = key
+
+	return key, nil
+}
+
+//take returns the latest key handed out and forgets it
+func (c *confirmations) take() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := c.last
+	c.last = ""
+
+	return key
+}
+
+//bouncer refuses every message to one address and hands the rest on
+type bouncer struct {
+	email.Transport
+
+	address string
+}
+
+func (b *bouncer) Send(ctx context.Context, msg *email.Message) error {
+	for _, to := range msg.To {
+		if to == b.address {
+			return fmt.Errorf("550 no such mailbox %s", to)
+		}
+	}
+
+	return b.Transport.Send(ctx, msg)
+}
+
+//logBuffer keeps what the server logs so the test can look for a request in it
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.Write(p)
+}
+
+//records returns every record logged so far
+func (b *logBuffer) records() []map[string]interface{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var records []map[string]interface{}
+
+	for _, line := range strings.Split(b.buf.String(), "\n") {
+		var record map[string]interface{}
+
+		if json.Unmarshal([]byte(line), &record) == nil {
+			records = append(records, record)
+		}
+	}
+
+	return records
+}
+
+type testServer struct {
+	*httptest.Server
+
+	t      *testing.T
+	suffix string
+	stores *models.Stores
+	inbox  *confirmations
+	mail   *email.Recorder
+	logs   *logBuffer
+
+	handler *controllers.Handler
+}
+
+func testConfig() *config.Config {
+	cfg := config.Default()
+	cfg.Auth.SigningKey = "test-signing-key"
+	cfg.Auth.SessionKey = "test-session-key"
+	cfg.SMTP.Transport = "memory"
+	cfg.SMTP.From = "Giveaway <noreply@example.com>"
+	cfg.CORS.AllowedOrigins = []string{"http://localhost:3000", "https://*.staging.example.com"}
+	cfg.Outbox.Workers = 2
+	cfg.Outbox.MaxAttempts = 2
+	cfg.Outbox.BaseDelay.Duration = 10 * time.Millisecond
+	cfg.Outbox.MaxDelay.Duration = 20 * time.Millisecond
+	cfg.Outbox.PollInterval.Duration = 10 * time.Millisecond
+	// enough that the wrong codes one two-factor challenge takes don't lock the account on their own
+	cfg.Lockout.MaxAttempts = tokens.MaxChallengeAttempts + 3
+	cfg.Lockout.BaseDelay.Duration = 200 * time.Millisecond
+	cfg.Lockout.MaxDelay.Duration = 200 * time.Millisecond
+	cfg.RateLimits["register"] = config.RateLimit{Limit: 100, Window: config.Duration{Duration: time.Minute}}
+	cfg.RateLimits["forgot_password"] = config.RateLimit{Limit: 2, Window: config.Duration{Duration: 2 * time.Second}}
+
+	return cfg
+}
+
+//newTestServer serves the api from stores. Users are told apart from earlier runs against the same databases by suffix.
+func newTestServer(t *testing.T, stores *models.Stores) *testServer {
+	cfg := testConfig()
+	suffix := fmt.Sprint(time.Now().UnixNano())
+
+	inbox := &confirmations{ConfirmationStore: stores.Confirmations}
+	stores.Confirmations = inbox
+
+	recorder := email.NewRecorder()
+	queue := mailer.New(stores.Outbox, &bouncer{Transport: recorder, address: "bounce" + suffix + "@example.com"}, cfg.Outbox)
+	queue.Start()
+	t.Cleanup(queue.Stop)
+
+	if err := email.Init(cfg.SMTP, queue); err != nil {
+		t.Fatal(err)
+	}
+
+	manager, err := keys.New(cfg.Auth)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logs := &logBuffer{}
+
+	cfg.Uploads.Dir = t.TempDir()
+	cfg.Uploads.MaxBytes = 64 << 10
+	cfg.Uploads.MaxPerItem = 2
+
+	files, err := blobs.New(cfg.Uploads)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := controllers.NewHandler(stores, files, cfg, manager)
+	srv := httptest.NewServer(newRouter(h, stores, manager, cfg, logging.New(logs, slog.LevelDebug)))
+	t.Cleanup(srv.Close)
+
+	return &testServer{Server: srv, t: t, suffix: suffix, stores: stores, inbox: inbox, mail: recorder, logs: logs, handler: h}
+}
+
+//waitForMail waits for the emails sent in the background and returns the first one to address with subject
+func (s *testServer) waitForMail(address, subject string) email.Message {
+	s.t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+
+	for time.Now().Before(deadline) {
+		for _, msg := range s.mail.Messages() {
+			if msg.Subject == subject && len(msg.To) == 1 && msg.To[0] == address {
+				return msg
+			}
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	s.t.Fatalf("no %q email was sent to %s", subject, address)
+
+	return email.Message{}
+}
+
+//do sends a request and decodes the json response into out when out isn't nil
+func (s *testServer) do(method, path, token string, body interface{}, out interface{}) int {
+	s.t.Helper()
+
+	var reader io.Reader
+
+	if body != nil {
+		buf, err := json.Marshal(body)
+
+		if err != nil {
+			s.t.Fatal(err)
+		}
+		reader = bytes.NewReader(buf)
+	}
+
+	req, err := http.NewRequest(method, s.URL+path, reader)
+
+	if err != nil {
+		s.t.Fatal(err)
+	}
+
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	raw, err := io.ReadAll(res.Body)
+
+	if err != nil {
+		s.t.Fatal(err)
+	}
+
+	if out != nil {
+		if err = json.Unmarshal(raw, out); err != nil {
+			s.t.Fatalf("%s %s: can't decode %q: %v", method, path, raw, err)
+		}
+	}
+
+	return res.StatusCode
+}
+
+//expect fails the test when a request doesn't answer with the wanted status
+func (s *testServer) expect(want int, method, path, token string, body interface{}, out interface{}) {
+	s.t.Helper()
+
+	if got := s.do(method, path, token, body, out); got != want {
+		s.t.Fatalf("%s %s: got status %d, want %d", method, path, got, want)
+	}
+}
+
+//login logs a user in with the password signUp gives them and returns the token
+func (s *testServer) login(mail string) string {
+	s.t.Helper()
+
+	var login map[string]string
+	s.expect(http.StatusOK, "POST", "/api/login", "", map[string]string{"email": mail, "password": "correct horse battery"}, &login)
+
+	if login["token"] == "" {
+		s.t.Fatalf("login %s: no token in %v", mail, login)
+	}
+
+	return login["token"]
+}
+
+//signUp registers, confirms and logs in a user and returns the login token
+func (s *testServer) signUp(name, mail string) string {
+	s.t.Helper()
+
+	user := map[string]string{
+		"display_name": name,
+		"first_name":   "Test",
+		"last_name":    "User",
+		"email":        mail,
+		"password":     "correct horse battery",
+	}
+
+	var registered map[string]string
+	s.expect(http.StatusCreated, "POST", "/api/users", "", user, &registered)
+
+	if registered["token"] == "" {
+		s.t.Fatalf("register %s: no token in %v", name, registered)
+	}
+
+	key := s.inbox.take()
+
+	var profile map[string]interface{}
+	s.expect(http.StatusOK, "GET", "/api/users/"+name, registered["token"], nil, &profile)
+
+	if profile["active"] != false || profile["email"] != mail {
+		s.t.Fatalf("new user %s: got %v", name, profile)
+	}
+
+	if _, ok := profile["password"]; ok {
+		s.t.Fatalf("user %s: password leaked in %v", name, profile)
+	}
+
+	if key == "" {
+		s.t.Fatalf("no confirmation key was issued to %s", name)
+	}
+
+	if msg := s.waitForMail(mail, "Welcome to our network"); !strings.Contains(msg.HTML, "key="+key) || !strings.Contains(msg.Text, "key="+key) {
+		s.t.Fatalf("confirmation email to %s doesn't link to key %s:\n%s\n%s", name, key, msg.Text, msg.HTML)
+	}
+
+	var confirmed map[string]string
+	s.expect(http.StatusOK, "GET", "/api/confirm-email?key="+key, "", nil, &confirmed)
+
+	if confirmed["token"] == "" {
+		s.t.Fatalf("confirm %s: no token in %v", name, confirmed)
+	}
+
+	s.expect(http.StatusBadRequest, "GET", "/api/confirm-email?key="+key, "", nil, nil)
+
+	return s.login(mail)
+}
+
+func TestMemoryRoutes(t *testing.T) {
+	testRoutes(t, newTestServer(t, models.NewMemoryStores()))
+}
+
+//TestPostgresRoutes runs the same flow against real databases when TEST_DATABASE_URL and TEST_REDIS_ADDR are set
+func TestPostgresRoutes(t *testing.T) {
+	databaseURL := os.Getenv("TEST_DATABASE_URL")
+	redisAddr := os.Getenv("TEST_REDIS_ADDR")
+
+	if databaseURL == "" || redisAddr == "" {
+		t.Skip("set TEST_DATABASE_URL and TEST_REDIS_ADDR to run against postgres and redis")
+	}
+
+	cfg := testConfig()
+	cfg.Database.URL = databaseURL
+	cfg.Redis.Addr = redisAddr
+
+	store, err := storage.Open(cfg)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	if _, err = migrations.Up(store.DB); err != nil {
+		t.Fatal(err)
+	}
+
+	testRoutes(t, newTestServer(t, models.NewStores(store.DB, store.Redis)))
+}
+
+//resetLink finds the token in the link of a password reset email
+var resetLink = regexp.MustCompile(`reset-password\?token=([A-Za-z0-9_-]+)`)
+
+func testRoutes(t *testing.T, s *testServer) {
+	suffix := s.suffix
+	owner := "owner" + suffix
+	bidder := "bidder" + suffix
+
+	ownerToken := s.signUp(owner, owner+"@example.com")
+	bidderToken := s.signUp(bidder, bidder+"@example.com")
+
+	// the owner runs the site. Roles are carried in the session, so they apply from the next login.
+	admin := &users.User{Email: owner + "@example.com"}
+
+	if err := s.stores.Users.GetByEmail(context.Background(), admin); err != nil || admin.Role != users.RoleUser {
+		t.Fatalf("new user: got role %q, %v", admin.Role, err)
+	}
+
+	admin.Role = users.RoleAdmin
+
+	if err := s.stores.Users.SetRole(context.Background(), admin); err != nil {
+		t.Fatal(err)
+	}
+
+	ownerToken = s.login(owner + "@example.com")
+
+	s.expect(http.StatusUnauthorized, "GET", "/api/users", "", nil, nil)
+	s.expect(http.StatusUnauthorized, "GET", "/api/users", "not-a-token", nil, nil)
+
+	// the test server only has a shared secret, which must never be published
+	var jwks map[string][]map[string]string
+	s.expect(http.StatusOK, "GET", "/.well-known/jwks.json", "", nil, &jwks)
+
+	if published, ok := jwks["keys"]; !ok || len(published) != 0 {
+		t.Fatalf("jwks: got %v", jwks)
+	}
+	s.expect(http.StatusBadRequest, "POST", "/api/login", "", map[string]string{"email": owner + "@example.com", "password": "wrong"}, nil)
+
+	// password reset, answering the same whether or not the account exists
+	var unknown, known map[string]string
+	s.expect(http.StatusOK, "POST", "/api/forgot-password", "", map[string]string{"email": "nobody" + suffix + "@example.com"}, &unknown)
+	s.expect(http.StatusOK, "POST", "/api/forgot-password", "", map[string]string{"email": bidder + "@example.com"}, &known)
+
+	if unknown["message"] == "" || unknown["message"] != known["message"] {
+		t.Fatalf("forgot password: got %v for an unknown email and %v for a known one", unknown, known)
+	}
+
+	// asking for more resets than the limit allows from one address is refused until the window moves on
+	limited, err := http.Post(s.URL+"/api/forgot-password", "application/json", strings.NewReader(`{"email": "nobody@example.com"}`))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	limited.Body.Close()
+
+	if limited.StatusCode != http.StatusTooManyRequests || limited.Header.Get("Retry-After") == "" || limited.Header.Get("RateLimit-Remaining") != "0" {
+		t.Fatalf("forgot password over the limit: got %d %v", limited.StatusCode, limited.Header)
+	}
+
+	reset := resetLink.FindStringSubmatch(s.waitForMail(bidder+"@example.com", "Reset your password").Text)
+
+	if reset == nil {
+		t.Fatal("the reset email has no link")
+	}
+
+	s.expect(http.StatusBadRequest, "POST", "/api/reset-password", "", map[string]string{"token": reset[1], "password": "short"}, nil)
+	s.expect(http.StatusBadRequest, "POST", "/api/reset-password", "", map[string]string{"token": "not-a-token", "password": "a brand new password"}, nil)
+	s.expect(http.StatusOK, "POST", "/api/reset-password", "", map[string]string{"token": reset[1], "password": "a brand new password"}, nil)
+	s.expect(http.StatusBadRequest, "POST", "/api/reset-password", "", map[string]string{"token": reset[1], "password": "another new password"}, nil)
+	s.expect(http.StatusBadRequest, "POST", "/api/login", "", map[string]string{"email": bidder + "@example.com", "password": "correct horse battery"}, nil)
+
+	// resetting the password ends the sessions started with the old one
+	s.expect(http.StatusUnauthorized, "GET", "/api/users", bidderToken, nil, nil)
+
+	var relogin map[string]string
+	s.expect(http.StatusOK, "POST", "/api/login", "", map[string]string{"email": bidder + "@example.com", "password": "a brand new password"}, &relogin)
+	bidderToken = relogin["token"]
+
+	for _, msg := range s.mail.Messages() {
+		if strings.HasPrefix(msg.To[0], "nobody") {
+			t.Fatalf("a reset email was sent to an unknown address: %v", msg.To)
+		}
+	}
+
+	// errors share one envelope, with a code to switch on, what is wrong with each field and the request id
+	var invalid struct {
+		Error     string            `json:"error"`
+		Code      string            `json:"code"`
+		Fields    map[string]string `json:"fields"`
+		RequestID string            `json:"request_id"`
+	}
+	s.expect(http.StatusBadRequest, "POST", "/api/users", "", map[string]string{"email": "not an email"}, &invalid)
+
+	if invalid.Code != "validation_failed" || invalid.Error == "" || invalid.Fields["email"] == "" || invalid.RequestID == "" {
+		t.Fatalf("validation error: got %+v", invalid)
+	}
+
+	var taken map[string]string
+	s.expect(http.StatusConflict, "POST", "/api/users", "", map[string]string{
+		"display_name": "someone" + suffix,
+		"first_name":   "Test",
+		"last_name":    "User",
+		"email":        owner + "@example.com",
+		"password":     "correct horse battery",
+	}, &taken)
+
+	if taken["code"] != "conflict" {
+		t.Fatalf("signing up with a taken email: got %v", taken)
+	}
+
+	var forbidden map[string]string
+	s.expect(http.StatusForbidden, "GET", "/api/admin/outbox", bidderToken, nil, &forbidden)
+
+	if forbidden["code"] != "forbidden" || forbidden["error"] == "" {
+		t.Fatalf("forbidden: got %v", forbidden)
+	}
+
+	var missing map[string]string
+	s.expect(http.StatusNotFound, "GET", "/api/nothing-here", "", nil, &missing)
+
+	if missing["code"] != "not_found" {
+		t.Fatalf("unknown route: got %v", missing)
+	}
+
+	var everyone listPage
+	s.expect(http.StatusOK, "GET", "/api/users?limit=1", ownerToken, nil, &everyone)
+
+	if len(everyone.Items) != 1 || !everyone.HasMore || everyone.NextCursor == "" {
+		t.Fatalf("expected a page of one user of many, got %v", everyone)
+	}
+
+	var next listPage
+	s.expect(http.StatusOK, "GET", "/api/users?limit=1&cursor="+url.QueryEscape(everyone.NextCursor), ownerToken, nil, &next)
+
+	if len(next.Items) != 1 || next.Items[0]["id"] == everyone.Items[0]["id"] {
+		t.Fatalf("next page of users: got %v after %v", next, everyone)
+	}
+
+	// cursors are only good for the order they were made in
+	var badCursor map[string]interface{}
+	s.expect(http.StatusBadRequest, "GET", "/api/users?sort=oldest&cursor="+url.QueryEscape(everyone.NextCursor), ownerToken, nil, &badCursor)
+
+	if fields, _ := badCursor["fields"].(map[string]interface{}); fields["cursor"] == nil {
+		t.Fatalf("cursor for another order: got %v", badCursor)
+	}
+
+	s.expect(http.StatusBadRequest, "GET", "/api/users?limit=0", ownerToken, nil, nil)
+	s.expect(http.StatusBadRequest, "GET", "/api/users?role=owner", ownerToken, nil, nil)
+	s.expect(http.StatusOK, "GET", "/api/users?role=admin&sort=newest", ownerToken, nil, &everyone)
+
+	if !containsID(everyone.Items, admin.ID) {
+		t.Fatalf("admins: %s missing from %v", admin.ID, everyone)
+	}
+
+	// only moderators and admins see every user, and other users' emails
+	s.expect(http.StatusForbidden, "GET", "/api/users", bidderToken, nil, nil)
+
+	var other, seen map[string]interface{}
+	s.expect(http.StatusOK, "GET", "/api/users/"+owner, bidderToken, nil, &other)
+
+	if other["email"] != "" || other["display_name"] != owner {
+		t.Fatalf("profile of another user: got %v", other)
+	}
+
+	s.expect(http.StatusOK, "GET", "/api/users/"+bidder, ownerToken, nil, &seen)
+
+	if seen["email"] != bidder+"@example.com" {
+		t.Fatalf("profile seen by an admin: got %v", seen)
+	}
+
+	var updated map[string]string
+	s.expect(http.StatusBadRequest, "PUT", "/api/users", ownerToken, map[string]string{"first_name": "Ada", "locale": "French"}, nil)
+	s.expect(http.StatusOK, "PUT", "/api/users", ownerToken, map[string]string{"first_name": "Ada", "locale": "fr"}, &updated)
+
+	var profile map[string]interface{}
+	s.expect(http.StatusOK, "GET", "/api/users/"+owner, ownerToken, nil, &profile)
+
+	if profile["first_name"] != "Ada" || profile["active"] != true {
+		t.Fatalf("updated user: got %v", profile)
+	}
+
+	// updates only ever change the user of the session, and only their names, locale and password
+	victim := &users.User{Email: bidder + "@example.com"}
+
+	if err := s.stores.Users.GetByEmail(context.Background(), victim); err != nil {
+		t.Fatal(err)
+	}
+
+	s.expect(http.StatusBadRequest, "PUT", "/api/users", ownerToken, map[string]string{"password": "short"}, nil)
+	s.expect(http.StatusConflict, "PUT", "/api/users", ownerToken, map[string]string{"display_name": bidder}, nil)
+	s.expect(http.StatusOK, "PUT", "/api/users", ownerToken, map[string]string{"id": victim.ID, "last_name": "Lovelace", "role": "admin", "email": "taken@example.com"}, nil)
+
+	profile = nil
+	s.expect(http.StatusOK, "GET", "/api/users/"+bidder, ownerToken, nil, &profile)
+
+	if profile["last_name"] == "Lovelace" || profile["email"] != bidder+"@example.com" {
+		t.Fatalf("user updated through another's session: got %v", profile)
+	}
+
+	profile = nil
+	s.expect(http.StatusOK, "GET", "/api/users/"+owner, ownerToken, nil, &profile)
+
+	if profile["last_name"] != "Lovelace" || profile["first_name"] != "Ada" || profile["email"] != owner+"@example.com" {
+		t.Fatalf("updated user: got %v", profile)
+	}
+
+	// changing the password keeps the session it was changed from, and ends the others
+	elsewhere := s.login(owner + "@example.com")
+	s.expect(http.StatusOK, "PUT", "/api/users", ownerToken, map[string]string{"password": "a changed password"}, nil)
+	s.expect(http.StatusUnauthorized, "GET", "/api/sessions", elsewhere, nil, nil)
+	s.expect(http.StatusBadRequest, "POST", "/api/login", "", map[string]string{"email": owner + "@example.com", "password": "correct horse battery"}, nil)
+	s.expect(http.StatusOK, "PUT", "/api/users", ownerToken, map[string]string{"password": "correct horse battery"}, nil)
+	s.login(owner + "@example.com")
+
+	// locations
+
+	city := "Lagos" + suffix
+	s.expect(http.StatusCreated, "POST", "/api/locations", ownerToken, map[string]string{"city": city, "state": "Lagos", "country_code": "NG"}, nil)
+
+	var locations listPage
+	s.expect(http.StatusOK, "GET", "/api/locations?sort=newest&country_code=ng", "", nil, &locations)
+
+	var locationID string
+
+	for _, location := range locations.Items {
+		if location["city"] == strings.ToUpper(city) {
+			locationID = location["location_id"].(string)
+
+			if location["country"] != "Nigeria" {
+				t.Fatalf("location country: got %v", location)
+			}
+		}
+	}
+
+	if locationID == "" {
+		t.Fatalf("created location %s not listed in %v", city, locations)
+	}
+
+	s.expect(http.StatusOK, "GET", "/api/locations?sort=newest&country_code=GH", "", nil, &locations)
+
+	if containsID(locations.Items, locationID) {
+		t.Fatalf("locations in Ghana: got %v", locations)
+	}
+
+	s.expect(http.StatusBadRequest, "GET", "/api/locations?country_code=XX", "", nil, nil)
+
+	var location map[string]interface{}
+	s.expect(http.StatusOK, "GET", "/api/locations/location?id="+locationID, "", nil, &location)
+
+	if location["city"] != strings.ToUpper(city) {
+		t.Fatalf("get location: got %v", location)
+	}
+
+	if _, ok := location["user_id"]; ok {
+		t.Fatalf("get location leaked the owner: %v", location)
+	}
+
+	s.expect(http.StatusOK, "PUT", "/api/locations?id="+locationID, ownerToken, map[string]string{"state": "LAGOS STATE"}, nil)
+	s.expect(http.StatusBadRequest, "PUT", "/api/locations?id="+locationID, ownerToken, map[string]string{"user_id": "x' --"}, nil)
+	s.expect(http.StatusForbidden, "PUT", "/api/locations?id="+locationID, bidderToken, map[string]string{"state": "OGUN"}, nil)
+	s.expect(http.StatusOK, "GET", "/api/locations/location?id="+locationID, "", nil, &location)
+
+	if location["state"] != "LAGOS STATE" {
+		t.Fatalf("updated location: got %v", location)
+	}
+
+	// items
+
+	item := map[string]interface{}{
+		"name":        "Sofa",
+		"phone_no":    "08012345678",
+		"instruction": "Pick up after 5pm",
+		"location":    map[string]string{"city": strings.ToUpper(city)},
+	}
+
+	var created map[string]string
+	s.expect(http.StatusCreated, "POST", "/api/items", ownerToken, item, &created)
+
+	itemID := created["id"]
+
+	if itemID == "" {
+		t.Fatalf("create item: no id in %v", created)
+	}
+
+	item["location"] = map[string]string{"city": "NOWHERE" + suffix}
+	s.expect(http.StatusBadRequest, "POST", "/api/items", ownerToken, item, nil)
+
+	var got map[string]interface{}
+	s.expect(http.StatusOK, "GET", "/api/items?id="+itemID, "", nil, &got)
+
+	if got["name"] != "Sofa" || got["display_name"] != owner || got["status"] != "open" {
+		t.Fatalf("get item: got %v", got)
+	}
+
+	s.expect(http.StatusForbidden, "PUT", "/api/items?id="+itemID, bidderToken, map[string]string{"name": "Mine now"}, nil)
+	s.expect(http.StatusOK, "PUT", "/api/items?id="+itemID, ownerToken, map[string]string{"name": "Blue sofa", "status": "handed_over", "user_id": admin.ID}, nil)
+	s.expect(http.StatusOK, "GET", "/api/items?id="+itemID, "", nil, &got)
+
+	// only the details of an item can be updated, its status is moved on with PATCH
+	if got["name"] != "Blue sofa" || got["status"] != "open" {
+		t.Fatalf("updated item: got %v", got)
+	}
+
+	var inLocation listPage
+	s.readSocket("/api/items/location?location_id="+locationID, &inLocation)
+
+	if len(inLocation.Items) != 1 || inLocation.Items[0]["id"] != itemID || inLocation.Items[0]["display_name"] != owner || inLocation.HasMore {
+		t.Fatalf("items in location: got %v", inLocation)
+	}
+
+	var all listPage
+	s.readSocket("/api/items?city="+city, &all)
+
+	if !containsID(all.Items, itemID) {
+		t.Fatalf("all items: %s missing from %v", itemID, all)
+	}
+
+	// lists can be paged through over plain http too
+	item["name"] = "Armchair"
+	item["instruction"] = "Pick up after 5pm, <b>sharp</b>"
+	item["location"] = map[string]string{"city": strings.ToUpper(city)}
+	s.expect(http.StatusCreated, "POST", "/api/items", ownerToken, item, &created)
+
+	var firstItems, secondItems listPage
+	s.expect(http.StatusOK, "GET", "/api/items/location?limit=1&location_id="+locationID, "", nil, &firstItems)
+	s.expect(http.StatusOK, "GET", "/api/items/location?limit=1&location_id="+locationID+"&cursor="+url.QueryEscape(firstItems.NextCursor), "", nil, &secondItems)
+
+	if len(firstItems.Items) != 1 || firstItems.Items[0]["id"] != created["id"] || !firstItems.HasMore {
+		t.Fatalf("first page of items in location: got %v", firstItems)
+	}
+
+	if len(secondItems.Items) != 1 || secondItems.Items[0]["id"] != itemID || secondItems.HasMore || secondItems.NextCursor != "" {
+		t.Fatalf("second page of items in location: got %v", secondItems)
+	}
+
+	s.expect(http.StatusOK, "GET", "/api/items?sort=oldest&city="+city+"&user="+admin.ID, "", nil, &all)
+
+	if len(all.Items) != 2 || all.Items[0]["id"] != itemID {
+		t.Fatalf("items of the owner, oldest first: got %v", all)
+	}
+
+	// search, matching the last word as a prefix and ranking matches in the name first
+	var found listPage
+	s.expect(http.StatusOK, "GET", "/api/items/search?q=sof&city="+city, "", nil, &found)
+
+	if len(found.Items) != 1 || found.Items[0]["id"] != itemID || !strings.Contains(found.Items[0]["snippet"].(string), "<mark>sofa</mark>") {
+		t.Fatalf("search for sof: got %v", found)
+	}
+
+	s.expect(http.StatusOK, "GET", "/api/items/search?q=pick+up&limit=1&city="+city, "", nil, &found)
+
+	if len(found.Items) != 1 || !found.HasMore {
+		t.Fatalf("first page of the search for pick up: got %v", found)
+	}
+
+	firstFound := found.Items[0]["id"]
+	s.expect(http.StatusOK, "GET", "/api/items/search?q=pick+up&limit=1&city="+city+"&cursor="+url.QueryEscape(found.NextCursor), "", nil, &found)
+
+	if len(found.Items) != 1 || found.Items[0]["id"] == firstFound || found.HasMore {
+		t.Fatalf("second page of the search for pick up: got %v", found)
+	}
+
+	s.expect(http.StatusOK, "GET", "/api/items/search?q=armchair&location_id="+locationID, "", nil, &found)
+
+	if len(found.Items) != 1 || found.Items[0]["id"] != created["id"] {
+		t.Fatalf("search for armchair: got %v", found)
+	}
+
+	s.expect(http.StatusOK, "GET", "/api/items/search?q=sharp&city="+city, "", nil, &found)
+
+	if len(found.Items) != 1 || !strings.Contains(found.Items[0]["snippet"].(string), "<mark>sharp</mark>") || strings.Contains(found.Items[0]["snippet"].(string), "<b>") {
+		t.Fatalf("search for sharp, escaping the item's own tags: got %v", found)
+	}
+
+	s.expect(http.StatusOK, "GET", "/api/items/search?q=sofa+armchair&city="+city, "", nil, &found)
+
+	if len(found.Items) != 0 {
+		t.Fatalf("search for sofa and armchair: got %v", found)
+	}
+
+	s.expect(http.StatusBadRequest, "GET", "/api/items/search?q=+%21", "", nil, nil)
+	s.expect(http.StatusBadRequest, "GET", "/api/items/search?q=sofa&sort=name", "", nil, nil)
+
+	s.expect(http.StatusBadRequest, "PATCH", "/api/items?id="+created["id"], ownerToken, nil, nil)
+	s.expect(http.StatusOK, "PATCH", "/api/items?id="+created["id"], ownerToken, map[string]string{"status": "cancelled"}, nil)
+	s.expect(http.StatusOK, "GET", "/api/items?status=cancelled&city="+city, "", nil, &all)
+
+	if len(all.Items) != 1 || all.Items[0]["id"] != created["id"] || all.Items[0]["status"] != "cancelled" {
+		t.Fatalf("cancelled items: got %v", all)
+	}
+
+	s.expect(http.StatusOK, "GET", "/api/items/search?q=armchair&status=cancelled&city="+city, "", nil, &found)
+
+	if len(found.Items) != 1 || found.Items[0]["id"] != created["id"] {
+		t.Fatalf("search for a cancelled armchair: got %v", found)
+	}
+
+	s.expect(http.StatusBadRequest, "GET", "/api/items?status=closed", "", nil, nil)
+	s.expect(http.StatusBadRequest, "GET", "/api/items?status=draft", "", nil, nil)
+	s.expect(http.StatusBadRequest, "GET", "/api/items?user="+url.QueryEscape("x' --"), "", nil, nil)
+
+	// categories, which only admins manage, and conditions
+	var furniture, sofas, chairs map[string]string
+	s.expect(http.StatusForbidden, "POST", "/api/categories", bidderToken, map[string]string{"name": "Furniture" + suffix}, nil)
+	s.expect(http.StatusCreated, "POST", "/api/categories", ownerToken, map[string]string{"name": "Furniture" + suffix}, &furniture)
+	s.expect(http.StatusCreated, "POST", "/api/categories", ownerToken, map[string]string{"name": "Sofas", "parent_id": furniture["id"]}, &sofas)
+	s.expect(http.StatusCreated, "POST", "/api/categories", ownerToken, map[string]string{"name": "Chairs", "parent_id": furniture["id"]}, &chairs)
+	s.expect(http.StatusConflict, "POST", "/api/categories", ownerToken, map[string]string{"name": "FURNITURE" + suffix}, nil)
+	s.expect(http.StatusConflict, "POST", "/api/categories", ownerToken, map[string]string{"name": "sofas", "parent_id": furniture["id"]}, nil)
+	s.expect(http.StatusBadRequest, "POST", "/api/categories", ownerToken, map[string]string{"name": "Lamps", "parent_id": "00000000-0000-4000-8000-000000000000"}, nil)
+	s.expect(http.StatusBadRequest, "POST", "/api/categories", ownerToken, map[string]string{"name": "x"}, nil)
+
+	var tree []map[string]interface{}
+	s.expect(http.StatusOK, "GET", "/api/categories", "", nil, &tree)
+
+	var top map[string]interface{}
+
+	for _, category := range tree {
+		if category["id"] == furniture["id"] {
+			top = category
+		}
+	}
+
+	if children, ok := top["children"].([]interface{}); !ok || len(children) != 2 || children[0].(map[string]interface{})["id"] != chairs["id"] {
+		t.Fatalf("category tree: got %v", top)
+	}
+
+	s.expect(http.StatusOK, "PUT", "/api/items?id="+itemID, ownerToken, map[string]string{"category_id": sofas["id"], "condition": "good"}, nil)
+	s.expect(http.StatusBadRequest, "PUT", "/api/items?id="+itemID, ownerToken, map[string]string{"condition": "mint"}, nil)
+	s.expect(http.StatusBadRequest, "PUT", "/api/items?id="+itemID, ownerToken, map[string]string{"category_id": "00000000-0000-4000-8000-000000000000"}, nil)
+	s.expect(http.StatusOK, "GET", "/api/items?id="+itemID, "", nil, &got)
+
+	if got["category_id"] != sofas["id"] || got["condition"] != "good" {
+		t.Fatalf("categorised item: got %v", got)
+	}
+
+	// the items of a category take in those of the categories under it
+	s.expect(http.StatusOK, "GET", "/api/items?category="+furniture["id"]+"&city="+city, "", nil, &all)
+
+	if len(all.Items) != 1 || all.Items[0]["id"] != itemID {
+		t.Fatalf("furniture: got %v", all)
+	}
+
+	s.expect(http.StatusOK, "GET", "/api/items?category="+chairs["id"]+"&city="+city, "", nil, &all)
+
+	if len(all.Items) != 0 {
+		t.Fatalf("chairs: got %v", all)
+	}
+
+	s.expect(http.StatusOK, "GET", "/api/items?condition=fair&city="+city, "", nil, &all)
+
+	if len(all.Items) != 0 {
+		t.Fatalf("fair items: got %v", all)
+	}
+
+	s.expect(http.StatusOK, "GET", "/api/items/search?q=sofa&condition=good&category="+furniture["id"], "", nil, &found)
+
+	if len(found.Items) != 1 || found.Items[0]["id"] != itemID {
+		t.Fatalf("search for a good sofa in furniture: got %v", found)
+	}
+
+	s.expect(http.StatusBadRequest, "GET", "/api/items?condition=mint", "", nil, nil)
+	s.expect(http.StatusBadRequest, "GET", "/api/items?category=sofas", "", nil, nil)
+
+	var counts []map[string]interface{}
+	s.expect(http.StatusOK, "GET", "/api/categories/counts?location_id="+locationID, "", nil, &counts)
+
+	for _, count := range counts {
+		var want float64
+
+		if count["category_id"] == furniture["id"] || count["category_id"] == sofas["id"] {
+			want = 1
+		}
+
+		if count["items"] != want {
+			t.Fatalf("category counts: got %v", counts)
+		}
+	}
+
+	s.expect(http.StatusBadRequest, "GET", "/api/categories/counts", "", nil, nil)
+
+	// categories can be renamed and moved, but not under themselves, and only deleted once empty of categories
+	s.expect(http.StatusForbidden, "PUT", "/api/categories/"+sofas["id"], bidderToken, map[string]string{"name": "Couches"}, nil)
+	s.expect(http.StatusOK, "PUT", "/api/categories/"+sofas["id"], ownerToken, map[string]string{"name": "Couches"}, nil)
+	s.expect(http.StatusBadRequest, "PUT", "/api/categories/"+furniture["id"], ownerToken, map[string]string{"parent_id": sofas["id"]}, nil)
+	s.expect(http.StatusConflict, "PUT", "/api/categories/"+chairs["id"], ownerToken, map[string]string{"name": "couches"}, nil)
+	s.expect(http.StatusNotFound, "PUT", "/api/categories/00000000-0000-4000-8000-000000000000", ownerToken, map[string]string{"name": "Couches"}, nil)
+	s.expect(http.StatusConflict, "DELETE", "/api/categories/"+furniture["id"], ownerToken, nil, nil)
+	s.expect(http.StatusOK, "DELETE", "/api/categories/"+sofas["id"], ownerToken, nil, nil)
+
+	// decoding merges into a map, so start from a fresh one to see that category_id is gone
+	got = nil
+	s.expect(http.StatusOK, "GET", "/api/items?id="+itemID, "", nil, &got)
+
+	if _, ok := got["category_id"]; ok || got["condition"] != "good" {
+		t.Fatalf("item of a deleted category: got %v", got)
+	}
+
+	// photos, kept without the metadata phones put in them
+	upload := photo(t)
+
+	s.upload(http.StatusForbidden, "/api/items/images?id="+itemID, bidderToken, "image/jpeg", upload, nil)
+	s.upload(http.StatusUnsupportedMediaType, "/api/items/images?id="+itemID, ownerToken, "text/plain", []byte("hello"), nil)
+	s.upload(http.StatusUnsupportedMediaType, "/api/items/images?id="+itemID, ownerToken, "image/jpeg", []byte("<html>hello</html>"), nil)
+	s.upload(http.StatusRequestEntityTooLarge, "/api/items/images?id="+itemID, ownerToken, "image/jpeg", append(upload, make([]byte, 64<<10)...), nil)
+
+	var firstImage, secondImage map[string]interface{}
+	s.upload(http.StatusCreated, "/api/items/images?id="+itemID, ownerToken, "image/jpeg", upload, &firstImage)
+
+	if firstImage["width"] != float64(40) || firstImage["height"] != float64(20) || firstImage["url"] == nil || firstImage["key"] != nil {
+		t.Fatalf("uploaded photo: got %v", firstImage)
+	}
+
+	stored, header := s.fetch(http.StatusOK, firstImage["url"].(string))
+
+	if header.Get("Content-Type") != "image/jpeg" || bytes.Contains(stored, []byte("Exif")) || bytes.Contains(stored, []byte("GPS")) {
+		t.Fatalf("stored photo: got %s with the metadata of the upload", header.Get("Content-Type"))
+	}
+
+	thumbnail, _ := s.fetch(http.StatusOK, firstImage["thumbnail_url"].(string))
+
+	if config, err := jpeg.DecodeConfig(bytes.NewReader(thumbnail)); err != nil || config.Width != 20 || config.Height != 20 {
+		t.Fatalf("thumbnail: got %v, %v", config, err)
+	}
+
+	s.upload(http.StatusCreated, "/api/items/images?id="+itemID, ownerToken, "image/jpeg", upload, &secondImage)
+	s.upload(http.StatusConflict, "/api/items/images?id="+itemID, ownerToken, "image/jpeg", upload, nil)
+
+	s.expect(http.StatusOK, "GET", "/api/items?id="+itemID, "", nil, &got)
+
+	if images, ok := got["images"].([]interface{}); !ok || len(images) != 2 || images[0].(map[string]interface{})["id"] != firstImage["id"] {
+		t.Fatalf("item with photos: got %v", got["images"])
+	}
+
+	s.expect(http.StatusOK, "GET", "/api/items?city="+city, "", nil, &all)
+
+	if len(all.Items) != 1 || len(all.Items[0]["images"].([]interface{})) != 2 {
+		t.Fatalf("items with photos: got %v", all)
+	}
+
+	s.expect(http.StatusForbidden, "DELETE", "/api/items/images?id="+itemID+"&image_id="+firstImage["id"].(string), bidderToken, nil, nil)
+	s.expect(http.StatusOK, "DELETE", "/api/items/images?id="+itemID+"&image_id="+firstImage["id"].(string), ownerToken, nil, nil)
+	s.expect(http.StatusNotFound, "DELETE", "/api/items/images?id="+itemID+"&image_id="+firstImage["id"].(string), ownerToken, nil, nil)
+	s.fetch(http.StatusNotFound, firstImage["url"].(string))
+	s.fetch(http.StatusNotFound, firstImage["thumbnail_url"].(string))
+	s.fetch(http.StatusOK, secondImage["url"].(string))
+
+	// avatars, which can no longer be set to anything but an upload
+	var avatar, replaced map[string]string
+	s.upload(http.StatusOK, "/api/users/avatar", bidderToken, "image/jpeg", upload, &avatar)
+	s.upload(http.StatusOK, "/api/users/avatar", bidderToken, "image/jpeg", upload, &replaced)
+	s.fetch(http.StatusNotFound, avatar["avatar"])
+
+	if square, _ := s.fetch(http.StatusOK, replaced["avatar"]); bytes.Contains(square, []byte("GPS")) {
+		t.Fatal("avatar: got the metadata of the upload")
+	}
+
+	var withAvatar, withoutAvatar map[string]interface{}
+	s.expect(http.StatusOK, "GET", "/api/users/"+bidder, bidderToken, nil, &withAvatar)
+
+	if withAvatar["avatar"] != replaced["avatar"] {
+		t.Fatalf("profile: got avatar %v, want %s", withAvatar["avatar"], replaced["avatar"])
+	}
+
+	s.expect(http.StatusOK, "DELETE", "/api/users/avatar", bidderToken, nil, nil)
+	s.fetch(http.StatusNotFound, replaced["avatar"])
+	s.expect(http.StatusOK, "GET", "/api/users/"+bidder, bidderToken, nil, &withoutAvatar)
+
+	if _, ok := withoutAvatar["avatar"]; ok {
+		t.Fatalf("profile without an avatar: got %v", withoutAvatar)
+	}
+
+	s.fetch(http.StatusNotFound, "/api/images/items/nothing/here.jpg")
+
+	// comments and replies
+
+	s.expect(http.StatusOK, "POST", "/api/comments", bidderToken, map[string]string{"item_id": itemID, "comment": "Is it still available?"}, nil)
+
+	var itemComments listPage
+	s.expect(http.StatusOK, "GET", "/api/comments/item?id="+itemID, "", nil, &itemComments)
+
+	if len(itemComments.Items) != 1 || itemComments.Items[0]["display_name"] != bidder || itemComments.HasMore {
+		t.Fatalf("item comments: got %v", itemComments)
+	}
+
+	commentID := itemComments.Items[0]["id"].(string)
+	repliesPath := "/api/comments/" + commentID + "/reply"
+
+	// malformed comments and replies are the client's fault, and are answered as such
+	var malformed map[string]string
+	s.expect(http.StatusBadRequest, "POST", "/api/comments", bidderToken, "not a comment", &malformed)
+
+	if !strings.Contains(malformed["error"], "comment text") {
+		t.Fatalf("malformed comment: got %v", malformed)
+	}
+
+	malformed = nil
+	s.expect(http.StatusBadRequest, "POST", repliesPath, ownerToken, "not a reply", &malformed)
+
+	if !strings.Contains(malformed["error"], "comment text") {
+		t.Fatalf("malformed reply: got %v", malformed)
+	}
+
+	s.expect(http.StatusBadRequest, "POST", repliesPath, ownerToken, map[string]string{"comment": ""}, nil)
+	s.expect(http.StatusOK, "POST", repliesPath, ownerToken, map[string]string{"comment": "Yes it is"}, nil)
+
+	var replies []map[string]interface{}
+	s.expect(http.StatusOK, "GET", repliesPath, "", nil, &replies)
+
+	if len(replies) != 1 || replies[0]["user_name"] != owner {
+		t.Fatalf("replies: got %v", replies)
+	}
+
+	replyID := replies[0]["id"].(string)
+
+	s.expect(http.StatusForbidden, "PUT", repliesPath+"?id="+replyID, bidderToken, map[string]string{"comment": "No it isn't"}, nil)
+
+	var reply map[string]interface{}
+	s.expect(http.StatusOK, "PUT", repliesPath+"?id="+replyID, ownerToken, map[string]string{"comment": "Yes, come by"}, &reply)
+
+	if reply["comment"] != "Yes, come by" {
+		t.Fatalf("updated reply: got %v", reply)
+	}
+
+	var comment map[string]interface{}
+	s.expect(http.StatusOK, "GET", "/api/comments?id="+commentID, "", nil, &comment)
+
+	if comment["comment"] != "Is it still available?" || len(comment["replies"].([]interface{})) != 1 {
+		t.Fatalf("get comment: got %v", comment)
+	}
+
+	s.expect(http.StatusBadRequest, "PUT", "/api/comments?id="+commentID, bidderToken, map[string]string{"display_name": owner}, nil)
+
+	comment = nil
+	s.expect(http.StatusOK, "PUT", "/api/comments?id="+commentID, bidderToken, map[string]string{"comment": "Still available?", "id": replyID, "display_name": owner}, &comment)
+
+	// only the text of a comment can be changed
+	if comment["comment"] != "Still available?" || comment["id"] != commentID || comment["display_name"] != bidder {
+		t.Fatalf("updated comment: got %v", comment)
+	}
+
+	// comments belong to their author's account, not the display name it had, which sessions pick up when changed
+	s.expect(http.StatusOK, "PUT", "/api/users", bidderToken, map[string]string{"display_name": bidder + "-renamed"}, nil)
+	s.expect(http.StatusOK, "PUT", "/api/users", ownerToken, map[string]string{"display_name": bidder}, nil)
+	s.expect(http.StatusForbidden, "PUT", "/api/comments?id="+commentID, ownerToken, map[string]string{"comment": "Sold"}, nil)
+	s.expect(http.StatusOK, "PUT", "/api/comments?id="+commentID, bidderToken, map[string]string{"comment": "Still available?"}, nil)
+	s.expect(http.StatusOK, "POST", repliesPath, bidderToken, map[string]string{"comment": "Thanks"}, nil)
+
+	var renamed []map[string]interface{}
+	s.expect(http.StatusOK, "GET", repliesPath, "", nil, &renamed)
+
+	if len(renamed) != 2 || renamed[1]["user_name"] != bidder+"-renamed" || renamed[0]["user_name"] != owner {
+		t.Fatalf("replies after renaming: got %v", renamed)
+	}
+
+	s.expect(http.StatusOK, "DELETE", repliesPath+"?id="+renamed[1]["id"].(string), bidderToken, nil, nil)
+	s.expect(http.StatusOK, "PUT", "/api/users", ownerToken, map[string]string{"display_name": owner}, nil)
+	s.expect(http.StatusOK, "PUT", "/api/users", bidderToken, map[string]string{"display_name": bidder}, nil)
+
+	// whatever an id names, unknown ones are not found
+	for _, id := range []string{itemID, "replies:" + commentID, "lockout:failures:ip:127.0.0.1", "00000000-0000-0000-0000-000000000000"} {
+		s.expect(http.StatusNotFound, "GET", "/api/comments?id="+url.QueryEscape(id), "", nil, nil)
+		s.expect(http.StatusNotFound, "PUT", "/api/comments?id="+url.QueryEscape(id), bidderToken, map[string]string{"comment": "Hi"}, nil)
+		s.expect(http.StatusNotFound, "DELETE", "/api/comments?id="+url.QueryEscape(id), bidderToken, nil, nil)
+		s.expect(http.StatusNotFound, "PUT", repliesPath+"?id="+url.QueryEscape(id), bidderToken, map[string]string{"comment": "Hi"}, nil)
+	}
+
+	s.expect(http.StatusNotFound, "DELETE", repliesPath+"?id="+commentID, bidderToken, nil, nil)
+	s.expect(http.StatusNotFound, "GET", "/api/users/nobody"+suffix, bidderToken, nil, nil)
+
+	for _, id := range []string{"not-an-id", "00000000-0000-0000-0000-000000000000"} {
+		s.expect(http.StatusNotFound, "PUT", "/api/admin/users/"+id+"/role", ownerToken, map[string]string{"role": "moderator"}, nil)
+		s.expect(http.StatusNotFound, "POST", "/api/admin/users/"+id+"/unlock", ownerToken, nil, nil)
+		s.expect(http.StatusNotFound, "PUT", "/api/categories/"+id, ownerToken, map[string]string{"name": "Nothing"}, nil)
+		s.expect(http.StatusNotFound, "DELETE", "/api/categories/"+id, ownerToken, nil, nil)
+		s.expect(http.StatusNotFound, "GET", "/api/locations/location?id="+id, "", nil, nil)
+		s.expect(http.StatusNotFound, "PUT", "/api/locations?id="+id, bidderToken, map[string]string{"city": "Nowhere"}, nil)
+		s.expect(http.StatusNotFound, "GET", "/api/categories/counts?location_id="+id, "", nil, nil)
+	}
+
+	s.expect(http.StatusOK, "DELETE", repliesPath+"?id="+replyID, ownerToken, nil, nil)
+	s.expect(http.StatusOK, "GET", repliesPath, "", nil, &replies)
+
+	if len(replies) != 0 {
+		t.Fatalf("replies after delete: got %v", replies)
+	}
+
+	s.expect(http.StatusOK, "GET", "/api/items?id="+itemID, "", nil, &got)
+
+	if len(got["comments"].([]interface{})) != 1 {
+		t.Fatalf("item comments: got %v", got)
+	}
+
+	// bids
+
+	s.expect(http.StatusNotFound, "POST", "/api/items/bid?id=00000000-0000-4000-8000-000000000000", bidderToken, map[string]string{"message": "Anyone?"}, nil)
+	s.expect(http.StatusNotFound, "POST", "/api/items/bid?id=not-an-id", bidderToken, map[string]string{"message": "Anyone?"}, nil)
+	s.expect(http.StatusNotFound, "GET", "/api/items/bid?id=00000000-0000-4000-8000-000000000000", ownerToken, nil, nil)
+	s.expect(http.StatusOK, "POST", "/api/items/bid?id="+itemID, bidderToken, map[string]string{"message": "I need it for my flat"}, nil)
+	s.expect(http.StatusForbidden, "GET", "/api/items/bid?id="+itemID, bidderToken, nil, nil)
+
+	var itemBids map[string]string
+	s.expect(http.StatusOK, "GET", "/api/items/bid?id="+itemID, ownerToken, nil, &itemBids)
+
+	if itemBids[bidder] != "I need it for my flat" {
+		t.Fatalf("bids: got %v", itemBids)
+	}
+
+	// the owner switched to french above
+	s.waitForMail(owner+"@example.com", "Nouvelle offre sur votre objet")
+
+	// the lifecycle of an item: reserved for someone, then handed over to them
+	recipient := &users.User{Email: bidder + "@example.com"}
+
+	if err := s.stores.Users.GetByEmail(context.Background(), recipient); err != nil {
+		t.Fatal(err)
+	}
+
+	reserve := map[string]string{"status": "reserved", "recipient_id": recipient.ID}
+
+	s.expect(http.StatusForbidden, "PATCH", "/api/items?id="+itemID, bidderToken, reserve, nil)
+	s.expect(http.StatusBadRequest, "PATCH", "/api/items?id="+itemID, ownerToken, map[string]string{"status": "given"}, nil)
+	s.expect(http.StatusConflict, "PATCH", "/api/items?id="+itemID, ownerToken, map[string]string{"status": "handed_over"}, nil)
+	s.expect(http.StatusConflict, "PATCH", "/api/items?id="+itemID, ownerToken, map[string]string{"status": "expired"}, nil)
+	s.expect(http.StatusBadRequest, "PATCH", "/api/items?id="+itemID, ownerToken, map[string]string{"status": "reserved"}, nil)
+	s.expect(http.StatusBadRequest, "PATCH", "/api/items?id="+itemID, ownerToken, map[string]string{"status": "reserved", "recipient_id": admin.ID}, nil)
+	s.expect(http.StatusBadRequest, "PATCH", "/api/items?id="+itemID, ownerToken, map[string]string{"status": "reserved", "recipient_id": "00000000-0000-4000-8000-000000000000"}, nil)
+	s.expect(http.StatusNotFound, "PATCH", "/api/items?id=00000000-0000-4000-8000-000000000000", ownerToken, reserve, nil)
+
+	var reserved map[string]interface{}
+	s.expect(http.StatusOK, "PATCH", "/api/items?id="+itemID, ownerToken, reserve, &reserved)
+
+	if reserved["from"] != "open" || reserved["to"] != "reserved" || reserved["recipient_id"] != recipient.ID || reserved["user_id"] != admin.ID {
+		t.Fatalf("reserving an item: got %v", reserved)
+	}
+
+	s.waitForMail(owner+"@example.com", "Votre objet est réservé")
+	s.waitForMail(bidder+"@example.com", "An item was reserved for you")
+
+	s.expect(http.StatusBadRequest, "POST", "/api/items/bid?id="+itemID, bidderToken, map[string]string{"message": "Too late?"}, nil)
+	s.expect(http.StatusForbidden, "PUT", "/api/items?id="+itemID, ownerToken, map[string]string{"name": "Reserved sofa"}, nil)
+	s.upload(http.StatusForbidden, "/api/items/images?id="+itemID, ownerToken, "image/jpeg", upload, nil)
+
+	s.expect(http.StatusOK, "PATCH", "/api/items?id="+itemID, ownerToken, map[string]string{"status": "handed_over"}, nil)
+	s.waitForMail(bidder+"@example.com", "An item was handed over to you")
+	s.expect(http.StatusConflict, "PATCH", "/api/items?id="+itemID, ownerToken, map[string]string{"status": "open"}, nil)
+	s.expect(http.StatusOK, "GET", "/api/items?id="+itemID, "", nil, &got)
+
+	if got["status"] != "handed_over" || got["recipient_id"] != recipient.ID {
+		t.Fatalf("handed over item: got %v", got)
+	}
+
+	s.expect(http.StatusOK, "GET", "/api/items?status=handed_over&city="+city, "", nil, &all)
+
+	if len(all.Items) != 1 || all.Items[0]["id"] != itemID {
+		t.Fatalf("items that changed hands: got %v", all)
+	}
+
+	var history []map[string]interface{}
+	s.expect(http.StatusForbidden, "GET", "/api/items/history?id="+itemID, bidderToken, nil, nil)
+	s.expect(http.StatusOK, "GET", "/api/items/history?id="+itemID, ownerToken, nil, &history)
+
+	if len(history) != 2 || history[0]["to"] != "reserved" || history[1]["from"] != "reserved" || history[1]["to"] != "handed_over" || history[1]["recipient_id"] != recipient.ID {
+		t.Fatalf("history: got %v", history)
+	}
+
+	// drafts are only shown to their owner until they are opened, and open items expire
+	item["name"] = "Lamp"
+	item["status"] = "reserved"
+	s.expect(http.StatusBadRequest, "POST", "/api/items", ownerToken, item, nil)
+
+	item["status"] = "draft"
+	s.expect(http.StatusCreated, "POST", "/api/items", ownerToken, item, &created)
+	s.expect(http.StatusNotFound, "GET", "/api/items?id="+created["id"], "", nil, nil)
+	s.expect(http.StatusNotFound, "POST", "/api/items/bid?id="+created["id"], bidderToken, map[string]string{"message": "A draft?"}, nil)
+	s.expect(http.StatusNotFound, "GET", "/api/items/bid?id="+created["id"], bidderToken, nil, nil)
+	s.expect(http.StatusBadRequest, "POST", "/api/items/bid?id="+created["id"], ownerToken, map[string]string{"message": "My own draft"}, nil)
+
+	var mine listPage
+	s.expect(http.StatusOK, "GET", "/api/items/mine?status=draft", ownerToken, nil, &mine)
+
+	if len(mine.Items) != 1 || mine.Items[0]["id"] != created["id"] {
+		t.Fatalf("drafts of the owner: got %v", mine)
+	}
+
+	s.expect(http.StatusOK, "GET", "/api/items/mine?status=draft", bidderToken, nil, &mine)
+
+	if len(mine.Items) != 0 {
+		t.Fatalf("drafts of someone else: got %v", mine)
+	}
+
+	s.expect(http.StatusOK, "PATCH", "/api/items?id="+created["id"], ownerToken, map[string]string{"status": "open"}, nil)
+	s.waitForMail(owner+"@example.com", "Votre objet est en ligne")
+	s.expect(http.StatusOK, "GET", "/api/items?id="+created["id"], "", nil, &got)
+
+	if got["status"] != "open" {
+		t.Fatalf("opened draft: got %v", got)
+	}
+
+	if err := s.handler.ExpireItems(context.Background(), time.Now().Add(testConfig().Items.ExpireAfter.Duration+time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	s.waitForMail(owner+"@example.com", "Votre objet a expiré")
+	s.expect(http.StatusOK, "GET", "/api/items?id="+created["id"], "", nil, &got)
+
+	if got["status"] != "expired" {
+		t.Fatalf("expired item: got %v", got)
+	}
+
+	history = nil
+	s.expect(http.StatusOK, "GET", "/api/items/history?id="+created["id"], ownerToken, nil, &history)
+
+	if len(history) != 2 || history[0]["from"] != "draft" || history[1]["to"] != "expired" || history[1]["user_id"] != nil {
+		t.Fatalf("history of an expired item: got %v", history)
+	}
+
+	// the owner is an admin, and moderators can remove anyone's comments
+	s.expect(http.StatusOK, "DELETE", "/api/comments?id="+commentID, ownerToken, nil, nil)
+	s.expect(http.StatusOK, "GET", "/api/comments/item?id="+itemID, "", nil, &itemComments)
+
+	if len(itemComments.Items) != 0 {
+		t.Fatalf("item comments after delete: got %v", itemComments)
+	}
+
+	// emails that can't be delivered
+
+	bounce := "bounce" + suffix
+	s.expect(http.StatusCreated, "POST", "/api/users", "", map[string]string{
+		"display_name": bounce,
+		"first_name":   "Test",
+		"last_name":    "User",
+		"email":        bounce + "@example.com",
+		"password":     "correct horse battery",
+	}, nil)
+
+	s.expect(http.StatusForbidden, "GET", "/api/admin/outbox", bidderToken, nil, nil)
+
+	var dead []map[string]interface{}
+
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		s.expect(http.StatusOK, "GET", "/api/admin/outbox", ownerToken, nil, &dead)
+
+		if len(dead) > 0 || time.Now().After(deadline) {
+			break
+		}
+	}
+
+	if len(dead) != 1 || dead[0]["attempts"] != float64(2) || !strings.Contains(dead[0]["last_error"].(string), "550") {
+		t.Fatalf("dead letters: got %v", dead)
+	}
+
+	// admins see who an email was for and what it was, never its links
+	if _, ok := dead[0]["message"]; ok || dead[0]["template"] != "confirmation" || dead[0]["to"].([]interface{})[0] != bounce+"@example.com" {
+		t.Fatalf("dead letter: got %v", dead[0])
+	}
+
+	s.expect(http.StatusForbidden, "GET", "/api/admin/metrics", bidderToken, nil, nil)
+
+	var vars map[string]interface{}
+	s.expect(http.StatusOK, "GET", "/api/admin/metrics", ownerToken, nil, &vars)
+
+	if _, ok := vars["panics"]; !ok {
+		t.Fatalf("metrics: got %v", vars)
+	}
+
+	s.expect(http.StatusNotFound, "POST", "/api/admin/outbox/nope/requeue", ownerToken, nil, nil)
+	s.expect(http.StatusOK, "POST", "/api/admin/outbox/"+dead[0]["id"].(string)+"/requeue", ownerToken, nil, nil)
+
+	// roles
+
+	s.expect(http.StatusForbidden, "PUT", "/api/admin/users/"+admin.ID+"/role", bidderToken, map[string]string{"role": "user"}, nil)
+	s.expect(http.StatusBadRequest, "PUT", "/api/admin/users/"+admin.ID+"/role", ownerToken, map[string]string{"role": "owner"}, nil)
+	s.expect(http.StatusNotFound, "PUT", "/api/admin/users/00000000-0000-0000-0000-000000000000/role", ownerToken, map[string]string{"role": "user"}, nil)
+
+	promoted := &users.User{Email: bidder + "@example.com"}
+
+	if err := s.stores.Users.GetByEmail(context.Background(), promoted); err != nil {
+		t.Fatal(err)
+	}
+
+	s.expect(http.StatusOK, "PUT", "/api/admin/users/"+promoted.ID+"/role", ownerToken, map[string]string{"role": "moderator"}, nil)
+
+	// changing a role ends the user's sessions, so the new role takes effect straight away
+	s.expect(http.StatusUnauthorized, "GET", "/api/sessions", bidderToken, nil, nil)
+
+	s.expect(http.StatusOK, "POST", "/api/login", "", map[string]string{"email": bidder + "@example.com", "password": "a brand new password"}, &relogin)
+	bidderToken = relogin["token"]
+	s.expect(http.StatusOK, "GET", "/api/users", bidderToken, nil, nil)
+	s.expect(http.StatusOK, "PUT", "/api/locations?id="+locationID, bidderToken, map[string]string{"state": "OGUN"}, nil)
+	s.expect(http.StatusForbidden, "GET", "/api/admin/outbox", bidderToken, nil, nil)
+
+	// cors
+
+	preflight := func(origin, path string) *http.Response {
+		req, _ := http.NewRequest("OPTIONS", s.URL+path, nil)
+		req.Header.Set("Origin", origin)
+		req.Header.Set("Access-Control-Request-Method", "POST")
+		res, err := http.DefaultClient.Do(req)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+
+		return res
+	}
+
+	// preflights are answered before any route runs, so they don't need a token
+	for _, path := range []string{"/api/users", "/api/login", "/api/items/bid", "/api/locations"} {
+		res := preflight("http://localhost:3000", path)
+
+		if res.StatusCode != http.StatusNoContent || res.Header.Get("Access-Control-Allow-Origin") != "http://localhost:3000" ||
+			res.Header.Get("Access-Control-Allow-Credentials") != "true" || res.Header.Get("Access-Control-Max-Age") == "" {
+			t.Fatalf("preflight of %s: got %d %v", path, res.StatusCode, res.Header)
+		}
+	}
+
+	if res := preflight("https://app.staging.example.com", "/api/login"); res.Header.Get("Access-Control-Allow-Origin") != "https://app.staging.example.com" {
+		t.Fatalf("preflight from a subdomain: got %v", res.Header)
+	}
+
+	for _, origin := range []string{"https://staging.example.com", "https://app.staging.example.com.evil.org", "http://evil.org"} {
+		if res := preflight(origin, "/api/login"); res.Header.Get("Access-Control-Allow-Origin") != "" {
+			t.Fatalf("preflight from %s should not be allowed, got %v", origin, res.Header)
+		}
+	}
+
+	req, _ := http.NewRequest("GET", s.URL+"/api/locations", nil)
+	req.Header.Set("Origin", "http://localhost:3000")
+	res, err := http.DefaultClient.Do(req)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusOK || res.Header.Get("Access-Control-Allow-Origin") != "http://localhost:3000" || res.Header.Get("Access-Control-Expose-Headers") == "" {
+		t.Fatalf("cross origin request: got %d %v", res.StatusCode, res.Header)
+	}
+
+	// request logging
+
+	traced := "trace-" + suffix
+
+	for id, want := range map[string]string{traced: traced, "not a valid id": ""} {
+		req, _ = http.NewRequest("GET", s.URL+"/api/users/"+owner, nil)
+		req.Header.Set("Authorization", "Bearer "+ownerToken)
+		req.Header.Set("X-Request-ID", id)
+		res, err = http.DefaultClient.Do(req)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+
+		got := res.Header.Get("X-Request-ID")
+
+		if got == "" || (want != "" && got != want) || (want == "" && got == id) {
+			t.Fatalf("request id %q came back as %q", id, got)
+		}
+	}
+
+	var logged map[string]interface{}
+
+	for _, record := range s.logs.records() {
+		if record["msg"] == "request" && record["request_id"] == traced {
+			logged = record
+		}
+	}
+
+	if logged == nil || logged["route"] != "/api/users/{username}" || logged["method"] != "GET" || logged["status"] != float64(http.StatusOK) ||
+		logged["user_id"] == "" || logged["bytes"] == float64(0) || logged["latency_ms"] == nil {
+		t.Fatalf("the request was logged as %v", logged)
+	}
+
+	// sessions
+
+	phone := s.login(owner + "@example.com")
+
+	var sessions []map[string]interface{}
+	s.expect(http.StatusOK, "GET", "/api/sessions", phone, nil, &sessions)
+
+	var current string
+
+	for _, session := range sessions {
+		if session["ip"] != "127.0.0.1" || session["user_agent"] == "" || session["created_at"] == "" {
+			t.Fatalf("session without device details: %v", session)
+		}
+
+		if session["current"] == true {
+			if current != "" {
+				t.Fatalf("more than one current session: %v", sessions)
+			}
+
+			current = session["id"].(string)
+		}
+	}
+
+	if len(sessions) < 2 || current == "" {
+		t.Fatalf("sessions: got %v", sessions)
+	}
+
+	s.expect(http.StatusNotFound, "DELETE", "/api/sessions/not-a-session", ownerToken, nil, nil)
+	s.expect(http.StatusNotFound, "DELETE", "/api/sessions/"+current, bidderToken, nil, nil)
+	s.expect(http.StatusOK, "DELETE", "/api/sessions/"+current, ownerToken, nil, nil)
+	s.expect(http.StatusUnauthorized, "GET", "/api/users", phone, nil, nil)
+	s.expect(http.StatusOK, "GET", "/api/users", ownerToken, nil, nil)
+
+	// refresh tokens
+
+	var mobile map[string]string
+	s.expect(http.StatusOK, "POST", "/api/login", "", map[string]string{"email": owner + "@example.com", "password": "correct horse battery"}, &mobile)
+
+	var refreshed map[string]string
+	s.expect(http.StatusOK, "POST", "/api/token/refresh", "", map[string]string{"refresh_token": mobile["refresh_token"]}, &refreshed)
+
+	if refreshed["token"] == "" || refreshed["refresh_token"] == "" || refreshed["refresh_token"] == mobile["refresh_token"] {
+		t.Fatalf("refresh: got %v after %v", refreshed, mobile)
+	}
+
+	s.expect(http.StatusOK, "GET", "/api/users", refreshed["token"], nil, nil)
+	s.expect(http.StatusBadRequest, "POST", "/api/token/refresh", "", map[string]string{}, nil)
+	s.expect(http.StatusUnauthorized, "POST", "/api/token/refresh", "", map[string]string{"refresh_token": "not-a-token"}, nil)
+
+	// a refresh token used twice was copied, so the session ends for whoever holds it
+	s.expect(http.StatusUnauthorized, "POST", "/api/token/refresh", "", map[string]string{"refresh_token": mobile["refresh_token"]}, nil)
+	s.expect(http.StatusUnauthorized, "GET", "/api/users", refreshed["token"], nil, nil)
+	s.expect(http.StatusUnauthorized, "POST", "/api/token/refresh", "", map[string]string{"refresh_token": refreshed["refresh_token"]}, nil)
+	s.expect(http.StatusOK, "GET", "/api/users", ownerToken, nil, nil)
+
+	// refreshing a session that ended in the meantime doesn't bring it back
+	gone := "ended-session-" + suffix
+
+	if err := s.stores.Sessions.ExtendSession(context.Background(), gone, map[string]interface{}{"userID": "someone"}, time.Minute); err != tokens.ErrSessionExpired {
+		t.Fatalf("extending an ended session: got %v", err)
+	}
+
+	if _, err := s.stores.Sessions.GetSession(context.Background(), gone); err != tokens.ErrSessionExpired {
+		t.Fatalf("ended session came back: got %v", err)
+	}
+
+	// two-factor authentication
+
+	guard := "guard" + suffix
+	guardMail := guard + "@example.com"
+	guardToken := s.signUp(guard, guardMail)
+
+	s.expect(http.StatusBadRequest, "POST", "/api/users/2fa/verify", guardToken, map[string]string{"code": "123456"}, nil)
+
+	var enrolled struct {
+		Secret        string   `json:"secret"`
+		URI           string   `json:"uri"`
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+	s.expect(http.StatusCreated, "POST", "/api/users/2fa", guardToken, nil, &enrolled)
+
+	if !strings.HasPrefix(enrolled.URI, "otpauth://totp/") || !strings.Contains(enrolled.URI, "secret="+enrolled.Secret) || len(enrolled.RecoveryCodes) != 10 {
+		t.Fatalf("enroll: got %+v", enrolled)
+	}
+
+	// until enrolment is verified the password is enough
+	s.login(guardMail)
+
+	code, err := totp.Code(enrolled.Secret, time.Now())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.expect(http.StatusBadRequest, "POST", "/api/users/2fa/verify", guardToken, map[string]string{"code": "abcdef"}, nil)
+	s.expect(http.StatusOK, "POST", "/api/users/2fa/verify", guardToken, map[string]string{"code": code}, nil)
+	s.expect(http.StatusConflict, "POST", "/api/users/2fa", guardToken, nil, nil)
+
+	challenge := func() string {
+		var login map[string]string
+		s.expect(http.StatusOK, "POST", "/api/login", "", map[string]string{"email": guardMail, "password": "correct horse battery"}, &login)
+
+		if login["challenge"] == "" || login["token"] != "" {
+			t.Fatalf("login with two-factor authentication: got %v", login)
+		}
+
+		return login["challenge"]
+	}
+
+	first := challenge()
+
+	// a code only works once
+	s.expect(http.StatusUnauthorized, "POST", "/api/login/2fa", "", map[string]string{"challenge": first, "code": code}, nil)
+
+	code, _ = totp.Code(enrolled.Secret, time.Now().Add(totp.Step))
+
+	var verified map[string]string
+	s.expect(http.StatusOK, "POST", "/api/login/2fa", "", map[string]string{"challenge": first, "code": code}, &verified)
+
+	if verified["token"] == "" || verified["refresh_token"] == "" {
+		t.Fatalf("second login step: got %v", verified)
+	}
+
+	s.expect(http.StatusOK, "GET", "/api/sessions", verified["token"], nil, nil)
+	s.expect(http.StatusUnauthorized, "POST", "/api/login/2fa", "", map[string]string{"challenge": first, "code": code}, nil)
+	s.expect(http.StatusBadRequest, "POST", "/api/login/2fa", "", map[string]string{"challenge": first}, nil)
+
+	// recovery codes work once, however they are typed
+	recovery := strings.ToUpper(strings.Replace(enrolled.RecoveryCodes[0], "-", " ", 1))
+	s.expect(http.StatusOK, "POST", "/api/login/2fa", "", map[string]string{"challenge": challenge(), "recovery_code": recovery}, nil)
+	s.expect(http.StatusUnauthorized, "POST", "/api/login/2fa", "", map[string]string{"challenge": challenge(), "recovery_code": enrolled.RecoveryCodes[0]}, nil)
+
+	// a challenge only takes a few wrong codes, each a failed login like the reused recovery code above
+	lockout := testConfig().Lockout
+	guessed := challenge()
+
+	for i := 0; i < tokens.MaxChallengeAttempts; i++ {
+		time.Sleep(lockout.MaxDelay.Duration)
+		s.expect(http.StatusUnauthorized, "POST", "/api/login/2fa", "", map[string]string{"challenge": guessed, "code": "abcdef"}, nil)
+	}
+
+	s.expect(http.StatusUnauthorized, "POST", "/api/login/2fa", "", map[string]string{"challenge": guessed, "recovery_code": enrolled.RecoveryCodes[1]}, nil)
+
+	// and guessing on across fresh challenges locks the account, right password or not
+	for i := tokens.MaxChallengeAttempts + 1; i < lockout.MaxAttempts; i++ {
+		time.Sleep(lockout.MaxDelay.Duration)
+		s.expect(http.StatusUnauthorized, "POST", "/api/login/2fa", "", map[string]string{"challenge": challenge(), "code": "abcdef"}, nil)
+	}
+
+	s.waitForMail(guardMail, "Your account has been locked")
+	s.expect(http.StatusTooManyRequests, "POST", "/api/login", "", map[string]string{"email": guardMail, "password": "correct horse battery"}, nil)
+
+	guardian := &users.User{Email: guardMail}
+
+	if err = s.stores.Users.GetByEmail(context.Background(), guardian); err != nil {
+		t.Fatal(err)
+	}
+
+	s.expect(http.StatusOK, "POST", "/api/admin/users/"+guardian.ID+"/unlock", ownerToken, nil, nil)
+
+	s.expect(http.StatusBadRequest, "DELETE", "/api/users/2fa", guardToken, map[string]string{"code": "abcdef"}, nil)
+	s.expect(http.StatusOK, "DELETE", "/api/users/2fa", guardToken, map[string]string{"recovery_code": enrolled.RecoveryCodes[1]}, nil)
+	s.login(guardMail)
+	s.expect(http.StatusOK, "DELETE", "/api/users", guardToken, nil, nil)
+
+	// failed logins
+
+	locked := "locked" + suffix
+	lockedMail := locked + "@example.com"
+	s.signUp(locked, lockedMail)
+
+	wrong := map[string]string{"email": lockedMail, "password": "not the password"}
+	right := map[string]string{"email": lockedMail, "password": "correct horse battery"}
+
+	var wrongPassword, wrongEmail map[string]string
+	s.expect(http.StatusBadRequest, "POST", "/api/login", "", wrong, &wrongPassword)
+	s.expect(http.StatusBadRequest, "POST", "/api/login", "", map[string]string{"email": "nobody" + suffix + "@example.com", "password": "not the password"}, &wrongEmail)
+
+	if wrongPassword["error"] == "" || wrongPassword["error"] != wrongEmail["error"] {
+		t.Fatalf("failed logins should look the same, got %v and %v", wrongPassword, wrongEmail)
+	}
+
+	for i := 1; i < lockout.FreeAttempts; i++ {
+		s.expect(http.StatusBadRequest, "POST", "/api/login", "", wrong, nil)
+	}
+
+	// past the free attempts each failure makes the next login wait, even with the right password
+	s.expect(http.StatusBadRequest, "POST", "/api/login", "", wrong, nil)
+
+	body, _ := json.Marshal(right)
+	res, err = http.Post(s.URL+"/api/login", "application/json", bytes.NewReader(body))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusTooManyRequests || res.Header.Get("Retry-After") != "1" {
+		t.Fatalf("login during a delay: got %d %v", res.StatusCode, res.Header)
+	}
+
+	time.Sleep(lockout.BaseDelay.Duration)
+
+	for i := lockout.FreeAttempts + 1; i < lockout.MaxAttempts; i++ {
+		s.expect(http.StatusBadRequest, "POST", "/api/login", "", wrong, nil)
+		time.Sleep(lockout.MaxDelay.Duration)
+	}
+
+	s.waitForMail(lockedMail, "Your account has been locked")
+	s.expect(http.StatusTooManyRequests, "POST", "/api/login", "", right, nil)
+
+	// confirmation keys can't name any other key, such as the ones lockouts are kept in
+	for _, key := range []string{"lockout:locked:account:" + lockedMail, "lockout:failures:account:" + lockedMail, "lockout:failures:ip:127.0.0.1"} {
+		s.expect(http.StatusBadRequest, "GET", "/api/confirm-email?key="+url.QueryEscape(key), "", nil, nil)
+	}
+
+	s.expect(http.StatusTooManyRequests, "POST", "/api/login", "", right, nil)
+
+	lockedOut := &users.User{Email: lockedMail}
+
+	if err = s.stores.Users.GetByEmail(context.Background(), lockedOut); err != nil {
+		t.Fatal(err)
+	}
+
+	s.expect(http.StatusForbidden, "POST", "/api/admin/users/"+lockedOut.ID+"/unlock", bidderToken, nil, nil)
+	s.expect(http.StatusOK, "POST", "/api/admin/users/"+lockedOut.ID+"/unlock", ownerToken, nil, nil)
+	s.login(lockedMail)
+
+	// logging out and leaving
+
+	laptop := s.login(owner + "@example.com")
+
+	var loggedOut map[string]string
+	s.expect(http.StatusOK, "GET", "/api/logout", ownerToken, nil, &loggedOut)
+
+	if loggedOut["message"] != "Success!" {
+		t.Fatalf("logout: got %v", loggedOut)
+	}
+
+	s.expect(http.StatusUnauthorized, "GET", "/api/users", ownerToken, nil, nil)
+	s.expect(http.StatusOK, "GET", "/api/users", laptop, nil, nil)
+
+	desktop := s.login(owner + "@example.com")
+	s.expect(http.StatusOK, "DELETE", "/api/sessions", laptop, nil, nil)
+	s.expect(http.StatusUnauthorized, "GET", "/api/users", laptop, nil, nil)
+	s.expect(http.StatusUnauthorized, "GET", "/api/users", desktop, nil, nil)
+	s.expect(http.StatusUnauthorized, "GET", "/api/sessions", desktop, nil, nil)
+
+	s.expect(http.StatusOK, "DELETE", "/api/users", bidderToken, nil, nil)
+	s.expect(http.StatusUnauthorized, "GET", "/api/users", bidderToken, nil, nil)
+	s.expect(http.StatusBadRequest, "POST", "/api/login", "", map[string]string{"email": bidder + "@example.com", "password": "a brand new password"}, nil)
+}
+
+//readSocket reads the first message pushed on a websocket route
+func (s *testServer) readSocket(path string, out interface{}) {
+	s.t.Helper()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(s.URL, "http")+path, nil)
+
+	if err != nil {
+		s.t.Fatalf("dial %s: %v", path, err)
+	}
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	if err = conn.ReadJSON(out); err != nil {
+		s.t.Fatalf("read %s: %v", path, err)
+	}
+}
+
+//listPage is a page of a list
+type listPage struct {
+	Items      []map[string]interface{} `json:"items"`
+	NextCursor string                   `json:"next_cursor"`
+	HasMore    bool                     `json:"has_more"`
+}
+
+//upload sends data as the image field of a multipart form, failing the test when the request doesn't answer with the
+//wanted status, and decodes the json response into out when out isn't nil
+func (s *testServer) upload(want int, path, token, contentType string, data []byte, out interface{}) {
+	s.t.Helper()
+
+	var body bytes.Buffer
+
+	form := multipart.NewWriter(&body)
+	part, err := form.CreatePart(textproto.MIMEHeader{
+		"Content-Disposition": {`form-data; name="image"; filename="photo"`},
+		"Content-Type":        {contentType},
+	})
+
+	if err == nil {
+		_, err = part.Write(data)
+	}
+
+	if err == nil {
+		err = form.Close()
+	}
+
+	if err != nil {
+		s.t.Fatal(err)
+	}
+
+	req, err := http.NewRequest("POST", s.URL+path, &body)
+
+	if err != nil {
+		s.t.Fatal(err)
+	}
+
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	res, err := http.DefaultClient.Do(req)
+
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	raw, _ := io.ReadAll(res.Body)
+
+	if res.StatusCode != want {
+		s.t.Fatalf("POST %s: got status %d, want %d: %s", path, res.StatusCode, want, raw)
+	}
+
+	if out != nil {
+		if err = json.Unmarshal(raw, out); err != nil {
+			s.t.Fatalf("POST %s: can't decode %q: %v", path, raw, err)
+		}
+	}
+}
+
+//fetch gets a file the api links to, failing the test when it doesn't answer with the wanted status
+func (s *testServer) fetch(want int, path string) ([]byte, http.Header) {
+	s.t.Helper()
+
+	res, err := http.Get(s.URL + path)
+
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+
+	if err != nil {
+		s.t.Fatal(err)
+	}
+
+	if res.StatusCode != want {
+		s.t.Fatalf("GET %s: got status %d, want %d", path, res.StatusCode, want)
+	}
+
+	return body, res.Header
+}
+
+//photo returns a jpeg 40 pixels wide and 20 high with an EXIF segment saying where it was taken, as phones add
+func photo(t *testing.T) []byte {
+	var buf bytes.Buffer
+
+	if err := jpeg.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 40, 20)), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	exif := []byte("Exif\x00\x00MM\x00\x2a\x00\x00\x00\x08\x00\x00GPSLatitude 6.5244 GPSLongitude 3.3792")
+	segment := append([]byte{0xFF, 0xE1, byte((len(exif) + 2) >> 8), byte(len(exif) + 2)}, exif...)
+
+	return append(append([]byte{0xFF, 0xD8}, segment...), buf.Bytes()[2:]...)
+}
+
+func containsID(list []map[string]interface{}, id string) bool {
+	for _, entry := range list {
+		if entry["id"] == id {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Samuyi/www/config"
+	"github.com/Samuyi/www/migrations"
+	"github.com/Samuyi/www/storage"
+)
+
+// migrate runs the migrate up|down|status subcommand
+func migrate(cfg config.Database, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: migrate up|down|status")
+	}
+
+	if cfg.Driver != "postgres" {
+		return fmt.Errorf("migrations only apply to the postgres driver")
+	}
+
+	db, err := storage.OpenDB(cfg)
+
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	switch args[0] {
+	case "up":
+		applied, err := migrations.Up(db)
+
+		if err != nil {
+			return err
+		}
+
+		if len(applied) == 0 {
+			fmt.Println("database is up to date")
+		}
+	case "down":
+		reverted, err := migrations.Down(db)
+
+		if err != nil {
+			return err
+		}
+
+		if reverted == nil {
+			fmt.Println("no migration to revert")
+		}
+	case "status":
+		statuses, err := migrations.List(db)
+
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+
+		for _, s := range statuses {
+			appliedAt := "pending"
+
+			if s.AppliedAt != nil {
+				appliedAt = s.AppliedAt.Format(time.RFC3339)
+			}
+
+			fmt.Fprintf(w, "%04d\t%s\t%s\n", s.Version, s.Name, appliedAt)
+		}
+
+		return w.Flush()
+	default:
+		return fmt.Errorf("usage: migrate up|down|status")
+	}
+
+	return nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/Samuyi/www/config"
+	"github.com/Samuyi/www/models/users"
+	"github.com/Samuyi/www/storage"
+)
+
+// role runs the role <email> user|moderator|admin subcommand, which is how the first admin is made.
+// The new role applies from the user's next login.
+func role(cfg config.Database, args []string) error {
+	if len(args) != 2 || !users.ValidRole(users.Role(args[1])) {
+		return fmt.Errorf("usage: role <email> user|moderator|admin")
+	}
+
+	if cfg.Driver != "postgres" {
+		return fmt.Errorf("roles can only be set on the postgres driver")
+	}
+
+	db, err := storage.OpenDB(cfg)
+
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	store := users.NewStore(db)
+	user := &users.User{Email: args[0]}
+
+	err = store.GetByEmail(context.Background(), user)
+
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("there is no user with the email %s", args[0])
+	}
+
+	if err != nil {
+		return err
+	}
+
+	user.Role = users.Role(args[1])
+
+	if err = store.SetRole(context.Background(), user); err != nil {
+		return err
+	}
+
+	fmt.Printf("%s is now %s\n", user.Email, user.Role)
+
+	return nil
+}
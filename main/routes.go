@@ -0,0 +1,119 @@
+package main
+
+import (
+	"expvar"
+	"log/slog"
+	"net/http"
+
+	"github.com/Samuyi/www/apperr"
+	"github.com/Samuyi/www/config"
+	"github.com/Samuyi/www/controllers"
+	"github.com/Samuyi/www/keys"
+	"github.com/Samuyi/www/middleware"
+	"github.com/Samuyi/www/models"
+	"github.com/Samuyi/www/models/users"
+	"github.com/gorilla/mux"
+)
+
+//newRouter registers every api route on a new router, limiting the routes named in cfg.RateLimits,
+//and applies the cors policy of cfg to all of them. Every request is logged with logger.
+func newRouter(h *controllers.Handler, stores *models.Stores, manager *keys.Manager, cfg *config.Config, logger *slog.Logger) http.Handler {
+	limits := cfg.RateLimits
+	auth := middleware.Auth(stores.Sessions, manager)
+
+	// every route behind auth names the roles allowed to use it
+	member := middleware.RequireRole(stores.Sessions, users.Roles...)
+	staff := middleware.RequireRole(stores.Sessions, users.RoleModerator, users.RoleAdmin)
+	admin := middleware.RequireRole(stores.Sessions, users.RoleAdmin)
+
+	// routes that send email or write on every call are limited. Limits on routes behind auth count per user,
+	// so they have to come before auth; the others count per address.
+	limit := func(name string, key middleware.RateKey) middleware.Middleware {
+		return middleware.RateLimit(stores.RateLimits, middleware.RatePolicy{Name: name, RateLimit: limits[name], Key: key})
+	}
+	byUser := middleware.ByUser(stores.Sessions)
+
+	registering := limit("register", middleware.ByIP)
+	forgetting := limit("forgot_password", middleware.ByIP)
+	bidding := limit("bid", byUser)
+	commenting := limit("comment", byUser)
+
+	router := mux.NewRouter()
+	router.Use(middleware.Route)
+
+	// requests no route answers get the usual json error too
+	router.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		apperr.Write(w, apperr.NotFound("Sorry that page doesn't exist"))
+	})
+	router.MethodNotAllowedHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		apperr.Write(w, apperr.MethodNotAllowed("Sorry that method isn't allowed here"))
+	})
+
+	router.HandleFunc("/.well-known/jwks.json", middleware.ChainMiddlewares(h.GetJWKS, middleware.Method("GET"))).Methods("GET")
+
+	router.HandleFunc("/api/users", middleware.ChainMiddlewares(h.RegisterUser, middleware.Method("POST"), registering)).Methods("POST")
+	router.HandleFunc("/api/users", middleware.ChainMiddlewares(h.GetAllUsers, middleware.Method("GET"), staff, auth)).Methods("GET")
+	router.HandleFunc("/api/users/{username}", middleware.ChainMiddlewares(h.GetUser, middleware.Method("GET"), member, auth)).Methods("GET")
+	router.HandleFunc("/api/login", middleware.ChainMiddlewares(h.Login, middleware.Method("POST"))).Methods("POST")
+	router.HandleFunc("/api/login/2fa", middleware.ChainMiddlewares(h.LoginTwoFactor, middleware.Method("POST"))).Methods("POST")
+	router.HandleFunc("/api/users/2fa", middleware.ChainMiddlewares(h.EnrollTwoFactor, middleware.Method("POST"), member, auth)).Methods("POST")
+	router.HandleFunc("/api/users/2fa/verify", middleware.ChainMiddlewares(h.VerifyTwoFactor, middleware.Method("POST"), member, auth)).Methods("POST")
+	router.HandleFunc("/api/users/2fa", middleware.ChainMiddlewares(h.DisableTwoFactor, middleware.Method("DELETE"), member, auth)).Methods("DELETE")
+	router.HandleFunc("/api/logout", middleware.ChainMiddlewares(h.LogOut, middleware.Method("GET"), member, auth)).Methods("GET")
+	router.HandleFunc("/api/token/refresh", middleware.ChainMiddlewares(h.RefreshToken, middleware.Method("POST"))).Methods("POST")
+	router.HandleFunc("/api/sessions", middleware.ChainMiddlewares(h.GetSessions, middleware.Method("GET"), member, auth)).Methods("GET")
+	router.HandleFunc("/api/sessions", middleware.ChainMiddlewares(h.LogOutEverywhere, middleware.Method("DELETE"), member, auth)).Methods("DELETE")
+	router.HandleFunc("/api/sessions/{id}", middleware.ChainMiddlewares(h.DeleteSession, middleware.Method("DELETE"), member, auth)).Methods("DELETE")
+	router.HandleFunc("/api/confirm-email", middleware.ChainMiddlewares(h.ConfirmUser, middleware.Method("GET"))).Methods("GET")
+	router.HandleFunc("/api/users", middleware.ChainMiddlewares(h.UpdateUser, middleware.Method("PUT"), member, auth)).Methods("PUT")
+	router.HandleFunc("/api/users", middleware.ChainMiddlewares(h.DeleteUser, middleware.Method("DELETE"), member, auth)).Methods("DELETE")
+	router.HandleFunc("/api/users/avatar", middleware.ChainMiddlewares(h.UploadAvatar, middleware.Method("POST"), member, auth)).Methods("POST")
+	router.HandleFunc("/api/users/avatar", middleware.ChainMiddlewares(h.DeleteAvatar, middleware.Method("DELETE"), member, auth)).Methods("DELETE")
+	router.HandleFunc("/api/forgot-password", middleware.ChainMiddlewares(h.ForgotPassword, middleware.Method("POST"), forgetting)).Methods("POST")
+	router.HandleFunc("/api/reset-password", middleware.ChainMiddlewares(h.ResetPassword, middleware.Method("POST"))).Methods("POST")
+
+	router.HandleFunc("/api/items", middleware.ChainMiddlewares(h.CreateItem, middleware.Method("POST"), member, auth)).Methods("POST")
+	router.HandleFunc("/api/items", middleware.ChainMiddlewares(h.GetItem, middleware.Method("GET"))).Methods("GET").Queries("id", "{id}")
+	router.HandleFunc("/api/items", middleware.ChainMiddlewares(h.GetAllItems, middleware.Method("GET"))).Methods("GET")
+	router.HandleFunc("/api/items/search", middleware.ChainMiddlewares(h.SearchItems, middleware.Method("GET"))).Methods("GET")
+	router.HandleFunc("/api/items/location", middleware.ChainMiddlewares(h.GetItemsInALocation, middleware.Method("GET"))).Methods("GET")
+	router.HandleFunc("/api/items", middleware.ChainMiddlewares(h.UpdateItem, middleware.Method("PUT"), member, auth)).Methods("PUT")
+	router.HandleFunc("/api/items", middleware.ChainMiddlewares(h.SetItemStatus, middleware.Method("PATCH"), member, auth)).Methods("PATCH")
+	router.HandleFunc("/api/items/mine", middleware.ChainMiddlewares(h.GetMyItems, middleware.Method("GET"), member, auth)).Methods("GET")
+	router.HandleFunc("/api/items/history", middleware.ChainMiddlewares(h.GetItemHistory, middleware.Method("GET"), member, auth)).Methods("GET")
+	router.HandleFunc("/api/items/bid", middleware.ChainMiddlewares(h.BidItem, middleware.Method("POST"), bidding, member, auth)).Methods("POST")
+	router.HandleFunc("/api/items/bid", middleware.ChainMiddlewares(h.GetBidsOnItem, middleware.Method("GET"), member, auth)).Methods("GET")
+	router.HandleFunc("/api/items/images", middleware.ChainMiddlewares(h.UploadItemImage, middleware.Method("POST"), member, auth)).Methods("POST")
+	router.HandleFunc("/api/items/images", middleware.ChainMiddlewares(h.DeleteItemImage, middleware.Method("DELETE"), member, auth)).Methods("DELETE")
+	router.HandleFunc("/api/images/{key:.+}", middleware.ChainMiddlewares(h.GetImage, middleware.Method("GET"))).Methods("GET")
+
+	router.HandleFunc("/api/categories", middleware.ChainMiddlewares(h.GetCategories, middleware.Method("GET"))).Methods("GET")
+	router.HandleFunc("/api/categories", middleware.ChainMiddlewares(h.CreateCategory, middleware.Method("POST"), admin, auth)).Methods("POST")
+	router.HandleFunc("/api/categories/counts", middleware.ChainMiddlewares(h.GetCategoryCounts, middleware.Method("GET"))).Methods("GET")
+	router.HandleFunc("/api/categories/{id}", middleware.ChainMiddlewares(h.UpdateCategory, middleware.Method("PUT"), admin, auth)).Methods("PUT")
+	router.HandleFunc("/api/categories/{id}", middleware.ChainMiddlewares(h.DeleteCategory, middleware.Method("DELETE"), admin, auth)).Methods("DELETE")
+
+	router.HandleFunc("/api/comments", middleware.ChainMiddlewares(h.CreateComment, middleware.Method("POST"), commenting, member, auth)).Methods("POST")
+	router.HandleFunc("/api/comments", middleware.ChainMiddlewares(h.GetComment, middleware.Method("GET"))).Methods("GET")
+	router.HandleFunc("/api/comments/item", middleware.ChainMiddlewares(h.GetItemComments, middleware.Method("GET"))).Methods("GET")
+	router.HandleFunc("/api/comments", middleware.ChainMiddlewares(h.UpdateComment, middleware.Method("PUT"), member, auth)).Methods("PUT")
+	router.HandleFunc("/api/comments", middleware.ChainMiddlewares(h.DeleteComment, middleware.Method("DELETE"), member, auth)).Methods("DELETE")
+	router.HandleFunc("/api/comments/{comment_id}/reply", middleware.ChainMiddlewares(h.GetReplies, middleware.Method("GET"))).Methods("GET")
+	router.HandleFunc("/api/comments/{comment_id}/reply", middleware.ChainMiddlewares(h.CreateReply, middleware.Method("POST"), commenting, member, auth)).Methods("POST")
+	router.HandleFunc("/api/comments/{comment_id}/reply", middleware.ChainMiddlewares(h.UpdateReply, middleware.Method("PUT"), member, auth)).Methods("PUT")
+	router.HandleFunc("/api/comments/{comment_id}/reply", middleware.ChainMiddlewares(h.DeleteReply, middleware.Method("DELETE"), member, auth)).Methods("DELETE")
+
+	router.HandleFunc("/api/locations", middleware.ChainMiddlewares(h.CreateLocation, middleware.Method("POST"), member, auth)).Methods("POST")
+	router.HandleFunc("/api/locations", middleware.ChainMiddlewares(h.GetLocations, middleware.Method("GET"))).Methods("GET")
+	router.HandleFunc("/api/locations/location", middleware.ChainMiddlewares(h.GetLocation, middleware.Method("GET"))).Methods("GET")
+	router.HandleFunc("/api/locations", middleware.ChainMiddlewares(h.UpdateLocation, middleware.Method("PUT"), member, auth)).Methods("PUT")
+
+	router.HandleFunc("/api/admin/outbox", middleware.ChainMiddlewares(h.GetDeadEmails, middleware.Method("GET"), admin, auth)).Methods("GET")
+	router.HandleFunc("/api/admin/users/{id}/role", middleware.ChainMiddlewares(h.SetUserRole, middleware.Method("PUT"), admin, auth)).Methods("PUT")
+	router.HandleFunc("/api/admin/users/{id}/unlock", middleware.ChainMiddlewares(h.UnlockUser, middleware.Method("POST"), admin, auth)).Methods("POST")
+	router.HandleFunc("/api/admin/outbox/{id}/requeue", middleware.ChainMiddlewares(h.RequeueEmail, middleware.Method("POST"), admin, auth)).Methods("POST")
+
+	router.HandleFunc("/api/admin/metrics", middleware.ChainMiddlewares(expvar.Handler().ServeHTTP, middleware.Method("GET"), admin, auth)).Methods("GET")
+
+	return middleware.Logging(logger)(middleware.Recover(middleware.NewCORS(cfg.CORS).Handler(router)))
+}
@@ -0,0 +1,8 @@
+//Package metrics holds the counters the application publishes with expvar. Admins can read them, along with
+//the memory statistics of the runtime, at /api/admin/metrics.
+package metrics
+
+import "expvar"
+
+//Panics counts the panics recovered while serving requests
+var Panics = expvar.NewInt("panics")
@@ -0,0 +1,118 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Samuyi/www/config"
+)
+
+//allowedMethods are the methods the api has routes for
+const allowedMethods = "GET, POST, PUT, PATCH, DELETE"
+
+//CORS is the cross origin policy of the api. It is applied to the router as a whole, so every route answers
+//the same way and preflights are answered before any route, or its authentication, runs.
+type CORS struct {
+	any         bool
+	origins     map[string]bool
+	wildcards   []wildcard
+	credentials bool
+	headers     string
+	exposed     string
+	maxAge      string
+}
+
+//wildcard is an allowed origin with a wildcard subdomain, https://*.example.com is https:// and .example.com
+type wildcard struct {
+	scheme string
+	domain string
+}
+
+//NewCORS returns the policy described by cfg, which is expected to have been validated
+func NewCORS(cfg config.CORS) *CORS {
+	cors := &CORS{
+		origins:     map[string]bool{},
+		credentials: cfg.AllowCredentials,
+		headers:     strings.Join(cfg.AllowedHeaders, ", "),
+		exposed:     strings.Join(cfg.ExposedHeaders, ", "),
+		maxAge:      strconv.Itoa(int(cfg.MaxAge.Seconds())),
+	}
+
+	for _, origin := range cfg.AllowedOrigins {
+		origin = strings.ToLower(origin)
+
+		switch {
+		case origin == "*":
+			cors.any = true
+		case strings.Contains(origin, "://*."):
+			parts := strings.SplitN(origin, "://*", 2)
+			cors.wildcards = append(cors.wildcards, wildcard{scheme: parts[0] + "://", domain: parts[1]})
+		default:
+			cors.origins[origin] = true
+		}
+	}
+
+	return cors
+}
+
+//Allows reports whether pages from origin may call the api
+func (c *CORS) Allows(origin string) bool {
+	if origin == "" {
+		return false
+	}
+
+	origin = strings.ToLower(origin)
+
+	if c.any || c.origins[origin] {
+		return true
+	}
+
+	// the wildcard stands for one or more subdomains, not for the domain itself
+	for _, w := range c.wildcards {
+		if strings.HasPrefix(origin, w.scheme) && strings.HasSuffix(origin, w.domain) && len(origin) > len(w.scheme)+len(w.domain) {
+			return true
+		}
+	}
+
+	return false
+}
+
+//Handler applies the policy to every request next serves. Preflights are answered straight away with a 204,
+//whether or not the origin is allowed; browsers only go on when the answer allows them to.
+func (c *CORS) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		preflight := r.Method == "OPTIONS" && origin != "" && r.Header.Get("Access-Control-Request-Method") != ""
+
+		w.Header().Add("Vary", "Origin")
+
+		if preflight {
+			w.Header().Add("Vary", "Access-Control-Request-Method")
+			w.Header().Add("Vary", "Access-Control-Request-Headers")
+		}
+
+		if c.Allows(origin) {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+
+			if c.credentials {
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+			}
+
+			if preflight {
+				w.Header().Set("Access-Control-Allow-Methods", allowedMethods)
+				w.Header().Set("Access-Control-Allow-Headers", c.headers)
+				w.Header().Set("Access-Control-Max-Age", c.maxAge)
+			} else if c.exposed != "" {
+				w.Header().Set("Access-Control-Expose-Headers", c.exposed)
+			}
+		}
+
+		if preflight {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,147 @@
+package middleware
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/Samuyi/www/logging"
+	"github.com/gorilla/mux"
+)
+
+//RequestIDHeader carries the id of a request, from the client or a proxy in front of the api, and back in the response
+const RequestIDHeader = "X-Request-ID"
+
+//validRequestID keeps ids from clients short and printable, anything else is replaced
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+type requestLogKey struct{}
+
+//requestLog collects what the log line of a request needs from the middlewares and routes that run inside Logging
+type requestLog struct {
+	route  string
+	userID string
+}
+
+//newRequestID returns 128 random bits in hex
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
+
+//responseRecorder remembers the status and size of a response
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += n
+
+	return n, err
+}
+
+//Hijack hands the connection over for websockets, which are logged as switching protocols
+func (rec *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := rec.ResponseWriter.(http.Hijacker)
+
+	if !ok {
+		return nil, nil, errors.New("middleware: the response can't be hijacked")
+	}
+
+	rec.status = http.StatusSwitchingProtocols
+
+	return hijacker.Hijack()
+}
+
+//Logging gives every request an id, taken from its X-Request-ID header when it has a valid one, and a logger
+//in its context that records it. Once the request is served it logs its method, route, status, latency,
+//user and size with logger.
+func Logging(logger *slog.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+
+			id := r.Header.Get(RequestIDHeader)
+
+			if !validRequestID.MatchString(id) {
+				id = newRequestID()
+			}
+
+			w.Header().Set(RequestIDHeader, id)
+
+			info := &requestLog{}
+			ctx := logging.WithRequestID(logging.NewContext(r.Context(), logger), id)
+			ctx = context.WithValue(ctx, requestLogKey{}, info)
+
+			rec := &responseRecorder{ResponseWriter: w}
+			next.ServeHTTP(rec, r.WithContext(ctx))
+
+			if rec.status == 0 {
+				rec.status = http.StatusOK
+			}
+
+			level := slog.LevelInfo
+
+			if rec.status >= http.StatusInternalServerError {
+				level = slog.LevelError
+			}
+
+			logging.From(ctx).Log(ctx, level, "request",
+				"method", r.Method,
+				"route", info.route,
+				"path", r.URL.Path,
+				"status", rec.status,
+				"latency_ms", float64(time.Since(start).Microseconds())/1000,
+				"user_id", info.userID,
+				"bytes", rec.bytes,
+			)
+		})
+	}
+}
+
+//Route records the template of the route serving a request for Logging. It is a mux middleware, so only
+//requests that match a route have one.
+func Route(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if info, ok := r.Context().Value(requestLogKey{}).(*requestLog); ok {
+			if route := mux.CurrentRoute(r); route != nil {
+				info.route, _ = route.GetPathTemplate()
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+//withUser records the user making r for Logging and adds them to the logger of the request
+func withUser(r *http.Request, userID string) *http.Request {
+	if info, ok := r.Context().Value(requestLogKey{}).(*requestLog); ok {
+		info.userID = userID
+	}
+
+	return r.WithContext(logging.With(r.Context(), "user_id", userID))
+}
@@ -1,12 +1,13 @@
 package middleware
 
 import (
-	"encoding/json"
-	"fmt"
 	"net/http"
-	"os"
+	"strings"
 
-	"github.com/Samuyi/www/utilities"
+	"github.com/Samuyi/www/apperr"
+	"github.com/Samuyi/www/keys"
+	"github.com/Samuyi/www/models"
+	"github.com/Samuyi/www/models/users"
 	jwt "github.com/dgrijalva/jwt-go"
 )
 
@@ -29,52 +30,35 @@ func Method(methods ...string) Middleware {
 				}
 			}
 
-			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
-			return
+			apperr.Write(w, apperr.MethodNotAllowed("Sorry that method isn't allowed here"))
 		}
 	}
 }
 
-var secret = []byte(os.Getenv("AUTH_KEY"))
-
-//Auth is a middleware to authenticate request on the server
-func Auth() Middleware {
+//Auth is a middleware to authenticate request on the server with tokens signed by one of the keys of manager
+func Auth(sessions models.SessionStore, manager *keys.Manager) Middleware {
 
 	return func(f http.HandlerFunc) http.HandlerFunc {
 		return func(w http.ResponseWriter, r *http.Request) {
 			bearerToken := r.Header.Get("Authorization")
 
 			if bearerToken == "" {
-				msg := map[string]string{"message": "Please supply a token"}
-				w.Header().Set("Content-type", "application/json")
-				w.WriteHeader(http.StatusUnauthorized)
-				json.NewEncoder(w).Encode(msg)
+				apperr.Write(w, apperr.Unauthorized("Please supply a token"))
 
 				return
 			}
 
-			tokenString := bearerToken[7:]
-			token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-				if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-					return nil, fmt.Errorf("There was an error")
-				}
-
-				return secret, nil
-			})
+			tokenString := strings.TrimPrefix(bearerToken, "Bearer ")
+			claims := jwt.MapClaims{}
+			token, err := manager.Parse(tokenString, claims)
 
 			if err != nil {
-				msg := map[string]string{"message": "Sorry token is invalid"}
-				w.Header().Set("Content-type", "application/json")
-				w.WriteHeader(http.StatusUnauthorized)
-				json.NewEncoder(w).Encode(msg)
+				apperr.Write(w, apperr.Unauthorized("Sorry token is invalid"))
 
 				return
 			}
 			if !token.Valid {
-				msg := map[string]string{"message": "Sorry token is invalid"}
-				w.Header().Set("Content-type", "application/json")
-				w.WriteHeader(http.StatusUnauthorized)
-				json.NewEncoder(w).Encode(msg)
+				apperr.Write(w, apperr.Unauthorized("Sorry token is invalid"))
 
 				return
 			}
@@ -82,54 +66,58 @@ func Auth() Middleware {
 			errors := token.Claims.Valid()
 
 			if errors != nil {
-				msg := map[string]string{"message": "Sorry token is invalid"}
-				w.Header().Set("Content-type", "application/json")
-				w.WriteHeader(http.StatusUnauthorized)
-				json.NewEncoder(w).Encode(msg)
+				apperr.Write(w, apperr.Unauthorized("Sorry token is invalid"))
 
 				return
 			}
 
-			claims := token.Claims.(jwt.MapClaims)
-
-			sessionID := claims["sessionID"].(string)
+			sessionID, _ := claims["sessionID"].(string)
 
-			_, err = utilities.GetSession(sessionID)
+			session, err := sessions.GetSession(r.Context(), sessionID)
 
 			if err != nil {
-				msg := map[string]string{"message": "Sorry session has expired"}
-				w.Header().Set("Content-type", "application/json")
-				w.WriteHeader(http.StatusUnauthorized)
-				json.NewEncoder(w).Encode(msg)
+				apperr.Write(w, apperr.Unauthorized("Sorry session has expired"))
 
 				return
 			}
 
 			r.Header.Set("sessionID", sessionID)
-			f(w, r)
+			f(w, withUser(r, session["userID"]))
 
 		}
 	}
 
 }
 
-//WithCors implemets cross origin request middleware
-func WithCors() Middleware {
-	return func(fn http.HandlerFunc) http.HandlerFunc {
+//RequireRole only lets through users with one of roles. It reads the session Auth found, so it has to come
+//before Auth in ChainMiddlewares, which makes Auth run first. Sessions from before roles existed count as users.
+func RequireRole(sessions models.SessionStore, roles ...users.Role) Middleware {
+
+	return func(f http.HandlerFunc) http.HandlerFunc {
 		return func(w http.ResponseWriter, r *http.Request) {
-			w.Header().Set("Access-Control-Allow-Origin", "http://localhost:3000")
-			w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, PATCH, DELETE")
-			w.Header().Set("Access-Control-Allow-Headers",
-				"Accept, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization")
+			session, err := sessions.GetSession(r.Context(), r.Header.Get("sessionID"))
+
+			if err != nil {
+				apperr.Write(w, apperr.Unauthorized("Sorry session has expired"))
 
-			if r.Method == "OPTIONS" {
-				w.WriteHeader(http.StatusOK)
 				return
 			}
 
-			fn(w, r)
+			role := users.Role(session["Role"])
+
+			if role == "" {
+				role = users.RoleUser
+			}
+
+			for _, allowed := range roles {
+				if role == allowed {
+					f(w, r)
+
+					return
+				}
+			}
 
-			return
+			apperr.Write(w, apperr.Forbidden("Sorry you're not authorized to view this page"))
 		}
 	}
 
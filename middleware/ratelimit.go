@@ -0,0 +1,104 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Samuyi/www/apperr"
+	"github.com/Samuyi/www/config"
+	"github.com/Samuyi/www/logging"
+	"github.com/Samuyi/www/models"
+	"github.com/Samuyi/www/models/tokens"
+	"github.com/Samuyi/www/utilities"
+)
+
+//RateKey names who a request counts against, "" when it can't tell and the address should be used instead
+type RateKey func(r *http.Request) string
+
+//RatePolicy is a rate limit applied to a route. Routes sharing a Name share their limit.
+type RatePolicy struct {
+	Name string
+	config.RateLimit
+	Key RateKey
+}
+
+//ByIP counts requests against the address they come from
+func ByIP(r *http.Request) string {
+	return "ip:" + utilities.ClientIP(r)
+}
+
+//BySession counts requests against the session making them. It reads the session Auth found, so it only works
+//on routes behind Auth.
+func BySession(r *http.Request) string {
+	sessionID := r.Header.Get("sessionID")
+
+	if sessionID == "" {
+		return ""
+	}
+
+	return "session:" + tokens.Hash(sessionID)
+}
+
+//ByUser counts requests against the user making them, whichever session they use. Like BySession it only
+//works on routes behind Auth.
+func ByUser(sessions models.SessionStore) RateKey {
+	return func(r *http.Request) string {
+		session, err := sessions.GetSession(r.Context(), r.Header.Get("sessionID"))
+
+		if err != nil || session["userID"] == "" {
+			return ""
+		}
+
+		return "user:" + session["userID"]
+	}
+}
+
+//seconds rounds d up to whole seconds, the unit of the rate limit headers
+func seconds(d time.Duration) string {
+	return strconv.Itoa(int((d + time.Second - 1) / time.Second))
+}
+
+//RateLimit refuses requests with a 429 once the client they count against made more than policy allows in its
+//window, and tells every client where it stands with the RateLimit-* headers. Requests go through when the
+//limits can't be checked, so an outage of the store doesn't take the routes down with it.
+func RateLimit(limiter models.RateLimitStore, policy RatePolicy) Middleware {
+
+	return func(f http.HandlerFunc) http.HandlerFunc {
+		if policy.Limit <= 0 {
+			return f
+		}
+
+		return func(w http.ResponseWriter, r *http.Request) {
+			key := policy.Key(r)
+
+			if key == "" {
+				key = ByIP(r)
+			}
+
+			rate, err := limiter.Take(r.Context(), policy.Name+":"+key, policy.Limit, policy.Window.Duration)
+
+			if err != nil {
+				logging.From(r.Context()).Error("checking a rate limit", "policy", policy.Name, "err", err)
+				f(w, r)
+
+				return
+			}
+
+			w.Header().Set("RateLimit-Limit", strconv.Itoa(policy.Limit))
+			w.Header().Set("RateLimit-Remaining", strconv.Itoa(rate.Remaining))
+			w.Header().Set("RateLimit-Reset", seconds(rate.Reset))
+			w.Header().Set("RateLimit-Policy", fmt.Sprintf("%d;w=%s", policy.Limit, seconds(policy.Window.Duration)))
+
+			if !rate.Allowed {
+				apperr.Write(w, apperr.TooManyRequests("Sorry you have made too many requests, please try again later", rate.Reset))
+
+				return
+			}
+
+			f(w, r)
+		}
+	}
+
+}
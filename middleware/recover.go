@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/Samuyi/www/apperr"
+	"github.com/Samuyi/www/logging"
+	"github.com/Samuyi/www/metrics"
+)
+
+//Recover turns a panic while serving a request into a 500 with the usual json error, logs it with its stack
+//and counts it. It has to run inside Logging so the panic is logged with the id of the request.
+func Recover(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			recovered := recover()
+
+			if recovered == nil {
+				return
+			}
+
+			// ErrAbortHandler is how handlers ask net/http to drop the connection, it isn't a failure
+			if recovered == http.ErrAbortHandler {
+				panic(recovered)
+			}
+
+			metrics.Panics.Add(1)
+			logging.From(r.Context()).Error("panic serving a request", "panic", fmt.Sprint(recovered), "stack", string(debug.Stack()))
+
+			// nothing can be sent when the handler already started its response
+			if rec, ok := w.(*responseRecorder); ok && rec.status != 0 {
+				return
+			}
+
+			apperr.Write(w, apperr.Internal(fmt.Errorf("panic: %v", recovered)))
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,83 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Samuyi/www/logging"
+	"github.com/Samuyi/www/metrics"
+)
+
+func TestRecover(t *testing.T) {
+	var logs bytes.Buffer
+
+	handler := Logging(logging.New(&logs, slog.LevelInfo))(Recover(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var claims map[string]interface{}
+		_ = claims["sessionID"].(string)
+	})))
+
+	before := metrics.Panics.Value()
+
+	req := httptest.NewRequest("GET", "/api/users", nil)
+	req.Header.Set(RequestIDHeader, "panicking-request")
+	res := httptest.NewRecorder()
+	handler.ServeHTTP(res, req)
+
+	var body map[string]string
+
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+
+	if res.Code != http.StatusInternalServerError || body["error"] == "" || res.Header().Get("Content-type") != "application/json" {
+		t.Fatalf("got %d %v", res.Code, body)
+	}
+
+	if metrics.Panics.Value() != before+1 {
+		t.Errorf("the panic wasn't counted")
+	}
+
+	var panicked, served bool
+
+	for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		var record map[string]interface{}
+
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatal(err)
+		}
+
+		if record["request_id"] != "panicking-request" {
+			t.Errorf("%v isn't logged with the request id", record)
+		}
+
+		switch record["msg"] {
+		case "panic serving a request":
+			panicked = strings.Contains(record["stack"].(string), "recover_test.go")
+		case "request":
+			served = record["status"] == float64(http.StatusInternalServerError)
+		}
+	}
+
+	if !panicked || !served {
+		t.Errorf("the panic wasn't logged with its stack and status:\n%s", logs.String())
+	}
+}
+
+func TestRecoverAfterResponseStarted(t *testing.T) {
+	handler := Logging(logging.New(&bytes.Buffer{}, slog.LevelInfo))(Recover(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+		panic("halfway through")
+	})))
+
+	res := httptest.NewRecorder()
+	handler.ServeHTTP(res, httptest.NewRequest("GET", "/", nil))
+
+	if res.Code != http.StatusAccepted || res.Body.Len() != 0 {
+		t.Fatalf("a started response should be left alone, got %d %q", res.Code, res.Body.String())
+	}
+}
@@ -0,0 +1,286 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed sql/*.sql
+var files embed.FS
+
+// lockID is the postgres advisory lock held while migrations run so two instances starting together don't race
+const lockID = 7264519
+
+//Migration is one numbered schema change
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+//Status of a migration in a database
+type Status struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+//All returns the embedded migrations ordered by version
+func All() ([]Migration, error) {
+	entries, err := files.ReadDir("sql")
+
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+
+	for _, entry := range entries {
+		name := entry.Name()
+
+		var direction string
+
+		switch {
+		case strings.HasSuffix(name, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(name, ".down.sql"):
+			direction = "down"
+		default:
+			return nil, fmt.Errorf("migrations: %s must end in .up.sql or .down.sql", name)
+		}
+
+		base := strings.TrimSuffix(name, "."+direction+".sql")
+		parts := strings.SplitN(base, "_", 2)
+
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("migrations: %s must be named <version>_<name>.%s.sql", name, direction)
+		}
+
+		version, err := strconv.Atoi(parts[0])
+
+		if err != nil {
+			return nil, fmt.Errorf("migrations: %s has an invalid version", name)
+		}
+
+		body, err := files.ReadFile(path.Join("sql", name))
+
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[version]
+
+		if !ok {
+			migration = &Migration{Version: version, Name: parts[1]}
+			byVersion[version] = migration
+		}
+
+		if migration.Name != parts[1] {
+			return nil, fmt.Errorf("migrations: version %d is used by both %s and %s", version, migration.Name, parts[1])
+		}
+
+		if direction == "up" {
+			migration.Up = string(body)
+		} else {
+			migration.Down = string(body)
+		}
+	}
+
+	var migrations []Migration
+
+	for _, migration := range byVersion {
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("migrations: %04d_%s needs both an up and a down file", migration.Version, migration.Name)
+		}
+
+		migrations = append(migrations, *migration)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+//Up applies every pending migration in order and returns the ones applied
+func Up(db *sql.DB) ([]Migration, error) {
+	var applied []Migration
+
+	err := withLock(db, func(conn *sql.Conn) error {
+		statuses, err := status(conn)
+
+		if err != nil {
+			return err
+		}
+
+		for _, s := range statuses {
+			if s.AppliedAt != nil {
+				continue
+			}
+
+			if err = run(conn, s.Up, func(tx *sql.Tx) error {
+				_, err := tx.Exec("INSERT INTO schema_migrations (version, name) VALUES ($1, $2)", s.Version, s.Name)
+				return err
+			}); err != nil {
+				return fmt.Errorf("migrations: %04d_%s up: %v", s.Version, s.Name, err)
+			}
+
+			log.Printf("applied migration %04d_%s", s.Version, s.Name)
+			applied = append(applied, s.Migration)
+		}
+
+		return nil
+	})
+
+	return applied, err
+}
+
+//Down rolls back the latest applied migration and returns it, or nil when nothing is applied
+func Down(db *sql.DB) (*Migration, error) {
+	var reverted *Migration
+
+	err := withLock(db, func(conn *sql.Conn) error {
+		statuses, err := status(conn)
+
+		if err != nil {
+			return err
+		}
+
+		for i := len(statuses) - 1; i >= 0; i-- {
+			s := statuses[i]
+
+			if s.AppliedAt == nil {
+				continue
+			}
+
+			if err = run(conn, s.Down, func(tx *sql.Tx) error {
+				_, err := tx.Exec("DELETE FROM schema_migrations WHERE version = $1", s.Version)
+				return err
+			}); err != nil {
+				return fmt.Errorf("migrations: %04d_%s down: %v", s.Version, s.Name, err)
+			}
+
+			log.Printf("reverted migration %04d_%s", s.Version, s.Name)
+			reverted = &s.Migration
+
+			return nil
+		}
+
+		return nil
+	})
+
+	return reverted, err
+}
+
+//List returns every known migration and when it was applied
+func List(db *sql.DB) ([]Status, error) {
+	var statuses []Status
+
+	err := withLock(db, func(conn *sql.Conn) error {
+		var err error
+		statuses, err = status(conn)
+		return err
+	})
+
+	return statuses, err
+}
+
+func withLock(db *sql.DB, fn func(conn *sql.Conn) error) error {
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err = conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", lockID); err != nil {
+		return err
+	}
+
+	defer func() {
+		if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", lockID); err != nil {
+			log.Println(err)
+		}
+	}()
+
+	query := "CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY, name text NOT NULL, applied_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP)"
+
+	if _, err = conn.ExecContext(ctx, query); err != nil {
+		return err
+	}
+
+	return fn(conn)
+}
+
+func status(conn *sql.Conn) ([]Status, error) {
+	migrations, err := All()
+
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := conn.QueryContext(context.Background(), "SELECT version, applied_at FROM schema_migrations")
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]time.Time{}
+
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	statuses := make([]Status, len(migrations))
+
+	for i, migration := range migrations {
+		statuses[i].Migration = migration
+
+		if appliedAt, ok := applied[migration.Version]; ok {
+			statuses[i].AppliedAt = &appliedAt
+		}
+	}
+
+	return statuses, nil
+}
+
+func run(conn *sql.Conn, body string, record func(tx *sql.Tx) error) error {
+	tx, err := conn.BeginTx(context.Background(), nil)
+
+	if err != nil {
+		return err
+	}
+
+	if _, err = tx.Exec(body); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err = record(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
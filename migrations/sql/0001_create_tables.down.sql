@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS items;
+DROP TABLE IF EXISTS locations;
+DROP TABLE IF EXISTS users;
@@ -1,3 +1,5 @@
+CREATE EXTENSION IF NOT EXISTS "uuid-ossp";
+
 CREATE TABLE IF NOT EXISTS users (
 id  uuid DEFAULT uuid_generate_v4() UNIQUE,
 display_name text NOT NULL UNIQUE,
@@ -30,9 +32,9 @@ CREATE TABLE IF NOT EXISTS items (
     closed BOOLEAN DEFAULT FALSE,
     city text REFERENCES locations(city),
     user_id uuid REFERENCES users(id) ON DELETE CASCADE,
-    instruction VARCHAR, 
+    instruction VARCHAR,
     phone_no VARCHAR(14) NOT NULL,
     created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP,
     PRIMARY KEY (id)
-);
\ No newline at end of file
+);
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS items_user_id_idx;
+DROP INDEX IF EXISTS items_location_id_idx;
+
+ALTER TABLE items DROP COLUMN IF EXISTS active;
+ALTER TABLE items DROP COLUMN IF EXISTS location_id;
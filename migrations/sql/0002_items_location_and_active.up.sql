@@ -0,0 +1,7 @@
+ALTER TABLE items ADD COLUMN IF NOT EXISTS location_id uuid REFERENCES locations(location_id) ON DELETE SET NULL;
+ALTER TABLE items ADD COLUMN IF NOT EXISTS active BOOLEAN NOT NULL DEFAULT TRUE;
+
+UPDATE items SET location_id = locations.location_id FROM locations WHERE locations.city = items.city AND items.location_id IS NULL;
+
+CREATE INDEX IF NOT EXISTS items_location_id_idx ON items (location_id);
+CREATE INDEX IF NOT EXISTS items_user_id_idx ON items (user_id);
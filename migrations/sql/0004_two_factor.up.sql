@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS user_totp (
+    user_id uuid REFERENCES users(id) ON DELETE CASCADE,
+    secret text NOT NULL,
+    enabled BOOLEAN NOT NULL DEFAULT FALSE,
+    last_counter BIGINT NOT NULL DEFAULT 0,
+    created_at TIMESTAMP DEFAULT NOW(),
+    PRIMARY KEY(user_id)
+);
+
+CREATE TABLE IF NOT EXISTS recovery_codes (
+    user_id uuid REFERENCES users(id) ON DELETE CASCADE,
+    code_hash text NOT NULL,
+    PRIMARY KEY(user_id, code_hash)
+);
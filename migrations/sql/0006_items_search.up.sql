@@ -0,0 +1,5 @@
+ALTER TABLE items ADD COLUMN IF NOT EXISTS search tsvector GENERATED ALWAYS AS (
+    setweight(to_tsvector('english', coalesce(name, '')), 'A') || setweight(to_tsvector('english', coalesce(instruction, '')), 'B')
+) STORED;
+
+CREATE INDEX IF NOT EXISTS items_search_idx ON items USING GIN (search);
@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS categories (
+    id uuid DEFAULT uuid_generate_v4() UNIQUE,
+    name text NOT NULL,
+    parent_id uuid REFERENCES categories(id) ON DELETE RESTRICT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE,
+    PRIMARY KEY (id)
+);
+
+-- names are unique among the children of a category, and among the categories at the top
+CREATE UNIQUE INDEX IF NOT EXISTS categories_name_key ON categories (COALESCE(parent_id, '00000000-0000-0000-0000-000000000000'), lower(name));
+CREATE INDEX IF NOT EXISTS categories_parent_id_idx ON categories (parent_id);
+
+ALTER TABLE items ADD COLUMN IF NOT EXISTS category_id uuid REFERENCES categories(id) ON DELETE SET NULL;
+ALTER TABLE items ADD COLUMN IF NOT EXISTS condition text CHECK (condition IN ('new', 'good', 'fair', 'for-parts'));
+
+CREATE INDEX IF NOT EXISTS items_category_id_idx ON items (category_id);
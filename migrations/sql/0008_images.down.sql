@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS item_images;
+
+-- avatars uploaded since are kept, the ones set aside by the up migration come back to users without one
+UPDATE users SET avatar = legacy_avatar WHERE avatar IS NULL AND legacy_avatar IS NOT NULL;
+ALTER TABLE users DROP COLUMN IF EXISTS legacy_avatar;
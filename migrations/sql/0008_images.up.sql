@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS item_images (
+    id uuid DEFAULT uuid_generate_v4() UNIQUE,
+    item_id uuid NOT NULL REFERENCES items(id) ON DELETE CASCADE,
+    key text NOT NULL,
+    thumbnail_key text NOT NULL,
+    width integer NOT NULL,
+    height integer NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id)
+);
+
+CREATE INDEX IF NOT EXISTS item_images_item_id_idx ON item_images (item_id, created_at);
+
+-- avatars were whatever users sent until they had to be uploaded, only the keys of uploaded ones mean anything now.
+-- The others are kept in legacy_avatar so reverting can put them back, until a later migration drops the column.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS legacy_avatar text;
+UPDATE users SET legacy_avatar = avatar, avatar = NULL WHERE avatar NOT LIKE 'avatars/%';
@@ -0,0 +1,9 @@
+DROP TABLE IF EXISTS item_transitions;
+DROP INDEX IF EXISTS items_status_idx;
+
+ALTER TABLE items ADD COLUMN IF NOT EXISTS closed BOOLEAN DEFAULT FALSE;
+UPDATE items SET closed = status <> 'open';
+
+ALTER TABLE items DROP COLUMN IF EXISTS status_changed_at;
+ALTER TABLE items DROP COLUMN IF EXISTS recipient_id;
+ALTER TABLE items DROP COLUMN IF EXISTS status;
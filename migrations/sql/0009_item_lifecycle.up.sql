@@ -0,0 +1,24 @@
+ALTER TABLE items ADD COLUMN IF NOT EXISTS status text NOT NULL DEFAULT 'open' CHECK (status IN ('draft', 'open', 'reserved', 'handed_over', 'cancelled', 'expired'));
+ALTER TABLE items ADD COLUMN IF NOT EXISTS recipient_id uuid REFERENCES users(id) ON DELETE SET NULL;
+ALTER TABLE items ADD COLUMN IF NOT EXISTS status_changed_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP;
+
+-- closing an item never said whether it changed hands, so closed items can only count as cancelled
+UPDATE items SET status = 'cancelled', status_changed_at = COALESCE(updated_at, created_at) WHERE closed;
+
+ALTER TABLE items DROP COLUMN IF EXISTS closed;
+
+CREATE INDEX IF NOT EXISTS items_status_idx ON items (status, status_changed_at);
+
+CREATE TABLE IF NOT EXISTS item_transitions (
+    id uuid DEFAULT uuid_generate_v4() UNIQUE,
+    item_id uuid NOT NULL REFERENCES items(id) ON DELETE CASCADE,
+    from_status text NOT NULL,
+    to_status text NOT NULL,
+    user_id uuid REFERENCES users(id) ON DELETE SET NULL,
+    recipient_id uuid REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id)
+);
+
+CREATE INDEX IF NOT EXISTS item_transitions_item_id_idx ON item_transitions (item_id, created_at);
+CREATE INDEX IF NOT EXISTS item_transitions_to_status_idx ON item_transitions (to_status, created_at);
@@ -0,0 +1,8 @@
+package bids
+
+//Bid is a request by a user to be given an item
+type Bid struct {
+	ItemID   string `json:"item_id"`
+	Username string `json:"display_name"`
+	Message  string `json:"message"`
+}
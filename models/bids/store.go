@@ -0,0 +1,52 @@
+package bids
+
+import (
+	"context"
+
+	"github.com/Samuyi/www/logging"
+	"github.com/go-redis/redis"
+)
+
+//Store keeps bids in redis, one hash per item keyed by the bidder's display name
+type Store struct {
+	client *redis.Client
+}
+
+//NewStore returns a bid store backed by client
+func NewStore(client *redis.Client) *Store {
+	return &Store{client: client}
+}
+
+func key(itemID string) string {
+	return itemID + ":bids"
+}
+
+//Place records a bid, replacing any earlier bid by the same user on the item
+func (s *Store) Place(ctx context.Context, bid *Bid) error {
+	_, err := s.client.HSet(key(bid.ItemID), bid.Username, bid.Message).Result()
+
+	if err != nil {
+		logging.From(ctx).Error("bids.Place", "err", err)
+		return err
+	}
+
+	return nil
+}
+
+//GetItemBids gets all bids placed on an item
+func (s *Store) GetItemBids(ctx context.Context, itemID string) ([]Bid, error) {
+	resp, err := s.client.HGetAll(key(itemID)).Result()
+
+	if err != nil {
+		logging.From(ctx).Error("bids.GetItemBids", "err", err)
+		return nil, err
+	}
+
+	var bids []Bid
+
+	for username, message := range resp {
+		bids = append(bids, Bid{ItemID: itemID, Username: username, Message: message})
+	}
+
+	return bids, nil
+}
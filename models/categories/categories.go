@@ -0,0 +1,124 @@
+package categories
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	validator "github.com/asaskevich/govalidator"
+)
+
+//ErrNameTaken is returned when a category is given the name of another with the same parent
+var ErrNameTaken = errors.New("Sorry a category with that name already exists there")
+
+//ErrUnknownParent is returned when a category is put under one that doesn't exist
+var ErrUnknownParent = errors.New("Please supply a valid parent category")
+
+//ErrHasChildren is returned when deleting a category that still has categories under it
+var ErrHasChildren = errors.New("Sorry that category still has categories under it, please move or delete them first")
+
+//Category items are sorted into. Categories form a tree, those without a parent being at the top.
+type Category struct {
+	ID        string     `json:"id"`
+	Name      string     `json:"name"`
+	ParentID  string     `json:"parent_id,omitempty"`
+	Children  []Category `json:"children,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at,omitempty"`
+}
+
+//Count is the number of open items in a category, including the categories under it
+type Count struct {
+	CategoryID string `json:"category_id"`
+	Name       string `json:"name"`
+	ParentID   string `json:"parent_id,omitempty"`
+	Items      int    `json:"items"`
+}
+
+//Validate category struct, saying what is wrong with each invalid field under its json name
+func (category *Category) Validate() map[string]string {
+	var errors = make(map[string]string)
+
+	category.Name = strings.TrimSpace(category.Name)
+
+	if len(category.Name) < 2 || len(category.Name) > 50 {
+		message := "Please supply a name between 2 and 50 characters"
+		errors["name"] = message
+	}
+
+	if category.ParentID != "" && (!validator.IsUUID(category.ParentID) || category.ParentID == category.ID) {
+		message := "Please supply a valid parent category"
+		errors["parent_id"] = message
+	}
+
+	if len(errors) > 0 {
+		return errors
+	}
+
+	return nil
+}
+
+//Tree nests every category of all under its parent, each level sorted by name
+func Tree(all []Category) []Category {
+	children := make(map[string][]Category)
+
+	for _, category := range all {
+		children[category.ParentID] = append(children[category.ParentID], category)
+	}
+
+	var nest func(parentID string) []Category
+
+	nest = func(parentID string) []Category {
+		level := children[parentID]
+
+		sort.Slice(level, func(i, j int) bool {
+			return strings.ToLower(level[i].Name) < strings.ToLower(level[j].Name)
+		})
+
+		for i := range level {
+			level[i].Children = nest(level[i].ID)
+		}
+
+		return level
+	}
+
+	return nest("")
+}
+
+//Descendants returns the ids of the category with id and of every category under it
+func Descendants(all []Category, id string) map[string]bool {
+	found := map[string]bool{id: true}
+
+	// parents can come after their children in all, so go round until nothing new turns up
+	for grown := true; grown; {
+		grown = false
+
+		for _, category := range all {
+			if found[category.ParentID] && !found[category.ID] {
+				found[category.ID] = true
+				grown = true
+			}
+		}
+	}
+
+	return found
+}
+
+//Counts adds the number of items directly in each category of all, given by direct, to the counts of the categories
+//above it. Categories come in the order of all.
+func Counts(all []Category, direct map[string]int) []Count {
+	counts := make([]Count, 0, len(all))
+
+	for _, category := range all {
+		count := Count{CategoryID: category.ID, Name: category.Name, ParentID: category.ParentID}
+
+		for id := range Descendants(all, category.ID) {
+			count.Items += direct[id]
+		}
+
+		counts = append(counts, count)
+	}
+
+	return counts
+}
@@ -0,0 +1,59 @@
+package categories
+
+import "testing"
+
+func all() []Category {
+	// children come before their parents, as they can when listed by name
+	return []Category{
+		{ID: "armchairs", Name: "Armchairs", ParentID: "chairs"},
+		{ID: "books", Name: "Books"},
+		{ID: "chairs", Name: "chairs", ParentID: "furniture"},
+		{ID: "furniture", Name: "Furniture"},
+		{ID: "tables", Name: "Tables", ParentID: "furniture"},
+	}
+}
+
+func TestTree(t *testing.T) {
+	tree := Tree(all())
+
+	if len(tree) != 2 || tree[0].ID != "books" || tree[1].ID != "furniture" {
+		t.Fatalf("top of the tree: got %v", tree)
+	}
+
+	furniture := tree[1].Children
+
+	if len(furniture) != 2 || furniture[0].ID != "chairs" || furniture[1].ID != "tables" {
+		t.Fatalf("furniture: got %v", furniture)
+	}
+
+	if len(furniture[0].Children) != 1 || furniture[0].Children[0].ID != "armchairs" || furniture[1].Children != nil {
+		t.Fatalf("under furniture: got %v", furniture)
+	}
+}
+
+func TestDescendants(t *testing.T) {
+	found := Descendants(all(), "furniture")
+
+	if len(found) != 4 || !found["furniture"] || !found["chairs"] || !found["armchairs"] || !found["tables"] {
+		t.Fatalf("under furniture: got %v", found)
+	}
+
+	if found := Descendants(all(), "armchairs"); len(found) != 1 {
+		t.Fatalf("under armchairs: got %v", found)
+	}
+}
+
+func TestCounts(t *testing.T) {
+	counts := Counts(all(), map[string]int{"armchairs": 2, "tables": 1, "furniture": 4})
+	want := map[string]int{"armchairs": 2, "books": 0, "chairs": 2, "furniture": 7, "tables": 1}
+
+	if len(counts) != len(want) {
+		t.Fatalf("got %v", counts)
+	}
+
+	for _, count := range counts {
+		if count.Items != want[count.CategoryID] {
+			t.Fatalf("%s: got %d, want %d", count.CategoryID, count.Items, want[count.CategoryID])
+		}
+	}
+}
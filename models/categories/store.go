@@ -0,0 +1,211 @@
+package categories
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/Samuyi/www/logging"
+	"github.com/lib/pq"
+)
+
+//Store keeps categories in postgres
+type Store struct {
+	db *sql.DB
+}
+
+//NewStore returns a category store backed by db
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+//constraintErr returns the error a violation of a constraint on categories stands for, or err when it isn't one
+func constraintErr(err error) error {
+	if pqErr, ok := err.(*pq.Error); ok {
+		switch pqErr.Constraint {
+		case "categories_name_key":
+			return ErrNameTaken
+		case "categories_parent_id_fkey":
+			return ErrUnknownParent
+		}
+	}
+
+	return err
+}
+
+//Create a category in the database
+func (s *Store) Create(ctx context.Context, category *Category) error {
+	query := "INSERT INTO categories (name, parent_id) VALUES ($1, NULLIF($2, '')::uuid) returning id, created_at"
+
+	stmt, err := s.db.PrepareContext(ctx, query)
+
+	if err != nil {
+		logging.From(ctx).Error("categories.Create", "err", err)
+		return err
+	}
+	defer stmt.Close()
+
+	err = stmt.QueryRowContext(ctx, category.Name, category.ParentID).Scan(&category.ID, &category.CreatedAt)
+
+	if err = constraintErr(err); err != nil {
+		if err != ErrNameTaken && err != ErrUnknownParent {
+			logging.From(ctx).Error("categories.Create", "err", err)
+		}
+		return err
+	}
+
+	return nil
+}
+
+//Get a category from the database
+func (s *Store) Get(ctx context.Context, category *Category) error {
+	query := "SELECT name, COALESCE(parent_id::text, ''), created_at FROM categories WHERE id = $1"
+
+	stmt, err := s.db.PrepareContext(ctx, query)
+
+	if err != nil {
+		logging.From(ctx).Error("categories.Get", "err", err)
+		return err
+	}
+	defer stmt.Close()
+
+	err = stmt.QueryRowContext(ctx, category.ID).Scan(&category.Name, &category.ParentID, &category.CreatedAt)
+
+	if err != nil && err != sql.ErrNoRows {
+		logging.From(ctx).Error("categories.Get", "err", err)
+	}
+
+	return err
+}
+
+//Update the name and parent of a category
+func (s *Store) Update(ctx context.Context, category *Category) error {
+	query := "UPDATE categories SET name = $1, parent_id = NULLIF($2, '')::uuid, updated_at = $3 WHERE id = $4"
+
+	stmt, err := s.db.PrepareContext(ctx, query)
+
+	if err != nil {
+		logging.From(ctx).Error("categories.Update", "err", err)
+		return err
+	}
+	defer stmt.Close()
+
+	category.UpdatedAt = time.Now()
+	_, err = stmt.ExecContext(ctx, category.Name, category.ParentID, category.UpdatedAt, category.ID)
+
+	if err = constraintErr(err); err != nil {
+		if err != ErrNameTaken && err != ErrUnknownParent {
+			logging.From(ctx).Error("categories.Update", "err", err)
+		}
+		return err
+	}
+
+	return nil
+}
+
+//Delete a category, which can't have categories under it. Its items are left without a category.
+func (s *Store) Delete(ctx context.Context, category *Category) error {
+	query := "DELETE FROM categories WHERE id = $1"
+
+	stmt, err := s.db.PrepareContext(ctx, query)
+
+	if err != nil {
+		logging.From(ctx).Error("categories.Delete", "err", err)
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.ExecContext(ctx, category.ID)
+
+	if constraintErr(err) == ErrUnknownParent {
+		return ErrHasChildren
+	}
+
+	if err != nil {
+		logging.From(ctx).Error("categories.Delete", "err", err)
+		return err
+	}
+
+	return nil
+}
+
+//GetAll categories, unnested and sorted by name
+func (s *Store) GetAll(ctx context.Context) ([]Category, error) {
+	query := "SELECT id, name, COALESCE(parent_id::text, ''), created_at, COALESCE(updated_at, created_at) FROM categories ORDER BY lower(name), id"
+
+	stmt, err := s.db.PrepareContext(ctx, query)
+
+	if err != nil {
+		logging.From(ctx).Error("categories.GetAll", "err", err)
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.QueryContext(ctx)
+
+	if err != nil {
+		logging.From(ctx).Error("categories.GetAll", "err", err)
+		return nil, err
+	}
+
+	var categories []Category
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var category Category
+		if err := rows.Scan(&category.ID, &category.Name, &category.ParentID, &category.CreatedAt, &category.UpdatedAt); err != nil {
+			logging.From(ctx).Error("categories.GetAll", "err", err)
+			return nil, err
+		}
+		categories = append(categories, category)
+	}
+
+	if err = rows.Err(); err != nil {
+		logging.From(ctx).Error("categories.GetAll", "err", err)
+		return nil, err
+	}
+
+	return categories, nil
+}
+
+//CountItems counts the open items directly in each category at a location, by category id
+func (s *Store) CountItems(ctx context.Context, locationID string) (map[string]int, error) {
+	query := "SELECT category_id, count(*) FROM items WHERE location_id = $1 AND status = 'open' AND category_id IS NOT NULL GROUP BY category_id"
+
+	stmt, err := s.db.PrepareContext(ctx, query)
+
+	if err != nil {
+		logging.From(ctx).Error("categories.CountItems", "err", err)
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.QueryContext(ctx, locationID)
+
+	if err != nil {
+		logging.From(ctx).Error("categories.CountItems", "err", err)
+		return nil, err
+	}
+
+	counts := make(map[string]int)
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var id string
+		var count int
+		if err := rows.Scan(&id, &count); err != nil {
+			logging.From(ctx).Error("categories.CountItems", "err", err)
+			return nil, err
+		}
+		counts[id] = count
+	}
+
+	if err = rows.Err(); err != nil {
+		logging.From(ctx).Error("categories.CountItems", "err", err)
+		return nil, err
+	}
+
+	return counts, nil
+}
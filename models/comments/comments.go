@@ -1,27 +1,36 @@
 package comments
 
 import (
-	"log"
+	"context"
+	"errors"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/Samuyi/www/logging"
+	"github.com/Samuyi/www/models/page"
 	"github.com/go-redis/redis"
 	uuid "github.com/satori/go.uuid"
 )
 
-var client *redis.Client
+//ErrNotFound is returned when there is no comment or reply with an id
+var ErrNotFound = errors.New("Sorry that comment doesn't exist")
 
-func init() {
-	client = redis.NewClient(&redis.Options{
-		Addr:     "localhost:6379",
-		Password: "",
-		DB:       0,
-	})
+//Store keeps comments and replies in redis
+type Store struct {
+	client *redis.Client
+}
+
+//NewStore returns a comment store backed by client
+func NewStore(client *redis.Client) *Store {
+	return &Store{client: client}
 }
 
 //Comment is the data structure of a comment on an item
 type Comment struct {
 	ID         string  `json:"id"`
 	ItemID     string  `json:"item_id"`
+	UserID     string  `json:"user_id"`
 	Username   string  `json:"display_name"`
 	Comment    string  `json:"comment"`
 	ReplyCount int64   `json:"reply_count"`
@@ -33,14 +42,15 @@ type Comment struct {
 //Reply a comment by a user
 type Reply struct {
 	ID        string `json:"id"`
+	UserID    string `json:"user_id"`
 	Username  string `json:"user_name"`
-	CommentID string `json:comment_id"`
+	CommentID string `json:"comment_id"`
 	Comment   string `json:"comment"`
 	CreatedAt string `json:"created_at"`
 }
 
 //Create a comment for an item
-func (comment *Comment) Create() error {
+func (s *Store) Create(ctx context.Context, comment *Comment) error {
 	date := time.Now()
 	comment.ID = uuid.Must(uuid.NewV4()).String()
 	comment.CreatedAt = date.String()
@@ -50,11 +60,12 @@ func (comment *Comment) Create() error {
 	z.Score = float64(date.Unix())
 	z.Member = comment.ID
 
-	pipeline := client.Pipeline()
+	pipeline := s.client.Pipeline()
 
 	pipeline.ZAdd(comment.ItemID, z) // add a comment id to a zset representing a an item
 
 	fields := map[string]interface{}{
+		"user_id":    comment.UserID,
 		"username":   comment.Username,
 		"item_id":    comment.ItemID,
 		"comment":    comment.Comment,
@@ -67,20 +78,21 @@ func (comment *Comment) Create() error {
 	_, err := pipeline.Exec()
 
 	if err != nil {
-		log.Println(err)
+		logging.From(ctx).Error("comments.Create", "err", err)
 		return err
 	}
 	return nil
 
 }
 
-//Create a reply to a comment by a user
-func (reply *Reply) Create() error {
+//CreateReply creates a reply to a comment by a user
+func (s *Store) CreateReply(ctx context.Context, reply *Reply) error {
 	date := time.Now()
 	reply.ID = uuid.Must(uuid.NewV4()).String()
 	reply.CreatedAt = date.String()
 
 	fields := map[string]interface{}{
+		"user_id":    reply.UserID,
 		"username":   reply.Username,
 		"comment":    reply.Comment,
 		"created_at": reply.CreatedAt,
@@ -88,13 +100,13 @@ func (reply *Reply) Create() error {
 
 	z := redis.Z{Score: float64(date.Unix()), Member: reply.ID}
 
-	pipeline := client.Pipeline()
+	pipeline := s.client.Pipeline()
 	pipeline.ZAdd("replies:"+reply.CommentID, z)
 	pipeline.HMSet(reply.ID, fields)
 	_, err := pipeline.Exec()
 
 	if err != nil {
-		log.Println(err)
+		logging.From(ctx).Error("comments.CreateReply", "err", err)
 		return err
 	}
 
@@ -102,72 +114,104 @@ func (reply *Reply) Create() error {
 
 }
 
-//Get a reply
-func (reply *Reply) Get() error {
-	resp, err := client.HGetAll(reply.ID).Result()
+//lookup reads the hash at id, which is a comment when it has an item and a reply when it doesn't. Ids are
+//only trusted as far as that, as any other key could be asked for.
+func (s *Store) lookup(id string, comment bool) (map[string]string, error) {
+	resp, err := s.client.HGetAll(id).Result()
+
+	if err != nil && strings.HasPrefix(err.Error(), "WRONGTYPE") {
+		return nil, ErrNotFound
+	}
 
 	if err != nil {
-		log.Println(err)
+		return nil, err
+	}
+
+	if resp["created_at"] == "" || (resp["item_id"] != "") != comment {
+		return nil, ErrNotFound
+	}
+
+	return resp, nil
+}
+
+//GetReply gets a reply, ErrNotFound when there is none with its id
+func (s *Store) GetReply(ctx context.Context, reply *Reply) error {
+	resp, err := s.lookup(reply.ID, false)
+
+	if err == ErrNotFound {
+		return err
+	}
+
+	if err != nil {
+		logging.From(ctx).Error("comments.GetReply", "err", err)
 		return err
 	}
 
 	reply.Comment = resp["comment"]
 	reply.CreatedAt, _ = resp["created_at"]
+	reply.UserID = resp["user_id"]
 	reply.Username = resp["username"]
 
 	return nil
 }
 
-//Get a comment from database
-func (comment *Comment) Get() error {
-	resp, err := client.HGetAll(comment.ID).Result()
+//Get a comment from database, ErrNotFound when there is none with its id
+func (s *Store) Get(ctx context.Context, comment *Comment) error {
+	resp, err := s.lookup(comment.ID, true)
+
+	if err == ErrNotFound {
+		return err
+	}
+
 	if err != nil {
-		log.Println(err)
+		logging.From(ctx).Error("comments.Get", "err", err)
 		return err
 	}
 
-	replyCount, err := client.ZCount("replies:"+comment.ID, "-inf", "+inf").Result()
+	replyCount, err := s.client.ZCount("replies:"+comment.ID, "-inf", "+inf").Result()
 
 	if err != nil {
-		log.Println(err)
+		logging.From(ctx).Error("comments.Get", "err", err)
 		return err
 	}
 
 	var reply = []Reply{}
 
+	comment.UserID = resp["user_id"]
 	comment.Username = resp["username"]
 	comment.Comment = resp["comment"]
 	comment.ItemID = resp["item_id"]
 	comment.ReplyCount = replyCount
 	comment.Replies = reply
 	comment.CreatedAt = resp["created_at"]
-	comment.UpdatedAt = resp["updates_at"]
+	comment.UpdatedAt = resp["updated_at"]
 
 	return nil
 
 }
 
 //GetReplies to a comment
-func (comment *Comment) GetReplies() error {
+func (s *Store) GetReplies(ctx context.Context, comment *Comment) error {
 
 	opt := redis.ZRangeBy{Min: "-inf", Max: "+inf"}
 
-	resp, err := client.ZRangeByScoreWithScores("replies:"+comment.ID, opt).Result()
+	resp, err := s.client.ZRangeByScoreWithScores("replies:"+comment.ID, opt).Result()
 
 	if err != nil {
-		log.Println(err)
+		logging.From(ctx).Error("comments.GetReplies", "err", err)
 		return err
 	}
 
 	for _, v := range resp {
 		var reply Reply
 		id := v.Member.(string)
-		res, err := client.HGetAll(id).Result()
+		res, err := s.client.HGetAll(id).Result()
 		if err != nil {
-			log.Println(err)
+			logging.From(ctx).Error("comments.GetReplies", "err", err)
 			continue
 		}
 
+		reply.UserID = res["user_id"]
 		reply.Username = res["username"]
 		reply.Comment = res["comment"]
 		reply.ID = id
@@ -181,23 +225,23 @@ func (comment *Comment) GetReplies() error {
 }
 
 //Delete a comment from the database
-func (comment *Comment) Delete() error {
-	pipeline := client.Pipeline()
+func (s *Store) Delete(ctx context.Context, comment *Comment) error {
+	pipeline := s.client.Pipeline()
 
 	pipeline.ZRem(comment.ItemID, comment.ID)
 	pipeline.Del(comment.ID)
 	_, err := pipeline.Exec()
 
 	if err != nil {
-		log.Println(err)
+		logging.From(ctx).Error("comments.Delete", "err", err)
 		return err
 	}
 
 	opt := redis.ZRangeBy{Min: "-inf", Max: "+inf"}
-	resp, err := client.ZRangeByScoreWithScores("replies:"+comment.ID, opt).Result()
+	resp, err := s.client.ZRangeByScoreWithScores("replies:"+comment.ID, opt).Result()
 
 	if err != nil {
-		log.Println(err)
+		logging.From(ctx).Error("comments.Delete", "err", err)
 		return err
 	}
 
@@ -209,22 +253,22 @@ func (comment *Comment) Delete() error {
 	_, err = pipeline.Exec()
 
 	if err != nil {
-		log.Println(err)
+		logging.From(ctx).Error("comments.Delete", "err", err)
 		return err
 	}
 
 	return nil
 }
 
-//Delete a reply
-func (reply *Reply) Delete() error {
-	pipeline := client.Pipeline()
+//DeleteReply deletes a reply
+func (s *Store) DeleteReply(ctx context.Context, reply *Reply) error {
+	pipeline := s.client.Pipeline()
 	pipeline.ZRem("replies:"+reply.CommentID, reply.ID)
 	pipeline.Del(reply.ID)
 	_, err := pipeline.Exec()
 
 	if err != nil {
-		log.Println(err)
+		logging.From(ctx).Error("comments.DeleteReply", "err", err)
 		return err
 	}
 
@@ -232,23 +276,23 @@ func (reply *Reply) Delete() error {
 }
 
 //Update a comment
-func (comment *Comment) Update() error {
+func (s *Store) Update(ctx context.Context, comment *Comment) error {
 	updatedAt := time.Now().String()
 	fields := map[string]interface{}{
 		"comment":    comment.Comment,
 		"updated_at": updatedAt,
 	}
-	_, err := client.HMSet(comment.ID, fields).Result()
+	_, err := s.client.HMSet(comment.ID, fields).Result()
 
 	if err != nil {
-		log.Println(err)
+		logging.From(ctx).Error("comments.Update", "err", err)
 		return err
 	}
 	return nil
 }
 
-//Update a reply to a comment
-func (reply *Reply) Update() error {
+//UpdateReply updates a reply to a comment
+func (s *Store) UpdateReply(ctx context.Context, reply *Reply) error {
 	updatedAt := time.Now().String()
 
 	fields := map[string]interface{}{
@@ -256,43 +300,93 @@ func (reply *Reply) Update() error {
 		"updated_at": updatedAt,
 	}
 
-	_, err := client.HMSet(reply.ID, fields).Result()
+	_, err := s.client.HMSet(reply.ID, fields).Result()
 
 	if err != nil {
-		log.Println(err)
+		logging.From(ctx).Error("comments.UpdateReply", "err", err)
 		return err
 	}
 
 	return nil
 }
 
-//GetItemComments gets all comments associated with an item
-func (comment *Comment) GetItemComments() ([]Comment, error) {
-	opts := redis.ZRangeBy{
-		Max: "+inf",
-		Min: "-inf",
+//members gets the page q asks for of the zset at key, whose members are scored with the unix time they were
+//created at. Members created in the same second are ordered by id, the same as redis orders them.
+func (s *Store) members(key string, q page.Query) (page.Page[redis.Z], error) {
+	rangeBy := s.client.ZRangeByScoreWithScores
+
+	if q.Sort == page.Newest {
+		rangeBy = s.client.ZRevRangeByScoreWithScores
+	}
+
+	min, max := "-inf", "+inf"
+
+	if !q.CreatedAfter.IsZero() {
+		min = "(" + strconv.FormatInt(q.CreatedAfter.Unix(), 10)
+	}
+
+	var members []redis.Z
+
+	// the members scored the same as the cursor that come after it, then the ones scored past it
+	if q.After != nil {
+		score := strconv.FormatInt(q.After.CreatedAt.Unix(), 10)
+		ties, err := rangeBy(key, redis.ZRangeBy{Min: score, Max: score}).Result()
+
+		if err != nil {
+			return page.Page[redis.Z]{}, err
+		}
+
+		for _, member := range ties {
+			if id := member.Member.(string); id != q.After.ID && (id > q.After.ID) == (q.Sort == page.Oldest) {
+				members = append(members, member)
+			}
+		}
+
+		if q.Sort == page.Oldest {
+			min = "(" + score
+		} else {
+			max = "(" + score
+		}
 	}
-	resp, err := client.ZRangeByScoreWithScores(comment.ItemID, opts).Result()
+
+	if len(members) <= q.Limit {
+		rest, err := rangeBy(key, redis.ZRangeBy{Min: min, Max: max, Count: int64(q.Limit + 1 - len(members))}).Result()
+
+		if err != nil {
+			return page.Page[redis.Z]{}, err
+		}
+
+		members = append(members, rest...)
+	}
+
+	return page.New(members, q, func(member redis.Z) page.Cursor {
+		return page.Cursor{CreatedAt: time.Unix(int64(member.Score), 0), ID: member.Member.(string)}
+	}), nil
+}
+
+//GetItemComments gets the page q asks for of the comments on an item
+func (s *Store) GetItemComments(ctx context.Context, itemID string, q page.Query) (page.Page[Comment], error) {
+	members, err := s.members(itemID, q)
 
 	if err != nil {
-		log.Panicln(err)
-		return nil, err
+		logging.From(ctx).Error("comments.GetItemComments", "err", err)
+		return page.Page[Comment]{}, err
 	}
 
-	var comments []Comment
+	comments := page.Page[Comment]{Items: []Comment{}, NextCursor: members.NextCursor, HasMore: members.HasMore}
 
-	for _, v := range resp {
+	for _, member := range members.Items {
 		var comment Comment
-		id := v.Member.(string)
+		id := member.Member.(string)
 		comment.ID = id
-		err := comment.Get()
+		err := s.Get(ctx, &comment)
 
 		if err != nil {
-			log.Println(err)
+			logging.From(ctx).Error("comments.GetItemComments", "err", err)
 			continue
 		}
 
-		comments = append(comments, comment)
+		comments.Items = append(comments.Items, comment)
 	}
 
 	return comments, nil
@@ -0,0 +1,115 @@
+package items
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/Samuyi/www/logging"
+	"github.com/lib/pq"
+)
+
+//ErrTooManyImages is returned when adding an image to an item that already has as many as it can
+var ErrTooManyImages = errors.New("Sorry that item has as many photos as it can have, please delete one first")
+
+//Image is a photo of an item. Key and ThumbnailKey are where the photo and its thumbnail are kept in the blob store,
+//URL and ThumbnailURL where clients can fetch them from.
+type Image struct {
+	ID           string    `json:"id"`
+	ItemID       string    `json:"-"`
+	Key          string    `json:"-"`
+	ThumbnailKey string    `json:"-"`
+	URL          string    `json:"url"`
+	ThumbnailURL string    `json:"thumbnail_url"`
+	Width        int       `json:"width"`
+	Height       int       `json:"height"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+//AddImage adds an image to an item with fewer than max images
+func (s *Store) AddImage(ctx context.Context, image *Image, max int) error {
+	query := "INSERT INTO item_images (item_id, key, thumbnail_key, width, height) SELECT $1::uuid, $2, $3, $4::integer, $5::integer WHERE (SELECT count(*) FROM item_images WHERE item_id = $1::uuid) < $6::integer returning id, created_at"
+
+	stmt, err := s.db.PrepareContext(ctx, query)
+
+	if err != nil {
+		logging.From(ctx).Error("items.AddImage", "err", err)
+		return err
+	}
+	defer stmt.Close()
+
+	err = stmt.QueryRowContext(ctx, image.ItemID, image.Key, image.ThumbnailKey, image.Width, image.Height, max).Scan(&image.ID, &image.CreatedAt)
+
+	if err == sql.ErrNoRows {
+		return ErrTooManyImages
+	}
+
+	if err != nil {
+		logging.From(ctx).Error("items.AddImage", "err", err)
+		return err
+	}
+
+	return nil
+}
+
+//RemoveImage removes an image from its item, filling in the keys of the photo and thumbnail it leaves behind
+func (s *Store) RemoveImage(ctx context.Context, image *Image) error {
+	query := "DELETE FROM item_images WHERE id = $1 AND item_id = $2 returning key, thumbnail_key"
+
+	stmt, err := s.db.PrepareContext(ctx, query)
+
+	if err != nil {
+		logging.From(ctx).Error("items.RemoveImage", "err", err)
+		return err
+	}
+	defer stmt.Close()
+
+	err = stmt.QueryRowContext(ctx, image.ID, image.ItemID).Scan(&image.Key, &image.ThumbnailKey)
+
+	if err != nil && err != sql.ErrNoRows {
+		logging.From(ctx).Error("items.RemoveImage", "err", err)
+	}
+
+	return err
+}
+
+//Images gets the images of each item with one of ids, oldest first, by item id
+func (s *Store) Images(ctx context.Context, ids []string) (map[string][]Image, error) {
+	query := "SELECT id, item_id, key, thumbnail_key, width, height, created_at FROM item_images WHERE item_id = ANY($1) ORDER BY created_at, id"
+
+	stmt, err := s.db.PrepareContext(ctx, query)
+
+	if err != nil {
+		logging.From(ctx).Error("items.Images", "err", err)
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.QueryContext(ctx, pq.Array(ids))
+
+	if err != nil {
+		logging.From(ctx).Error("items.Images", "err", err)
+		return nil, err
+	}
+
+	images := make(map[string][]Image)
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var image Image
+		if err := rows.Scan(&image.ID, &image.ItemID, &image.Key, &image.ThumbnailKey, &image.Width, &image.Height, &image.CreatedAt); err != nil {
+			logging.From(ctx).Error("items.Images", "err", err)
+			return nil, err
+		}
+		images[image.ItemID] = append(images[image.ItemID], image)
+	}
+
+	if err = rows.Err(); err != nil {
+		logging.From(ctx).Error("items.Images", "err", err)
+		return nil, err
+	}
+
+	return images, nil
+}
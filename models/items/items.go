@@ -1,43 +1,49 @@
 package items
 
 import (
-	"database/sql"
-	"fmt"
-	"log"
+	"errors"
+	"strings"
 	"time"
+	"unicode"
 
 	"github.com/Samuyi/www/models/comments"
 	"github.com/Samuyi/www/models/locations"
+	"github.com/Samuyi/www/models/page"
 	validator "github.com/asaskevich/govalidator"
-	_ "github.com/lib/pq" // postgres driver
 )
 
-var db *sql.DB
+//ErrUnknownCity is returned when an item is placed in a city that isn't a known location
+var ErrUnknownCity = errors.New("Please supply a valid city")
 
-const (
-	host     = "localhost"
-	port     = 5432
-	user     = "help"
-	password = "help"
-	dbname   = "help.ng"
-)
+//ErrUnknownCategory is returned when an item is put in a category that doesn't exist
+var ErrUnknownCategory = errors.New("Please supply a valid category")
 
-func init() {
-	var err error
+//Condition an item is in
+type Condition string
 
-	psqlInfo := fmt.Sprintf("host=%s port=%d user=%s "+"password=%s dbname=%s sslmode=disable", host, port, user, password, dbname)
-	db, err = sql.Open("postgres", psqlInfo)
+const (
+	//ConditionNew is unused, possibly still in its packaging
+	ConditionNew Condition = "new"
+	//ConditionGood is used but works and looks well
+	ConditionGood Condition = "good"
+	//ConditionFair is worn or marked but works
+	ConditionFair Condition = "fair"
+	//ConditionForParts doesn't work and is only good for spares or repair
+	ConditionForParts Condition = "for-parts"
+)
 
-	if err != nil {
-		log.Println(err)
-	}
-	err = db.Ping()
+//Conditions are every condition, from the best to the worst
+var Conditions = []Condition{ConditionNew, ConditionGood, ConditionFair, ConditionForParts}
 
-	if err != nil {
-		log.Println(err)
+//ValidCondition reports whether condition is one of Conditions
+func ValidCondition(condition Condition) bool {
+	for _, valid := range Conditions {
+		if condition == valid {
+			return true
+		}
 	}
 
-	log.Println("connected to database")
+	return false
 }
 
 //Item data structure
@@ -49,191 +55,78 @@ type Item struct {
 	UserEmail   string             `json:"user_email"`
 	PhoneNo     string             `json:"phone_no"`
 	Location    locations.Location `json:"location"`
-	Closed      bool               `json:"closed"`
+	Status      Status             `json:"status"`
+	RecipientID string             `json:"recipient_id,omitempty"`
 	Instruction string             `json:"instruction"`
+	CategoryID  string             `json:"category_id,omitempty"`
+	Condition   Condition          `json:"condition,omitempty"`
+	Images      []Image            `json:"images,omitempty"`
 	Comments    []comments.Comment `json:"comments,omitempty"`
+	Rank        float64            `json:"rank,omitempty"`
+	Snippet     string             `json:"snippet,omitempty"`
 	CreatedAt   time.Time          `json:"created_at"`
 	UpdatedAt   time.Time          `json:"updated_at,omitempty"`
+
+	//StatusChangedAt is when the item last changed status, or was created
+	StatusChangedAt time.Time `json:"status_changed_at"`
+}
+
+//Filter narrows the items listed to those with Status and when set, in City or the location with LocationID,
+//belonging to UserID, in Condition, or in the category with CategoryID or any category under it
+type Filter struct {
+	Status     Status
+	City       string
+	LocationID string
+	UserID     string
+	CategoryID string
+	Condition  Condition
+}
+
+//Key returns the position of item in a page of items
+func Key(item Item) page.Cursor {
+	return page.Cursor{CreatedAt: item.CreatedAt, ID: item.ID, Rank: item.Rank}
+}
+
+//Terms splits a search into the lower cased words it matches items by. Searches match items with every term, and
+//the last term is matched as a prefix so results can be shown while it is typed.
+func Terms(search string) []string {
+	return strings.FieldsFunc(strings.ToLower(search), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
 }
 
-//Validate item struct
+//Validate item struct, saying what is wrong with each invalid field under its json name
 func (item *Item) Validate() map[string]string {
 	var errors = make(map[string]string)
 
 	if len(item.Name) <= 2 {
 		message := "item name must be at least three words"
-		errors["Invalid item Name"] = message
+		errors["name"] = message
 	}
 
 	if !validator.IsUUID(item.UserID) {
 		message := "Please supply a valid user id"
-		errors["Invalid UserID"] = message
+		errors["user_id"] = message
 	}
 
 	if len(item.PhoneNo) <= 5 {
 		message := "Please supply a valid phone number"
-		errors["Invalid phone number"] = message
-	}
-
-	if len(errors) > 0 {
-		return errors
-	}
-
-	return nil
-}
-
-//Create an item in the databsae
-func (item *Item) Create() error {
-	query := "INSERT INTO items (user_id, name, phone_no, instruction, city ) VALUES ($1, $2, $3, $4, $5) returning id"
-
-	stmt, err := db.Prepare(query)
-	defer stmt.Close()
-
-	if err != nil {
-		log.Println(err)
-		return err
-	}
-
-	err = stmt.QueryRow(item.UserID, item.Name, item.PhoneNo, item.Instruction, item.Location.City).Scan(&item.ID)
-
-	if err != nil {
-		log.Println(err)
-		return err
-	}
-	return nil
-}
-
-//Get an item from the database
-func (item *Item) Get() error {
-	query := "SELECT name, display_name, email, items.user_id, items.city, instruction, phone_no, items.created_at as created_at, state, country FROM items INNER JOIN users ON items.user_id = users.id INNER JOIN locations ON locations.city = items.city where items.id = $1"
-
-	stmt, err := db.Prepare(query)
-	defer stmt.Close()
-
-	if err != nil {
-		log.Println(err)
-		return err
-	}
-
-	err = stmt.QueryRow(item.ID).Scan(&item.Name, &item.DisplayName, &item.UserEmail, &item.UserID, &item.Location.City, &item.Instruction, &item.PhoneNo, &item.CreatedAt, &item.Location.State, &item.Location.Country)
-
-	if err != nil {
-		log.Println(err)
-		return err
-	}
-
-	return nil
-}
-
-//Update an item in the database
-func (item *Item) Update() error {
-	query := "UPDATE items SET name = $1, phone_no = $2, closed = $3, instruction = $4, updated_at=$5 where id = $6"
-
-	stmt, err := db.Prepare(query)
-	defer stmt.Close()
-
-	if err != nil {
-		log.Println(err)
-		return err
+		errors["phone_no"] = message
 	}
 
-	item.UpdatedAt = time.Now()
-	_, err = stmt.Exec(item.Name, item.PhoneNo, item.Closed, item.Instruction, item.UpdatedAt, item.ID)
-
-	if err != nil {
-		log.Println(err)
-		return err
+	if item.CategoryID != "" && !validator.IsUUID(item.CategoryID) {
+		message := "Please supply a valid category"
+		errors["category_id"] = message
 	}
 
-	return nil
-}
-
-//Delete itemm from the database
-func (item *Item) Delete() error {
-	query := "DELETE FROM items where id = $id"
-
-	stmt, err := db.Prepare(query)
-	defer stmt.Close()
-
-	if err != nil {
-		log.Println(err)
-		return err
+	if item.Condition != "" && !ValidCondition(item.Condition) {
+		message := "Please supply a condition of new, good, fair or for-parts"
+		errors["condition"] = message
 	}
 
-	_, err = stmt.Exec(item.ID)
-
-	if err != nil {
-		log.Println(err)
-		return err
+	if len(errors) > 0 {
+		return errors
 	}
 
 	return nil
 }
-
-//ItemsInALocation gets items in a particular location
-func (item *Item) ItemsInALocation() ([]Item, error) {
-	query := "SELECT id, name, user_id, user.display_name, email, phone_no, instruction FROM items INNER JOIN users ON items.user_id = users.id WHERE location_id = $1 and closed = false ORDER BY created_at DESC"
-
-	stmt, err := db.Prepare(query)
-	defer stmt.Close()
-
-	if err != nil {
-		log.Println(err)
-		return nil, err
-	}
-
-	rows, err := stmt.Query(item.Location.LocationID)
-
-	var itemArray []Item
-
-	defer rows.Close()
-	for rows.Next() {
-		var item Item
-		if err := rows.Scan(&item.ID, &item.Name, &item.UserID, &item.DisplayName, &item.UserEmail, &item.PhoneNo, &item.Instruction, &item.CreatedAt); err != nil {
-			log.Println(err)
-			return nil, err
-		}
-		itemArray = append(itemArray, item)
-	}
-
-	if err = rows.Err(); err != nil {
-		log.Println(err)
-		return nil, err
-	}
-
-	return itemArray, nil
-}
-
-//GetAllItems gets all items still open currently
-func (item *Item) GetAllItems() ([]Item, error) {
-	query := "SELECT id, name, user_id, user.display_name, email, phone_no, instruction, city, created_at FROM items INNER JOIN users ON items.user_id = user.id WHERE closed = false ORDER BY created_at DESC"
-
-	stmt, err := db.Prepare(query)
-	defer stmt.Close()
-
-	if err != nil {
-		log.Println(err)
-		return nil, err
-	}
-
-	rows, err := stmt.Query()
-	var itemArray []Item
-
-	defer rows.Close()
-
-	for rows.Next() {
-		var item Item
-		if err := rows.Scan(&item.ID, &item.Name, &item.UserID, &item.PhoneNo, &item.Instruction, &item.Location.City, &item.CreatedAt); err != nil {
-			log.Println(err)
-			return nil, err
-		}
-		itemArray = append(itemArray, item)
-	}
-
-	if err = rows.Err(); err != nil {
-		log.Println(err)
-		return nil, err
-	}
-
-	return itemArray, nil
-}
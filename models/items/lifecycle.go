@@ -0,0 +1,207 @@
+package items
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/Samuyi/www/logging"
+)
+
+//ErrStatusChanged is returned when an item is moved on from a status it has already left
+var ErrStatusChanged = errors.New("Sorry that item changed in the meantime, please try again")
+
+//Status is where an item is in its lifecycle
+type Status string
+
+const (
+	//StatusDraft isn't listed yet, only its owner sees it
+	StatusDraft Status = "draft"
+	//StatusOpen is listed and takes bids
+	StatusOpen Status = "open"
+	//StatusReserved is set aside for the recipient the owner chose
+	StatusReserved Status = "reserved"
+	//StatusHandedOver has been given to its recipient
+	StatusHandedOver Status = "handed_over"
+	//StatusCancelled was taken back by its owner
+	StatusCancelled Status = "cancelled"
+	//StatusExpired stayed open too long without being reserved
+	StatusExpired Status = "expired"
+)
+
+//Statuses are every status, in the order items go through them
+var Statuses = []Status{StatusDraft, StatusOpen, StatusReserved, StatusHandedOver, StatusCancelled, StatusExpired}
+
+//next holds the statuses each status can move on to. Items that were handed over, cancelled or expired stay so.
+var next = map[Status][]Status{
+	StatusDraft:    {StatusOpen, StatusCancelled},
+	StatusOpen:     {StatusReserved, StatusCancelled, StatusExpired},
+	StatusReserved: {StatusHandedOver, StatusOpen, StatusCancelled},
+}
+
+//ValidStatus reports whether status is one of Statuses
+func ValidStatus(status Status) bool {
+	for _, valid := range Statuses {
+		if status == valid {
+			return true
+		}
+	}
+
+	return false
+}
+
+//CanMove reports whether an item can go from status to the status to
+func (status Status) CanMove(to Status) bool {
+	for _, allowed := range next[status] {
+		if to == allowed {
+			return true
+		}
+	}
+
+	return false
+}
+
+//Editable reports whether the owner can still change an item and its photos, which they can until it is reserved
+func (status Status) Editable() bool {
+	return status == StatusDraft || status == StatusOpen
+}
+
+//Transition is a move of an item from one status to another. UserID is who made it, empty when items expire.
+//RecipientID is who the item is reserved for or was handed over to.
+type Transition struct {
+	ID          string    `json:"id"`
+	ItemID      string    `json:"item_id"`
+	From        Status    `json:"from"`
+	To          Status    `json:"to"`
+	UserID      string    `json:"user_id,omitempty"`
+	RecipientID string    `json:"recipient_id,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+//Transition moves item from the status it has on to transition.To, for transition.RecipientID, and records the move.
+//It fails with ErrStatusChanged when the item has left that status since it was read.
+func (s *Store) Transition(ctx context.Context, item *Item, transition *Transition) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+
+	if err != nil {
+		logging.From(ctx).Error("items.Transition", "err", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	transition.ItemID, transition.From = item.ID, item.Status
+
+	query := "UPDATE items SET status = $1, recipient_id = NULLIF($2, '')::uuid, status_changed_at = NOW(), updated_at = NOW() WHERE id = $3 AND status = $4 returning status_changed_at"
+
+	err = tx.QueryRowContext(ctx, query, transition.To, transition.RecipientID, item.ID, transition.From).Scan(&transition.CreatedAt)
+
+	if err == sql.ErrNoRows {
+		return ErrStatusChanged
+	}
+
+	if err != nil {
+		logging.From(ctx).Error("items.Transition", "err", err)
+		return err
+	}
+
+	query = "INSERT INTO item_transitions (item_id, from_status, to_status, user_id, recipient_id, created_at) VALUES ($1, $2, $3, NULLIF($4, '')::uuid, NULLIF($5, '')::uuid, $6) returning id"
+
+	err = tx.QueryRowContext(ctx, query, item.ID, transition.From, transition.To, transition.UserID, transition.RecipientID, transition.CreatedAt).Scan(&transition.ID)
+
+	if err != nil {
+		logging.From(ctx).Error("items.Transition", "err", err)
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		logging.From(ctx).Error("items.Transition", "err", err)
+		return err
+	}
+
+	item.Status, item.RecipientID, item.StatusChangedAt = transition.To, transition.RecipientID, transition.CreatedAt
+
+	return nil
+}
+
+//History gets the transitions of an item, oldest first
+func (s *Store) History(ctx context.Context, itemID string) ([]Transition, error) {
+	query := "SELECT id, item_id, from_status, to_status, COALESCE(user_id::text, ''), COALESCE(recipient_id::text, ''), created_at FROM item_transitions WHERE item_id = $1 ORDER BY created_at, id"
+
+	stmt, err := s.db.PrepareContext(ctx, query)
+
+	if err != nil {
+		logging.From(ctx).Error("items.History", "err", err)
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.QueryContext(ctx, itemID)
+
+	if err != nil {
+		logging.From(ctx).Error("items.History", "err", err)
+		return nil, err
+	}
+
+	var history []Transition
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var transition Transition
+		if err := rows.Scan(&transition.ID, &transition.ItemID, &transition.From, &transition.To, &transition.UserID, &transition.RecipientID, &transition.CreatedAt); err != nil {
+			logging.From(ctx).Error("items.History", "err", err)
+			return nil, err
+		}
+		history = append(history, transition)
+	}
+
+	if err = rows.Err(); err != nil {
+		logging.From(ctx).Error("items.History", "err", err)
+		return nil, err
+	}
+
+	return history, nil
+}
+
+//Expire moves the items that have been open since before on to expired, recording each move, and returns them
+func (s *Store) Expire(ctx context.Context, before time.Time) ([]Item, error) {
+	query := "WITH expired AS (UPDATE items SET status = 'expired', status_changed_at = NOW(), updated_at = NOW() WHERE status = 'open' AND status_changed_at < $1 returning id, name, user_id, status_changed_at), " +
+		"recorded AS (INSERT INTO item_transitions (item_id, from_status, to_status, created_at) SELECT id, 'open', 'expired', status_changed_at FROM expired) " +
+		"SELECT id, name, user_id, status_changed_at FROM expired"
+
+	stmt, err := s.db.PrepareContext(ctx, query)
+
+	if err != nil {
+		logging.From(ctx).Error("items.Expire", "err", err)
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.QueryContext(ctx, before)
+
+	if err != nil {
+		logging.From(ctx).Error("items.Expire", "err", err)
+		return nil, err
+	}
+
+	var expired []Item
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var item = Item{Status: StatusExpired}
+		if err := rows.Scan(&item.ID, &item.Name, &item.UserID, &item.StatusChangedAt); err != nil {
+			logging.From(ctx).Error("items.Expire", "err", err)
+			return nil, err
+		}
+		expired = append(expired, item)
+	}
+
+	if err = rows.Err(); err != nil {
+		logging.From(ctx).Error("items.Expire", "err", err)
+		return nil, err
+	}
+
+	return expired, nil
+}
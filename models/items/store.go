@@ -0,0 +1,331 @@
+package items
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/Samuyi/www/logging"
+	"github.com/Samuyi/www/models/page"
+	"github.com/lib/pq"
+)
+
+//Store keeps items in postgres
+type Store struct {
+	db *sql.DB
+}
+
+//NewStore returns an item store backed by db
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+//Create an item in the databsae
+func (s *Store) Create(ctx context.Context, item *Item) error {
+	query := "INSERT INTO items (user_id, name, phone_no, instruction, city, location_id, category_id, condition, status) VALUES ($1, $2, $3, $4, $5, (SELECT location_id FROM locations WHERE city = $5), NULLIF($6, '')::uuid, NULLIF($7, ''), $8) returning id, status_changed_at"
+
+	stmt, err := s.db.PrepareContext(ctx, query)
+
+	if err != nil {
+		logging.From(ctx).Error("items.Create", "err", err)
+		return err
+	}
+	defer stmt.Close()
+
+	err = stmt.QueryRowContext(ctx, item.UserID, item.Name, item.PhoneNo, item.Instruction, item.Location.City, item.CategoryID, item.Condition, item.Status).Scan(&item.ID, &item.StatusChangedAt)
+
+	if pqErr, ok := err.(*pq.Error); ok {
+		switch pqErr.Constraint {
+		case "items_city_fkey":
+			return ErrUnknownCity
+		case "items_category_id_fkey":
+			return ErrUnknownCategory
+		}
+	}
+
+	if err != nil {
+		logging.From(ctx).Error("items.Create", "err", err)
+		return err
+	}
+	return nil
+}
+
+//Get an item from the database
+func (s *Store) Get(ctx context.Context, item *Item) error {
+	query := "SELECT name, display_name, email, items.user_id, items.city, COALESCE(instruction, ''), COALESCE(category_id::text, ''), COALESCE(condition, ''), phone_no, status, COALESCE(recipient_id::text, ''), status_changed_at, items.created_at as created_at, state, country FROM items INNER JOIN users ON items.user_id = users.id INNER JOIN locations ON locations.city = items.city where items.id = $1"
+
+	stmt, err := s.db.PrepareContext(ctx, query)
+
+	if err != nil {
+		logging.From(ctx).Error("items.Get", "err", err)
+		return err
+	}
+	defer stmt.Close()
+
+	err = stmt.QueryRowContext(ctx, item.ID).Scan(&item.Name, &item.DisplayName, &item.UserEmail, &item.UserID, &item.Location.City, &item.Instruction, &item.CategoryID, &item.Condition, &item.PhoneNo, &item.Status, &item.RecipientID, &item.StatusChangedAt, &item.CreatedAt, &item.Location.State, &item.Location.Country)
+
+	if err != nil {
+		logging.From(ctx).Error("items.Get", "err", err)
+		return err
+	}
+
+	return nil
+}
+
+//Update an item in the database
+func (s *Store) Update(ctx context.Context, item *Item) error {
+	query := "UPDATE items SET name = $1, phone_no = $2, instruction = $3, category_id = NULLIF($4, '')::uuid, condition = NULLIF($5, ''), updated_at=$6 where id = $7"
+
+	stmt, err := s.db.PrepareContext(ctx, query)
+
+	if err != nil {
+		logging.From(ctx).Error("items.Update", "err", err)
+		return err
+	}
+	defer stmt.Close()
+
+	item.UpdatedAt = time.Now()
+	_, err = stmt.ExecContext(ctx, item.Name, item.PhoneNo, item.Instruction, item.CategoryID, item.Condition, item.UpdatedAt, item.ID)
+
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Constraint == "items_category_id_fkey" {
+		return ErrUnknownCategory
+	}
+
+	if err != nil {
+		logging.From(ctx).Error("items.Update", "err", err)
+		return err
+	}
+
+	return nil
+}
+
+//Delete itemm from the database
+func (s *Store) Delete(ctx context.Context, item *Item) error {
+	query := "DELETE FROM items where id = $1"
+
+	stmt, err := s.db.PrepareContext(ctx, query)
+
+	if err != nil {
+		logging.From(ctx).Error("items.Delete", "err", err)
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.ExecContext(ctx, item.ID)
+
+	if err != nil {
+		logging.From(ctx).Error("items.Delete", "err", err)
+		return err
+	}
+
+	return nil
+}
+
+//where adds the conditions that match the items of filter to where
+func (filter Filter) where(where *page.Where) {
+	where.Add("items.status = ?", filter.Status)
+
+	if filter.City != "" {
+		where.Add("items.city = ?", filter.City)
+	}
+
+	if filter.LocationID != "" {
+		where.Add("items.location_id = ?", filter.LocationID)
+	}
+
+	if filter.UserID != "" {
+		where.Add("items.user_id = ?", filter.UserID)
+	}
+
+	if filter.CategoryID != "" {
+		where.Add("items.category_id IN (WITH RECURSIVE tree AS (SELECT id FROM categories WHERE id = ? UNION ALL SELECT categories.id FROM categories INNER JOIN tree ON categories.parent_id = tree.id) SELECT id FROM tree)", filter.CategoryID)
+	}
+
+	if filter.Condition != "" {
+		where.Add("items.condition = ?", filter.Condition)
+	}
+}
+
+//ItemsInALocation gets the page q asks for of the items in a particular location that match filter
+func (s *Store) ItemsInALocation(ctx context.Context, locationID string, filter Filter, q page.Query) (page.Page[Item], error) {
+	var where page.Where
+
+	filter.LocationID = locationID
+	filter.where(&where)
+	tail := q.Keyset(&where, "items.created_at", "items.id")
+
+	query := "SELECT items.id, name, user_id, users.display_name, email, phone_no, COALESCE(instruction, ''), COALESCE(category_id::text, ''), COALESCE(condition, ''), status, COALESCE(recipient_id::text, ''), status_changed_at, items.created_at FROM items INNER JOIN users ON items.user_id = users.id" + where.String() + tail
+
+	stmt, err := s.db.PrepareContext(ctx, query)
+
+	if err != nil {
+		logging.From(ctx).Error("items.ItemsInALocation", "err", err)
+		return page.Page[Item]{}, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.QueryContext(ctx, where.Args...)
+
+	if err != nil {
+		logging.From(ctx).Error("items.ItemsInALocation", "err", err)
+		return page.Page[Item]{}, err
+	}
+
+	var itemArray []Item
+
+	defer rows.Close()
+	for rows.Next() {
+		var item Item
+		if err := rows.Scan(&item.ID, &item.Name, &item.UserID, &item.DisplayName, &item.UserEmail, &item.PhoneNo, &item.Instruction, &item.CategoryID, &item.Condition, &item.Status, &item.RecipientID, &item.StatusChangedAt, &item.CreatedAt); err != nil {
+			logging.From(ctx).Error("items.ItemsInALocation", "err", err)
+			return page.Page[Item]{}, err
+		}
+		itemArray = append(itemArray, item)
+	}
+
+	if err = rows.Err(); err != nil {
+		logging.From(ctx).Error("items.ItemsInALocation", "err", err)
+		return page.Page[Item]{}, err
+	}
+
+	return page.New(itemArray, q, Key), nil
+}
+
+//GetAllItems gets the page q asks for of the items that match filter
+func (s *Store) GetAllItems(ctx context.Context, filter Filter, q page.Query) (page.Page[Item], error) {
+	var where page.Where
+
+	filter.where(&where)
+	tail := q.Keyset(&where, "items.created_at", "items.id")
+
+	query := "SELECT items.id, name, user_id, users.display_name, phone_no, COALESCE(instruction, ''), city, COALESCE(category_id::text, ''), COALESCE(condition, ''), status, COALESCE(recipient_id::text, ''), status_changed_at, items.created_at FROM items INNER JOIN users ON items.user_id = users.id" + where.String() + tail
+
+	stmt, err := s.db.PrepareContext(ctx, query)
+
+	if err != nil {
+		logging.From(ctx).Error("items.GetAllItems", "err", err)
+		return page.Page[Item]{}, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.QueryContext(ctx, where.Args...)
+
+	if err != nil {
+		logging.From(ctx).Error("items.GetAllItems", "err", err)
+		return page.Page[Item]{}, err
+	}
+
+	var itemArray []Item
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var item Item
+		if err := rows.Scan(&item.ID, &item.Name, &item.UserID, &item.DisplayName, &item.PhoneNo, &item.Instruction, &item.Location.City, &item.CategoryID, &item.Condition, &item.Status, &item.RecipientID, &item.StatusChangedAt, &item.CreatedAt); err != nil {
+			logging.From(ctx).Error("items.GetAllItems", "err", err)
+			return page.Page[Item]{}, err
+		}
+		itemArray = append(itemArray, item)
+	}
+
+	if err = rows.Err(); err != nil {
+		logging.From(ctx).Error("items.GetAllItems", "err", err)
+		return page.Page[Item]{}, err
+	}
+
+	return page.New(itemArray, q, Key), nil
+}
+
+//GetUserItems gets all items belonging to a particular user
+func (s *Store) GetUserItems(ctx context.Context, userID string) ([]Item, error) {
+	query := "SELECT id, name, COALESCE(location_id::text, ''), COALESCE(instruction, ''), status, COALESCE(recipient_id::text, ''), status_changed_at, created_at FROM items where user_id = $1 and active = true ORDER BY created_at DESC"
+
+	stmt, err := s.db.PrepareContext(ctx, query)
+
+	if err != nil {
+		logging.From(ctx).Error("items.GetUserItems", "err", err)
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.QueryContext(ctx, userID)
+
+	if err != nil {
+		logging.From(ctx).Error("items.GetUserItems", "err", err)
+		return nil, err
+	}
+
+	var itemArray []Item
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var item Item
+		if err := rows.Scan(&item.ID, &item.Name, &item.Location.LocationID, &item.Instruction, &item.Status, &item.RecipientID, &item.StatusChangedAt, &item.CreatedAt); err != nil {
+			logging.From(ctx).Error("items.GetUserItems", "err", err)
+			return nil, err
+		}
+		itemArray = append(itemArray, item)
+	}
+
+	if err = rows.Err(); err != nil {
+		logging.From(ctx).Error("items.GetUserItems", "err", err)
+		return nil, err
+	}
+
+	return itemArray, nil
+}
+
+//Search gets the page q asks for of the items that match filter and every term of search, see Terms. Matches in the
+//name rank above ones in the instruction, and each item comes with a snippet of its text with the matches between
+//<mark> tags. The rest of the snippet is html escaped.
+func (s *Store) Search(ctx context.Context, search string, filter Filter, q page.Query) (page.Page[Item], error) {
+	// the query searched for is the first argument
+	where := page.Where{Args: []interface{}{strings.Join(Terms(search), " & ") + ":*"}}
+	where.Add("items.search @@ query")
+	filter.where(&where)
+
+	// the ranked items are found first, so only the page sent back is highlighted
+	found := "SELECT items.id, name, items.user_id, users.display_name, phone_no, COALESCE(instruction, '') AS instruction, items.city, COALESCE(category_id::text, '') AS category_id, COALESCE(condition, '') AS condition, status, COALESCE(recipient_id::text, '') AS recipient_id, status_changed_at, items.created_at, ROUND(ts_rank(items.search, query)::numeric, 6) AS rank, query FROM items INNER JOIN users ON items.user_id = users.id, to_tsquery('english', $1) AS query" + where.String()
+
+	outer := page.Where{Args: where.Args}
+	tail := q.Ranked(&outer, "rank", "created_at", "id")
+
+	query := "SELECT id, name, user_id, display_name, phone_no, instruction, city, category_id, condition, status, recipient_id, status_changed_at, created_at, rank, ts_headline('english', replace(replace(replace(name || ' ' || instruction, '&', '&amp;'), '<', '&lt;'), '>', '&gt;'), query, 'StartSel=<mark>, StopSel=</mark>, MaxWords=30, MinWords=10') FROM (" + found + ") AS found" + outer.String() + tail
+
+	stmt, err := s.db.PrepareContext(ctx, query)
+
+	if err != nil {
+		logging.From(ctx).Error("items.Search", "err", err)
+		return page.Page[Item]{}, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.QueryContext(ctx, outer.Args...)
+
+	if err != nil {
+		logging.From(ctx).Error("items.Search", "err", err)
+		return page.Page[Item]{}, err
+	}
+
+	var itemArray []Item
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var item Item
+		if err := rows.Scan(&item.ID, &item.Name, &item.UserID, &item.DisplayName, &item.PhoneNo, &item.Instruction, &item.Location.City, &item.CategoryID, &item.Condition, &item.Status, &item.RecipientID, &item.StatusChangedAt, &item.CreatedAt, &item.Rank, &item.Snippet); err != nil {
+			logging.From(ctx).Error("items.Search", "err", err)
+			return page.Page[Item]{}, err
+		}
+		itemArray = append(itemArray, item)
+	}
+
+	if err = rows.Err(); err != nil {
+		logging.From(ctx).Error("items.Search", "err", err)
+		return page.Page[Item]{}, err
+	}
+
+	return page.New(itemArray, q, Key), nil
+}
@@ -1,17 +1,17 @@
 package locations
 
 import (
-	"bytes"
-	"database/sql"
-	"fmt"
-	"log"
+	"errors"
 	"strings"
 	"time"
 
+	"github.com/Samuyi/www/models/page"
 	validator "github.com/asaskevich/govalidator"
-	_ "github.com/lib/pq" // postgres driver
 )
 
+//ErrInvalidChange is returned when an update touches anything other than the city, state or country
+var ErrInvalidChange = errors.New("Sorry only city, country or state names can be updated")
+
 //Location where an item is based
 type Location struct {
 	LocationID  string    `json:"location_id"`
@@ -268,56 +268,39 @@ var countries = map[string]string{
 	"ZW": "Zimbabwe",
 }
 
-var db *sql.DB
-
-const (
-	host     = "localhost"
-	port     = 5432
-	user     = "help"
-	password = "help"
-	dbname   = "help.ng"
-)
-
-func init() {
-	var err error
-
-	psqlInfo := fmt.Sprintf("host=%s port=%d user=%s "+"password=%s dbname=%s sslmode=disable", host, port, user, password, dbname)
-	db, err = sql.Open("postgres", psqlInfo)
-
-	if err != nil {
-		log.Println(err)
-	}
-	err = db.Ping()
-
-	if err != nil {
-		log.Println(err)
-	}
+//Filter narrows the locations listed to those in Country and State, when they are set
+type Filter struct {
+	Country string
+	State   string
+}
 
-	log.Println("connected to database")
+//Key returns the position of location in a page of locations
+func Key(location Location) page.Cursor {
+	return page.Cursor{CreatedAt: location.CreatedAt, ID: location.LocationID}
 }
 
-//Validate location struct
+//Validate location struct, saying what is wrong with each invalid field under its json name
 func (location *Location) Validate() map[string]string {
 	var errors = make(map[string]string)
 
 	if len(location.City) <= 2 {
 		message := "Please supply a valid city"
-		errors["Invalid City"] = message
+		errors["city"] = message
 	}
 
 	if len(location.State) <= 2 {
 		message := "Please supply a valid state"
-		errors["Invalid State"] = message
+		errors["state"] = message
 	}
 
 	if _, ok := countries[location.CountryCode]; !ok {
 		message := "Please supply a valid country code"
-		errors["Invalid Country code"] = message
+		errors["country_code"] = message
 	}
 
 	if !validator.IsUUID(location.UserID) {
 		message := "Please supply a valid user id"
-		errors["Invalid user Id"] = message
+		errors["user_id"] = message
 	}
 
 	if len(errors) > 0 {
@@ -327,146 +310,9 @@ func (location *Location) Validate() map[string]string {
 	return nil
 }
 
-//Create a location
-func (location *Location) Create() error {
-	query := "INSERT INTO locations (city, user_id, state, country) VALUES ($1, $2, $3, $4) returning location_id"
-
-	stmt, err := db.Prepare(query)
-
-	defer stmt.Close()
-
-	if err != nil {
-		log.Println(err)
-		return err
-	}
-
+//Normalize upper cases the city and state and fills in the country from the country code
+func (location *Location) Normalize() {
 	location.City = strings.ToUpper(location.City)
 	location.State = strings.ToUpper(location.State)
 	location.Country = countries[location.CountryCode]
-
-	err = stmt.QueryRow(location.City, location.UserID, location.State, location.Country).Scan(&location.LocationID)
-
-	if err != nil {
-		log.Println(err)
-		return err
-	}
-
-	return nil
-}
-
-//Get a location
-func (location *Location) Get() error {
-	query := "SELECT city, state, country, created_at FROM locations where location_id = $1"
-
-	stmt, err := db.Prepare(query)
-
-	defer stmt.Close()
-
-	if err != nil {
-		log.Println(err)
-		return err
-	}
-
-	err = stmt.QueryRow(location.LocationID).Scan(&location.City, &location.State, &location.Country, &location.CreatedAt)
-
-	if err != nil {
-		log.Println(err)
-		return err
-	}
-
-	return nil
-
-}
-
-//Update a location
-func (location *Location) Update(changes map[string]string) error {
-	var query bytes.Buffer
-	query.Write([]byte("UPDATE locations SET"))
-	for k, v := range changes {
-		query.Write([]byte(" "))
-		query.Write([]byte(k))
-		query.Write([]byte(" ="))
-		query.Write([]byte(" "))
-		query.Write([]byte("'" + v + "'"))
-		query.Write([]byte(", "))
-	}
-
-	query.Write([]byte(" updated_at = $1 WHERE location_id = $2"))
-
-	stmt, err := db.Prepare(query.String())
-
-	defer stmt.Close()
-
-	if err != nil {
-		log.Println(err)
-		return err
-	}
-	location.UpdatedAt = time.Now()
-
-	_, err = stmt.Exec(location.UpdatedAt, location.LocationID)
-
-	if err != nil {
-		log.Println(err)
-		return err
-	}
-
-	return nil
-}
-
-//Delete a location
-func (location *Location) Delete() error {
-	query := "DELETE FROM locations WHERE location_id = $1"
-
-	stmt, err := db.Prepare(query)
-	defer stmt.Close()
-
-	if err != nil {
-		log.Println(err)
-		return err
-	}
-
-	_, err = stmt.Exec(location.LocationID)
-
-	if err != nil {
-		log.Println(err)
-		return err
-	}
-
-	return nil
-}
-
-//GetAll locations
-func (location *Location) GetAll() ([]Location, error) {
-	query := "SELECT location_id, city, state, country FROM locations"
-
-	stmt, err := db.Prepare(query)
-
-	defer stmt.Close()
-
-	if err != nil {
-		log.Println(err)
-		return nil, err
-	}
-
-	rows, err := stmt.Query()
-
-	if err != nil {
-		log.Println(err)
-		return nil, err
-	}
-
-	var locations []Location
-
-	defer rows.Close()
-	for rows.Next() {
-		var location Location
-
-		if err := rows.Scan(&location.LocationID, &location.City, &location.State, &location.Country); err != nil {
-			log.Println(err)
-			return nil, err
-		}
-		locations = append(locations, location)
-	}
-
-	return locations, nil
 }
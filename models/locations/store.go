@@ -0,0 +1,185 @@
+package locations
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/Samuyi/www/logging"
+	"github.com/Samuyi/www/models/page"
+	"time"
+)
+
+//Store keeps locations in postgres
+type Store struct {
+	db *sql.DB
+}
+
+//NewStore returns a location store backed by db
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+//Create a location
+func (s *Store) Create(ctx context.Context, location *Location) error {
+	query := "INSERT INTO locations (city, user_id, state, country) VALUES ($1, $2, $3, $4) returning location_id"
+
+	stmt, err := s.db.PrepareContext(ctx, query)
+
+	if err != nil {
+		logging.From(ctx).Error("locations.Create", "err", err)
+		return err
+	}
+	defer stmt.Close()
+
+	location.Normalize()
+
+	err = stmt.QueryRowContext(ctx, location.City, location.UserID, location.State, location.Country).Scan(&location.LocationID)
+
+	if err != nil {
+		logging.From(ctx).Error("locations.Create", "err", err)
+		return err
+	}
+
+	return nil
+}
+
+//Get a location
+func (s *Store) Get(ctx context.Context, location *Location) error {
+	query := "SELECT city, state, country, COALESCE(user_id::text, ''), created_at FROM locations where location_id = $1"
+
+	stmt, err := s.db.PrepareContext(ctx, query)
+
+	if err != nil {
+		logging.From(ctx).Error("locations.Get", "err", err)
+		return err
+	}
+	defer stmt.Close()
+
+	err = stmt.QueryRowContext(ctx, location.LocationID).Scan(&location.City, &location.State, &location.Country, &location.UserID, &location.CreatedAt)
+
+	if err != nil {
+		logging.From(ctx).Error("locations.Get", "err", err)
+		return err
+	}
+
+	return nil
+
+}
+
+//Update a location
+func (s *Store) Update(ctx context.Context, location *Location, changes map[string]string) error {
+	var query bytes.Buffer
+	var args []interface{}
+
+	query.Write([]byte("UPDATE locations SET"))
+	for _, k := range []string{"city", "state", "country"} {
+		v, ok := changes[k]
+
+		if !ok {
+			continue
+		}
+
+		args = append(args, v)
+		query.Write([]byte(fmt.Sprintf(" %s = $%d,", k, len(args))))
+	}
+
+	if len(args) != len(changes) {
+		return ErrInvalidChange
+	}
+
+	location.UpdatedAt = time.Now()
+	args = append(args, location.UpdatedAt, location.LocationID)
+	query.Write([]byte(fmt.Sprintf(" updated_at = $%d WHERE location_id = $%d", len(args)-1, len(args))))
+
+	stmt, err := s.db.PrepareContext(ctx, query.String())
+
+	if err != nil {
+		logging.From(ctx).Error("locations.Update", "err", err)
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.ExecContext(ctx, args...)
+
+	if err != nil {
+		logging.From(ctx).Error("locations.Update", "err", err)
+		return err
+	}
+
+	return nil
+}
+
+//Delete a location
+func (s *Store) Delete(ctx context.Context, location *Location) error {
+	query := "DELETE FROM locations WHERE location_id = $1"
+
+	stmt, err := s.db.PrepareContext(ctx, query)
+
+	if err != nil {
+		logging.From(ctx).Error("locations.Delete", "err", err)
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.ExecContext(ctx, location.LocationID)
+
+	if err != nil {
+		logging.From(ctx).Error("locations.Delete", "err", err)
+		return err
+	}
+
+	return nil
+}
+
+//GetAll gets the page q asks for of the locations that match filter
+func (s *Store) GetAll(ctx context.Context, filter Filter, q page.Query) (page.Page[Location], error) {
+	var where page.Where
+
+	if filter.Country != "" {
+		where.Add("country = ?", filter.Country)
+	}
+
+	if filter.State != "" {
+		where.Add("state = ?", filter.State)
+	}
+
+	tail := q.Keyset(&where, "created_at", "location_id")
+
+	query := "SELECT location_id, city, state, country, created_at FROM locations" + where.String() + tail
+
+	stmt, err := s.db.PrepareContext(ctx, query)
+
+	if err != nil {
+		logging.From(ctx).Error("locations.GetAll", "err", err)
+		return page.Page[Location]{}, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.QueryContext(ctx, where.Args...)
+
+	if err != nil {
+		logging.From(ctx).Error("locations.GetAll", "err", err)
+		return page.Page[Location]{}, err
+	}
+
+	var locations []Location
+
+	defer rows.Close()
+	for rows.Next() {
+		var location Location
+
+		if err := rows.Scan(&location.LocationID, &location.City, &location.State, &location.Country, &location.CreatedAt); err != nil {
+			logging.From(ctx).Error("locations.GetAll", "err", err)
+			return page.Page[Location]{}, err
+		}
+		locations = append(locations, location)
+	}
+
+	if err = rows.Err(); err != nil {
+		logging.From(ctx).Error("locations.GetAll", "err", err)
+		return page.Page[Location]{}, err
+	}
+
+	return page.New(locations, q, Key), nil
+}
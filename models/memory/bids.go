@@ -0,0 +1,44 @@
+package memory
+
+import (
+	"context"
+	"github.com/Samuyi/www/models/bids"
+)
+
+//Bids is an in-memory bid store
+type Bids struct {
+	db *DB
+}
+
+//Bids returns the bid store of the database
+func (db *DB) Bids() *Bids {
+	return &Bids{db: db}
+}
+
+//Place records a bid, replacing any earlier bid by the same user on the item
+func (s *Bids) Place(ctx context.Context, bid *bids.Bid) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	if s.db.bids[bid.ItemID] == nil {
+		s.db.bids[bid.ItemID] = map[string]string{}
+	}
+
+	s.db.bids[bid.ItemID][bid.Username] = bid.Message
+
+	return nil
+}
+
+//GetItemBids gets all bids placed on an item
+func (s *Bids) GetItemBids(ctx context.Context, itemID string) ([]bids.Bid, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	var all []bids.Bid
+
+	for username, message := range s.db.bids[itemID] {
+		all = append(all, bids.Bid{ItemID: itemID, Username: username, Message: message})
+	}
+
+	return all, nil
+}
@@ -0,0 +1,171 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Samuyi/www/models/categories"
+	"github.com/Samuyi/www/models/items"
+)
+
+//Categories is an in-memory category store
+type Categories struct {
+	db *DB
+}
+
+//Categories returns the category store of the database
+func (db *DB) Categories() *Categories {
+	return &Categories{db: db}
+}
+
+//allCategories returns every category, sorted by name
+func (db *DB) allCategories() []categories.Category {
+	all := make([]categories.Category, 0, len(db.categories))
+
+	for _, stored := range db.categories {
+		all = append(all, stored)
+	}
+
+	sort.Slice(all, func(i, j int) bool {
+		if name, other := strings.ToLower(all[i].Name), strings.ToLower(all[j].Name); name != other {
+			return name < other
+		}
+
+		return all[i].ID < all[j].ID
+	})
+
+	return all
+}
+
+//check returns why category can't be stored with its name and parent, if it can't
+func (db *DB) check(category *categories.Category) error {
+	if _, ok := db.categories[category.ParentID]; category.ParentID != "" && !ok {
+		return categories.ErrUnknownParent
+	}
+
+	for _, stored := range db.categories {
+		if stored.ID != category.ID && stored.ParentID == category.ParentID && strings.EqualFold(stored.Name, category.Name) {
+			return categories.ErrNameTaken
+		}
+	}
+
+	return nil
+}
+
+//Create a category, whose name is unique among the children of its parent
+func (s *Categories) Create(ctx context.Context, category *categories.Category) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	if err := s.db.check(category); err != nil {
+		return err
+	}
+
+	category.ID = newID()
+	category.CreatedAt = time.Now()
+
+	s.db.categories[category.ID] = categories.Category{
+		ID:        category.ID,
+		Name:      category.Name,
+		ParentID:  category.ParentID,
+		CreatedAt: category.CreatedAt,
+	}
+
+	return nil
+}
+
+//Get a category
+func (s *Categories) Get(ctx context.Context, category *categories.Category) error {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	stored, ok := s.db.categories[category.ID]
+
+	if !ok {
+		return errNoRows
+	}
+
+	category.Name = stored.Name
+	category.ParentID = stored.ParentID
+	category.CreatedAt = stored.CreatedAt
+
+	return nil
+}
+
+//Update the name and parent of a category
+func (s *Categories) Update(ctx context.Context, category *categories.Category) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	if err := s.db.check(category); err != nil {
+		return err
+	}
+
+	category.UpdatedAt = time.Now()
+
+	if stored, ok := s.db.categories[category.ID]; ok {
+		stored.Name = category.Name
+		stored.ParentID = category.ParentID
+		stored.UpdatedAt = category.UpdatedAt
+		s.db.categories[category.ID] = stored
+	}
+
+	return nil
+}
+
+//Delete a category without categories under it, leaving its items without a category
+func (s *Categories) Delete(ctx context.Context, category *categories.Category) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	for _, stored := range s.db.categories {
+		if stored.ParentID == category.ID {
+			return categories.ErrHasChildren
+		}
+	}
+
+	for id, item := range s.db.items {
+		if item.CategoryID == category.ID {
+			item.CategoryID = ""
+			s.db.items[id] = item
+		}
+	}
+
+	delete(s.db.categories, category.ID)
+
+	return nil
+}
+
+//GetAll categories, unnested and sorted by name
+func (s *Categories) GetAll(ctx context.Context) ([]categories.Category, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	all := s.db.allCategories()
+
+	for i := range all {
+		if all[i].UpdatedAt.IsZero() {
+			all[i].UpdatedAt = all[i].CreatedAt
+		}
+	}
+
+	return all, nil
+}
+
+//CountItems counts the open items directly in each category at a location, by category id
+func (s *Categories) CountItems(ctx context.Context, locationID string) (map[string]int, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	counts := make(map[string]int)
+
+	for _, item := range s.db.items {
+		if item.Status == items.StatusOpen && item.CategoryID != "" && item.Location.LocationID == locationID {
+			counts[item.CategoryID]++
+		}
+	}
+
+	return counts, nil
+}
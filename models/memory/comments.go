@@ -0,0 +1,196 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"github.com/Samuyi/www/models/comments"
+	"github.com/Samuyi/www/models/page"
+)
+
+//Comments is an in-memory comment store
+type Comments struct {
+	db *DB
+}
+
+//Comments returns the comment store of the database
+func (db *DB) Comments() *Comments {
+	return &Comments{db: db}
+}
+
+//Create a comment for an item
+func (s *Comments) Create(ctx context.Context, comment *comments.Comment) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	date := time.Now()
+	comment.ID = newID()
+	comment.CreatedAt = date.String()
+	comment.UpdatedAt = ""
+
+	s.db.comments[comment.ID] = comments.Comment{
+		ID:        comment.ID,
+		ItemID:    comment.ItemID,
+		UserID:    comment.UserID,
+		Username:  comment.Username,
+		Comment:   comment.Comment,
+		CreatedAt: comment.CreatedAt,
+	}
+	s.db.itemComments[comment.ItemID] = append(s.db.itemComments[comment.ItemID], scored{id: comment.ID, score: date.Unix()})
+
+	return nil
+}
+
+//Get a comment, comments.ErrNotFound when there is none with its id
+func (s *Comments) Get(ctx context.Context, comment *comments.Comment) error {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	stored, ok := s.db.comments[comment.ID]
+
+	if !ok {
+		return comments.ErrNotFound
+	}
+
+	comment.UserID = stored.UserID
+	comment.Username = stored.Username
+	comment.Comment = stored.Comment
+	comment.ItemID = stored.ItemID
+	comment.ReplyCount = int64(len(s.db.replyIDs[comment.ID]))
+	comment.Replies = []comments.Reply{}
+	comment.CreatedAt = stored.CreatedAt
+	comment.UpdatedAt = stored.UpdatedAt
+
+	return nil
+}
+
+//GetReplies appends the replies of a comment to it, oldest first
+func (s *Comments) GetReplies(ctx context.Context, comment *comments.Comment) error {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	for _, member := range s.db.replyIDs[comment.ID] {
+		stored := s.db.replies[member.id]
+
+		comment.Replies = append(comment.Replies, comments.Reply{
+			ID:        member.id,
+			UserID:    stored.UserID,
+			Username:  stored.Username,
+			Comment:   stored.Comment,
+			CreatedAt: stored.CreatedAt,
+		})
+	}
+
+	return nil
+}
+
+//GetItemComments gets the page q asks for of the comments on an item
+func (s *Comments) GetItemComments(ctx context.Context, itemID string, q page.Query) (page.Page[comments.Comment], error) {
+	s.db.mu.RLock()
+	members := append([]scored(nil), s.db.itemComments[itemID]...)
+	s.db.mu.RUnlock()
+
+	ids := page.Slice(members, q, func(member scored) page.Cursor {
+		return page.Cursor{CreatedAt: time.Unix(member.score, 0), ID: member.id}
+	})
+
+	return page.Convert(ids, func(member scored) comments.Comment {
+		comment := comments.Comment{ID: member.id}
+		s.Get(ctx, &comment)
+
+		return comment
+	}), nil
+}
+
+//Update the text of a comment
+func (s *Comments) Update(ctx context.Context, comment *comments.Comment) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	stored := s.db.comments[comment.ID]
+	stored.ID = comment.ID
+	stored.Comment = comment.Comment
+	stored.UpdatedAt = time.Now().String()
+	s.db.comments[comment.ID] = stored
+
+	return nil
+}
+
+//Delete a comment and its replies
+func (s *Comments) Delete(ctx context.Context, comment *comments.Comment) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	s.db.itemComments[comment.ItemID] = removeScored(s.db.itemComments[comment.ItemID], comment.ID)
+	delete(s.db.comments, comment.ID)
+
+	for _, member := range s.db.replyIDs[comment.ID] {
+		delete(s.db.replies, member.id)
+	}
+
+	return nil
+}
+
+//CreateReply creates a reply to a comment
+func (s *Comments) CreateReply(ctx context.Context, reply *comments.Reply) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	date := time.Now()
+	reply.ID = newID()
+	reply.CreatedAt = date.String()
+
+	s.db.replies[reply.ID] = comments.Reply{
+		ID:        reply.ID,
+		UserID:    reply.UserID,
+		Username:  reply.Username,
+		Comment:   reply.Comment,
+		CreatedAt: reply.CreatedAt,
+	}
+	s.db.replyIDs[reply.CommentID] = append(s.db.replyIDs[reply.CommentID], scored{id: reply.ID, score: date.Unix()})
+
+	return nil
+}
+
+//GetReply gets a reply, comments.ErrNotFound when there is none with its id
+func (s *Comments) GetReply(ctx context.Context, reply *comments.Reply) error {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	stored, ok := s.db.replies[reply.ID]
+
+	if !ok {
+		return comments.ErrNotFound
+	}
+
+	reply.Comment = stored.Comment
+	reply.CreatedAt = stored.CreatedAt
+	reply.UserID = stored.UserID
+	reply.Username = stored.Username
+
+	return nil
+}
+
+//UpdateReply updates the text of a reply
+func (s *Comments) UpdateReply(ctx context.Context, reply *comments.Reply) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	stored := s.db.replies[reply.ID]
+	stored.ID = reply.ID
+	stored.Comment = reply.Comment
+	s.db.replies[reply.ID] = stored
+
+	return nil
+}
+
+//DeleteReply deletes a reply
+func (s *Comments) DeleteReply(ctx context.Context, reply *comments.Reply) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	s.db.replyIDs[reply.CommentID] = removeScored(s.db.replyIDs[reply.CommentID], reply.ID)
+	delete(s.db.replies, reply.ID)
+
+	return nil
+}
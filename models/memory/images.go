@@ -0,0 +1,113 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/Samuyi/www/models/items"
+)
+
+//deleteItem deletes an item along with its images and history
+func (db *DB) deleteItem(id string) {
+	delete(db.items, id)
+
+	for imageID, image := range db.images {
+		if image.ItemID == id {
+			delete(db.images, imageID)
+		}
+	}
+
+	kept := db.history[:0]
+
+	for _, transition := range db.history {
+		if transition.ItemID != id {
+			kept = append(kept, transition)
+		}
+	}
+
+	db.history = kept
+}
+
+//AddImage adds an image to an item with fewer than max images
+func (s *Items) AddImage(ctx context.Context, image *items.Image, max int) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	count := 0
+
+	for _, stored := range s.db.images {
+		if stored.ItemID == image.ItemID {
+			count++
+		}
+	}
+
+	if count >= max {
+		return items.ErrTooManyImages
+	}
+
+	image.ID = newID()
+	image.CreatedAt = time.Now()
+
+	s.db.images[image.ID] = items.Image{
+		ID:           image.ID,
+		ItemID:       image.ItemID,
+		Key:          image.Key,
+		ThumbnailKey: image.ThumbnailKey,
+		Width:        image.Width,
+		Height:       image.Height,
+		CreatedAt:    image.CreatedAt,
+	}
+
+	return nil
+}
+
+//RemoveImage removes an image from its item, filling in the keys of the photo and thumbnail it leaves behind
+func (s *Items) RemoveImage(ctx context.Context, image *items.Image) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	stored, ok := s.db.images[image.ID]
+
+	if !ok || stored.ItemID != image.ItemID {
+		return errNoRows
+	}
+
+	image.Key = stored.Key
+	image.ThumbnailKey = stored.ThumbnailKey
+	delete(s.db.images, image.ID)
+
+	return nil
+}
+
+//Images gets the images of each item with one of ids, oldest first, by item id
+func (s *Items) Images(ctx context.Context, ids []string) (map[string][]items.Image, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	wanted := make(map[string]bool, len(ids))
+
+	for _, id := range ids {
+		wanted[id] = true
+	}
+
+	images := make(map[string][]items.Image)
+
+	for _, stored := range s.db.images {
+		if wanted[stored.ItemID] {
+			images[stored.ItemID] = append(images[stored.ItemID], stored)
+		}
+	}
+
+	for _, list := range images {
+		sort.Slice(list, func(i, j int) bool {
+			if !list[i].CreatedAt.Equal(list[j].CreatedAt) {
+				return list[i].CreatedAt.Before(list[j].CreatedAt)
+			}
+
+			return list[i].ID < list[j].ID
+		})
+	}
+
+	return images, nil
+}
@@ -0,0 +1,359 @@
+package memory
+
+import (
+	"context"
+	"html"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/Samuyi/www/models/categories"
+	"github.com/Samuyi/www/models/items"
+	"github.com/Samuyi/www/models/locations"
+	"github.com/Samuyi/www/models/page"
+)
+
+//Items is an in-memory item store
+type Items struct {
+	db *DB
+}
+
+//Items returns the item store of the database
+func (db *DB) Items() *Items {
+	return &Items{db: db}
+}
+
+func (db *DB) locationByCity(city string) (locations.Location, bool) {
+	for _, location := range db.locations {
+		if location.City == city {
+			return location, true
+		}
+	}
+
+	return locations.Location{}, false
+}
+
+//Create an item, the city must be a known location
+func (s *Items) Create(ctx context.Context, item *items.Item) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	location, ok := s.db.locationByCity(item.Location.City)
+
+	if !ok {
+		return items.ErrUnknownCity
+	}
+
+	if _, ok := s.db.categories[item.CategoryID]; item.CategoryID != "" && !ok {
+		return items.ErrUnknownCategory
+	}
+
+	item.ID = newID()
+	item.StatusChangedAt = time.Now()
+
+	s.db.items[item.ID] = items.Item{
+		ID:          item.ID,
+		Name:        item.Name,
+		UserID:      item.UserID,
+		PhoneNo:     item.PhoneNo,
+		Instruction: item.Instruction,
+		CategoryID:  item.CategoryID,
+		Condition:   item.Condition,
+		Location:    locations.Location{LocationID: location.LocationID, City: location.City},
+		Status:      item.Status,
+		CreatedAt:   item.StatusChangedAt,
+
+		StatusChangedAt: item.StatusChangedAt,
+	}
+
+	return nil
+}
+
+//Get an item along with its owner and location
+func (s *Items) Get(ctx context.Context, item *items.Item) error {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	stored, ok := s.db.items[item.ID]
+
+	if !ok {
+		return errNoRows
+	}
+
+	owner, ok := s.db.users[stored.UserID]
+
+	if !ok {
+		return errNoRows
+	}
+
+	location, ok := s.db.locationByCity(stored.Location.City)
+
+	if !ok {
+		return errNoRows
+	}
+
+	item.Name = stored.Name
+	item.DisplayName = owner.DisplayName
+	item.UserEmail = owner.Email
+	item.UserID = stored.UserID
+	item.Location.City = stored.Location.City
+	item.Instruction = stored.Instruction
+	item.CategoryID = stored.CategoryID
+	item.Condition = stored.Condition
+	item.PhoneNo = stored.PhoneNo
+	item.CreatedAt = stored.CreatedAt
+	item.Location.State = location.State
+	item.Location.Country = location.Country
+	item.Status = stored.Status
+	item.RecipientID = stored.RecipientID
+	item.StatusChangedAt = stored.StatusChangedAt
+
+	return nil
+}
+
+//Update an item
+func (s *Items) Update(ctx context.Context, item *items.Item) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	if _, ok := s.db.categories[item.CategoryID]; item.CategoryID != "" && !ok {
+		return items.ErrUnknownCategory
+	}
+
+	item.UpdatedAt = time.Now()
+
+	if stored, ok := s.db.items[item.ID]; ok {
+		stored.Name = item.Name
+		stored.PhoneNo = item.PhoneNo
+		stored.Instruction = item.Instruction
+		stored.CategoryID = item.CategoryID
+		stored.Condition = item.Condition
+		stored.UpdatedAt = item.UpdatedAt
+		s.db.items[item.ID] = stored
+	}
+
+	return nil
+}
+
+//Delete an item
+func (s *Items) Delete(ctx context.Context, item *items.Item) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	s.db.deleteItem(item.ID)
+
+	return nil
+}
+
+func (s *Items) list(match func(item items.Item) bool, fill func(stored items.Item, owner, email string) items.Item) []items.Item {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	var matched []items.Item
+
+	for _, stored := range s.db.items {
+		if match(stored) {
+			owner := s.db.users[stored.UserID]
+			matched = append(matched, fill(stored, owner.DisplayName, owner.Email))
+		}
+	}
+
+	sort.Slice(matched, func(i, j int) bool {
+		return matched[i].CreatedAt.After(matched[j].CreatedAt)
+	})
+
+	return matched
+}
+
+//within returns the ids of the categories filter narrows items down to, or nil when it doesn't
+func (s *Items) within(filter items.Filter) map[string]bool {
+	if filter.CategoryID == "" {
+		return nil
+	}
+
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	return categories.Descendants(s.db.allCategories(), filter.CategoryID)
+}
+
+//matches tells whether item is one of the items of filter, in one of the categories within
+func matches(item items.Item, filter items.Filter, within map[string]bool) bool {
+	return item.Status == filter.Status &&
+		(filter.City == "" || item.Location.City == filter.City) &&
+		(filter.LocationID == "" || item.Location.LocationID == filter.LocationID) &&
+		(filter.UserID == "" || item.UserID == filter.UserID) &&
+		(filter.Condition == "" || item.Condition == filter.Condition) &&
+		(within == nil || within[item.CategoryID])
+}
+
+//ItemsInALocation gets the page q asks for of the items in a location that match filter
+func (s *Items) ItemsInALocation(ctx context.Context, locationID string, filter items.Filter, q page.Query) (page.Page[items.Item], error) {
+	filter.LocationID = locationID
+
+	within := s.within(filter)
+
+	return page.Slice(s.list(func(item items.Item) bool {
+		return matches(item, filter, within)
+	}, func(stored items.Item, owner, email string) items.Item {
+		return items.Item{
+			ID:          stored.ID,
+			Name:        stored.Name,
+			UserID:      stored.UserID,
+			DisplayName: owner,
+			UserEmail:   email,
+			PhoneNo:     stored.PhoneNo,
+			Instruction: stored.Instruction,
+			CategoryID:  stored.CategoryID,
+			Condition:   stored.Condition,
+			Status:      stored.Status,
+			RecipientID: stored.RecipientID,
+			CreatedAt:   stored.CreatedAt,
+
+			StatusChangedAt: stored.StatusChangedAt,
+		}
+	}), q, items.Key), nil
+}
+
+//GetAllItems gets the page q asks for of the items that match filter
+func (s *Items) GetAllItems(ctx context.Context, filter items.Filter, q page.Query) (page.Page[items.Item], error) {
+	within := s.within(filter)
+
+	return page.Slice(s.list(func(item items.Item) bool {
+		return matches(item, filter, within)
+	}, func(stored items.Item, owner, email string) items.Item {
+		return items.Item{
+			ID:          stored.ID,
+			Name:        stored.Name,
+			UserID:      stored.UserID,
+			DisplayName: owner,
+			PhoneNo:     stored.PhoneNo,
+			Instruction: stored.Instruction,
+			CategoryID:  stored.CategoryID,
+			Condition:   stored.Condition,
+			Location:    locations.Location{City: stored.Location.City},
+			Status:      stored.Status,
+			RecipientID: stored.RecipientID,
+			CreatedAt:   stored.CreatedAt,
+
+			StatusChangedAt: stored.StatusChangedAt,
+		}
+	}), q, items.Key), nil
+}
+
+//GetUserItems gets every item belonging to a user, newest first
+func (s *Items) GetUserItems(ctx context.Context, userID string) ([]items.Item, error) {
+	return s.list(func(item items.Item) bool {
+		return item.UserID == userID
+	}, func(stored items.Item, owner, email string) items.Item {
+		return items.Item{
+			ID:          stored.ID,
+			Name:        stored.Name,
+			Location:    locations.Location{LocationID: stored.Location.LocationID},
+			Instruction: stored.Instruction,
+			CategoryID:  stored.CategoryID,
+			Condition:   stored.Condition,
+			Status:      stored.Status,
+			RecipientID: stored.RecipientID,
+			CreatedAt:   stored.CreatedAt,
+
+			StatusChangedAt: stored.StatusChangedAt,
+		}
+	}), nil
+}
+
+//matchesTerm tells whether a lower cased word matches the term of a search, which is a prefix when it is the last
+func matchesTerm(word, term string, last bool) bool {
+	return word == term || (last && strings.HasPrefix(word, term))
+}
+
+//rank scores how well an item matches every one of terms, or is 0 when it doesn't. Terms found in the name count
+//double, a rough stand-in for the weights postgres ranks by.
+func rank(item items.Item, terms []string) float64 {
+	var score float64
+
+	name, instruction := items.Terms(item.Name), items.Terms(item.Instruction)
+
+	for i, term := range terms {
+		found := func(words []string) bool {
+			for _, word := range words {
+				if matchesTerm(word, term, i == len(terms)-1) {
+					return true
+				}
+			}
+
+			return false
+		}
+
+		switch {
+		case found(name):
+			score += 2
+		case found(instruction):
+			score++
+		default:
+			return 0
+		}
+	}
+
+	return score / float64(2*len(terms))
+}
+
+//highlight html escapes text and puts the words matching terms between <mark> tags
+func highlight(text string, terms []string) string {
+	var b strings.Builder
+
+	write := func(run string, isWord bool) {
+		for i, term := range terms {
+			if isWord && matchesTerm(strings.ToLower(run), term, i == len(terms)-1) {
+				b.WriteString("<mark>" + html.EscapeString(run) + "</mark>")
+
+				return
+			}
+		}
+
+		b.WriteString(html.EscapeString(run))
+	}
+
+	start, inWord := 0, false
+
+	for i, r := range text {
+		if isWord := unicode.IsLetter(r) || unicode.IsDigit(r); isWord != inWord {
+			write(text[start:i], inWord)
+			start, inWord = i, isWord
+		}
+	}
+
+	write(text[start:], inWord)
+
+	return b.String()
+}
+
+//Search gets the page q asks for of the items that match filter and every term of search, see items.Terms
+func (s *Items) Search(ctx context.Context, search string, filter items.Filter, q page.Query) (page.Page[items.Item], error) {
+	terms := items.Terms(search)
+	within := s.within(filter)
+
+	return page.Slice(s.list(func(item items.Item) bool {
+		return matches(item, filter, within) && rank(item, terms) > 0
+	}, func(stored items.Item, owner, email string) items.Item {
+		return items.Item{
+			ID:          stored.ID,
+			Name:        stored.Name,
+			UserID:      stored.UserID,
+			DisplayName: owner,
+			PhoneNo:     stored.PhoneNo,
+			Instruction: stored.Instruction,
+			CategoryID:  stored.CategoryID,
+			Condition:   stored.Condition,
+			Location:    locations.Location{City: stored.Location.City},
+			Status:      stored.Status,
+			RecipientID: stored.RecipientID,
+			CreatedAt:   stored.CreatedAt,
+			Rank:        rank(stored, terms),
+			Snippet:     highlight(stored.Name+" "+stored.Instruction, terms),
+
+			StatusChangedAt: stored.StatusChangedAt,
+		}
+	}), q, items.Key), nil
+}
@@ -0,0 +1,77 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"github.com/Samuyi/www/models/items"
+)
+
+//Transition moves item from the status it has on to transition.To, for transition.RecipientID, and records the move
+func (s *Items) Transition(ctx context.Context, item *items.Item, transition *items.Transition) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	stored, ok := s.db.items[item.ID]
+
+	if !ok {
+		return errNoRows
+	}
+
+	if stored.Status != item.Status {
+		return items.ErrStatusChanged
+	}
+
+	transition.ID = newID()
+	transition.ItemID, transition.From = item.ID, item.Status
+	transition.CreatedAt = time.Now()
+
+	stored.Status, stored.RecipientID, stored.StatusChangedAt = transition.To, transition.RecipientID, transition.CreatedAt
+	stored.UpdatedAt = transition.CreatedAt
+	s.db.items[item.ID] = stored
+	s.db.history = append(s.db.history, *transition)
+
+	item.Status, item.RecipientID, item.StatusChangedAt = transition.To, transition.RecipientID, transition.CreatedAt
+
+	return nil
+}
+
+//History gets the transitions of an item, oldest first
+func (s *Items) History(ctx context.Context, itemID string) ([]items.Transition, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	var history []items.Transition
+
+	for _, transition := range s.db.history {
+		if transition.ItemID == itemID {
+			history = append(history, transition)
+		}
+	}
+
+	return history, nil
+}
+
+//Expire moves the items that have been open since before on to expired, recording each move, and returns them
+func (s *Items) Expire(ctx context.Context, before time.Time) ([]items.Item, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	var expired []items.Item
+
+	now := time.Now()
+
+	for id, stored := range s.db.items {
+		if stored.Status != items.StatusOpen || !stored.StatusChangedAt.Before(before) {
+			continue
+		}
+
+		stored.Status, stored.StatusChangedAt, stored.UpdatedAt = items.StatusExpired, now, now
+		s.db.items[id] = stored
+		s.db.history = append(s.db.history, items.Transition{ID: newID(), ItemID: id, From: items.StatusOpen, To: items.StatusExpired, CreatedAt: now})
+
+		expired = append(expired, items.Item{ID: id, Name: stored.Name, UserID: stored.UserID, Status: stored.Status, StatusChangedAt: now})
+	}
+
+	return expired, nil
+}
@@ -0,0 +1,146 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Samuyi/www/models/locations"
+	"github.com/Samuyi/www/models/page"
+)
+
+//Locations is an in-memory location store
+type Locations struct {
+	db *DB
+}
+
+//Locations returns the location store of the database
+func (db *DB) Locations() *Locations {
+	return &Locations{db: db}
+}
+
+//Create a location, cities are unique
+func (s *Locations) Create(ctx context.Context, location *locations.Location) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	location.Normalize()
+
+	if _, ok := s.db.locationByCity(location.City); ok {
+		return errors.New("locations: city already exists")
+	}
+
+	location.LocationID = newID()
+	location.CreatedAt = time.Now()
+
+	s.db.locations[location.LocationID] = *location
+
+	return nil
+}
+
+//Get a location
+func (s *Locations) Get(ctx context.Context, location *locations.Location) error {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	stored, ok := s.db.locations[location.LocationID]
+
+	if !ok {
+		return errNoRows
+	}
+
+	location.City = stored.City
+	location.State = stored.State
+	location.Country = stored.Country
+	location.UserID = stored.UserID
+	location.CreatedAt = stored.CreatedAt
+
+	return nil
+}
+
+//Update the city, state or country of a location
+func (s *Locations) Update(ctx context.Context, location *locations.Location, changes map[string]string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	stored, ok := s.db.locations[location.LocationID]
+
+	for column, value := range changes {
+		switch column {
+		case "city":
+			if existing, found := s.db.locationByCity(value); found && existing.LocationID != location.LocationID {
+				return errors.New("locations: city already exists")
+			}
+
+			for id, item := range s.db.items {
+				if item.Location.City == stored.City {
+					return fmt.Errorf("locations: city %s is used by item %s", stored.City, id)
+				}
+			}
+
+			stored.City = value
+		case "state":
+			stored.State = value
+		case "country":
+			stored.Country = value
+		default:
+			return locations.ErrInvalidChange
+		}
+	}
+
+	location.UpdatedAt = time.Now()
+
+	if ok {
+		stored.UpdatedAt = location.UpdatedAt
+		s.db.locations[location.LocationID] = stored
+	}
+
+	return nil
+}
+
+//Delete a location that no item uses
+func (s *Locations) Delete(ctx context.Context, location *locations.Location) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	stored, ok := s.db.locations[location.LocationID]
+
+	if !ok {
+		return nil
+	}
+
+	for id, item := range s.db.items {
+		if item.Location.City == stored.City {
+			return fmt.Errorf("locations: city %s is used by item %s", stored.City, id)
+		}
+	}
+
+	delete(s.db.locations, location.LocationID)
+
+	return nil
+}
+
+//GetAll gets the page q asks for of the locations that match filter
+func (s *Locations) GetAll(ctx context.Context, filter locations.Filter, q page.Query) (page.Page[locations.Location], error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	var all []locations.Location
+
+	for _, stored := range s.db.locations {
+		if (filter.Country != "" && stored.Country != filter.Country) || (filter.State != "" && stored.State != filter.State) {
+			continue
+		}
+
+		all = append(all, locations.Location{
+			LocationID: stored.LocationID,
+			City:       stored.City,
+			State:      stored.State,
+			Country:    stored.Country,
+			CreatedAt:  stored.CreatedAt,
+		})
+	}
+
+	return page.Slice(all, q, locations.Key), nil
+}
@@ -0,0 +1,140 @@
+package memory
+
+import (
+	"database/sql"
+	"sync"
+	"time"
+
+	"github.com/Samuyi/www/models/categories"
+	"github.com/Samuyi/www/models/comments"
+	"github.com/Samuyi/www/models/items"
+	"github.com/Samuyi/www/models/locations"
+	"github.com/Samuyi/www/models/outbox"
+	"github.com/Samuyi/www/models/users"
+	uuid "github.com/satori/go.uuid"
+)
+
+//DB is an in-memory stand-in for postgres and redis. It is safe for concurrent use.
+//Missing rows are reported with sql.ErrNoRows, the same as the postgres stores.
+type DB struct {
+	mu sync.RWMutex
+
+	users      map[string]users.User
+	twoFactor  map[string]twoFactor
+	items      map[string]items.Item
+	images     map[string]items.Image
+	history    []items.Transition
+	locations  map[string]locations.Location
+	categories map[string]categories.Category
+
+	comments     map[string]comments.Comment
+	itemComments map[string][]scored
+	replies      map[string]comments.Reply
+	replyIDs     map[string][]scored
+	bids         map[string]map[string]string
+
+	sessions      map[string]session
+	confirmations map[string]reset
+	resets        map[string]reset
+	refreshes     map[string]refresh
+	userResets    map[string]string
+	challenges    map[string]challenge
+	failures      map[string]failures
+	locks         map[string]time.Time
+	hits          map[string][]time.Time
+
+	outbox  map[string]outbox.Entry
+	pending map[string]time.Time
+	dead    []scored
+
+	//deadUntil holds when each dead letter expires
+	deadUntil map[string]time.Time
+}
+
+// scored is a member of a sorted set, mirroring the redis zsets used by the comment store
+type scored struct {
+	id    string
+	score int64
+}
+
+type session struct {
+	values    map[string]string
+	expiresAt time.Time
+}
+
+// refresh is a refresh token, stored under its hash
+type refresh struct {
+	sessionID string
+	used      bool
+	expiresAt time.Time
+}
+
+// twoFactor is the two-factor secret of a user along with the hashes of their unused recovery codes
+type twoFactor struct {
+	users.TwoFactor
+	recovery map[string]bool
+}
+
+// reset is a password reset token or email confirmation key, stored under its hash
+type reset struct {
+	userID    string
+	expiresAt time.Time
+}
+
+// challenge is a login waiting for a two-factor code, stored under the hash of its token
+type challenge struct {
+	userID    string
+	attempts  int
+	expiresAt time.Time
+}
+
+// failures counts the failed logins of an account or an address
+type failures struct {
+	count     int64
+	expiresAt time.Time
+}
+
+//New returns an empty in-memory database
+func New() *DB {
+	return &DB{
+		users:         map[string]users.User{},
+		twoFactor:     map[string]twoFactor{},
+		items:         map[string]items.Item{},
+		images:        map[string]items.Image{},
+		locations:     map[string]locations.Location{},
+		categories:    map[string]categories.Category{},
+		comments:      map[string]comments.Comment{},
+		itemComments:  map[string][]scored{},
+		replies:       map[string]comments.Reply{},
+		replyIDs:      map[string][]scored{},
+		bids:          map[string]map[string]string{},
+		sessions:      map[string]session{},
+		confirmations: map[string]reset{},
+		resets:        map[string]reset{},
+		refreshes:     map[string]refresh{},
+		userResets:    map[string]string{},
+		challenges:    map[string]challenge{},
+		failures:      map[string]failures{},
+		locks:         map[string]time.Time{},
+		hits:          map[string][]time.Time{},
+		outbox:        map[string]outbox.Entry{},
+		pending:       map[string]time.Time{},
+		deadUntil:     map[string]time.Time{},
+	}
+}
+
+func newID() string {
+	return uuid.Must(uuid.NewV4()).String()
+}
+
+var errNoRows = sql.ErrNoRows
+
+func removeScored(set []scored, id string) []scored {
+	for i, member := range set {
+		if member.id == id {
+			return append(set[:i:i], set[i+1:]...)
+		}
+	}
+
+	return set
+}
@@ -0,0 +1,166 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"github.com/Samuyi/www/models/outbox"
+)
+
+//Outbox is an in-memory email outbox
+type Outbox struct {
+	db *DB
+}
+
+//Outbox returns the outbox of the database
+func (db *DB) Outbox() *Outbox {
+	return &Outbox{db: db}
+}
+
+func copyEntry(entry *outbox.Entry) outbox.Entry {
+	stored := *entry
+	stored.Message.To = append([]string(nil), entry.Message.To...)
+
+	return stored
+}
+
+//Enqueue adds a new entry that is due straight away
+func (s *Outbox) Enqueue(ctx context.Context, entry *outbox.Entry) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	entry.ID = newID()
+	entry.CreatedAt = time.Now()
+	entry.NextAttempt = entry.CreatedAt
+
+	s.db.outbox[entry.ID] = copyEntry(entry)
+	s.db.pending[entry.ID] = entry.NextAttempt
+
+	return nil
+}
+
+//Claim returns the next due entry and hides it from other workers for lease, or nil when nothing is due
+func (s *Outbox) Claim(ctx context.Context, lease time.Duration) (*outbox.Entry, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	now := time.Now()
+
+	var next string
+
+	for id, due := range s.db.pending {
+		if !due.After(now) && (next == "" || due.Before(s.db.pending[next])) {
+			next = id
+		}
+	}
+
+	if next == "" {
+		return nil, nil
+	}
+
+	s.db.pending[next] = now.Add(lease)
+	stored := s.db.outbox[next]
+	entry := copyEntry(&stored)
+
+	return &entry, nil
+}
+
+//Complete removes a delivered entry
+func (s *Outbox) Complete(ctx context.Context, entry *outbox.Entry) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	delete(s.db.pending, entry.ID)
+	delete(s.db.outbox, entry.ID)
+
+	return nil
+}
+
+//Retry saves the failed attempt on entry and schedules it again at next
+func (s *Outbox) Retry(ctx context.Context, entry *outbox.Entry, next time.Time) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	entry.NextAttempt = next
+	s.db.outbox[entry.ID] = copyEntry(entry)
+	s.db.pending[entry.ID] = next
+
+	return nil
+}
+
+//Bury moves entry to the dead letters, where it is kept for ttl
+func (s *Outbox) Bury(ctx context.Context, entry *outbox.Entry, ttl time.Duration) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	now := time.Now()
+
+	s.db.outbox[entry.ID] = copyEntry(entry)
+	delete(s.db.pending, entry.ID)
+	s.db.dead = append([]scored{{id: entry.ID, score: now.UnixNano()}}, removeScored(s.db.dead, entry.ID)...)
+	s.db.deadUntil[entry.ID] = now.Add(ttl)
+
+	return nil
+}
+
+//expireDead drops the dead letters that are past their ttl
+func (s *Outbox) expireDead() {
+	now := time.Now()
+	var kept []scored
+
+	for _, member := range s.db.dead {
+		if now.Before(s.db.deadUntil[member.id]) {
+			kept = append(kept, member)
+			continue
+		}
+
+		delete(s.db.deadUntil, member.id)
+		delete(s.db.outbox, member.id)
+	}
+
+	s.db.dead = kept
+}
+
+//Dead returns the dead letters, latest first
+func (s *Outbox) Dead(ctx context.Context) ([]outbox.Entry, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	s.expireDead()
+
+	entries := []outbox.Entry{}
+
+	for _, member := range s.db.dead {
+		entry := s.db.outbox[member.id]
+		entries = append(entries, copyEntry(&entry))
+	}
+
+	return entries, nil
+}
+
+//Requeue gives a dead letter a fresh set of attempts
+func (s *Outbox) Requeue(ctx context.Context, id string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	s.expireDead()
+
+	dead := removeScored(s.db.dead, id)
+
+	if len(dead) == len(s.db.dead) {
+		return outbox.ErrNotFound
+	}
+
+	s.db.dead = dead
+	delete(s.db.deadUntil, id)
+
+	entry := s.db.outbox[id]
+	entry.Attempts = 0
+	entry.LastError = ""
+	entry.NextAttempt = time.Now()
+
+	s.db.outbox[id] = entry
+	s.db.pending[id] = entry.NextAttempt
+
+	return nil
+}
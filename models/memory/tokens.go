@@ -0,0 +1,412 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/Samuyi/www/models/tokens"
+)
+
+//Tokens is an in-memory session, confirmation key, refresh and reset token, login challenge, lockout and rate limit store
+type Tokens struct {
+	db *DB
+}
+
+//Tokens returns the token store of the database
+func (db *DB) Tokens() *Tokens {
+	return &Tokens{db: db}
+}
+
+//SetSession saves the values of a session for ttl
+func (s *Tokens) SetSession(ctx context.Context, sessionID string, values map[string]interface{}, ttl time.Duration) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	stored, ok := s.db.sessions[sessionID]
+
+	if !ok || time.Now().After(stored.expiresAt) {
+		stored = session{values: map[string]string{}}
+	}
+
+	for k, v := range values {
+		stored.values[k] = fmt.Sprint(v)
+	}
+
+	stored.expiresAt = time.Now().Add(ttl)
+	s.db.sessions[sessionID] = stored
+
+	return nil
+}
+
+//UpdateSession changes values of a session without extending it
+func (s *Tokens) UpdateSession(ctx context.Context, sessionID string, values map[string]interface{}) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	stored, ok := s.db.sessions[sessionID]
+
+	if !ok || time.Now().After(stored.expiresAt) {
+		return tokens.ErrSessionExpired
+	}
+
+	for k, v := range values {
+		stored.values[k] = fmt.Sprint(v)
+	}
+
+	return nil
+}
+
+//ExtendSession changes values of a session and keeps it going for another ttl
+func (s *Tokens) ExtendSession(ctx context.Context, sessionID string, values map[string]interface{}, ttl time.Duration) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	stored, ok := s.db.sessions[sessionID]
+
+	if !ok || time.Now().After(stored.expiresAt) {
+		return tokens.ErrSessionExpired
+	}
+
+	for k, v := range values {
+		stored.values[k] = fmt.Sprint(v)
+	}
+
+	stored.expiresAt = time.Now().Add(ttl)
+	s.db.sessions[sessionID] = stored
+
+	return nil
+}
+
+//GetSession gets the values of a session
+func (s *Tokens) GetSession(ctx context.Context, sessionID string) (map[string]string, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	stored, ok := s.db.sessions[sessionID]
+
+	if !ok || time.Now().After(stored.expiresAt) {
+		return nil, tokens.ErrSessionExpired
+	}
+
+	values := make(map[string]string, len(stored.values))
+
+	for k, v := range stored.values {
+		values[k] = v
+	}
+
+	return values, nil
+}
+
+//DeleteSession ends a session
+func (s *Tokens) DeleteSession(ctx context.Context, sessionID string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	delete(s.db.sessions, sessionID)
+
+	return nil
+}
+
+//GetUserSessions returns the sessions of a user that haven't expired, latest to expire first
+func (s *Tokens) GetUserSessions(ctx context.Context, userID string) ([]tokens.Session, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	sessions := []tokens.Session{}
+
+	for key, stored := range s.db.sessions {
+		if stored.values["userID"] == userID && time.Now().Before(stored.expiresAt) {
+			sessions = append(sessions, tokens.NewSession(key, stored.values, stored.expiresAt))
+		}
+	}
+
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].ExpiresAt.After(sessions[j].ExpiresAt) })
+
+	return sessions, nil
+}
+
+//DeleteUserSessions ends every session of a user
+func (s *Tokens) DeleteUserSessions(ctx context.Context, userID string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	for key, stored := range s.db.sessions {
+		if stored.values["userID"] == userID {
+			delete(s.db.sessions, key)
+		}
+	}
+
+	return nil
+}
+
+//SetConfirmation issues a key for a user to confirm their email with that is valid for ttl
+func (s *Tokens) SetConfirmation(ctx context.Context, userID string, ttl time.Duration) (string, error) {
+	key, err := tokens.Random()
+
+	if err != nil {
+		return "", err
+	}
+
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	s.db.confirmations[tokens.Hash(key)] = reset{userID: userID, expiresAt: time.Now().Add(ttl)}
+
+	return key, nil
+}
+
+//TakeConfirmation returns the user a confirmation key was issued to and deletes the key, so it only works once
+func (s *Tokens) TakeConfirmation(ctx context.Context, key string) (string, error) {
+	if !tokens.Valid(key) {
+		return "", tokens.ErrInvalidKey
+	}
+
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	hash := tokens.Hash(key)
+	stored, ok := s.db.confirmations[hash]
+
+	if !ok {
+		return "", tokens.ErrInvalidKey
+	}
+
+	delete(s.db.confirmations, hash)
+
+	if time.Now().After(stored.expiresAt) {
+		return "", tokens.ErrInvalidKey
+	}
+
+	return stored.userID, nil
+}
+
+//SetReset issues a password reset token for a user that is valid for ttl and replaces any earlier one
+func (s *Tokens) SetReset(ctx context.Context, userID string, ttl time.Duration) (string, error) {
+	token, err := tokens.Random()
+
+	if err != nil {
+		return "", err
+	}
+
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	delete(s.db.resets, s.db.userResets[userID])
+
+	hash := tokens.Hash(token)
+	s.db.resets[hash] = reset{userID: userID, expiresAt: time.Now().Add(ttl)}
+	s.db.userResets[userID] = hash
+
+	return token, nil
+}
+
+//TakeReset returns the user a reset token was issued to and deletes the token, so it only works once
+func (s *Tokens) TakeReset(ctx context.Context, token string) (string, error) {
+	if !tokens.Valid(token) {
+		return "", tokens.ErrInvalidKey
+	}
+
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	hash := tokens.Hash(token)
+	stored, ok := s.db.resets[hash]
+
+	if !ok {
+		return "", tokens.ErrInvalidKey
+	}
+
+	delete(s.db.resets, hash)
+	delete(s.db.userResets, stored.userID)
+
+	if time.Now().After(stored.expiresAt) {
+		return "", tokens.ErrInvalidKey
+	}
+
+	return stored.userID, nil
+}
+
+//SetRefresh issues a refresh token for a session that is valid for ttl
+func (s *Tokens) SetRefresh(ctx context.Context, sessionID string, ttl time.Duration) (string, error) {
+	token, err := tokens.Random()
+
+	if err != nil {
+		return "", err
+	}
+
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	s.db.refreshes[tokens.Hash(token)] = refresh{sessionID: sessionID, expiresAt: time.Now().Add(ttl)}
+
+	return token, nil
+}
+
+//UseRefresh returns the session a refresh token belongs to and marks the token as used. A token that was
+//used before is reported with ErrRefreshReused along with its session.
+func (s *Tokens) UseRefresh(ctx context.Context, token string) (string, error) {
+	if !tokens.Valid(token) {
+		return "", tokens.ErrInvalidKey
+	}
+
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	hash := tokens.Hash(token)
+	stored, ok := s.db.refreshes[hash]
+
+	if !ok || time.Now().After(stored.expiresAt) {
+		return "", tokens.ErrInvalidKey
+	}
+
+	if stored.used {
+		return stored.sessionID, tokens.ErrRefreshReused
+	}
+
+	stored.used = true
+	s.db.refreshes[hash] = stored
+
+	return stored.sessionID, nil
+}
+
+//SetChallenge starts a login that is waiting for the two-factor code of a user, valid for ttl
+func (s *Tokens) SetChallenge(ctx context.Context, userID string, ttl time.Duration) (string, error) {
+	token, err := tokens.Random()
+
+	if err != nil {
+		return "", err
+	}
+
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	s.db.challenges[tokens.Hash(token)] = challenge{userID: userID, expiresAt: time.Now().Add(ttl)}
+
+	return token, nil
+}
+
+//CheckChallenge returns the user a login challenge belongs to and counts a code tried against it.
+//After MaxChallengeAttempts codes the challenge is reported as ErrInvalidKey.
+func (s *Tokens) CheckChallenge(ctx context.Context, token string) (string, error) {
+	if !tokens.Valid(token) {
+		return "", tokens.ErrInvalidKey
+	}
+
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	hash := tokens.Hash(token)
+	stored, ok := s.db.challenges[hash]
+
+	if !ok || time.Now().After(stored.expiresAt) {
+		return "", tokens.ErrInvalidKey
+	}
+
+	stored.attempts++
+
+	if stored.attempts > tokens.MaxChallengeAttempts {
+		delete(s.db.challenges, hash)
+		return "", tokens.ErrInvalidKey
+	}
+
+	s.db.challenges[hash] = stored
+
+	return stored.userID, nil
+}
+
+//DeleteChallenge ends a login challenge once a code was accepted
+func (s *Tokens) DeleteChallenge(ctx context.Context, token string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	delete(s.db.challenges, tokens.Hash(token))
+
+	return nil
+}
+
+//AddFailure counts a failed login for key and returns how many there have been.
+//Failures are forgotten once there hasn't been one for window.
+func (s *Tokens) AddFailure(ctx context.Context, key string, window time.Duration) (int64, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	stored := s.db.failures[key]
+
+	if time.Now().After(stored.expiresAt) {
+		stored = failures{}
+	}
+
+	stored.count++
+	stored.expiresAt = time.Now().Add(window)
+	s.db.failures[key] = stored
+
+	return stored.count, nil
+}
+
+//Lock stops logins for key for ttl
+func (s *Tokens) Lock(ctx context.Context, key string, ttl time.Duration) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	s.db.locks[key] = time.Now().Add(ttl)
+
+	return nil
+}
+
+//LockedFor returns how much longer logins for key are stopped, 0 when they aren't
+func (s *Tokens) LockedFor(ctx context.Context, key string) (time.Duration, error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	left := time.Until(s.db.locks[key])
+
+	if left < 0 {
+		return 0, nil
+	}
+
+	return left, nil
+}
+
+//Unlock lifts the lock on key and forgets its failures
+func (s *Tokens) Unlock(ctx context.Context, key string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	delete(s.db.locks, key)
+	delete(s.db.failures, key)
+
+	return nil
+}
+
+//Take counts a request against key when fewer than limit were made in the last window
+func (s *Tokens) Take(ctx context.Context, key string, limit int, window time.Duration) (tokens.Rate, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	now := time.Now()
+	hits := s.db.hits[key][:0]
+
+	for _, hit := range s.db.hits[key] {
+		if now.Sub(hit) < window {
+			hits = append(hits, hit)
+		}
+	}
+
+	allowed := len(hits) < limit
+
+	if allowed {
+		hits = append(hits, now)
+	}
+
+	s.db.hits[key] = hits
+
+	reset := window
+
+	if len(hits) > 0 {
+		reset = hits[0].Add(window).Sub(now)
+	}
+
+	return tokens.Rate{Allowed: allowed, Remaining: limit - len(hits), Reset: reset}, nil
+}
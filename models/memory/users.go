@@ -0,0 +1,421 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"github.com/Samuyi/www/models/page"
+	"github.com/Samuyi/www/models/users"
+	"github.com/Samuyi/www/utilities"
+)
+
+//Users is an in-memory user store
+type Users struct {
+	db *DB
+}
+
+//Users returns the user store of the database
+func (db *DB) Users() *Users {
+	return &Users{db: db}
+}
+
+//Create a user
+func (s *Users) Create(ctx context.Context, user *users.User) error {
+	password, err := utilities.HashPassword(user.Password)
+
+	if err != nil {
+		return err
+	}
+
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	for _, existing := range s.db.users {
+		if existing.Email == user.Email {
+			return users.ErrEmailTaken
+		}
+
+		if existing.DisplayName == user.DisplayName {
+			return users.ErrDisplayNameTaken
+		}
+	}
+
+	user.ID = newID()
+
+	stored := *user
+	stored.Password = password
+	stored.Items = nil
+	stored.CreatedAt = time.Now()
+	stored.UpdatedAt = time.Time{}
+
+	if stored.Locale == "" {
+		stored.Locale = "en"
+	}
+
+	stored.Role = users.RoleUser
+
+	user.Locale = stored.Locale
+	user.Role = stored.Role
+
+	s.db.users[user.ID] = stored
+
+	return nil
+}
+
+//Get a user by id
+func (s *Users) Get(ctx context.Context, user *users.User) error {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	stored, ok := s.db.users[user.ID]
+
+	if !ok {
+		return errNoRows
+	}
+
+	user.FirstName = stored.FirstName
+	user.LastName = stored.LastName
+	user.DisplayName = stored.DisplayName
+	user.Email = stored.Email
+	user.Ratings = stored.Ratings
+	user.Active = stored.Active
+	user.Password = stored.Password
+	user.Locale = stored.Locale
+	user.Role = stored.Role
+	user.TwoFactor = s.db.twoFactor[user.ID].Enabled
+	user.CreatedAt = stored.CreatedAt
+
+	return nil
+}
+
+//GetByName gets a user by display name
+func (s *Users) GetByName(ctx context.Context, user *users.User) error {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	for _, stored := range s.db.users {
+		if stored.DisplayName == user.DisplayName {
+			user.FirstName = stored.FirstName
+			user.LastName = stored.LastName
+			user.Email = stored.Email
+			user.Ratings = stored.Ratings
+			user.Avatar = stored.Avatar
+			user.Active = stored.Active
+			user.CreatedAt = stored.CreatedAt
+
+			return nil
+		}
+	}
+
+	return errNoRows
+}
+
+//GetByEmail gets the id and password hash of a user by email
+func (s *Users) GetByEmail(ctx context.Context, user *users.User) error {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	for _, stored := range s.db.users {
+		if stored.Email == user.Email {
+			user.ID = stored.ID
+			user.Password = stored.Password
+			user.Active = stored.Active
+			user.DisplayName = stored.DisplayName
+			user.FirstName = stored.FirstName
+			user.LastName = stored.LastName
+			user.Avatar = stored.Avatar
+			user.Locale = stored.Locale
+			user.Role = stored.Role
+			user.TwoFactor = s.db.twoFactor[stored.ID].Enabled
+
+			return nil
+		}
+	}
+
+	return errNoRows
+}
+
+//Update the names and, when set, the locale and password of a user
+func (s *Users) Update(ctx context.Context, user *users.User) error {
+	var password string
+
+	if user.Password != "" {
+		var err error
+		password, err = utilities.HashPassword(user.Password)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	stored, ok := s.db.users[user.ID]
+
+	if !ok {
+		return nil
+	}
+
+	for id, existing := range s.db.users {
+		if id != user.ID && existing.DisplayName == user.DisplayName {
+			return users.ErrDisplayNameTaken
+		}
+	}
+
+	user.UpdatedAt = time.Now()
+
+	stored.FirstName = user.FirstName
+	stored.LastName = user.LastName
+	stored.DisplayName = user.DisplayName
+	stored.UpdatedAt = user.UpdatedAt
+
+	if user.Locale != "" {
+		stored.Locale = user.Locale
+	}
+
+	if password != "" {
+		stored.Password = password
+	}
+
+	s.db.users[user.ID] = stored
+
+	return nil
+}
+
+//UpdatePassword of a user
+func (s *Users) UpdatePassword(ctx context.Context, user *users.User) error {
+	password, err := utilities.HashPassword(user.Password)
+
+	if err != nil {
+		return err
+	}
+
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	user.UpdatedAt = time.Now()
+
+	if stored, ok := s.db.users[user.ID]; ok {
+		stored.Password = password
+		stored.UpdatedAt = user.UpdatedAt
+		s.db.users[user.ID] = stored
+	}
+
+	return nil
+}
+
+//SetActive makes a user active on the network
+func (s *Users) SetActive(ctx context.Context, user *users.User) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	user.UpdatedAt = time.Now()
+	user.Active = true
+
+	if stored, ok := s.db.users[user.ID]; ok {
+		stored.Active = true
+		stored.UpdatedAt = user.UpdatedAt
+		s.db.users[user.ID] = stored
+	}
+
+	return nil
+}
+
+//SetRole changes the role of a user
+func (s *Users) SetRole(ctx context.Context, user *users.User) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	user.UpdatedAt = time.Now()
+
+	if stored, ok := s.db.users[user.ID]; ok {
+		stored.Role = user.Role
+		stored.UpdatedAt = user.UpdatedAt
+		s.db.users[user.ID] = stored
+	}
+
+	return nil
+}
+
+//SetAvatar changes the key of the avatar of a user, returning the key of the one it replaces
+func (s *Users) SetAvatar(ctx context.Context, user *users.User) (string, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	stored, ok := s.db.users[user.ID]
+
+	if !ok {
+		return "", errNoRows
+	}
+
+	previous := stored.Avatar
+	user.UpdatedAt = time.Now()
+	stored.Avatar = user.Avatar
+	stored.UpdatedAt = user.UpdatedAt
+	s.db.users[user.ID] = stored
+
+	return previous, nil
+}
+
+//Delete a user along with their items and locations
+func (s *Users) Delete(ctx context.Context, user *users.User) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	delete(s.db.users, user.ID)
+	delete(s.db.twoFactor, user.ID)
+
+	for id, item := range s.db.items {
+		if item.UserID == user.ID {
+			s.db.deleteItem(id)
+		} else if item.RecipientID == user.ID {
+			item.RecipientID = ""
+			s.db.items[id] = item
+		}
+	}
+
+	for i, transition := range s.db.history {
+		if transition.UserID == user.ID {
+			s.db.history[i].UserID = ""
+		}
+
+		if transition.RecipientID == user.ID {
+			s.db.history[i].RecipientID = ""
+		}
+	}
+
+	for id, location := range s.db.locations {
+		if location.UserID == user.ID {
+			delete(s.db.locations, id)
+		}
+	}
+
+	return nil
+}
+
+//GetAll gets the page q asks for of the users that match filter
+func (s *Users) GetAll(ctx context.Context, filter users.Filter, q page.Query) (page.Page[users.User], error) {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	var all []users.User
+
+	for _, stored := range s.db.users {
+		if filter.Role != "" && stored.Role != filter.Role {
+			continue
+		}
+
+		all = append(all, users.User{
+			ID:          stored.ID,
+			DisplayName: stored.DisplayName,
+			Email:       stored.Email,
+			Ratings:     stored.Ratings,
+			Avatar:      stored.Avatar,
+			Role:        stored.Role,
+			CreatedAt:   stored.CreatedAt,
+		})
+	}
+
+	return page.Slice(all, q, users.Key), nil
+}
+
+//SetTwoFactor starts enrolling a user in two-factor authentication with a new secret and recovery codes,
+//replacing any enrolment that wasn't finished
+func (s *Users) SetTwoFactor(ctx context.Context, tf *users.TwoFactor, recoveryHashes []string) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	if s.db.twoFactor[tf.UserID].Enabled {
+		return users.ErrTwoFactorEnabled
+	}
+
+	tf.Enabled = false
+	tf.LastCounter = 0
+
+	stored := twoFactor{TwoFactor: *tf, recovery: map[string]bool{}}
+
+	for _, hash := range recoveryHashes {
+		stored.recovery[hash] = true
+	}
+
+	s.db.twoFactor[tf.UserID] = stored
+
+	return nil
+}
+
+//GetTwoFactor fetches the two-factor secret of a user, sql.ErrNoRows when they never enrolled
+func (s *Users) GetTwoFactor(ctx context.Context, tf *users.TwoFactor) error {
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+
+	stored, ok := s.db.twoFactor[tf.UserID]
+
+	if !ok {
+		return errNoRows
+	}
+
+	*tf = stored.TwoFactor
+
+	return nil
+}
+
+//EnableTwoFactor finishes enrolment, from then on the user needs a code to log in
+func (s *Users) EnableTwoFactor(ctx context.Context, tf *users.TwoFactor) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	if stored, ok := s.db.twoFactor[tf.UserID]; ok {
+		stored.Enabled = true
+		s.db.twoFactor[tf.UserID] = stored
+	}
+
+	tf.Enabled = true
+
+	return nil
+}
+
+//DisableTwoFactor removes the secret and recovery codes of a user
+func (s *Users) DisableTwoFactor(ctx context.Context, tf *users.TwoFactor) error {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	delete(s.db.twoFactor, tf.UserID)
+	tf.Enabled = false
+
+	return nil
+}
+
+//UseCounter records that the code of step counter was used, reporting false when that step or a later one was used already
+func (s *Users) UseCounter(ctx context.Context, tf *users.TwoFactor, counter int64) (bool, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	stored, ok := s.db.twoFactor[tf.UserID]
+
+	if !ok || stored.LastCounter >= counter {
+		return false, nil
+	}
+
+	stored.LastCounter = counter
+	s.db.twoFactor[tf.UserID] = stored
+	tf.LastCounter = counter
+
+	return true, nil
+}
+
+//UseRecoveryCode deletes the recovery code of a user with hash, reporting false when there is none
+func (s *Users) UseRecoveryCode(ctx context.Context, tf *users.TwoFactor, hash string) (bool, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+
+	stored, ok := s.db.twoFactor[tf.UserID]
+
+	if !ok || !stored.recovery[hash] {
+		return false, nil
+	}
+
+	delete(stored.recovery, hash)
+
+	return true, nil
+}
@@ -0,0 +1,260 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/Samuyi/www/models/bids"
+	"github.com/Samuyi/www/models/categories"
+	"github.com/Samuyi/www/models/comments"
+	"github.com/Samuyi/www/models/items"
+	"github.com/Samuyi/www/models/locations"
+	"github.com/Samuyi/www/models/memory"
+	"github.com/Samuyi/www/models/outbox"
+	"github.com/Samuyi/www/models/page"
+	"github.com/Samuyi/www/models/tokens"
+	"github.com/Samuyi/www/models/users"
+	"github.com/go-redis/redis"
+)
+
+//UserStore persists users
+type UserStore interface {
+	Create(ctx context.Context, user *users.User) error
+	Get(ctx context.Context, user *users.User) error
+	GetByName(ctx context.Context, user *users.User) error
+	GetByEmail(ctx context.Context, user *users.User) error
+	Update(ctx context.Context, user *users.User) error
+	UpdatePassword(ctx context.Context, user *users.User) error
+	SetActive(ctx context.Context, user *users.User) error
+	SetRole(ctx context.Context, user *users.User) error
+	SetAvatar(ctx context.Context, user *users.User) (string, error)
+	Delete(ctx context.Context, user *users.User) error
+	GetAll(ctx context.Context, filter users.Filter, q page.Query) (page.Page[users.User], error)
+}
+
+//TwoFactorStore persists the authenticator secrets and recovery codes of users
+type TwoFactorStore interface {
+	SetTwoFactor(ctx context.Context, tf *users.TwoFactor, recoveryHashes []string) error
+	GetTwoFactor(ctx context.Context, tf *users.TwoFactor) error
+	EnableTwoFactor(ctx context.Context, tf *users.TwoFactor) error
+	DisableTwoFactor(ctx context.Context, tf *users.TwoFactor) error
+	UseCounter(ctx context.Context, tf *users.TwoFactor, counter int64) (bool, error)
+	UseRecoveryCode(ctx context.Context, tf *users.TwoFactor, hash string) (bool, error)
+}
+
+//ItemStore persists items
+type ItemStore interface {
+	Create(ctx context.Context, item *items.Item) error
+	Get(ctx context.Context, item *items.Item) error
+	Update(ctx context.Context, item *items.Item) error
+	Delete(ctx context.Context, item *items.Item) error
+	ItemsInALocation(ctx context.Context, locationID string, filter items.Filter, q page.Query) (page.Page[items.Item], error)
+	GetAllItems(ctx context.Context, filter items.Filter, q page.Query) (page.Page[items.Item], error)
+	GetUserItems(ctx context.Context, userID string) ([]items.Item, error)
+	Search(ctx context.Context, search string, filter items.Filter, q page.Query) (page.Page[items.Item], error)
+	AddImage(ctx context.Context, image *items.Image, max int) error
+	RemoveImage(ctx context.Context, image *items.Image) error
+	Images(ctx context.Context, ids []string) (map[string][]items.Image, error)
+	Transition(ctx context.Context, item *items.Item, transition *items.Transition) error
+	History(ctx context.Context, itemID string) ([]items.Transition, error)
+	Expire(ctx context.Context, before time.Time) ([]items.Item, error)
+}
+
+//LocationStore persists locations
+type LocationStore interface {
+	Create(ctx context.Context, location *locations.Location) error
+	Get(ctx context.Context, location *locations.Location) error
+	Update(ctx context.Context, location *locations.Location, changes map[string]string) error
+	Delete(ctx context.Context, location *locations.Location) error
+	GetAll(ctx context.Context, filter locations.Filter, q page.Query) (page.Page[locations.Location], error)
+}
+
+//CategoryStore persists the tree of categories items are sorted into
+type CategoryStore interface {
+	Create(ctx context.Context, category *categories.Category) error
+	Get(ctx context.Context, category *categories.Category) error
+	Update(ctx context.Context, category *categories.Category) error
+	Delete(ctx context.Context, category *categories.Category) error
+	GetAll(ctx context.Context) ([]categories.Category, error)
+	CountItems(ctx context.Context, locationID string) (map[string]int, error)
+}
+
+//CommentStore persists comments and their replies
+type CommentStore interface {
+	Create(ctx context.Context, comment *comments.Comment) error
+	Get(ctx context.Context, comment *comments.Comment) error
+	GetReplies(ctx context.Context, comment *comments.Comment) error
+	GetItemComments(ctx context.Context, itemID string, q page.Query) (page.Page[comments.Comment], error)
+	Update(ctx context.Context, comment *comments.Comment) error
+	Delete(ctx context.Context, comment *comments.Comment) error
+	CreateReply(ctx context.Context, reply *comments.Reply) error
+	GetReply(ctx context.Context, reply *comments.Reply) error
+	UpdateReply(ctx context.Context, reply *comments.Reply) error
+	DeleteReply(ctx context.Context, reply *comments.Reply) error
+}
+
+//BidStore persists bids on items
+type BidStore interface {
+	Place(ctx context.Context, bid *bids.Bid) error
+	GetItemBids(ctx context.Context, itemID string) ([]bids.Bid, error)
+}
+
+//SessionStore persists login sessions and keeps an index of the sessions of each user
+type SessionStore interface {
+	SetSession(ctx context.Context, sessionID string, values map[string]interface{}, ttl time.Duration) error
+	UpdateSession(ctx context.Context, sessionID string, values map[string]interface{}) error
+	ExtendSession(ctx context.Context, sessionID string, values map[string]interface{}, ttl time.Duration) error
+	GetSession(ctx context.Context, sessionID string) (map[string]string, error)
+	DeleteSession(ctx context.Context, sessionID string) error
+	GetUserSessions(ctx context.Context, userID string) ([]tokens.Session, error)
+	DeleteUserSessions(ctx context.Context, userID string) error
+}
+
+//ConfirmationStore persists the keys sent to users to confirm their email
+type ConfirmationStore interface {
+	SetConfirmation(ctx context.Context, userID string, ttl time.Duration) (string, error)
+	TakeConfirmation(ctx context.Context, key string) (string, error)
+}
+
+//RefreshStore persists refresh tokens, remembering used ones so a copied token can be spotted
+type RefreshStore interface {
+	SetRefresh(ctx context.Context, sessionID string, ttl time.Duration) (string, error)
+	UseRefresh(ctx context.Context, token string) (string, error)
+}
+
+//ResetStore persists the tokens sent to users to reset their password
+type ResetStore interface {
+	SetReset(ctx context.Context, userID string, ttl time.Duration) (string, error)
+	TakeReset(ctx context.Context, token string) (string, error)
+}
+
+//ChallengeStore persists the logins waiting for a two-factor code, limiting how many codes each can try
+type ChallengeStore interface {
+	SetChallenge(ctx context.Context, userID string, ttl time.Duration) (string, error)
+	CheckChallenge(ctx context.Context, token string) (string, error)
+	DeleteChallenge(ctx context.Context, token string) error
+}
+
+//LockoutStore counts failed logins by account and by address and locks out the ones that fail too often
+type LockoutStore interface {
+	AddFailure(ctx context.Context, key string, window time.Duration) (int64, error)
+	Lock(ctx context.Context, key string, ttl time.Duration) error
+	LockedFor(ctx context.Context, key string) (time.Duration, error)
+	Unlock(ctx context.Context, key string) error
+}
+
+//RateLimitStore counts the requests clients make so they can be limited
+type RateLimitStore interface {
+	Take(ctx context.Context, key string, limit int, window time.Duration) (tokens.Rate, error)
+}
+
+//OutboxStore persists emails until they are delivered
+type OutboxStore interface {
+	Enqueue(ctx context.Context, entry *outbox.Entry) error
+	Claim(ctx context.Context, lease time.Duration) (*outbox.Entry, error)
+	Complete(ctx context.Context, entry *outbox.Entry) error
+	Retry(ctx context.Context, entry *outbox.Entry, next time.Time) error
+	Bury(ctx context.Context, entry *outbox.Entry, ttl time.Duration) error
+	Dead(ctx context.Context) ([]outbox.Entry, error)
+	Requeue(ctx context.Context, id string) error
+}
+
+var (
+	_ UserStore         = (*users.Store)(nil)
+	_ UserStore         = (*memory.Users)(nil)
+	_ TwoFactorStore    = (*users.Store)(nil)
+	_ TwoFactorStore    = (*memory.Users)(nil)
+	_ ItemStore         = (*items.Store)(nil)
+	_ ItemStore         = (*memory.Items)(nil)
+	_ LocationStore     = (*locations.Store)(nil)
+	_ LocationStore     = (*memory.Locations)(nil)
+	_ CategoryStore     = (*categories.Store)(nil)
+	_ CategoryStore     = (*memory.Categories)(nil)
+	_ CommentStore      = (*comments.Store)(nil)
+	_ CommentStore      = (*memory.Comments)(nil)
+	_ BidStore          = (*bids.Store)(nil)
+	_ BidStore          = (*memory.Bids)(nil)
+	_ SessionStore      = (*tokens.Store)(nil)
+	_ SessionStore      = (*memory.Tokens)(nil)
+	_ ConfirmationStore = (*tokens.Store)(nil)
+	_ ConfirmationStore = (*memory.Tokens)(nil)
+	_ RefreshStore      = (*tokens.Store)(nil)
+	_ RefreshStore      = (*memory.Tokens)(nil)
+	_ ResetStore        = (*tokens.Store)(nil)
+	_ ResetStore        = (*memory.Tokens)(nil)
+	_ ChallengeStore    = (*tokens.Store)(nil)
+	_ ChallengeStore    = (*memory.Tokens)(nil)
+	_ LockoutStore      = (*tokens.Store)(nil)
+	_ LockoutStore      = (*memory.Tokens)(nil)
+	_ RateLimitStore    = (*tokens.Store)(nil)
+	_ RateLimitStore    = (*memory.Tokens)(nil)
+	_ OutboxStore       = (*outbox.Store)(nil)
+	_ OutboxStore       = (*memory.Outbox)(nil)
+)
+
+//Stores bundles every store the application uses
+type Stores struct {
+	Users         UserStore
+	TwoFactor     TwoFactorStore
+	Items         ItemStore
+	Locations     LocationStore
+	Categories    CategoryStore
+	Comments      CommentStore
+	Bids          BidStore
+	Sessions      SessionStore
+	Confirmations ConfirmationStore
+	Refreshes     RefreshStore
+	Resets        ResetStore
+	Challenges    ChallengeStore
+	Lockouts      LockoutStore
+	RateLimits    RateLimitStore
+	Outbox        OutboxStore
+}
+
+//NewStores returns the stores backed by postgres and redis
+func NewStores(db *sql.DB, client *redis.Client) *Stores {
+	tokenStore := tokens.NewStore(client)
+	userStore := users.NewStore(db)
+
+	return &Stores{
+		Users:         userStore,
+		TwoFactor:     userStore,
+		Items:         items.NewStore(db),
+		Locations:     locations.NewStore(db),
+		Categories:    categories.NewStore(db),
+		Comments:      comments.NewStore(client),
+		Bids:          bids.NewStore(client),
+		Sessions:      tokenStore,
+		Confirmations: tokenStore,
+		Refreshes:     tokenStore,
+		Resets:        tokenStore,
+		Challenges:    tokenStore,
+		Lockouts:      tokenStore,
+		RateLimits:    tokenStore,
+		Outbox:        outbox.NewStore(client),
+	}
+}
+
+//NewMemoryStores returns stores that keep everything in memory, for tests and demos
+func NewMemoryStores() *Stores {
+	db := memory.New()
+
+	return &Stores{
+		Users:         db.Users(),
+		TwoFactor:     db.Users(),
+		Items:         db.Items(),
+		Locations:     db.Locations(),
+		Categories:    db.Categories(),
+		Comments:      db.Comments(),
+		Bids:          db.Bids(),
+		Sessions:      db.Tokens(),
+		Confirmations: db.Tokens(),
+		Refreshes:     db.Tokens(),
+		Resets:        db.Tokens(),
+		Challenges:    db.Tokens(),
+		Lockouts:      db.Tokens(),
+		RateLimits:    db.Tokens(),
+		Outbox:        db.Outbox(),
+	}
+}
@@ -0,0 +1,258 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/Samuyi/www/email"
+	"github.com/Samuyi/www/logging"
+	"github.com/go-redis/redis"
+	uuid "github.com/satori/go.uuid"
+)
+
+//ErrNotFound is returned when an entry isn't in the outbox, or isn't dead when requeueing
+var ErrNotFound = errors.New("Sorry that message isn't in the outbox")
+
+//Entry is an email waiting to be delivered
+type Entry struct {
+	ID          string        `json:"id"`
+	Message     email.Message `json:"message"`
+	Attempts    int           `json:"attempts"`
+	LastError   string        `json:"last_error,omitempty"`
+	CreatedAt   time.Time     `json:"created_at"`
+	NextAttempt time.Time     `json:"next_attempt"`
+}
+
+const (
+	pendingKey = "outbox:pending"
+	deadKey    = "outbox:dead"
+)
+
+func entryKey(id string) string {
+	return "outbox:" + id
+}
+
+//Store keeps the outbox in redis. Pending entries sit in a sorted set scored by when they are next due,
+//dead ones in a sorted set scored by when they were given up on.
+type Store struct {
+	client *redis.Client
+}
+
+//NewStore returns an outbox backed by client
+func NewStore(client *redis.Client) *Store {
+	return &Store{client: client}
+}
+
+func score(t time.Time) float64 {
+	return float64(t.UnixNano() / int64(time.Millisecond))
+}
+
+//save keeps entry for ttl, or until it is removed when ttl is 0
+func (s *Store) save(entry *Entry, ttl time.Duration) error {
+	body, err := json.Marshal(entry)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = s.client.Set(entryKey(entry.ID), body, ttl).Result()
+
+	return err
+}
+
+//Enqueue adds a new entry that is due straight away
+func (s *Store) Enqueue(ctx context.Context, entry *Entry) error {
+	entry.ID = uuid.Must(uuid.NewV4()).String()
+	entry.CreatedAt = time.Now()
+	entry.NextAttempt = entry.CreatedAt
+
+	if err := s.save(entry, 0); err != nil {
+		logging.From(ctx).Error("outbox.Enqueue", "err", err)
+		return err
+	}
+
+	_, err := s.client.ZAdd(pendingKey, redis.Z{Score: score(entry.NextAttempt), Member: entry.ID}).Result()
+
+	if err != nil {
+		logging.From(ctx).Error("outbox.Enqueue", "err", err)
+		return err
+	}
+
+	return nil
+}
+
+// claim takes the first due entry and pushes it back by the lease, so it is retried if the worker dies
+var claim = redis.NewScript(`
+local due = redis.call("ZRANGEBYSCORE", KEYS[1], "-inf", ARGV[1], "LIMIT", 0, 1)
+if #due == 0 then
+	return false
+end
+redis.call("ZADD", KEYS[1], ARGV[2], due[1])
+return due[1]
+`)
+
+//Claim returns the next due entry and hides it from other workers for lease, or nil when nothing is due
+func (s *Store) Claim(ctx context.Context, lease time.Duration) (*Entry, error) {
+	now := time.Now()
+
+	id, err := claim.Run(s.client, []string{pendingKey}, strconv.FormatFloat(score(now), 'f', 0, 64), strconv.FormatFloat(score(now.Add(lease)), 'f', 0, 64)).String()
+
+	if err == redis.Nil {
+		return nil, nil
+	}
+
+	if err != nil {
+		logging.From(ctx).Error("outbox.Claim", "err", err)
+		return nil, err
+	}
+
+	body, err := s.client.Get(entryKey(id)).Bytes()
+
+	if err == redis.Nil {
+		// the entry was lost, there's nothing left to send
+		s.client.ZRem(pendingKey, id)
+		return nil, nil
+	}
+
+	if err != nil {
+		logging.From(ctx).Error("outbox.Claim", "err", err)
+		return nil, err
+	}
+
+	var entry Entry
+
+	if err = json.Unmarshal(body, &entry); err != nil {
+		return nil, err
+	}
+
+	return &entry, nil
+}
+
+//Complete removes a delivered entry
+func (s *Store) Complete(ctx context.Context, entry *Entry) error {
+	pipe := s.client.TxPipeline()
+	pipe.ZRem(pendingKey, entry.ID)
+	pipe.Del(entryKey(entry.ID))
+
+	if _, err := pipe.Exec(); err != nil {
+		logging.From(ctx).Error("outbox.Complete", "err", err)
+		return err
+	}
+
+	return nil
+}
+
+//Retry saves the failed attempt on entry and schedules it again at next
+func (s *Store) Retry(ctx context.Context, entry *Entry, next time.Time) error {
+	entry.NextAttempt = next
+
+	if err := s.save(entry, 0); err != nil {
+		logging.From(ctx).Error("outbox.Retry", "err", err)
+		return err
+	}
+
+	_, err := s.client.ZAdd(pendingKey, redis.Z{Score: score(next), Member: entry.ID}).Result()
+
+	if err != nil {
+		logging.From(ctx).Error("outbox.Retry", "err", err)
+		return err
+	}
+
+	return nil
+}
+
+//Bury moves entry to the dead letters, where it is kept for ttl. Messages hold live links, such as to reset a
+//password, so they aren't kept for good.
+func (s *Store) Bury(ctx context.Context, entry *Entry, ttl time.Duration) error {
+	if err := s.save(entry, ttl); err != nil {
+		logging.From(ctx).Error("outbox.Bury", "err", err)
+		return err
+	}
+
+	pipe := s.client.TxPipeline()
+	pipe.ZRem(pendingKey, entry.ID)
+	pipe.ZAdd(deadKey, redis.Z{Score: score(time.Now()), Member: entry.ID})
+
+	if _, err := pipe.Exec(); err != nil {
+		logging.From(ctx).Error("outbox.Bury", "err", err)
+		return err
+	}
+
+	return nil
+}
+
+//Dead returns the dead letters, latest first
+func (s *Store) Dead(ctx context.Context) ([]Entry, error) {
+	ids, err := s.client.ZRevRange(deadKey, 0, -1).Result()
+
+	if err != nil {
+		logging.From(ctx).Error("outbox.Dead", "err", err)
+		return nil, err
+	}
+
+	entries := []Entry{}
+
+	for _, id := range ids {
+		body, err := s.client.Get(entryKey(id)).Bytes()
+
+		if err == redis.Nil {
+			// the dead letter expired
+			s.client.ZRem(deadKey, id)
+			continue
+		}
+
+		if err != nil {
+			logging.From(ctx).Error("outbox.Dead", "err", err)
+			return nil, err
+		}
+
+		var entry Entry
+
+		if err = json.Unmarshal(body, &entry); err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+//Requeue gives a dead letter a fresh set of attempts
+func (s *Store) Requeue(ctx context.Context, id string) error {
+	removed, err := s.client.ZRem(deadKey, id).Result()
+
+	if err != nil {
+		logging.From(ctx).Error("outbox.Requeue", "err", err)
+		return err
+	}
+
+	if removed == 0 {
+		return ErrNotFound
+	}
+
+	body, err := s.client.Get(entryKey(id)).Bytes()
+
+	if err == redis.Nil {
+		return ErrNotFound
+	}
+
+	if err != nil {
+		logging.From(ctx).Error("outbox.Requeue", "err", err)
+		return err
+	}
+
+	var entry Entry
+
+	if err = json.Unmarshal(body, &entry); err != nil {
+		return err
+	}
+
+	entry.Attempts = 0
+	entry.LastError = ""
+
+	return s.Retry(ctx, &entry, time.Now())
+}
@@ -0,0 +1,220 @@
+//Package page splits lists into pages. Lists are ordered by when their rows were created, or by how well they match a
+//search, ties broken by id, and every page but the last ends with an opaque cursor to fetch the next one from. Cursors point at a row rather than
+//an offset, so rows added or removed while a client pages through a list don't shift what comes next.
+package page
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	validator "github.com/asaskevich/govalidator"
+)
+
+//DefaultLimit is the size of a page when clients don't ask for one and MaxLimit the largest they can ask for
+const (
+	DefaultLimit = 20
+	MaxLimit     = 100
+)
+
+//Sort is the order of a list
+type Sort string
+
+//The orders lists can be sorted in. Only search results can be sorted by Relevance, the best matches first.
+const (
+	Newest    Sort = "newest"
+	Oldest    Sort = "oldest"
+	Relevance Sort = "relevance"
+)
+
+//ErrInvalidCursor is returned for cursors that weren't made by Encode, or were made for a list in another order
+var ErrInvalidCursor = errors.New("Sorry that cursor is invalid, please start again from the first page")
+
+//Cursor is the position of a row in a list. Rank is how well the row matches a search, in lists sorted by Relevance.
+type Cursor struct {
+	CreatedAt time.Time
+	ID        string
+	Rank      float64
+}
+
+//before tells whether c comes before other in a list sorted by s
+func (c Cursor) before(other Cursor, s Sort) bool {
+	if s == Relevance && c.Rank != other.Rank {
+		return c.Rank > other.Rank
+	}
+
+	if s != Relevance && !c.CreatedAt.Equal(other.CreatedAt) {
+		return c.CreatedAt.Before(other.CreatedAt) == (s == Oldest)
+	}
+
+	if c.ID == other.ID {
+		return false
+	}
+
+	return (c.ID < other.ID) == (s == Oldest)
+}
+
+//cursor is what an encoded Cursor holds, along with the order it was made for
+type cursor struct {
+	CreatedAt time.Time `json:"t"`
+	ID        string    `json:"id"`
+	Rank      float64   `json:"r,omitempty"`
+	Sort      Sort      `json:"s"`
+}
+
+//Query asks for the page of a list that starts after the row at After, or the first page when After is nil.
+//Lists only hold rows created after CreatedAfter, unless it is zero.
+type Query struct {
+	Limit        int
+	Sort         Sort
+	After        *Cursor
+	CreatedAfter time.Time
+}
+
+//Encode returns the opaque form of c sent to clients
+func (q Query) Encode(c Cursor) string {
+	b, _ := json.Marshal(cursor{CreatedAt: c.CreatedAt, ID: c.ID, Rank: c.Rank, Sort: q.Sort})
+
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+//Decode reads a cursor sent by a client, which has to be for a list in the order of q and point at a row by its uuid
+func (q Query) Decode(value string) (Cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(value)
+
+	if err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+
+	var c cursor
+
+	if err := json.Unmarshal(b, &c); err != nil || !validator.IsUUID(c.ID) || c.Sort != q.Sort {
+		return Cursor{}, ErrInvalidCursor
+	}
+
+	return Cursor{CreatedAt: c.CreatedAt, ID: c.ID, Rank: c.Rank}, nil
+}
+
+//Parse reads the limit, sort, cursor and created_after parameters of a request for a list sorted by fallback unless
+//it asks otherwise. Lists can only be sorted by Relevance when that is their fallback. Like Validate on the models, it says what is wrong with each invalid parameter under its name in
+//problems, which callers share with the parameters they read themselves.
+func Parse(values url.Values, fallback Sort, problems map[string]string) Query {
+	q := Query{Limit: DefaultLimit, Sort: fallback}
+
+	if limit := values.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+
+		if err != nil || n < 1 || n > MaxLimit {
+			problems["limit"] = "Please supply a limit between 1 and " + strconv.Itoa(MaxLimit)
+		}
+
+		q.Limit = n
+	}
+
+	switch s := Sort(values.Get("sort")); {
+	case s == "":
+	case s == Newest, s == Oldest, s == Relevance && fallback == Relevance:
+		q.Sort = s
+	case fallback == Relevance:
+		problems["sort"] = "Please sort by relevance, newest or oldest"
+	default:
+		problems["sort"] = "Please sort by newest or oldest"
+	}
+
+	if value := values.Get("cursor"); value != "" {
+		c, err := q.Decode(value)
+
+		if err != nil {
+			problems["cursor"] = err.Error()
+		}
+
+		q.After = &c
+	}
+
+	if value := values.Get("created_after"); value != "" {
+		t, err := time.Parse(time.RFC3339, value)
+
+		if err != nil {
+			problems["created_after"] = "Please supply a time such as 2006-01-02T15:04:05Z"
+		}
+
+		q.CreatedAfter = t
+	}
+
+	return q
+}
+
+//Page is one page of a list
+type Page[T any] struct {
+	Items      []T    `json:"items"`
+	NextCursor string `json:"next_cursor,omitempty"`
+	HasMore    bool   `json:"has_more"`
+}
+
+//New makes a page of rows, which are fetched in order with one row more than q.Limit so New can tell
+//whether more follow. key returns the position of a row.
+func New[T any](rows []T, q Query, key func(T) Cursor) Page[T] {
+	p := Page[T]{Items: rows}
+
+	if len(rows) > q.Limit {
+		p.Items = rows[:q.Limit]
+		p.HasMore = true
+		p.NextCursor = q.Encode(key(p.Items[len(p.Items)-1]))
+	}
+
+	if p.Items == nil {
+		p.Items = []T{}
+	}
+
+	return p
+}
+
+//Slice returns the page q asks for from every row of a list, in any order. It pages lists kept in memory.
+func Slice[T any](rows []T, q Query, key func(T) Cursor) Page[T] {
+	if !q.CreatedAfter.IsZero() {
+		var after []T
+
+		for _, row := range rows {
+			if key(row).CreatedAt.After(q.CreatedAfter) {
+				after = append(after, row)
+			}
+		}
+
+		rows = after
+	}
+
+	sort.Slice(rows, func(i, j int) bool {
+		return key(rows[i]).before(key(rows[j]), q.Sort)
+	})
+
+	start := 0
+
+	if q.After != nil {
+		start = sort.Search(len(rows), func(i int) bool {
+			return q.After.before(key(rows[i]), q.Sort)
+		})
+	}
+
+	end := start + q.Limit + 1
+
+	if end > len(rows) {
+		end = len(rows)
+	}
+
+	return New(rows[start:end], q, key)
+}
+
+//Convert returns p with each of its rows converted by f
+func Convert[T, U any](p Page[T], f func(T) U) Page[U] {
+	converted := Page[U]{Items: make([]U, 0, len(p.Items)), NextCursor: p.NextCursor, HasMore: p.HasMore}
+
+	for _, row := range p.Items {
+		converted.Items = append(converted.Items, f(row))
+	}
+
+	return converted
+}
@@ -0,0 +1,195 @@
+package page
+
+import (
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+type row struct {
+	id      string
+	created time.Time
+	rank    float64
+}
+
+//id makes a uuid of the one letter names of the rows, which sort the same way
+func id(name string) string {
+	return "00000000-0000-4000-8000-00000000000" + name
+}
+
+func key(r row) Cursor {
+	return Cursor{CreatedAt: r.created, ID: id(r.id), Rank: r.rank}
+}
+
+func rows() []row {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// b and c were created at the same time, and a and d rank the same, so they are ordered by id
+	return []row{
+		{"c", start.Add(time.Minute), 0.3},
+		{"a", start, 0.5},
+		{"e", start.Add(3 * time.Minute), 0.1},
+		{"b", start.Add(time.Minute), 0.9},
+		{"d", start.Add(2 * time.Minute), 0.5},
+	}
+}
+
+func walk(t *testing.T, q Query) string {
+	var ids string
+
+	for pages := 0; pages < 10; pages++ {
+		p := Slice(rows(), q, key)
+
+		for _, r := range p.Items {
+			ids += r.id
+		}
+
+		if !p.HasMore {
+			if p.NextCursor != "" {
+				t.Fatalf("the last page has a cursor: %v", p)
+			}
+
+			return ids
+		}
+
+		c, err := q.Decode(p.NextCursor)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		q.After = &c
+	}
+
+	t.Fatalf("paging never ended, got %s", ids)
+
+	return ""
+}
+
+func TestSlice(t *testing.T) {
+	cases := []struct {
+		sort  Sort
+		limit int
+		want  string
+	}{
+		{Newest, 2, "edcba"},
+		{Oldest, 2, "abcde"},
+		{Oldest, 1, "abcde"},
+		{Newest, 5, "edcba"},
+		{Newest, MaxLimit, "edcba"},
+		{Relevance, 2, "bdace"},
+		{Relevance, 1, "bdace"},
+	}
+
+	for _, c := range cases {
+		if got := walk(t, Query{Limit: c.limit, Sort: c.sort}); got != c.want {
+			t.Errorf("%s by %d: got %s, want %s", c.sort, c.limit, got, c.want)
+		}
+	}
+
+	created := rows()[3].created
+
+	if got := walk(t, Query{Limit: 2, Sort: Oldest, CreatedAfter: created}); got != "de" {
+		t.Errorf("created after %v: got %s", created, got)
+	}
+
+	if p := Slice(nil, Query{Limit: 2, Sort: Newest}, key); p.Items == nil || len(p.Items) != 0 || p.HasMore {
+		t.Errorf("empty list: got %v", p)
+	}
+}
+
+func TestParse(t *testing.T) {
+	problems := make(map[string]string)
+	q := Parse(url.Values{}, Oldest, problems)
+
+	if len(problems) != 0 || q.Limit != DefaultLimit || q.Sort != Oldest || q.After != nil || !q.CreatedAfter.IsZero() {
+		t.Fatalf("no parameters: got %+v, %v", q, problems)
+	}
+
+	newest := Query{Sort: Newest}
+	cursor := newest.Encode(Cursor{CreatedAt: time.Now(), ID: id("a")})
+
+	q = Parse(url.Values{"limit": {"5"}, "sort": {"newest"}, "cursor": {cursor}, "created_after": {"2020-01-01T00:00:00Z"}}, Oldest, problems)
+
+	if len(problems) != 0 || q.Limit != 5 || q.Sort != Newest || q.After == nil || q.After.ID != id("a") || q.CreatedAfter.Year() != 2020 {
+		t.Fatalf("every parameter: got %+v, %v", q, problems)
+	}
+
+	Parse(url.Values{"limit": {strconv.Itoa(MaxLimit + 1)}, "sort": {"name"}, "created_after": {"yesterday"}}, Oldest, problems)
+
+	for _, name := range []string{"limit", "sort", "created_after"} {
+		if problems[name] == "" {
+			t.Errorf("%s: expected a problem, got %v", name, problems)
+		}
+	}
+
+	// a cursor is only good for the order it was made for
+	problems = make(map[string]string)
+	Parse(url.Values{"cursor": {cursor}}, Oldest, problems)
+
+	if problems["cursor"] != ErrInvalidCursor.Error() {
+		t.Errorf("cursor for another order: got %v", problems)
+	}
+
+	problems = make(map[string]string)
+	Parse(url.Values{"sort": {"relevance"}}, Newest, problems)
+
+	if problems["sort"] == "" {
+		t.Errorf("relevance for a list that isn't a search: got %v", problems)
+	}
+
+	problems = make(map[string]string)
+
+	if q = Parse(url.Values{"sort": {"relevance"}}, Relevance, problems); len(problems) != 0 || q.Sort != Relevance {
+		t.Errorf("relevance for a search: got %+v, %v", q, problems)
+	}
+
+	Parse(url.Values{"cursor": {"not a cursor"}}, Newest, problems)
+
+	if problems["cursor"] != ErrInvalidCursor.Error() {
+		t.Errorf("garbled cursor: got %v", problems)
+	}
+
+	// cursors are only made for rows, which all have uuids
+	problems = make(map[string]string)
+	Parse(url.Values{"cursor": {newest.Encode(Cursor{CreatedAt: time.Now(), ID: "a' OR 1=1"})}}, Newest, problems)
+
+	if problems["cursor"] != ErrInvalidCursor.Error() {
+		t.Errorf("cursor without a uuid: got %v", problems)
+	}
+}
+
+func TestKeyset(t *testing.T) {
+	var w Where
+	w.Add("closed = ?", false)
+
+	after := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	q := Query{Limit: 10, Sort: Oldest, After: &Cursor{CreatedAt: after, ID: "a"}, CreatedAfter: after}
+	tail := q.Keyset(&w, "items.created_at", "items.id")
+
+	if got, want := w.String()+tail, " WHERE closed = $1 AND items.created_at > $2 AND (items.created_at, items.id) > ($3, $4) ORDER BY items.created_at ASC, items.id ASC LIMIT $5"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if len(w.Args) != 5 || w.Args[4] != 11 {
+		t.Errorf("args: got %v", w.Args)
+	}
+
+	w = Where{}
+	q.Sort = Relevance
+	q.After.Rank = 0.5
+	tail = q.Ranked(&w, "rank", "created_at", "id")
+
+	if got, want := w.String()+tail, " WHERE created_at > $1 AND (rank, id) < ($2, $3) ORDER BY rank DESC, id DESC LIMIT $4"; got != want {
+		t.Errorf("ranked: got %q, want %q", got, want)
+	}
+
+	w = Where{}
+	q.Sort = Newest
+	q.After = nil
+
+	if got, want := q.Ranked(&w, "rank", "created_at", "id"), " ORDER BY created_at DESC, id DESC LIMIT $2"; got != want {
+		t.Errorf("ranked by date: got %q, want %q", got, want)
+	}
+}
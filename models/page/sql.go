@@ -0,0 +1,74 @@
+package page
+
+import (
+	"strconv"
+	"strings"
+)
+
+//Where collects the conditions of a postgres query along with their arguments
+type Where struct {
+	conditions []string
+	Args       []interface{}
+}
+
+//Add a condition, with a ? standing in for each of args in turn
+func (w *Where) Add(condition string, args ...interface{}) {
+	for _, arg := range args {
+		w.Args = append(w.Args, arg)
+		condition = strings.Replace(condition, "?", "$"+strconv.Itoa(len(w.Args)), 1)
+	}
+
+	w.conditions = append(w.conditions, condition)
+}
+
+//String returns the where clause, which is empty without conditions
+func (w *Where) String() string {
+	if len(w.conditions) == 0 {
+		return ""
+	}
+
+	return " WHERE " + strings.Join(w.conditions, " AND ")
+}
+
+//Keyset adds the condition that starts the page q asks for to w, given the columns a row was created at and its id,
+//and returns the order and limit that end the query. It fetches a row more than q.Limit for New. Call it before
+//String, as the condition is part of the where clause.
+func (q Query) Keyset(w *Where, createdAt, id string) string {
+	direction, comparison := "DESC", "<"
+
+	if q.Sort == Oldest {
+		direction, comparison = "ASC", ">"
+	}
+
+	if !q.CreatedAfter.IsZero() {
+		w.Add(createdAt+" > ?", q.CreatedAfter)
+	}
+
+	if q.After != nil {
+		w.Add("("+createdAt+", "+id+") "+comparison+" (?, ?)", q.After.CreatedAt, q.After.ID)
+	}
+
+	w.Args = append(w.Args, q.Limit+1)
+
+	return " ORDER BY " + createdAt + " " + direction + ", " + id + " " + direction + " LIMIT $" + strconv.Itoa(len(w.Args))
+}
+
+//Ranked is Keyset for lists that can be sorted by Relevance, given the expression ranking a row as well. Ranks are
+//compared exactly, so rank should round to a few decimal places to survive the trip through a cursor.
+func (q Query) Ranked(w *Where, rank, createdAt, id string) string {
+	if q.Sort != Relevance {
+		return q.Keyset(w, createdAt, id)
+	}
+
+	if !q.CreatedAfter.IsZero() {
+		w.Add(createdAt+" > ?", q.CreatedAfter)
+	}
+
+	if q.After != nil {
+		w.Add("("+rank+", "+id+") < (?, ?)", q.After.Rank, q.After.ID)
+	}
+
+	w.Args = append(w.Args, q.Limit+1)
+
+	return " ORDER BY " + rank + " DESC, " + id + " DESC LIMIT $" + strconv.Itoa(len(w.Args))
+}
@@ -0,0 +1,703 @@
+package tokens
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/Samuyi/www/logging"
+	"github.com/go-redis/redis"
+	uuid "github.com/satori/go.uuid"
+)
+
+//ErrSessionExpired is returned when a session doesn't exist anymore
+var ErrSessionExpired = errors.New("Session has expired")
+
+//ErrInvalidKey is returned when a confirmation key or reset token is unknown, expired or already used
+var ErrInvalidKey = errors.New("Please supply a valid key")
+
+//ErrRefreshReused is returned when a refresh token that was already exchanged is presented again,
+//which means it was copied. The session it belongs to should be ended.
+var ErrRefreshReused = errors.New("Sorry that refresh token was already used")
+
+//MaxChallengeAttempts is how many codes can be tried against one login challenge before it is thrown away
+const MaxChallengeAttempts = 5
+
+//Random returns a url safe token made of 256 random bits
+func Random() (string, error) {
+	b := make([]byte, 32)
+
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+//Valid reports whether token looks like one made by Random. Anything else is refused before it reaches redis,
+//so a key of another kind can never be passed off as a token.
+func Valid(token string) bool {
+	b, err := base64.RawURLEncoding.DecodeString(token)
+
+	return err == nil && len(b) == 32
+}
+
+//Hash returns the hex encoded sha256 of a token, so tokens can be looked up without being stored
+func Hash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+
+	return hex.EncodeToString(sum[:])
+}
+
+//Session describes one of the sessions of a user. Key is the session id itself, which is never sent to clients;
+//they refer to a session by ID, the hash of the key.
+type Session struct {
+	Key       string    `json:"-"`
+	ID        string    `json:"id"`
+	UserAgent string    `json:"user_agent"`
+	IP        string    `json:"ip"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Current   bool      `json:"current"`
+}
+
+//NewSession describes the session with key from its values
+func NewSession(key string, values map[string]string, expiresAt time.Time) Session {
+	createdAt, _ := time.Parse(time.RFC3339, values["CreatedAt"])
+
+	return Session{
+		Key:       key,
+		ID:        Hash(key),
+		UserAgent: values["UserAgent"],
+		IP:        values["IP"],
+		CreatedAt: createdAt,
+		ExpiresAt: expiresAt,
+	}
+}
+
+//userSessionsKey is the sorted set of the sessions of a user, scored by when they expire
+func userSessionsKey(userID string) string {
+	return "sessions:user:" + userID
+}
+
+func confirmKey(token string) string {
+	return "confirm:" + Hash(token)
+}
+
+func refreshKey(token string) string {
+	return "refresh:" + Hash(token)
+}
+
+func resetKey(token string) string {
+	return "reset:" + Hash(token)
+}
+
+func userResetKey(userID string) string {
+	return "reset:user:" + userID
+}
+
+func failuresKey(key string) string {
+	return "lockout:failures:" + key
+}
+
+func lockedKey(key string) string {
+	return "lockout:locked:" + key
+}
+
+func rateKey(key string) string {
+	return "ratelimit:" + key
+}
+
+func challengeKey(token string) string {
+	return "challenge:" + Hash(token)
+}
+
+//Rate is where a client stands against a rate limit after a request. Allowed is false when the request went over
+//the limit, Remaining is how many more requests fit in the window and Reset is when the oldest one leaves it.
+type Rate struct {
+	Allowed   bool
+	Remaining int
+	Reset     time.Duration
+}
+
+//Store keeps sessions, email confirmation keys, refresh, password reset tokens, login challenges, lockouts
+//and rate limits in redis
+type Store struct {
+	client *redis.Client
+}
+
+//NewStore returns a token store backed by client
+func NewStore(client *redis.Client) *Store {
+	return &Store{client: client}
+}
+
+//SetSession saves the values of a session for ttl. Sessions with a "userID" value are added to the index of that user.
+func (s *Store) SetSession(ctx context.Context, sessionID string, values map[string]interface{}, ttl time.Duration) error {
+	_, err := s.client.HMSet(sessionID, values).Result()
+	if err != nil {
+		logging.From(ctx).Error("tokens.SetSession", "err", err)
+		return err
+	}
+
+	_, err = s.client.Expire(sessionID, ttl).Result()
+	if err != nil {
+		logging.From(ctx).Error("tokens.SetSession", "err", err)
+		return err
+	}
+
+	userID, ok := values["userID"]
+
+	if !ok {
+		return nil
+	}
+
+	if err = s.index(fmt.Sprint(userID), sessionID, ttl); err != nil {
+		logging.From(ctx).Error("tokens.SetSession", "err", err)
+		return err
+	}
+
+	return nil
+}
+
+//index adds a session that lasts for ttl to the index of the sessions of a user
+func (s *Store) index(userID, sessionID string, ttl time.Duration) error {
+	index := userSessionsKey(userID)
+	expiresAt := time.Now().Add(ttl)
+
+	pipe := s.client.TxPipeline()
+	pipe.ZAdd(index, redis.Z{Score: float64(expiresAt.Unix()), Member: sessionID})
+	pipe.ExpireAt(index, expiresAt)
+
+	_, err := pipe.Exec()
+
+	return err
+}
+
+// extendSession sets fields of a session and makes it last another ttl only while it exists, so a session that
+// ended isn't brought back. It returns the user of the session.
+var extendSession = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return false
+end
+if #ARGV > 1 then
+	redis.call("HMSET", KEYS[1], unpack(ARGV, 2))
+end
+redis.call("PEXPIRE", KEYS[1], ARGV[1])
+return redis.call("HGET", KEYS[1], "userID") or ""
+`)
+
+//ExtendSession changes values of a session and keeps it going for another ttl. A session that ended is reported with
+//ErrSessionExpired.
+func (s *Store) ExtendSession(ctx context.Context, sessionID string, values map[string]interface{}, ttl time.Duration) error {
+	args := []interface{}{ttl.Milliseconds()}
+
+	for k, v := range values {
+		args = append(args, k, v)
+	}
+
+	userID, err := extendSession.Run(s.client, []string{sessionID}, args...).String()
+
+	if err == redis.Nil {
+		return ErrSessionExpired
+	}
+
+	if err != nil {
+		logging.From(ctx).Error("tokens.ExtendSession", "err", err)
+		return err
+	}
+
+	if userID == "" {
+		return nil
+	}
+
+	if err = s.index(userID, sessionID, ttl); err != nil {
+		logging.From(ctx).Error("tokens.ExtendSession", "err", err)
+		return err
+	}
+
+	return nil
+}
+
+// updateSession sets fields of a session only while it exists, so a session that ended isn't brought back
+var updateSession = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return 0
+end
+redis.call("HMSET", KEYS[1], unpack(ARGV))
+return 1
+`)
+
+//UpdateSession changes values of a session without extending it. A session that ended is reported with ErrSessionExpired.
+func (s *Store) UpdateSession(ctx context.Context, sessionID string, values map[string]interface{}) error {
+	args := make([]interface{}, 0, 2*len(values))
+
+	for k, v := range values {
+		args = append(args, k, v)
+	}
+
+	updated, err := updateSession.Run(s.client, []string{sessionID}, args...).Int64()
+
+	if err != nil {
+		logging.From(ctx).Error("tokens.UpdateSession", "err", err)
+		return err
+	}
+
+	if updated == 0 {
+		return ErrSessionExpired
+	}
+
+	return nil
+}
+
+//GetSession gets the values of a session
+func (s *Store) GetSession(ctx context.Context, sessionID string) (map[string]string, error) {
+	session, err := s.client.HGetAll(sessionID).Result()
+
+	if err != nil {
+		logging.From(ctx).Error("tokens.GetSession", "err", err)
+		return nil, err
+	}
+
+	if len(session) == 0 {
+		return nil, ErrSessionExpired
+	}
+
+	return session, nil
+}
+
+//DeleteSession ends a session
+func (s *Store) DeleteSession(ctx context.Context, sessionID string) error {
+	userID, err := s.client.HGet(sessionID, "userID").Result()
+
+	if err != nil && err != redis.Nil {
+		logging.From(ctx).Error("tokens.DeleteSession", "err", err)
+		return err
+	}
+
+	pipe := s.client.TxPipeline()
+	pipe.Del(sessionID)
+
+	if userID != "" {
+		pipe.ZRem(userSessionsKey(userID), sessionID)
+	}
+
+	if _, err = pipe.Exec(); err != nil {
+		logging.From(ctx).Error("tokens.DeleteSession", "err", err)
+		return err
+	}
+
+	return nil
+}
+
+//GetUserSessions returns the sessions of a user that haven't expired, latest to expire first
+func (s *Store) GetUserSessions(ctx context.Context, userID string) ([]Session, error) {
+	index := userSessionsKey(userID)
+
+	_, err := s.client.ZRemRangeByScore(index, "-inf", strconv.FormatInt(time.Now().Unix(), 10)).Result()
+
+	if err != nil {
+		logging.From(ctx).Error("tokens.GetUserSessions", "err", err)
+		return nil, err
+	}
+
+	members, err := s.client.ZRevRangeWithScores(index, 0, -1).Result()
+
+	if err != nil {
+		logging.From(ctx).Error("tokens.GetUserSessions", "err", err)
+		return nil, err
+	}
+
+	sessions := []Session{}
+
+	for _, member := range members {
+		key := member.Member.(string)
+
+		values, err := s.client.HGetAll(key).Result()
+
+		if err != nil {
+			logging.From(ctx).Error("tokens.GetUserSessions", "err", err)
+			return nil, err
+		}
+
+		if len(values) == 0 {
+			// the session ended without going through DeleteSession
+			s.client.ZRem(index, key)
+			continue
+		}
+
+		sessions = append(sessions, NewSession(key, values, time.Unix(int64(member.Score), 0)))
+	}
+
+	return sessions, nil
+}
+
+//DeleteUserSessions ends every session of a user
+func (s *Store) DeleteUserSessions(ctx context.Context, userID string) error {
+	index := userSessionsKey(userID)
+
+	keys, err := s.client.ZRange(index, 0, -1).Result()
+
+	if err != nil {
+		logging.From(ctx).Error("tokens.DeleteUserSessions", "err", err)
+		return err
+	}
+
+	_, err = s.client.Del(append(keys, index)...).Result()
+
+	if err != nil {
+		logging.From(ctx).Error("tokens.DeleteUserSessions", "err", err)
+		return err
+	}
+
+	return nil
+}
+
+//SetConfirmation issues a key for a user to confirm their email with that is valid for ttl. Only the hash of the
+//key is kept.
+func (s *Store) SetConfirmation(ctx context.Context, userID string, ttl time.Duration) (string, error) {
+	key, err := Random()
+
+	if err != nil {
+		logging.From(ctx).Error("tokens.SetConfirmation", "err", err)
+		return "", err
+	}
+
+	if _, err = s.client.Set(confirmKey(key), userID, ttl).Result(); err != nil {
+		logging.From(ctx).Error("tokens.SetConfirmation", "err", err)
+		return "", err
+	}
+
+	return key, nil
+}
+
+//TakeConfirmation returns the user a confirmation key was issued to and deletes the key, so it only works once
+func (s *Store) TakeConfirmation(ctx context.Context, key string) (string, error) {
+	if !Valid(key) {
+		return "", ErrInvalidKey
+	}
+
+	pipe := s.client.TxPipeline()
+	get := pipe.Get(confirmKey(key))
+	pipe.Del(confirmKey(key))
+
+	_, err := pipe.Exec()
+
+	if err == redis.Nil {
+		return "", ErrInvalidKey
+	}
+
+	if err != nil {
+		logging.From(ctx).Error("tokens.TakeConfirmation", "err", err)
+		return "", err
+	}
+
+	return get.Val(), nil
+}
+
+//SetReset issues a password reset token for a user that is valid for ttl and replaces any earlier one.
+//Only the hash of the token is kept.
+func (s *Store) SetReset(ctx context.Context, userID string, ttl time.Duration) (string, error) {
+	token, err := Random()
+
+	if err != nil {
+		logging.From(ctx).Error("tokens.SetReset", "err", err)
+		return "", err
+	}
+
+	previous, err := s.client.Get(userResetKey(userID)).Result()
+
+	if err != nil && err != redis.Nil {
+		logging.From(ctx).Error("tokens.SetReset", "err", err)
+		return "", err
+	}
+
+	pipe := s.client.TxPipeline()
+
+	if previous != "" {
+		pipe.Del(previous)
+	}
+
+	pipe.Set(resetKey(token), userID, ttl)
+	pipe.Set(userResetKey(userID), resetKey(token), ttl)
+
+	if _, err = pipe.Exec(); err != nil {
+		logging.From(ctx).Error("tokens.SetReset", "err", err)
+		return "", err
+	}
+
+	return token, nil
+}
+
+//TakeReset returns the user a reset token was issued to and deletes the token, so it only works once
+func (s *Store) TakeReset(ctx context.Context, token string) (string, error) {
+	if !Valid(token) {
+		return "", ErrInvalidKey
+	}
+
+	pipe := s.client.TxPipeline()
+	get := pipe.Get(resetKey(token))
+	pipe.Del(resetKey(token))
+
+	_, err := pipe.Exec()
+
+	if err == redis.Nil {
+		return "", ErrInvalidKey
+	}
+
+	if err != nil {
+		logging.From(ctx).Error("tokens.TakeReset", "err", err)
+		return "", err
+	}
+
+	id := get.Val()
+
+	if _, err = s.client.Del(userResetKey(id)).Result(); err != nil {
+		logging.From(ctx).Error("tokens.TakeReset", "err", err)
+	}
+
+	return id, nil
+}
+
+//SetRefresh issues a refresh token for a session that is valid for ttl. Only the hash of the token is kept.
+func (s *Store) SetRefresh(ctx context.Context, sessionID string, ttl time.Duration) (string, error) {
+	token, err := Random()
+
+	if err != nil {
+		logging.From(ctx).Error("tokens.SetRefresh", "err", err)
+		return "", err
+	}
+
+	pipe := s.client.TxPipeline()
+	pipe.HMSet(refreshKey(token), map[string]interface{}{"session": sessionID, "used": "0"})
+	pipe.Expire(refreshKey(token), ttl)
+
+	if _, err = pipe.Exec(); err != nil {
+		logging.From(ctx).Error("tokens.SetRefresh", "err", err)
+		return "", err
+	}
+
+	return token, nil
+}
+
+// useRefresh marks a refresh token as used and returns its session and whether it had been used before
+var useRefresh = redis.NewScript(`
+local session = redis.call("HGET", KEYS[1], "session")
+if not session then
+	return false
+end
+local used = redis.call("HGET", KEYS[1], "used")
+redis.call("HSET", KEYS[1], "used", "1")
+return {session, used}
+`)
+
+//UseRefresh returns the session a refresh token belongs to and marks the token as used. A token that was
+//used before is reported with ErrRefreshReused along with its session.
+func (s *Store) UseRefresh(ctx context.Context, token string) (string, error) {
+	if !Valid(token) {
+		return "", ErrInvalidKey
+	}
+
+	result, err := useRefresh.Run(s.client, []string{refreshKey(token)}).Result()
+
+	if err == redis.Nil {
+		return "", ErrInvalidKey
+	}
+
+	if err != nil {
+		logging.From(ctx).Error("tokens.UseRefresh", "err", err)
+		return "", err
+	}
+
+	values, ok := result.([]interface{})
+
+	if !ok || len(values) != 2 {
+		return "", fmt.Errorf("tokens: unexpected reply %v", result)
+	}
+
+	sessionID, _ := values[0].(string)
+
+	if used, _ := values[1].(string); used == "1" {
+		return sessionID, ErrRefreshReused
+	}
+
+	return sessionID, nil
+}
+
+//SetChallenge starts a login that is waiting for the two-factor code of a user, valid for ttl.
+//Only the hash of the token is kept.
+func (s *Store) SetChallenge(ctx context.Context, userID string, ttl time.Duration) (string, error) {
+	token, err := Random()
+
+	if err != nil {
+		logging.From(ctx).Error("tokens.SetChallenge", "err", err)
+		return "", err
+	}
+
+	pipe := s.client.TxPipeline()
+	pipe.HMSet(challengeKey(token), map[string]interface{}{"user": userID, "attempts": "0"})
+	pipe.Expire(challengeKey(token), ttl)
+
+	if _, err = pipe.Exec(); err != nil {
+		logging.From(ctx).Error("tokens.SetChallenge", "err", err)
+		return "", err
+	}
+
+	return token, nil
+}
+
+// checkChallenge counts an attempt against a challenge and returns its user, deleting the challenge once it runs out of attempts
+var checkChallenge = redis.NewScript(`
+local user = redis.call("HGET", KEYS[1], "user")
+if not user then
+	return false
+end
+local attempts = redis.call("HINCRBY", KEYS[1], "attempts", 1)
+if attempts > tonumber(ARGV[1]) then
+	redis.call("DEL", KEYS[1])
+	return false
+end
+return user
+`)
+
+//CheckChallenge returns the user a login challenge belongs to and counts a code tried against it.
+//After MaxChallengeAttempts codes the challenge is reported as ErrInvalidKey, and the user has to log in again.
+func (s *Store) CheckChallenge(ctx context.Context, token string) (string, error) {
+	if !Valid(token) {
+		return "", ErrInvalidKey
+	}
+
+	result, err := checkChallenge.Run(s.client, []string{challengeKey(token)}, MaxChallengeAttempts).Result()
+
+	if err == redis.Nil {
+		return "", ErrInvalidKey
+	}
+
+	if err != nil {
+		logging.From(ctx).Error("tokens.CheckChallenge", "err", err)
+		return "", err
+	}
+
+	userID, ok := result.(string)
+
+	if !ok {
+		return "", fmt.Errorf("tokens: unexpected reply %v", result)
+	}
+
+	return userID, nil
+}
+
+//DeleteChallenge ends a login challenge once a code was accepted
+func (s *Store) DeleteChallenge(ctx context.Context, token string) error {
+	if _, err := s.client.Del(challengeKey(token)).Result(); err != nil {
+		logging.From(ctx).Error("tokens.DeleteChallenge", "err", err)
+		return err
+	}
+
+	return nil
+}
+
+//AddFailure counts a failed login for key, an account or an address, and returns how many there have been.
+//Failures are forgotten once there hasn't been one for window.
+func (s *Store) AddFailure(ctx context.Context, key string, window time.Duration) (int64, error) {
+	pipe := s.client.TxPipeline()
+	incr := pipe.Incr(failuresKey(key))
+	pipe.Expire(failuresKey(key), window)
+
+	if _, err := pipe.Exec(); err != nil {
+		logging.From(ctx).Error("tokens.AddFailure", "err", err)
+		return 0, err
+	}
+
+	return incr.Val(), nil
+}
+
+//Lock stops logins for key for ttl
+func (s *Store) Lock(ctx context.Context, key string, ttl time.Duration) error {
+	if _, err := s.client.Set(lockedKey(key), "1", ttl).Result(); err != nil {
+		logging.From(ctx).Error("tokens.Lock", "err", err)
+		return err
+	}
+
+	return nil
+}
+
+//LockedFor returns how much longer logins for key are stopped, 0 when they aren't
+func (s *Store) LockedFor(ctx context.Context, key string) (time.Duration, error) {
+	ttl, err := s.client.PTTL(lockedKey(key)).Result()
+
+	if err != nil {
+		logging.From(ctx).Error("tokens.LockedFor", "err", err)
+		return 0, err
+	}
+
+	// redis answers with a negative ttl when the key doesn't exist
+	if ttl < 0 {
+		return 0, nil
+	}
+
+	return ttl, nil
+}
+
+//Unlock lifts the lock on key and forgets its failures
+func (s *Store) Unlock(ctx context.Context, key string) error {
+	if _, err := s.client.Del(lockedKey(key), failuresKey(key)).Result(); err != nil {
+		logging.From(ctx).Error("tokens.Unlock", "err", err)
+		return err
+	}
+
+	return nil
+}
+
+// takeRate keeps the requests of the last window milliseconds in a sorted set scored by when they were made,
+// adding the new one only when it fits under the limit so refused requests don't push the window back
+var takeRate = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
+redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", now - window)
+local count = redis.call("ZCARD", KEYS[1])
+local allowed = 0
+if count < limit then
+	redis.call("ZADD", KEYS[1], now, ARGV[4])
+	count = count + 1
+	allowed = 1
+end
+redis.call("PEXPIRE", KEYS[1], window)
+local reset = window
+local oldest = redis.call("ZRANGE", KEYS[1], 0, 0, "WITHSCORES")
+if oldest[2] then
+	reset = tonumber(oldest[2]) + window - now
+end
+return {allowed, limit - count, reset}
+`)
+
+//Take counts a request against key when fewer than limit were made in the last window, a sliding window
+func (s *Store) Take(ctx context.Context, key string, limit int, window time.Duration) (Rate, error) {
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	member := strconv.FormatInt(now, 10) + ":" + uuid.Must(uuid.NewV4()).String()
+
+	result, err := takeRate.Run(s.client, []string{rateKey(key)}, now, int64(window/time.Millisecond), limit, member).Result()
+
+	if err != nil {
+		logging.From(ctx).Error("tokens.Take", "err", err)
+		return Rate{}, err
+	}
+
+	values, ok := result.([]interface{})
+
+	if !ok || len(values) != 3 {
+		return Rate{}, fmt.Errorf("tokens: unexpected reply %v", result)
+	}
+
+	allowed, _ := values[0].(int64)
+	remaining, _ := values[1].(int64)
+	reset, _ := values[2].(int64)
+
+	return Rate{Allowed: allowed == 1, Remaining: int(remaining), Reset: time.Duration(reset) * time.Millisecond}, nil
+}
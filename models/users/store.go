@@ -0,0 +1,361 @@
+package users
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/Samuyi/www/logging"
+	"github.com/Samuyi/www/models/page"
+	utilities "github.com/Samuyi/www/utilities"
+	"github.com/lib/pq"
+)
+
+//Store keeps users in postgres
+type Store struct {
+	db *sql.DB
+}
+
+//NewStore returns a user store backed by db
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+// Create a user in the database
+func (s *Store) Create(ctx context.Context, user *User) error {
+	query := "INSERT INTO users (first_name, last_name, display_name, email, password, avatar, locale) VALUES ($1, $2, $3, $4, $5, $6, COALESCE(NULLIF($7, ''), 'en')) returning id, locale, role;"
+	stmt, err := s.db.PrepareContext(ctx, query)
+
+	if err != nil {
+		logging.From(ctx).Error("users.Create", "err", err)
+		return err
+	}
+	defer stmt.Close()
+
+	var password string
+	password, err = utilities.HashPassword(user.Password)
+
+	if err != nil {
+		logging.From(ctx).Error("users.Create", "err", err)
+		return err
+	}
+
+	err = stmt.QueryRowContext(ctx, user.FirstName, user.LastName, user.DisplayName, user.Email, password, user.Avatar, user.Locale).Scan(&user.ID, &user.Locale, &user.Role)
+
+	if pqErr, ok := err.(*pq.Error); ok {
+		switch pqErr.Constraint {
+		case "users_email_key":
+			return ErrEmailTaken
+		case "users_display_name_key":
+			return ErrDisplayNameTaken
+		}
+	}
+
+	if err != nil {
+		logging.From(ctx).Error("users.Create", "err", err)
+		return err
+	}
+
+	return nil
+}
+
+//Get is used to fetch a user from the database
+func (s *Store) Get(ctx context.Context, user *User) error {
+	query := "SELECT first_name, last_name, display_name, email, ratings, active, password, locale, role, " + twoFactorEnabled + ", created_at FROM users WHERE id = $1"
+
+	stmt, err := s.db.PrepareContext(ctx, query)
+
+	if err != nil {
+		logging.From(ctx).Error("users.Get", "err", err)
+		return err
+	}
+	defer stmt.Close()
+
+	err = stmt.QueryRowContext(ctx, user.ID).Scan(&user.FirstName, &user.LastName, &user.DisplayName, &user.Email, &user.Ratings, &user.Active, &user.Password, &user.Locale, &user.Role, &user.TwoFactor, &user.CreatedAt)
+
+	if err != nil && err != sql.ErrNoRows {
+		logging.From(ctx).Error("users.Get", "err", err)
+	}
+
+	return err
+}
+
+//GetByName gets a users based on username
+func (s *Store) GetByName(ctx context.Context, user *User) error {
+	query := "SELECT first_name, last_name, email, ratings, COALESCE(avatar, ''), active, created_at FROM users WHERE display_name = $1"
+
+	stmt, err := s.db.PrepareContext(ctx, query)
+
+	if err != nil {
+		logging.From(ctx).Error("users.GetByName", "err", err)
+		return err
+	}
+	defer stmt.Close()
+
+	err = stmt.QueryRowContext(ctx, user.DisplayName).Scan(&user.FirstName, &user.LastName, &user.Email, &user.Ratings, &user.Avatar, &user.Active, &user.CreatedAt)
+
+	if err != nil && err != sql.ErrNoRows {
+		logging.From(ctx).Error("users.GetByName", "err", err)
+	}
+
+	return err
+}
+
+//GetByEmail gets the id and password asociated with an email
+func (s *Store) GetByEmail(ctx context.Context, user *User) error {
+	query := "SELECT id, password, active, display_name, first_name, last_name, COALESCE(avatar, ''), locale, role, " + twoFactorEnabled + " FROM users where email = $1"
+
+	stmt, err := s.db.PrepareContext(ctx, query)
+
+	if err != nil {
+		logging.From(ctx).Error("users.GetByEmail", "err", err)
+		return err
+	}
+	defer stmt.Close()
+
+	err = stmt.QueryRowContext(ctx, user.Email).Scan(&user.ID, &user.Password, &user.Active, &user.DisplayName, &user.FirstName, &user.LastName, &user.Avatar, &user.Locale, &user.Role, &user.TwoFactor)
+
+	if err != nil && err != sql.ErrNoRows {
+		logging.From(ctx).Error("users.GetByEmail", "err", err)
+	}
+
+	return err
+}
+
+//Update a user in the database
+func (s *Store) Update(ctx context.Context, user *User) error {
+	user.UpdatedAt = time.Now()
+	if user.Password == "" {
+		query := "UPDATE users SET first_name = $1, last_name = $2, display_name = $3, locale = COALESCE(NULLIF($4, ''), locale), updated_at=$5 WHERE id = $6"
+
+		stmt, err := s.db.PrepareContext(ctx, query)
+		if err != nil {
+			logging.From(ctx).Error("users.Update", "err", err)
+			return err
+		}
+		defer stmt.Close()
+
+		_, err = stmt.ExecContext(ctx, user.FirstName, user.LastName, user.DisplayName, user.Locale, user.UpdatedAt, user.ID)
+
+		if displayNameTaken(err) {
+			return ErrDisplayNameTaken
+		}
+
+		if err != nil {
+			logging.From(ctx).Error("users.Update", "err", err)
+			return err
+		}
+		return nil
+	}
+	query := "UPDATE users SET first_name = $1, last_name = $2, display_name = $3, password = $4, locale = COALESCE(NULLIF($5, ''), locale), updated_at=$6 WHERE id = $7"
+	stmt, err := s.db.PrepareContext(ctx, query)
+	if err != nil {
+		logging.From(ctx).Error("users.Update", "err", err)
+		return err
+	}
+	defer stmt.Close()
+
+	var password string
+	password, err = utilities.HashPassword(user.Password)
+
+	if err != nil {
+		logging.From(ctx).Error("users.Update", "err", err)
+		return err
+	}
+
+	_, err = stmt.ExecContext(ctx, user.FirstName, user.LastName, user.DisplayName, password, user.Locale, user.UpdatedAt, user.ID)
+
+	if displayNameTaken(err) {
+		return ErrDisplayNameTaken
+	}
+
+	if err != nil {
+		logging.From(ctx).Error("users.Update", "err", err)
+		return err
+	}
+
+	return nil
+
+}
+
+//displayNameTaken reports whether err is the display name of a user clashing with another's
+func displayNameTaken(err error) bool {
+	pqErr, ok := err.(*pq.Error)
+
+	return ok && pqErr.Constraint == "users_display_name_key"
+}
+
+//UpdatePassword of a user
+func (s *Store) UpdatePassword(ctx context.Context, user *User) error {
+	query := "UPDATE users SET password = $1, updated_at=$2 where id = $3"
+
+	stmt, err := s.db.PrepareContext(ctx, query)
+
+	if err != nil {
+		logging.From(ctx).Error("users.UpdatePassword", "err", err)
+		return err
+	}
+	defer stmt.Close()
+
+	var password string
+	password, err = utilities.HashPassword(user.Password)
+
+	if err != nil {
+		logging.From(ctx).Error("users.UpdatePassword", "err", err)
+		return err
+	}
+	user.UpdatedAt = time.Now()
+
+	_, err = stmt.ExecContext(ctx, password, user.UpdatedAt, user.ID)
+
+	if err != nil {
+		logging.From(ctx).Error("users.UpdatePassword", "err", err)
+		return err
+	}
+
+	return nil
+}
+
+//SetActive makes a user active on the network
+func (s *Store) SetActive(ctx context.Context, user *User) error {
+	query := "UPDATE users SET active = true, updated_at=$1 WHERE id = $2"
+
+	stmt, err := s.db.PrepareContext(ctx, query)
+
+	if err != nil {
+		logging.From(ctx).Error("users.SetActive", "err", err)
+		return err
+	}
+	defer stmt.Close()
+
+	user.UpdatedAt = time.Now()
+	_, err = stmt.ExecContext(ctx, user.UpdatedAt, user.ID)
+
+	user.Active = true
+
+	if err != nil {
+		logging.From(ctx).Error("users.SetActive", "err", err)
+		return err
+	}
+
+	return nil
+
+}
+
+//SetRole changes the role of a user
+func (s *Store) SetRole(ctx context.Context, user *User) error {
+	query := "UPDATE users SET role = $1, updated_at=$2 WHERE id = $3"
+
+	stmt, err := s.db.PrepareContext(ctx, query)
+
+	if err != nil {
+		logging.From(ctx).Error("users.SetRole", "err", err)
+		return err
+	}
+	defer stmt.Close()
+
+	user.UpdatedAt = time.Now()
+	_, err = stmt.ExecContext(ctx, user.Role, user.UpdatedAt, user.ID)
+
+	if err != nil {
+		logging.From(ctx).Error("users.SetRole", "err", err)
+		return err
+	}
+
+	return nil
+}
+
+//SetAvatar changes the key of the avatar of a user, returning the key of the one it replaces
+func (s *Store) SetAvatar(ctx context.Context, user *User) (string, error) {
+	query := "WITH old AS (SELECT avatar FROM users WHERE id = $3) UPDATE users SET avatar = NULLIF($1, ''), updated_at=$2 WHERE id = $3 returning COALESCE((SELECT avatar FROM old), '')"
+
+	stmt, err := s.db.PrepareContext(ctx, query)
+
+	if err != nil {
+		logging.From(ctx).Error("users.SetAvatar", "err", err)
+		return "", err
+	}
+	defer stmt.Close()
+
+	var previous string
+
+	user.UpdatedAt = time.Now()
+	err = stmt.QueryRowContext(ctx, user.Avatar, user.UpdatedAt, user.ID).Scan(&previous)
+
+	if err != nil {
+		logging.From(ctx).Error("users.SetAvatar", "err", err)
+		return "", err
+	}
+
+	return previous, nil
+}
+
+//Delete a user from the database
+func (s *Store) Delete(ctx context.Context, user *User) error {
+	query := "DELETE FROM users WHERE id = $1"
+
+	stmt, err := s.db.PrepareContext(ctx, query)
+
+	if err != nil {
+		logging.From(ctx).Error("users.Delete", "err", err)
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.ExecContext(ctx, user.ID)
+
+	if err != nil {
+		logging.From(ctx).Error("users.Delete", "err", err)
+		return err
+	}
+
+	return nil
+}
+
+//GetAll gets the page q asks for of the users that match filter
+func (s *Store) GetAll(ctx context.Context, filter Filter, q page.Query) (page.Page[User], error) {
+	var where page.Where
+
+	if filter.Role != "" {
+		where.Add("role = ?", filter.Role)
+	}
+
+	tail := q.Keyset(&where, "created_at", "id")
+
+	query := "SELECT id, display_name, email, ratings, COALESCE(avatar, ''), role, created_at FROM users" + where.String() + tail
+
+	stmt, err := s.db.PrepareContext(ctx, query)
+
+	if err != nil {
+		logging.From(ctx).Error("users.GetAll", "err", err)
+		return page.Page[User]{}, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.QueryContext(ctx, where.Args...)
+
+	if err != nil {
+		logging.From(ctx).Error("users.GetAll", "err", err)
+		return page.Page[User]{}, err
+	}
+
+	var users []User
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var user User
+		if err := rows.Scan(&user.ID, &user.DisplayName, &user.Email, &user.Ratings, &user.Avatar, &user.Role, &user.CreatedAt); err != nil {
+			logging.From(ctx).Error("users.GetAll", "err", err)
+			return page.Page[User]{}, err
+		}
+		users = append(users, user)
+	}
+
+	if err = rows.Err(); err != nil {
+		logging.From(ctx).Error("users.GetAll", "err", err)
+		return page.Page[User]{}, err
+	}
+
+	return page.New(users, q, Key), nil
+}
@@ -0,0 +1,219 @@
+package users
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/Samuyi/www/logging"
+)
+
+//ErrTwoFactorEnabled is returned when enrolling a user who already has two-factor authentication turned on
+var ErrTwoFactorEnabled = errors.New("Two-factor authentication is already enabled")
+
+//twoFactorEnabled selects whether the user in a query on users has turned on two-factor authentication
+const twoFactorEnabled = "EXISTS(SELECT 1 FROM user_totp WHERE user_totp.user_id = users.id AND user_totp.enabled)"
+
+//TwoFactor is the authenticator secret of a user. It is only required at login once Enabled, after the user
+//has shown they can produce codes with it. LastCounter is the step of the last code used, so codes work once.
+type TwoFactor struct {
+	UserID      string
+	Secret      string
+	Enabled     bool
+	LastCounter int64
+}
+
+//SetTwoFactor starts enrolling a user in two-factor authentication with a new secret and recovery codes,
+//replacing any enrolment that wasn't finished. Only the hashes of the recovery codes are kept.
+//Users who finished enrolling have to disable two-factor authentication first.
+func (s *Store) SetTwoFactor(ctx context.Context, tf *TwoFactor, recoveryHashes []string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+
+	if err != nil {
+		logging.From(ctx).Error("users.SetTwoFactor", "err", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	query := "INSERT INTO user_totp (user_id, secret) VALUES ($1, $2) ON CONFLICT (user_id) DO UPDATE SET secret = $2, enabled = false, last_counter = 0, created_at = NOW() WHERE user_totp.enabled = false"
+
+	result, err := tx.ExecContext(ctx, query, tf.UserID, tf.Secret)
+
+	if err != nil {
+		logging.From(ctx).Error("users.SetTwoFactor", "err", err)
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+
+	if err != nil {
+		logging.From(ctx).Error("users.SetTwoFactor", "err", err)
+		return err
+	}
+
+	if rows == 0 {
+		return ErrTwoFactorEnabled
+	}
+
+	if _, err = tx.ExecContext(ctx, "DELETE FROM recovery_codes WHERE user_id = $1", tf.UserID); err != nil {
+		logging.From(ctx).Error("users.SetTwoFactor", "err", err)
+		return err
+	}
+
+	for _, hash := range recoveryHashes {
+		if _, err = tx.ExecContext(ctx, "INSERT INTO recovery_codes (user_id, code_hash) VALUES ($1, $2)", tf.UserID, hash); err != nil {
+			logging.From(ctx).Error("users.SetTwoFactor", "err", err)
+			return err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		logging.From(ctx).Error("users.SetTwoFactor", "err", err)
+		return err
+	}
+
+	tf.Enabled = false
+	tf.LastCounter = 0
+
+	return nil
+}
+
+//GetTwoFactor fetches the two-factor secret of a user, sql.ErrNoRows when they never enrolled
+func (s *Store) GetTwoFactor(ctx context.Context, tf *TwoFactor) error {
+	query := "SELECT secret, enabled, last_counter FROM user_totp WHERE user_id = $1"
+
+	stmt, err := s.db.PrepareContext(ctx, query)
+
+	if err != nil {
+		logging.From(ctx).Error("users.GetTwoFactor", "err", err)
+		return err
+	}
+	defer stmt.Close()
+
+	err = stmt.QueryRowContext(ctx, tf.UserID).Scan(&tf.Secret, &tf.Enabled, &tf.LastCounter)
+
+	if err != nil && err != sql.ErrNoRows {
+		logging.From(ctx).Error("users.GetTwoFactor", "err", err)
+	}
+
+	return err
+}
+
+//EnableTwoFactor finishes enrolment, from then on the user needs a code to log in
+func (s *Store) EnableTwoFactor(ctx context.Context, tf *TwoFactor) error {
+	query := "UPDATE user_totp SET enabled = true WHERE user_id = $1"
+
+	stmt, err := s.db.PrepareContext(ctx, query)
+
+	if err != nil {
+		logging.From(ctx).Error("users.EnableTwoFactor", "err", err)
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.ExecContext(ctx, tf.UserID)
+
+	if err != nil {
+		logging.From(ctx).Error("users.EnableTwoFactor", "err", err)
+		return err
+	}
+
+	tf.Enabled = true
+
+	return nil
+}
+
+//DisableTwoFactor removes the secret and recovery codes of a user
+func (s *Store) DisableTwoFactor(ctx context.Context, tf *TwoFactor) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+
+	if err != nil {
+		logging.From(ctx).Error("users.DisableTwoFactor", "err", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.ExecContext(ctx, "DELETE FROM recovery_codes WHERE user_id = $1", tf.UserID); err != nil {
+		logging.From(ctx).Error("users.DisableTwoFactor", "err", err)
+		return err
+	}
+
+	if _, err = tx.ExecContext(ctx, "DELETE FROM user_totp WHERE user_id = $1", tf.UserID); err != nil {
+		logging.From(ctx).Error("users.DisableTwoFactor", "err", err)
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		logging.From(ctx).Error("users.DisableTwoFactor", "err", err)
+		return err
+	}
+
+	tf.Enabled = false
+
+	return nil
+}
+
+//UseCounter records that the code of step counter was used. It reports false when that step, or a later one,
+//was used already, so a code that was seen can't be replayed.
+func (s *Store) UseCounter(ctx context.Context, tf *TwoFactor, counter int64) (bool, error) {
+	query := "UPDATE user_totp SET last_counter = $1 WHERE user_id = $2 AND last_counter < $1"
+
+	stmt, err := s.db.PrepareContext(ctx, query)
+
+	if err != nil {
+		logging.From(ctx).Error("users.UseCounter", "err", err)
+		return false, err
+	}
+	defer stmt.Close()
+
+	result, err := stmt.ExecContext(ctx, counter, tf.UserID)
+
+	if err != nil {
+		logging.From(ctx).Error("users.UseCounter", "err", err)
+		return false, err
+	}
+
+	rows, err := result.RowsAffected()
+
+	if err != nil {
+		logging.From(ctx).Error("users.UseCounter", "err", err)
+		return false, err
+	}
+
+	if rows == 0 {
+		return false, nil
+	}
+
+	tf.LastCounter = counter
+
+	return true, nil
+}
+
+//UseRecoveryCode deletes the recovery code of a user with hash, reporting false when there is none
+func (s *Store) UseRecoveryCode(ctx context.Context, tf *TwoFactor, hash string) (bool, error) {
+	query := "DELETE FROM recovery_codes WHERE user_id = $1 AND code_hash = $2"
+
+	stmt, err := s.db.PrepareContext(ctx, query)
+
+	if err != nil {
+		logging.From(ctx).Error("users.UseRecoveryCode", "err", err)
+		return false, err
+	}
+	defer stmt.Close()
+
+	result, err := stmt.ExecContext(ctx, tf.UserID, hash)
+
+	if err != nil {
+		logging.From(ctx).Error("users.UseRecoveryCode", "err", err)
+		return false, err
+	}
+
+	rows, err := result.RowsAffected()
+
+	if err != nil {
+		logging.From(ctx).Error("users.UseRecoveryCode", "err", err)
+		return false, err
+	}
+
+	return rows > 0, nil
+}
@@ -0,0 +1,282 @@
+//Package photos turns uploaded images into the ones the site serves. Images are decoded, turned the right way up and
+//encoded again, which leaves behind the metadata cameras put in them, GPS coordinates included, and shrunk to the
+//sizes they are shown at.
+package photos
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+
+	// registers gif with image.Decode
+	_ "image/gif"
+)
+
+//Types are the content types of the images that can be uploaded
+var Types = []string{"image/jpeg", "image/png", "image/gif"}
+
+//ErrUnsupported is returned for uploads that aren't an image of one of Types
+var ErrUnsupported = errors.New("Please upload a jpeg, png or gif image")
+
+//ErrTooManyPixels is returned for images with more pixels than allowed, which would take too much memory to decode
+var ErrTooManyPixels = errors.New("Sorry that image is too big, please upload a smaller one")
+
+//Photo is an encoded image
+type Photo struct {
+	Body        []byte
+	ContentType string
+	Ext         string
+	Width       int
+	Height      int
+}
+
+//Supported reports whether contentType is one of Types
+func Supported(contentType string) bool {
+	for _, supported := range Types {
+		if contentType == supported {
+			return true
+		}
+	}
+
+	return false
+}
+
+//Decode reads an image of one of Types with at most maxPixels pixels, turned the way its EXIF orientation says it
+//is meant to be seen. Format is jpeg, png or gif.
+func Decode(data []byte, maxPixels int) (img image.Image, format string, err error) {
+	if !Supported(http.DetectContentType(data)) {
+		return nil, "", ErrUnsupported
+	}
+
+	// the header says how big the image is before any of it is decoded
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+
+	if err != nil {
+		return nil, "", ErrUnsupported
+	}
+
+	if config.Width <= 0 || config.Height <= 0 || config.Width*config.Height > maxPixels {
+		return nil, "", ErrTooManyPixels
+	}
+
+	img, _, err = image.Decode(bytes.NewReader(data))
+
+	if err != nil {
+		return nil, "", ErrUnsupported
+	}
+
+	if format == "jpeg" {
+		img = orient(rgba(img), orientation(data))
+	}
+
+	return img, format, nil
+}
+
+//Encode encodes img in the format it was decoded from, but gifs, which become pngs. Nothing but the pixels is kept.
+func Encode(img image.Image, format string) (Photo, error) {
+	var buf bytes.Buffer
+
+	photo := Photo{ContentType: "image/png", Ext: "png", Width: img.Bounds().Dx(), Height: img.Bounds().Dy()}
+
+	if format == "jpeg" {
+		photo.ContentType, photo.Ext = "image/jpeg", "jpg"
+
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 85}); err != nil {
+			return Photo{}, err
+		}
+	} else if err := png.Encode(&buf, img); err != nil {
+		return Photo{}, err
+	}
+
+	photo.Body = buf.Bytes()
+
+	return photo, nil
+}
+
+//Fit shrinks img to at most size pixels a side, keeping its proportions. Smaller images are left as they are.
+func Fit(img image.Image, size int) image.Image {
+	width, height := img.Bounds().Dx(), img.Bounds().Dy()
+
+	if width <= size && height <= size {
+		return img
+	}
+
+	if width > height {
+		width, height = size, max(1, height*size/width)
+	} else {
+		width, height = max(1, width*size/height), size
+	}
+
+	return scale(rgba(img), width, height)
+}
+
+//Square crops the middle of img to a square and shrinks it to at most size pixels a side
+func Square(img image.Image, size int) image.Image {
+	bounds := img.Bounds()
+	side := min(bounds.Dx(), bounds.Dy())
+	corner := bounds.Min.Add(image.Pt((bounds.Dx()-side)/2, (bounds.Dy()-side)/2))
+
+	square := image.NewRGBA(image.Rect(0, 0, side, side))
+	draw.Draw(square, square.Bounds(), img, corner, draw.Src)
+
+	return Fit(square, size)
+}
+
+//rgba returns img as an *image.RGBA starting at 0, 0
+func rgba(img image.Image) *image.RGBA {
+	if converted, ok := img.(*image.RGBA); ok && converted.Bounds().Min == (image.Point{}) {
+		return converted
+	}
+
+	converted := image.NewRGBA(image.Rect(0, 0, img.Bounds().Dx(), img.Bounds().Dy()))
+	draw.Draw(converted, converted.Bounds(), img, img.Bounds().Min, draw.Src)
+
+	return converted
+}
+
+//scale shrinks src to width by height pixels, each the average of the pixels of src it covers
+func scale(src *image.RGBA, width, height int) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	srcWidth, srcHeight := src.Bounds().Dx(), src.Bounds().Dy()
+
+	for y := 0; y < height; y++ {
+		top, bottom := y*srcHeight/height, max((y+1)*srcHeight/height, y*srcHeight/height+1)
+
+		for x := 0; x < width; x++ {
+			left, right := x*srcWidth/width, max((x+1)*srcWidth/width, x*srcWidth/width+1)
+
+			var sum [4]int
+
+			for sy := top; sy < bottom; sy++ {
+				row := src.Pix[sy*src.Stride+left*4 : sy*src.Stride+right*4]
+
+				for i := 0; i < len(row); i += 4 {
+					sum[0] += int(row[i])
+					sum[1] += int(row[i+1])
+					sum[2] += int(row[i+2])
+					sum[3] += int(row[i+3])
+				}
+			}
+
+			n := (bottom - top) * (right - left)
+			i := y*dst.Stride + x*4
+
+			for c := range sum {
+				dst.Pix[i+c] = uint8(sum[c] / n)
+			}
+		}
+	}
+
+	return dst
+}
+
+//orient turns src the way EXIF orientation o says it is meant to be seen
+func orient(src *image.RGBA, o int) *image.RGBA {
+	if o < 2 || o > 8 {
+		return src
+	}
+
+	width, height := src.Bounds().Dx(), src.Bounds().Dy()
+
+	// from 5 on the image is on its side
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	if o >= 5 {
+		dst = image.NewRGBA(image.Rect(0, 0, height, width))
+	}
+
+	for y := 0; y < dst.Bounds().Dy(); y++ {
+		for x := 0; x < dst.Bounds().Dx(); x++ {
+			var sx, sy int
+
+			switch o {
+			case 2: // mirrored
+				sx, sy = width-1-x, y
+			case 3: // upside down
+				sx, sy = width-1-x, height-1-y
+			case 4: // mirrored upside down
+				sx, sy = x, height-1-y
+			case 5: // mirrored along the diagonal from the top left
+				sx, sy = y, x
+			case 6: // needs turning clockwise
+				sx, sy = y, height-1-x
+			case 7: // mirrored along the diagonal from the top right
+				sx, sy = width-1-y, height-1-x
+			case 8: // needs turning anticlockwise
+				sx, sy = width-1-y, x
+			}
+
+			copy(dst.Pix[y*dst.Stride+x*4:y*dst.Stride+x*4+4], src.Pix[sy*src.Stride+sx*4:sy*src.Stride+sx*4+4])
+		}
+	}
+
+	return dst
+}
+
+//orientation returns the EXIF orientation of a jpeg, 1 when it has none
+func orientation(data []byte) int {
+	for i := 2; i+4 <= len(data) && data[i] == 0xFF; {
+		marker := data[i+1]
+
+		// the image data starts, metadata only comes before it
+		if marker == 0xDA || marker == 0xD9 {
+			break
+		}
+
+		size := int(binary.BigEndian.Uint16(data[i+2:]))
+
+		if size < 2 || i+2+size > len(data) {
+			break
+		}
+
+		if segment := data[i+4 : i+2+size]; marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return exifOrientation(segment[6:])
+		}
+
+		i += 2 + size
+	}
+
+	return 1
+}
+
+//exifOrientation reads the orientation tag from the first directory of the TIFF structure EXIF data is kept in
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+
+	var order binary.ByteOrder
+
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	directory := int(order.Uint32(tiff[4:]))
+
+	if directory < 8 || directory+2 > len(tiff) {
+		return 1
+	}
+
+	for n, entry := int(order.Uint16(tiff[directory:])), directory+2; n > 0 && entry+12 <= len(tiff); n, entry = n-1, entry+12 {
+		// the orientation is a single short, kept in the entry itself
+		if order.Uint16(tiff[entry:]) == 0x0112 && order.Uint16(tiff[entry+2:]) == 3 {
+			if o := int(order.Uint16(tiff[entry+8:])); o >= 1 && o <= 8 {
+				return o
+			}
+
+			return 1
+		}
+	}
+
+	return 1
+}
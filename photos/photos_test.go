@@ -0,0 +1,164 @@
+package photos
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+//halves returns an image 40 pixels wide and 20 high, red on the left and blue on the right
+func halves() *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 40, 20))
+
+	for y := 0; y < 20; y++ {
+		for x := 0; x < 40; x++ {
+			img.Set(x, y, color.RGBA{B: 255, A: 255})
+
+			if x < 20 {
+				img.Set(x, y, color.RGBA{R: 255, A: 255})
+			}
+		}
+	}
+
+	return img
+}
+
+//withExif returns a jpeg of img with an EXIF segment holding orientation o and, as phones add, where it was taken
+func withExif(t *testing.T, img image.Image, o uint16) []byte {
+	var buf bytes.Buffer
+
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	tiff := []byte("MM\x00\x2a\x00\x00\x00\x08\x00\x01")
+	tiff = binary.BigEndian.AppendUint16(tiff, 0x0112)
+	tiff = binary.BigEndian.AppendUint16(tiff, 3)
+	tiff = binary.BigEndian.AppendUint32(tiff, 1)
+	tiff = binary.BigEndian.AppendUint16(tiff, o)
+	tiff = append(tiff, 0, 0, 0, 0, 0, 0)
+	tiff = append(tiff, "GPSLatitude 6.5244 GPSLongitude 3.3792"...)
+
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+	header := append([]byte{0xFF, 0xE1}, binary.BigEndian.AppendUint16(nil, uint16(len(segment)+2))...)
+
+	jpg := buf.Bytes()
+
+	return append(append(append([]byte{}, jpg[:2]...), append(header, segment...)...), jpg[2:]...)
+}
+
+func red(c color.Color) bool {
+	r, g, b, _ := c.RGBA()
+
+	return r > 0xC000 && g < 0x4000 && b < 0x4000
+}
+
+func TestDecodeTurnsAndStrips(t *testing.T) {
+	upload := withExif(t, halves(), 6)
+
+	img, format, err := Decode(upload, 1000)
+
+	if err != nil || format != "jpeg" {
+		t.Fatalf("got %s, %v", format, err)
+	}
+
+	// turned clockwise, the red left half ends up on top
+	if img.Bounds().Dx() != 20 || img.Bounds().Dy() != 40 || !red(img.At(10, 5)) || red(img.At(10, 35)) {
+		t.Fatalf("got a %v image, %v at the top and %v at the bottom", img.Bounds(), img.At(10, 5), img.At(10, 35))
+	}
+
+	photo, err := Encode(img, format)
+
+	if err != nil || photo.ContentType != "image/jpeg" || photo.Ext != "jpg" || photo.Width != 20 || photo.Height != 40 {
+		t.Fatalf("got %+v, %v", photo, err)
+	}
+
+	if bytes.Contains(photo.Body, []byte("Exif")) || bytes.Contains(photo.Body, []byte("GPS")) {
+		t.Fatal("the encoded photo still has the metadata of the upload")
+	}
+}
+
+func TestOrientation(t *testing.T) {
+	for o := uint16(1); o <= 8; o++ {
+		img, _, err := Decode(withExif(t, halves(), o), 1000)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := image.Pt(40, 20)
+
+		if o >= 5 {
+			want = image.Pt(20, 40)
+		}
+
+		if img.Bounds().Size() != want {
+			t.Errorf("orientation %d: got %v", o, img.Bounds())
+		}
+	}
+}
+
+func TestDecodeRefuses(t *testing.T) {
+	if _, _, err := Decode([]byte("<html>not an image</html>"), 1000); err != ErrUnsupported {
+		t.Fatalf("html: got %v", err)
+	}
+
+	var buf bytes.Buffer
+	jpeg.Encode(&buf, halves(), nil)
+
+	if _, _, err := Decode(buf.Bytes()[:buf.Len()/2], 1000); err != ErrUnsupported {
+		t.Fatalf("half a jpeg: got %v", err)
+	}
+
+	if _, _, err := Decode(buf.Bytes(), 799); err != ErrTooManyPixels {
+		t.Fatalf("too many pixels: got %v", err)
+	}
+}
+
+func TestGifsBecomePngs(t *testing.T) {
+	var buf bytes.Buffer
+
+	if err := gif.Encode(&buf, halves(), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	img, format, err := Decode(buf.Bytes(), 1000)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	photo, err := Encode(img, format)
+
+	if err != nil || photo.ContentType != "image/png" {
+		t.Fatalf("got %+v, %v", photo, err)
+	}
+
+	if _, err = png.Decode(bytes.NewReader(photo.Body)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFitAndSquare(t *testing.T) {
+	fitted := Fit(halves(), 10)
+
+	if fitted.Bounds().Size() != image.Pt(10, 5) || !red(fitted.At(2, 2)) || red(fitted.At(7, 2)) {
+		t.Fatalf("fit: got a %v image", fitted.Bounds())
+	}
+
+	if small := Fit(halves(), 100); small.Bounds().Size() != image.Pt(40, 20) {
+		t.Fatalf("fit of a smaller image: got %v", small.Bounds())
+	}
+
+	// the middle of the image, half red and half blue
+	square := Square(halves(), 8)
+
+	if square.Bounds().Size() != image.Pt(8, 8) || !red(square.At(1, 4)) || red(square.At(6, 4)) {
+		t.Fatalf("square: got a %v image", square.Bounds())
+	}
+}
@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+
+	"github.com/Samuyi/www/config"
+	"github.com/go-redis/redis"
+	_ "github.com/lib/pq" // postgres driver
+)
+
+//Storage owns the connections shared by every model
+type Storage struct {
+	DB    *sql.DB
+	Redis *redis.Client
+}
+
+//Open connects to postgres and redis and fails if either can't be reached
+func Open(cfg *config.Config) (*Storage, error) {
+	db, err := OpenDB(cfg.Database)
+
+	if err != nil {
+		return nil, err
+	}
+
+	client := redis.NewClient(&redis.Options{
+		Addr:     cfg.Redis.Addr,
+		Password: cfg.Redis.Password,
+		DB:       cfg.Redis.DB,
+	})
+
+	if _, err = client.Ping().Result(); err != nil {
+		db.Close()
+		client.Close()
+		return nil, fmt.Errorf("storage: can't reach redis at %s: %v", cfg.Redis.Addr, err)
+	}
+
+	log.Println("connected to redis")
+
+	return &Storage{DB: db, Redis: client}, nil
+}
+
+//OpenDB opens the postgres connection pool described by cfg and checks that the database is reachable
+func OpenDB(cfg config.Database) (*sql.DB, error) {
+	db, err := sql.Open("postgres", cfg.DSN())
+
+	if err != nil {
+		return nil, fmt.Errorf("storage: %v", err)
+	}
+
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.ConnMaxLifetime.Duration)
+	db.SetConnMaxIdleTime(cfg.ConnMaxIdleTime.Duration)
+
+	ctx := context.Background()
+
+	if cfg.ConnectTimeout.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.ConnectTimeout.Duration)
+		defer cancel()
+	}
+
+	if err = db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("storage: can't reach postgres: %v", err)
+	}
+
+	log.Println("connected to database")
+
+	return db, nil
+}
+
+//Close releases every connection held by the storage
+func (s *Storage) Close() error {
+	dbErr := s.DB.Close()
+	redisErr := s.Redis.Close()
+
+	if dbErr != nil {
+		return dbErr
+	}
+
+	return redisErr
+}
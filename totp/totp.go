@@ -0,0 +1,149 @@
+//Package totp implements the time-based one-time passwords of RFC 6238 used for two-factor authentication,
+//along with the recovery codes that stand in for them when a user loses their authenticator
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+//Step is how long each code is valid for
+const Step = 30 * time.Second
+
+//Digits is the length of a code
+const Digits = 6
+
+//Skew is how many steps either side of the current one a code is still accepted in, to allow for clock drift
+const Skew = 1
+
+//ErrInvalidSecret is returned when a secret isn't valid base32
+var ErrInvalidSecret = errors.New("totp: invalid secret")
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+//NewSecret returns a random 160 bit secret, base32 encoded the way authenticator apps expect it
+func NewSecret() (string, error) {
+	b := make([]byte, 20)
+
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return encoding.EncodeToString(b), nil
+}
+
+//Counter returns the step t falls in
+func Counter(t time.Time) int64 {
+	return t.Unix() / int64(Step/time.Second)
+}
+
+//Code returns the code for secret at t
+func Code(secret string, t time.Time) (string, error) {
+	key, err := decode(secret)
+
+	if err != nil {
+		return "", err
+	}
+
+	return code(key, Counter(t)), nil
+}
+
+//Validate reports whether code is valid for secret at t, allowing for Skew. It also returns the step the code
+//belongs to, which callers should remember so a code can't be used twice.
+func Validate(secret, value string, t time.Time) (int64, bool) {
+	key, err := decode(secret)
+
+	if err != nil || len(value) != Digits {
+		return 0, false
+	}
+
+	now := Counter(t)
+
+	for counter := now - Skew; counter <= now+Skew; counter++ {
+		if subtle.ConstantTimeCompare([]byte(code(key, counter)), []byte(value)) == 1 {
+			return counter, true
+		}
+	}
+
+	return 0, false
+}
+
+//URI returns the otpauth:// uri authenticator apps read, usually from a QR code, to add an account
+func URI(issuer, account, secret string) string {
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(Digits))
+	query.Set("period", fmt.Sprint(int(Step/time.Second)))
+
+	label := url.PathEscape(issuer + ":" + account)
+
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+//RecoveryCodes returns n random single-use codes of 50 bits each, written as two groups of five characters
+func RecoveryCodes(n int) ([]string, error) {
+	codes := make([]string, n)
+
+	for i := range codes {
+		b := make([]byte, 7)
+
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+
+		s := strings.ToLower(encoding.EncodeToString(b))[:10]
+		codes[i] = s[:5] + "-" + s[5:]
+	}
+
+	return codes, nil
+}
+
+//NormalizeRecoveryCode strips the spaces and dashes users tend to add or leave out and ignores case,
+//so a recovery code can be hashed the same way however it was typed
+func NormalizeRecoveryCode(value string) string {
+	value = strings.ToLower(value)
+
+	return strings.NewReplacer("-", "", " ", "").Replace(value)
+}
+
+func decode(secret string) ([]byte, error) {
+	key, err := encoding.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+
+	if err != nil || len(key) == 0 {
+		return nil, ErrInvalidSecret
+	}
+
+	return key, nil
+}
+
+//code is the HOTP value of RFC 4226 for key and counter
+func code(key []byte, counter int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(counter))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+
+	for i := 0; i < Digits; i++ {
+		mod *= 10
+	}
+
+	return fmt.Sprintf("%0*d", Digits, value%mod)
+}
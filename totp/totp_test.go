@@ -0,0 +1,114 @@
+package totp
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+//rfcSecret is the SHA1 seed of the RFC 6238 test vectors, "12345678901234567890", in base32
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestRFC6238Vectors(t *testing.T) {
+	// the RFC lists 8 digit codes, the last 6 digits are the 6 digit codes
+	vectors := map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	}
+
+	for unix, want := range vectors {
+		got, err := Code(rfcSecret, time.Unix(unix, 0))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got != want {
+			t.Errorf("code at %d = %s, want %s", unix, got, want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret, err := NewSecret()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Unix(1600000000, 0)
+	code, _ := Code(secret, now)
+
+	counter, ok := Validate(secret, code, now)
+
+	if !ok || counter != Counter(now) {
+		t.Fatalf("Validate(current code) = %d, %v", counter, ok)
+	}
+
+	if _, ok = Validate(secret, code, now.Add(Step)); !ok {
+		t.Error("a code from the previous step should be accepted")
+	}
+
+	if _, ok = Validate(secret, code, now.Add(3*Step)); ok {
+		t.Error("a code from three steps ago should be rejected")
+	}
+
+	if _, ok = Validate(secret, "12345", now); ok {
+		t.Error("a short code should be rejected")
+	}
+
+	if _, ok = Validate("not base32!", code, now); ok {
+		t.Error("an invalid secret should be rejected")
+	}
+}
+
+func TestURI(t *testing.T) {
+	uri, err := url.Parse(URI("Giveaway", "jane@example.com", rfcSecret))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if uri.Scheme != "otpauth" || uri.Host != "totp" || uri.Path != "/Giveaway:jane@example.com" {
+		t.Errorf("unexpected uri %s", uri)
+	}
+
+	query := uri.Query()
+
+	if query.Get("secret") != rfcSecret || query.Get("issuer") != "Giveaway" || query.Get("digits") != "6" || query.Get("period") != "30" {
+		t.Errorf("unexpected parameters %v", query)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := RecoveryCodes(10)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	seen := map[string]bool{}
+
+	for _, code := range codes {
+		if len(code) != 11 || code[5] != '-' {
+			t.Errorf("unexpected recovery code %q", code)
+		}
+
+		if seen[code] {
+			t.Errorf("recovery code %q repeated", code)
+		}
+
+		seen[code] = true
+
+		typed := " " + strings.ToUpper(strings.Replace(code, "-", "", 1))
+
+		if NormalizeRecoveryCode(typed) != NormalizeRecoveryCode(code) {
+			t.Errorf("%q and %q should normalize the same", typed, code)
+		}
+	}
+}
@@ -1,8 +1,6 @@
 package utilities
 
 import (
-	"log"
-
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -10,7 +8,6 @@ import (
 func HashPassword(password string) (hash string, err error) {
 	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 14)
 	if err != nil {
-		log.Println(err)
 		return "", err
 	}
 	return string(bytes), err
@@ -19,6 +16,5 @@ func HashPassword(password string) (hash string, err error) {
 //CheckPassword checks a password if it matches the hash
 func CheckPassword(password string, hash string) bool {
 	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
-	log.Println(err)
 	return err == nil
 }
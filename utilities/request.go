@@ -0,0 +1,18 @@
+package utilities
+
+import (
+	"net"
+	"net/http"
+)
+
+//ClientIP returns the address a request came from. Lockouts, rate limits and sessions all use it, so they agree
+//on who the client is.
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}